@@ -0,0 +1,107 @@
+package chaindb
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/danitello/go-blockchain/core/types"
+)
+
+// CacheStats reports how effective the Block cache of a ChainDB has been -
+// Hits - reads served from the cache
+// Misses - reads that had to go to the database
+type CacheStats struct {
+	Hits   int
+	Misses int
+}
+
+// blockCache is a least recently used cache of Blocks keyed by hash
+type blockCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List // front is most recently used
+	stats    CacheStats
+}
+
+// cacheEntry is the value held by each element of blockCache.order
+type cacheEntry struct {
+	key   string
+	block *types.Block
+}
+
+// initBlockCache creates a new blockCache holding at most capacity Blocks
+func initBlockCache(capacity int) *blockCache {
+	return &blockCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New()}
+}
+
+// get retrieves a copy of the Block with a given hash if it is cached, so the caller may change it
+func (c *blockCache) get(hash []byte) (*types.Block, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[string(hash)]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+	c.stats.Hits++
+	c.order.MoveToFront(elem)
+
+	return elem.Value.(*cacheEntry).block.Copy(), true
+}
+
+// add caches a copy of a Block under a given hash, so the caller may go on changing it, evicting the least recently
+// used Block if full
+func (c *blockCache) add(hash []byte, block *types.Block) {
+	block = block.Copy()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := string(hash)
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*cacheEntry).block = block
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheEntry{key, block})
+
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// remove drops the Block with a given hash from the cache
+func (c *blockCache) remove(hash []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[string(hash)]; ok {
+		c.order.Remove(elem)
+		delete(c.entries, string(hash))
+	}
+}
+
+// purge drops every Block from the cache
+func (c *blockCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+}
+
+// getStats retrieves a snapshot of the hit and miss counts
+func (c *blockCache) getStats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.stats
+}
@@ -0,0 +1,148 @@
+package chaindb
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/danitello/go-blockchain/core/types"
+)
+
+// benchBlocks is the number of Blocks read over and over by the benchmarks
+const benchBlocks = 8
+
+// writeTestChain writes n Blocks, each with a Transaction, to db and returns their hashes
+func writeTestChain(t testing.TB, db *ChainDB, n int) [][]byte {
+	t.Helper()
+	var hashes [][]byte
+	var prev *types.Block
+
+	for i := 0; i < n; i++ {
+		block := testBlock(i, prev)
+		block.Transactions = []*types.Transaction{{
+			ID:      []byte{byte(i)},
+			Inputs:  []types.TxInput{{TxID: []byte{}, OutputIdx: -1, Data: bytes.Repeat([]byte{byte(i)}, 64)}},
+			Outputs: []types.TxOutput{{Amount: i, PubKeyHash: bytes.Repeat([]byte{byte(i)}, 20)}}}}
+		if err := db.WriteNewLastBlock(block); err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, block.Hash)
+		prev = block
+	}
+
+	return hashes
+}
+
+func TestReadBlockWithHashCached(t *testing.T) {
+	db := openTestDB(t, 4)
+	hash := writeTestChain(t, db, 1)[0]
+
+	first, err := db.ReadBlockWithHash(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := db.ReadBlockWithHash(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := db.CacheStats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Fatalf("stats %+v, want 1 hit and 1 miss", stats)
+	}
+
+	// Each read gets its own Block, so changing one leaves the cache and the others alone
+	first.Transactions[0].Outputs[0].Amount = 1000
+	first.Transactions[0].ID[0] = 0xff
+	first.Transactions = nil
+	second.Hash[0] = 0xff
+
+	third, err := db.ReadBlockWithHash(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(third.Transactions) != 1 || third.Transactions[0].Outputs[0].Amount != 0 || third.Transactions[0].ID[0] != 0 ||
+		bytes.Compare(third.Hash, hash) != 0 {
+		t.Fatal("changing a read block changed the cached one")
+	}
+}
+
+func TestBlockCacheEvicts(t *testing.T) {
+	db := openTestDB(t, 2)
+	hashes := writeTestChain(t, db, 3)
+
+	for _, hash := range hashes {
+		if _, err := db.ReadBlockWithHash(hash); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The first Block was evicted, the others are still cached
+	for i := len(hashes) - 1; i >= 0; i-- {
+		if _, err := db.ReadBlockWithHash(hashes[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := db.CacheStats(); stats.Hits != 2 || stats.Misses != 4 {
+		t.Fatalf("stats %+v, want 2 hits and 4 misses", stats)
+	}
+}
+
+func TestInvalidateCache(t *testing.T) {
+	db := openTestDB(t, 4)
+	hashes := writeTestChain(t, db, 2)
+
+	for _, hash := range hashes {
+		db.ReadBlockWithHash(hash)
+	}
+	db.InvalidateCache(hashes[0])
+	db.ReadBlockWithHash(hashes[0])
+	db.ReadBlockWithHash(hashes[1])
+
+	if stats := db.CacheStats(); stats.Hits != 1 || stats.Misses != 3 {
+		t.Fatalf("stats %+v, want 1 hit and 3 misses", stats)
+	}
+}
+
+// BenchmarkReadBlockWithHash reads the same few Blocks over and over, as an explorer serving the tip does, reporting
+// the reads that went to badger (and were deserialized) per op
+func BenchmarkReadBlockWithHash(b *testing.B) {
+	for _, cacheSize := range []int{0, benchBlocks} {
+		b.Run(fmt.Sprintf("cache=%d", cacheSize), func(b *testing.B) {
+			db := openTestDB(b, cacheSize)
+			hashes := writeTestChain(b, db, benchBlocks)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := db.ReadBlockWithHash(hashes[i%benchBlocks]); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+
+			reads := b.N // every read goes to badger without a cache
+			if cacheSize > 0 {
+				reads = db.CacheStats().Misses
+			}
+			b.ReportMetric(float64(reads)/float64(b.N), "dbreads/op")
+		})
+	}
+}
+
+// BenchmarkReadBlockWithHashParallel is BenchmarkReadBlockWithHash with reads from many goroutines, as a server makes
+func BenchmarkReadBlockWithHashParallel(b *testing.B) {
+	for _, cacheSize := range []int{0, benchBlocks} {
+		b.Run(fmt.Sprintf("cache=%d", cacheSize), func(b *testing.B) {
+			db := openTestDB(b, cacheSize)
+			hashes := writeTestChain(b, db, benchBlocks)
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					if _, err := db.ReadBlockWithHash(hashes[i%benchBlocks]); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
@@ -3,9 +3,12 @@ package chaindb
 // Database interfacing
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"strings"
+	"time"
 
-	"github.com/danitello/go-blockchain/common/byteutil"
 	"github.com/danitello/go-blockchain/common/errutil"
 	"github.com/danitello/go-blockchain/core/types"
 	"github.com/dgraph-io/badger"
@@ -14,6 +17,11 @@ import (
 // ChainDB is the database for a BlockChain
 type ChainDB struct {
 	Database *badger.DB
+	codec    Codec
+	cache    *blockCache
+	stopGC   chan struct{} // closed to stop the goroutine started by StartGC
+	gcDone   chan struct{} // closed when that goroutine has returned
+	readOnly bool
 }
 
 const (
@@ -22,17 +30,131 @@ const (
 
 	// LastHashKey is the db key -> value is hash of most recent block in db
 	LastHashKey = "lastHashKey"
+
+	// GenesisHashKey is the db key -> value is hash of the first block in db
+	GenesisHashKey = "genesisHashKey"
+
+	// DefaultGCDiscardRatio is the recommended discard ratio for RunGC
+	DefaultGCDiscardRatio = 0.5
+
+	// prunedPrefix prefixes the db key marking a Block as pruned -> value is empty
+	prunedPrefix = "pruned-"
+
+	// headerPrefix prefixes the db key of a BlockHeader -> value is the serialized BlockHeader
+	headerPrefix = "header-"
+)
+
+var (
+	// ErrBlockPruned is returned when reading a Block whose Transactions have been pruned
+	ErrBlockPruned = errors.New("Block has been pruned")
+	// ErrBlockNotFound is returned when reading a Block that is not in the database
+	ErrBlockNotFound = errors.New("Block not found")
+	// ErrKeyNotFound is returned when reading a value (other than a Block) that is not in the database
+	ErrKeyNotFound = errors.New("Key not found")
+	// ErrReadOnly is returned when writing to a ChainDB opened with InitDBReadOnly
+	ErrReadOnly = errors.New("Database is open read-only")
+	// ErrDBLocked is returned when opening a database another process has open
+	ErrDBLocked = errors.New("Database is in use by another process")
+	// ErrDBCorrupt is returned when data in the database can't be read or decoded. The cause is logged
+	ErrDBCorrupt = errors.New("Database is corrupt")
 )
 
-// InitDB instantiates a new ChainDB instance from the specified directory
+// InitDB instantiates a new ChainDB instance from the default directory
 func InitDB() *ChainDB {
+	return InitDBWithCache(Dir, 0)
+}
+
+// InitDBWithCache instantiates a new ChainDB instance from the specified directory which keeps up to
+// cacheSize recently read Blocks in memory (no cache if cacheSize <= 0)
+func InitDBWithCache(dir string, cacheSize int) *ChainDB {
+	db, err := OpenDB(dir, cacheSize)
+	errutil.Handle(err)
+	return db
+}
+
+// InitDBWithCodec is InitDBWithCache storing Blocks and BlockHeaders with a given Codec
+func InitDBWithCodec(dir string, cacheSize int, codec Codec) *ChainDB {
+	db, err := OpenDBWithCodec(dir, cacheSize, codec)
+	errutil.Handle(err)
+	return db
+}
+
+// OpenDB is InitDBWithCache returning an error instead of panicking, which is ErrDBLocked if another process has
+// the directory open
+func OpenDB(dir string, cacheSize int) (*ChainDB, error) {
+	return OpenDBWithCodec(dir, cacheSize, DefaultCodec)
+}
+
+// OpenDBWithCodec is OpenDB storing Blocks and BlockHeaders with a given Codec
+func OpenDBWithCodec(dir string, cacheSize int, codec Codec) (*ChainDB, error) {
+	opts := badger.DefaultOptions
+	opts.Dir = dir
+	opts.ValueDir = dir
+	bdb, err := badger.Open(opts)
+	if err != nil && strings.Contains(err.Error(), "Cannot acquire directory lock") {
+		return nil, ErrDBLocked
+	} else if err != nil {
+		return nil, err
+	}
+
+	db := ChainDB{Database: bdb, codec: codec}
+	if cacheSize > 0 {
+		db.cache = initBlockCache(cacheSize)
+	}
+	return &db, nil
+}
+
+// InitDBReadOnly instantiates a new ChainDB instance from the specified directory that can only be read from,
+// so another process (e.g. a running node) may keep using the directory. Write methods fail with ErrReadOnly
+func InitDBReadOnly(dir string) (*ChainDB, error) {
+	return InitDBReadOnlyWithCodec(dir, DefaultCodec)
+}
+
+// InitDBReadOnlyWithCodec is InitDBReadOnly for a database written with a given Codec
+func InitDBReadOnlyWithCodec(dir string, codec Codec) (*ChainDB, error) {
 	opts := badger.DefaultOptions
-	opts.Dir = Dir
-	opts.ValueDir = Dir
+	opts.Dir = dir
+	opts.ValueDir = dir
+	opts.ReadOnly = true
 	bdb, err := badger.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ChainDB{Database: bdb, codec: codec, readOnly: true}, nil
+}
+
+// encode converts a Block or BlockHeader to []byte with the Codec of the ChainDB
+func (db *ChainDB) encode(v interface{}) []byte {
+	data, err := db.codec.Encode(v)
 	errutil.Handle(err)
-	db := ChainDB{bdb}
-	return &db
+
+	return data
+}
+
+// decodeBlock converts a Block written by encode back into a Block
+func (db *ChainDB) decodeBlock(data []byte) (*types.Block, error) {
+	var block types.Block
+	if err := db.codec.Decode(data, &block); err != nil {
+		return nil, fmt.Errorf("Malformed block data: %s", err)
+	}
+
+	return &block, nil
+}
+
+// decodeHeader converts a BlockHeader written by encode back into a BlockHeader
+func (db *ChainDB) decodeHeader(data []byte) (*types.BlockHeader, error) {
+	var header types.BlockHeader
+	if err := db.codec.Decode(data, &header); err != nil {
+		return nil, fmt.Errorf("Malformed block header data: %s", err)
+	}
+
+	return &header, nil
+}
+
+// IsReadOnly determines whether the ChainDB was opened with InitDBReadOnly
+func (db *ChainDB) IsReadOnly() bool {
+	return db.readOnly
 }
 
 // HasChain determines whether the ChainDB instance has a previously initiated BlockChain
@@ -54,49 +176,359 @@ func (db *ChainDB) HasChain() bool {
 }
 
 // ReadLastHash gets the hash of the most recent Block in the database
-func (db *ChainDB) ReadLastHash() (lastHash []byte) {
-	err := db.Database.View(func(txn *badger.Txn) (err error) {
+func (db *ChainDB) ReadLastHash() (lastHash []byte, err error) {
+	err = db.Database.View(func(txn *badger.Txn) (err error) {
 		item, err := txn.Get([]byte(LastHashKey))
-		errutil.Handle(err)
+		if err != nil {
+			return err
+		}
 
 		lastHash, err = item.Value()
 		return
 	})
-	errutil.Handle(err)
+	if err != nil {
+		return nil, readError(err, ErrKeyNotFound)
+	}
 
 	return
 }
 
-// ReadBlockWithHash gets a Block from the database, given it's hash
-func (db *ChainDB) ReadBlockWithHash(hash []byte) (resBlock *types.Block) {
-	err := db.Database.View(func(txn *badger.Txn) error {
+// ReadGenesisHash gets the hash of the first Block in the database
+func (db *ChainDB) ReadGenesisHash() (genesisHash []byte, err error) {
+	err = db.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(GenesisHashKey))
+		if err != nil {
+			return err
+		}
+
+		genesisHash, err = item.Value()
+		return err
+	})
+	if err == nil {
+		return
+	} else if err != badger.ErrKeyNotFound {
+		return nil, readError(err, ErrKeyNotFound)
+	}
+
+	// Chains created before the genesis hash was recorded - walk back to it
+	hash, err := db.ReadLastHash()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		header, err := db.ReadHeaderWithHash(hash)
+		if err != nil {
+			return nil, err
+		}
+		if len(header.PrevHash) == 0 {
+			return header.Hash, nil
+		}
+		hash = header.PrevHash
+	}
+}
+
+// ReadBlockWithHash gets a Block from the database, given it's hash.
+// If the Block has been pruned, it is returned without its Transactions along with ErrBlockPruned
+func (db *ChainDB) ReadBlockWithHash(hash []byte) (resBlock *types.Block, err error) {
+	if db.cache != nil {
+		if block, ok := db.cache.get(hash); ok {
+			return block, nil
+		}
+	}
+
+	pruned := false
+	err = db.Database.View(func(txn *badger.Txn) error {
 		item, err := txn.Get([]byte(hash))
-		errutil.Handle(err)
+		if err != nil {
+			return err
+		}
 
 		value, err := item.Value()
-		resBlock = types.DeserializeBlock(value)
+		if err != nil {
+			return err
+		}
+		resBlock, err = db.decodeBlock(value)
+		if err != nil {
+			return err
+		}
+
+		if _, err := txn.Get(append([]byte(prunedPrefix), hash...)); err == nil {
+			pruned = true
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, readError(err, ErrBlockNotFound)
+	}
+
+	if pruned {
+		return resBlock, ErrBlockPruned
+	}
+
+	if db.cache != nil {
+		db.cache.add(hash, resBlock)
+	}
+
+	return resBlock, nil
+}
+
+// ReadRawBlockWithHash gets the serialized Block with a given hash exactly as it is stored in the database (in the
+// format of its Codec, see RawContentType), without deserializing it.
+// If the Block has been pruned, what is stored of it without its Transactions is returned along with ErrBlockPruned
+func (db *ChainDB) ReadRawBlockWithHash(hash []byte) (raw []byte, err error) {
+	pruned := false
+	err = db.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(hash)
+		if err != nil {
+			return err
+		}
+		value, err := item.Value()
+		if err != nil {
+			return err
+		}
+		raw = append([]byte{}, value...)
+
+		if _, err := txn.Get(append([]byte(prunedPrefix), hash...)); err == nil {
+			pruned = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, readError(err, ErrBlockNotFound)
+	}
+
+	if pruned {
+		return raw, ErrBlockPruned
+	}
+	return raw, nil
+}
+
+// RawContentType gets the MIME type of the serialized Blocks ReadRawBlockWithHash gets, which depends on the Codec
+func (db *ChainDB) RawContentType() string {
+	if _, ok := db.codec.(JSONCodec); ok {
+		return "application/json"
+	}
+	return "application/octet-stream"
+}
 
+// ReadHeaderWithHash gets the BlockHeader of a Block from the database, given the Block's hash
+func (db *ChainDB) ReadHeaderWithHash(hash []byte) (resHeader *types.BlockHeader, err error) {
+	err = db.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(append([]byte(headerPrefix), hash...))
+		if err == badger.ErrKeyNotFound {
+			// Blocks written before headers were stored separately
+			item, err = txn.Get(hash)
+			if err != nil {
+				return err
+			}
+			value, err := item.Value()
+			if err != nil {
+				return err
+			}
+			block, err := db.decodeBlock(value)
+			if err != nil {
+				return err
+			}
+			resHeader = block.Header()
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		value, err := item.Value()
+		if err != nil {
+			return err
+		}
+		resHeader, err = db.decodeHeader(value)
 		return err
 	})
-	errutil.Handle(err)
+	if err != nil {
+		return nil, readError(err, ErrBlockNotFound)
+	}
 
 	return
 }
 
-// WriteNewLastBlock writes a new Block into the database and updates the last hash value
-func (db *ChainDB) WriteNewLastBlock(newBlock *types.Block) {
+// readError converts an error reading from the database into notFound if the key was missing, otherwise ErrDBCorrupt
+func readError(err error, notFound error) error {
+	if err == badger.ErrKeyNotFound {
+		return notFound
+	}
+
+	log.Printf("Database read failed: %s\n", err)
+	return ErrDBCorrupt
+}
+
+// PruneBlock replaces the stored Block with a given hash by one without Transactions, keeping the rest of it
+// and its BlockHeader
+func (db *ChainDB) PruneBlock(hash []byte) error {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+
 	err := db.Database.Update(func(txn *badger.Txn) error {
-		err := txn.Set(newBlock.Hash, byteutil.Serialize(newBlock))
-		errutil.Handle(err)
+		item, err := txn.Get(hash)
+		if err != nil {
+			return err
+		}
+		value, err := item.Value()
+		if err != nil {
+			return err
+		}
 
-		err = txn.Set([]byte(LastHashKey), newBlock.Hash)
-		return err
+		block, err := db.decodeBlock(value)
+		if err != nil {
+			return err
+		}
+		err = txn.Set(append([]byte(headerPrefix), hash...), db.encode(block.Header()))
+		if err != nil {
+			return err
+		}
+
+		block.Transactions = nil
+		if err := txn.Set(hash, db.encode(block)); err != nil {
+			return err
+		}
+		return txn.Set(append([]byte(prunedPrefix), hash...), []byte{})
 	})
 
+	db.InvalidateCache(hash)
+
+	return err
+}
+
+// HasPrunedBlocks determines whether any Block in the database has been pruned
+func (db *ChainDB) HasPrunedBlocks() bool {
+	found := false
+
+	err := db.Database.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		it.Seek([]byte(prunedPrefix))
+		found = it.ValidForPrefix([]byte(prunedPrefix))
+		return nil
+	})
 	errutil.Handle(err)
+
+	return found
+}
+
+// WriteNewLastBlock writes a new Block and its BlockHeader into the database and updates the last hash value
+// (and the genesis hash value, for the first Block)
+func (db *ChainDB) WriteNewLastBlock(newBlock *types.Block) error {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+
+	return db.Database.Update(func(txn *badger.Txn) error {
+		return db.WriteNewLastBlockWithTxn(txn, newBlock)
+	})
 }
 
-// CloseDB closes the badgerdb
+// WriteNewLastBlockWithTxn does the work of WriteNewLastBlock within a db transaction, so the Block can be written
+// along with other changes that must be made with it. The caller must discard txn on error
+func (db *ChainDB) WriteNewLastBlockWithTxn(txn *badger.Txn, newBlock *types.Block) error {
+	if err := txn.Set(newBlock.Hash, db.encode(newBlock)); err != nil {
+		return err
+	}
+	if err := txn.Set(append([]byte(headerPrefix), newBlock.Hash...), db.encode(newBlock.Header())); err != nil {
+		return err
+	}
+	if len(newBlock.PrevHash) == 0 {
+		if err := txn.Set([]byte(GenesisHashKey), newBlock.Hash); err != nil {
+			return err
+		}
+	}
+
+	return txn.Set([]byte(LastHashKey), newBlock.Hash)
+}
+
+// WriteHeaderWithTxn writes the BlockHeader of a Block that isn't stored yet within a db transaction, e.g. one
+// downloaded ahead of its Block. The Block itself is still ErrBlockNotFound until written with WriteNewLastBlock
+func (db *ChainDB) WriteHeaderWithTxn(txn *badger.Txn, header *types.BlockHeader) error {
+	return txn.Set(append([]byte(headerPrefix), header.Hash...), db.encode(header))
+}
+
+// RunGC reclaims disk space held by garbage in the badgerdb value log -
+// discardRatio - fraction of a value log file that must be garbage for it to be rewritten.
+// 0.5 (DefaultGCDiscardRatio) is a good balance; lower values (e.g. 0.1) reclaim more space
+// but rewrite files more often, higher values (e.g. 0.9) are cheaper but leave more garbage behind
+func (db *ChainDB) RunGC(discardRatio float64) error {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+
+	// A single call rewrites at most one file, so keep going until there is nothing left to clean
+	for {
+		err := db.Database.RunValueLogGC(discardRatio)
+		if err == badger.ErrNoRewrite {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// StartGC runs RunGC in the background every interval until the ChainDB is closed
+func (db *ChainDB) StartGC(interval time.Duration, discardRatio float64) {
+	if db.stopGC != nil || db.readOnly {
+		return
+	}
+	db.stopGC = make(chan struct{})
+	db.gcDone = make(chan struct{})
+
+	go func(stop, done chan struct{}) {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := db.RunGC(discardRatio); err != nil {
+					log.Println(err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}(db.stopGC, db.gcDone)
+}
+
+// CacheStats gets the hit and miss counts of the Block cache (zero if the ChainDB has no cache)
+func (db *ChainDB) CacheStats() CacheStats {
+	if db.cache == nil {
+		return CacheStats{}
+	}
+	return db.cache.getStats()
+}
+
+// InvalidateCache drops the Blocks with the given hashes from the cache, or every cached Block if none are given.
+// Used when stored Blocks are replaced or removed, e.g. when the chain is reorganized
+func (db *ChainDB) InvalidateCache(hashes ...[]byte) {
+	if db.cache == nil {
+		return
+	}
+
+	if len(hashes) == 0 {
+		db.cache.purge()
+		return
+	}
+	for _, hash := range hashes {
+		db.cache.remove(hash)
+	}
+}
+
+// CloseDB closes the badgerdb, first waiting for any GC started by StartGC to finish
 func (db *ChainDB) CloseDB() {
+	if db.stopGC != nil {
+		close(db.stopGC)
+		<-db.gcDone
+		db.stopGC, db.gcDone = nil, nil
+	}
 	db.Database.Close()
 }
@@ -0,0 +1,187 @@
+package chaindb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/danitello/go-blockchain/core/types"
+	"github.com/dgraph-io/badger"
+)
+
+// openTestDB opens a ChainDB in a temporary directory removed when the test finishes
+func openTestDB(t testing.TB, cacheSize int) *ChainDB {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "chaindb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := OpenDB(dir, cacheSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.CloseDB()
+		os.RemoveAll(dir)
+	})
+
+	return db
+}
+
+// testBlock makes an unmined Block, whose Hash is just its index, following prev
+func testBlock(index int, prev *types.Block) *types.Block {
+	block := &types.Block{Index: index, Hash: []byte{byte(index), 0xbb}, PrevHash: []byte{}}
+	if prev != nil {
+		block.PrevHash = prev.Hash
+	}
+
+	return block
+}
+
+func TestWriteNewLastBlock(t *testing.T) {
+	db := openTestDB(t, 0)
+	genesis := testBlock(0, nil)
+	block := testBlock(1, genesis)
+
+	for _, b := range []*types.Block{genesis, block} {
+		if err := db.WriteNewLastBlock(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if lastHash, err := db.ReadLastHash(); err != nil || bytes.Compare(lastHash, block.Hash) != 0 {
+		t.Fatalf("last hash %x, %v", lastHash, err)
+	}
+	if genesisHash, err := db.ReadGenesisHash(); err != nil || bytes.Compare(genesisHash, genesis.Hash) != 0 {
+		t.Fatalf("genesis hash %x, %v", genesisHash, err)
+	}
+	if header, err := db.ReadHeaderWithHash(block.Hash); err != nil || header.Index != 1 {
+		t.Fatalf("header %+v, %v", header, err)
+	}
+}
+
+func TestWriteNewLastBlockError(t *testing.T) {
+	db := openTestDB(t, 0)
+	genesis := testBlock(0, nil)
+	if err := db.WriteNewLastBlock(genesis); err != nil {
+		t.Fatal(err)
+	}
+
+	// A hash longer than badger allows of a key fails the write, which must leave the last hash alone
+	block := testBlock(1, genesis)
+	block.Hash = make([]byte, 1<<17)
+	if err := db.WriteNewLastBlock(block); err == nil {
+		t.Fatal("write of an oversized key succeeded")
+	}
+
+	if lastHash, err := db.ReadLastHash(); err != nil || bytes.Compare(lastHash, genesis.Hash) != 0 {
+		t.Fatalf("last hash %x, %v after a failed write", lastHash, err)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	db := openTestDB(t, 0)
+
+	if _, err := db.ReadLastHash(); err != ErrKeyNotFound {
+		t.Errorf("last hash of an empty db: got %v, want ErrKeyNotFound", err)
+	}
+	if _, err := db.ReadBlockWithHash([]byte("missing")); err != ErrBlockNotFound {
+		t.Errorf("missing block: got %v, want ErrBlockNotFound", err)
+	}
+
+	// A value that doesn't decode is corruption, not a missing Block
+	err := db.Database.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte("garbage"), []byte{0xff, 0x00, 0xff})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ReadBlockWithHash([]byte("garbage")); err != ErrDBCorrupt {
+		t.Errorf("undecodable block: got %v, want ErrDBCorrupt", err)
+	}
+}
+
+func TestRunGC(t *testing.T) {
+	db := openTestDB(t, 0)
+
+	// Nothing to rewrite is not an error
+	if err := db.RunGC(DefaultGCDiscardRatio); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCloseDBWaitsForGC(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chaindb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := OpenDB(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db.StartGC(time.Millisecond, DefaultGCDiscardRatio)
+	done := db.gcDone
+	time.Sleep(10 * time.Millisecond)
+	db.CloseDB()
+
+	select {
+	case <-done:
+	default:
+		t.Fatal("CloseDB returned while GC was still running")
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chaindb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := OpenDB(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesis := testBlock(0, nil)
+	block := testBlock(1, genesis)
+	for _, b := range []*types.Block{genesis, block} {
+		if err := db.WriteNewLastBlock(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.CloseDB()
+
+	db, err = InitDBReadOnly(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.CloseDB()
+	if !db.IsReadOnly() {
+		t.Fatal("db opened read-only is not IsReadOnly")
+	}
+
+	if lastHash, err := db.ReadLastHash(); err != nil || bytes.Compare(lastHash, block.Hash) != 0 {
+		t.Fatalf("last hash %x, %v", lastHash, err)
+	}
+	if read, err := db.ReadBlockWithHash(genesis.Hash); err != nil || read.Index != 0 {
+		t.Fatalf("genesis %+v, %v", read, err)
+	}
+
+	if err := db.WriteNewLastBlock(testBlock(2, block)); err != ErrReadOnly {
+		t.Errorf("write: got %v, want ErrReadOnly", err)
+	}
+	if err := db.PruneBlock(genesis.Hash); err != ErrReadOnly {
+		t.Errorf("prune: got %v, want ErrReadOnly", err)
+	}
+	if err := db.RunGC(DefaultGCDiscardRatio); err != ErrReadOnly {
+		t.Errorf("gc: got %v, want ErrReadOnly", err)
+	}
+	if lastHash, err := db.ReadLastHash(); err != nil || bytes.Compare(lastHash, block.Hash) != 0 {
+		t.Fatalf("last hash %x, %v after refused writes", lastHash, err)
+	}
+}
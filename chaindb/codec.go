@@ -0,0 +1,68 @@
+package chaindb
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+)
+
+// Codec converts the Blocks and BlockHeaders a ChainDB stores to and from []byte. A database must always be opened
+// with the Codec it was written with
+type Codec interface {
+	Encode(v interface{}) ([]byte, error)
+	Decode(data []byte, v interface{}) error
+}
+
+// GobCodec stores values with encoding/gob, the format ChainDB has always used
+type GobCodec struct{}
+
+// JSONCodec stores values with encoding/json, for other tools to read
+type JSONCodec struct{}
+
+// DefaultCodec is the Codec of a ChainDB opened without one
+var DefaultCodec Codec = GobCodec{}
+
+// CodecByName gets the Codec with a given name - "gob" or "json"
+func CodecByName(name string) (Codec, error) {
+	switch name {
+	case "gob":
+		return GobCodec{}, nil
+	case "json":
+		return JSONCodec{}, nil
+	}
+
+	return nil, fmt.Errorf("Unknown codec %s", name)
+}
+
+// Encode converts v to []byte with gob
+func (GobCodec) Encode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Decode converts data written by Encode into v, which must be a pointer
+func (GobCodec) Decode(data []byte, v interface{}) (err error) {
+	// gob can panic on malformed data rather than failing
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// Encode converts v to []byte with json
+func (JSONCodec) Encode(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Decode converts data written by Encode into v, which must be a pointer
+func (JSONCodec) Decode(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,87 @@
+package chaindb
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/danitello/go-blockchain/core/types"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	for _, name := range []string{"gob", "json"} {
+		codec, err := CodecByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dir, err := ioutil.TempDir("", "chaindb")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		db, err := OpenDBWithCodec(dir, 0, codec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		genesis := testBlock(0, nil)
+		genesis.Transactions = []*types.Transaction{{
+			ID:      []byte{1, 2, 3},
+			Inputs:  []types.TxInput{{TxID: []byte{}, OutputIdx: -1, Data: []byte("genesis")}},
+			Outputs: []types.TxOutput{{Amount: 50, PubKeyHash: bytes.Repeat([]byte{9}, types.PubKeyHashLen)}}}}
+		if err := db.WriteNewLastBlock(genesis); err != nil {
+			t.Fatal(err)
+		}
+		db.CloseDB()
+
+		db, err = InitDBReadOnlyWithCodec(dir, codec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.CloseDB()
+
+		read, err := db.ReadBlockWithHash(genesis.Hash)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if !bytes.Equal(read.Hash, genesis.Hash) || len(read.Transactions) != 1 ||
+			!reflect.DeepEqual(read.Transactions[0].Outputs, genesis.Transactions[0].Outputs) ||
+			!bytes.Equal(read.Transactions[0].Inputs[0].Data, genesis.Transactions[0].Inputs[0].Data) {
+			t.Fatalf("%s: got %+v, want %+v", name, read, genesis)
+		}
+		if header, err := db.ReadHeaderWithHash(genesis.Hash); err != nil || header.Index != 0 {
+			t.Fatalf("%s: header %+v, %v", name, header, err)
+		}
+
+		raw, err := db.ReadRawBlockWithHash(genesis.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if json.Valid(raw) != (name == "json") {
+			t.Fatalf("%s: stored block is JSON: %v", name, json.Valid(raw))
+		}
+		if (db.RawContentType() == "application/json") != (name == "json") {
+			t.Fatalf("%s: raw content type %s", name, db.RawContentType())
+		}
+	}
+}
+
+func TestCodecMismatch(t *testing.T) {
+	if err := (GobCodec{}).Decode([]byte(`{"Index": 1}`), &types.Block{}); err == nil {
+		t.Fatal("gob decoded a JSON block")
+	}
+	data, err := (GobCodec{}).Encode(testBlock(1, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (JSONCodec{}).Decode(data, &types.Block{}); err == nil {
+		t.Fatal("JSON decoded a gob block")
+	}
+
+	if _, err := CodecByName("protobuf"); err == nil {
+		t.Fatal("Got a codec for an unknown name")
+	}
+}
@@ -1,20 +1,25 @@
 package cli
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"math"
+	"net/http"
 	"os"
+	"os/signal"
 	"runtime"
 	"strconv"
 
 	"github.com/danitello/go-blockchain/wallet"
 
+	"github.com/danitello/go-blockchain/chaindb"
 	"github.com/danitello/go-blockchain/common/errutil"
 
 	"github.com/danitello/go-blockchain/core"
 	"github.com/danitello/go-blockchain/core/types"
+	"github.com/danitello/go-blockchain/rpc"
 )
 
 // Run starts the cli and processes the args
@@ -27,39 +32,83 @@ func Run() {
 
 	// Commands
 	balanceCommand := flag.NewFlagSet("balance", flag.ExitOnError)
+	blockTimeCommand := flag.NewFlagSet("block-time", flag.ExitOnError)
+	chainTipsCommand := flag.NewFlagSet("chain-tips", flag.ExitOnError)
 	createWalletCommand := flag.NewFlagSet("create-wallet", flag.ExitOnError)
+	deleteWalletCommand := flag.NewFlagSet("delete-wallet", flag.ExitOnError)
 	initChainCommand := flag.NewFlagSet("init-chain", flag.ExitOnError)
+	labelCommand := flag.NewFlagSet("label", flag.ExitOnError)
+	mergeWalletsCommand := flag.NewFlagSet("merge-wallets", flag.ExitOnError)
 	helpCommand := flag.NewFlagSet("help", flag.ExitOnError)
 	addressListCommand := flag.NewFlagSet("address-list", flag.ExitOnError)
 	printCommand := flag.NewFlagSet("print-chain", flag.ExitOnError)
 	reindexCommand := flag.NewFlagSet("reindex", flag.ExitOnError)
+	reindexUTXOCommand := flag.NewFlagSet("reindexutxo", flag.ExitOnError)
+	reindexTxCommand := flag.NewFlagSet("reindextx", flag.ExitOnError)
+	rpcServerCommand := flag.NewFlagSet("rpc-server", flag.ExitOnError)
 	sendCommand := flag.NewFlagSet("send", flag.ExitOnError)
+	startMiningCommand := flag.NewFlagSet("startmining", flag.ExitOnError)
+	validateWalletCommand := flag.NewFlagSet("validate-wallet", flag.ExitOnError)
+	watchXPubCommand := flag.NewFlagSet("watch-xpub", flag.ExitOnError)
 
 	// Subcommands (pointers)
 	balanceAddress := balanceCommand.String("address", "", "(Required) The address to get balance of.")
+	blockTimeBlocks := blockTimeCommand.Int("blocks", 100, "The number of recent blocks to average over.")
+	createWalletCompressed := createWalletCommand.Bool("compressed", false, "Derive the address from the compressed pub key.")
+	deleteWalletAddress := deleteWalletCommand.String("address", "", "(Required) The address whose wallet to delete.")
 	initChainCommandAddress := initChainCommand.String("address", "", "(Required) The address to init the chain with.")
+	labelCommandAddress := labelCommand.String("address", "", "(Required) The address to label.")
+	labelCommandLabel := labelCommand.String("label", "", "The label, or empty to remove it.")
+	mergeWalletsFile := mergeWalletsCommand.String("file", "", "(Required) The wallet file to merge in.")
+	rpcServerAddr := rpcServerCommand.String("addr", "127.0.0.1:8332", "The address to serve JSON-RPC on.")
 	sendCommandFrom := sendCommand.String("from", "", "(Required) The address to send from.")
 	sendCommandTo := sendCommand.String("to", "", "(Required) The address to send to.")
 	sendCommandAmount := sendCommand.String("amount", "", "(Required) The amount to send.")
+	startMiningAddress := startMiningCommand.String("address", "", "(Required) The address to reward.")
+	startMiningThreads := startMiningCommand.Int("threads", runtime.NumCPU(), "The number of goroutines to mine on.")
+	watchXPubKey := watchXPubCommand.String("xpub", "", "(Required) The extended public key of the account to watch.")
+	watchXPubCount := watchXPubCommand.Uint("count", 20, "The number of receive addresses to check.")
 
 	// Parse relevant commands
 	switch os.Args[1] {
 	case "balance":
 		balanceCommand.Parse(os.Args[2:])
+	case "block-time":
+		blockTimeCommand.Parse(os.Args[2:])
+	case "chain-tips":
+		chainTipsCommand.Parse(os.Args[2:])
 	case "create-wallet":
 		createWalletCommand.Parse(os.Args[2:])
+	case "delete-wallet":
+		deleteWalletCommand.Parse(os.Args[2:])
 	case "help":
 		helpCommand.Parse(os.Args[2:])
 	case "init-chain":
 		initChainCommand.Parse(os.Args[2:])
 	case "address-list":
 		addressListCommand.Parse(os.Args[2:])
+	case "label":
+		labelCommand.Parse(os.Args[2:])
+	case "merge-wallets":
+		mergeWalletsCommand.Parse(os.Args[2:])
 	case "print-chain":
 		printCommand.Parse(os.Args[2:])
 	case "reindex":
 		reindexCommand.Parse(os.Args[2:])
+	case "reindexutxo":
+		reindexUTXOCommand.Parse(os.Args[2:])
+	case "reindextx":
+		reindexTxCommand.Parse(os.Args[2:])
+	case "rpc-server":
+		rpcServerCommand.Parse(os.Args[2:])
 	case "send":
 		sendCommand.Parse(os.Args[2:])
+	case "startmining":
+		startMiningCommand.Parse(os.Args[2:])
+	case "validate-wallet":
+		validateWalletCommand.Parse(os.Args[2:])
+	case "watch-xpub":
+		watchXPubCommand.Parse(os.Args[2:])
 	default:
 		printHelp()
 		runtime.Goexit()
@@ -75,8 +124,25 @@ func Run() {
 		getBalance(*balanceAddress)
 	}
 
+	if blockTimeCommand.Parsed() {
+		blockTime(*blockTimeBlocks)
+	}
+
+	if chainTipsCommand.Parsed() {
+		chainTips()
+	}
+
 	if createWalletCommand.Parsed() {
-		createWallet()
+		createWallet(*createWalletCompressed)
+	}
+
+	if deleteWalletCommand.Parsed() {
+		if *deleteWalletAddress == "" {
+			deleteWalletCommand.Usage()
+			runtime.Goexit()
+		}
+
+		deleteWallet(*deleteWalletAddress)
 	}
 
 	if helpCommand.Parsed() {
@@ -98,14 +164,40 @@ func Run() {
 		addressList()
 	}
 
+	if labelCommand.Parsed() {
+		if *labelCommandAddress == "" {
+			labelCommand.Usage()
+			runtime.Goexit()
+		}
+
+		setLabel(*labelCommandAddress, *labelCommandLabel)
+	}
+
+	if mergeWalletsCommand.Parsed() {
+		if *mergeWalletsFile == "" {
+			mergeWalletsCommand.Usage()
+			runtime.Goexit()
+		}
+
+		mergeWallets(*mergeWalletsFile)
+	}
+
 	if printCommand.Parsed() {
 		printChain()
 	}
 
-	if reindexCommand.Parsed() {
+	if reindexCommand.Parsed() || reindexUTXOCommand.Parsed() {
 		reindex()
 	}
 
+	if reindexTxCommand.Parsed() {
+		reindexTx()
+	}
+
+	if rpcServerCommand.Parsed() {
+		rpcServer(*rpcServerAddr)
+	}
+
 	if sendCommand.Parsed() {
 		// Make sure the required input was submitted
 		if *sendCommandFrom == "" || *sendCommandTo == "" || *sendCommandAmount == "" {
@@ -119,6 +211,28 @@ func Run() {
 		send(*sendCommandFrom, *sendCommandTo, amt)
 	}
 
+	if startMiningCommand.Parsed() {
+		if *startMiningAddress == "" {
+			startMiningCommand.Usage()
+			runtime.Goexit()
+		}
+
+		startMining(*startMiningAddress, *startMiningThreads)
+	}
+
+	if validateWalletCommand.Parsed() {
+		validateWallet()
+	}
+
+	if watchXPubCommand.Parsed() {
+		if *watchXPubKey == "" {
+			watchXPubCommand.Usage()
+			runtime.Goexit()
+		}
+
+		watchXPub(*watchXPubKey, uint32(*watchXPubCount))
+	}
+
 }
 
 // addressList iterates through current Wallets and prints each Wallet address
@@ -126,7 +240,11 @@ func addressList() {
 	ws, _ := wallet.InitWallets()
 	addresses := ws.GetAddresses()
 	for _, address := range addresses {
-		fmt.Println(address)
+		if label := ws.GetLabel(address); label != "" {
+			fmt.Printf("%s (%s)\n", address, label)
+		} else {
+			fmt.Println(address)
+		}
 	}
 }
 
@@ -146,10 +264,93 @@ func getBalance(address string) {
 	fmt.Printf("Balance of %s: %d\n", address, balance)
 }
 
+// watchXPub prints the balance of each of the first count receive addresses of an extended public key, and their total
+func watchXPub(xpub string, count uint32) {
+	hd, err := wallet.ImportXPub(xpub)
+	errutil.Handle(err)
+
+	bc := core.GetBlockChain()
+	defer bc.ChainDB.CloseDB()
+
+	balances, total, err := bc.GetHDWatchBalance(hd, count)
+	errutil.Handle(err)
+	for i, balance := range balances {
+		address, err := hd.ReceiveAddress(uint32(i))
+		if err != nil {
+			continue // no key at this index
+		}
+		fmt.Printf("%d %s: %d\n", i, address, balance)
+	}
+	fmt.Printf("Total: %d\n", total)
+}
+
+// blockTime prints the average time the most recent Blocks took to mine along with the target
+func blockTime(lastN int) {
+	bc := core.GetBlockChain()
+	defer bc.ChainDB.CloseDB()
+
+	average, err := bc.AverageBlockTime(lastN)
+	errutil.Handle(err)
+	fmt.Printf("Average block time: %s (target %s)\n", average, bc.TargetBlockInterval)
+}
+
+// chainTips prints the tip of every branch the BlockChain knows about
+func chainTips() {
+	bc := core.GetBlockChain()
+	defer bc.ChainDB.CloseDB()
+
+	tips, err := bc.ChainTips()
+	errutil.Handle(err)
+	for _, tip := range tips {
+		fmt.Printf("%x height: %d branch length: %d status: %s work: %s\n", tip.Hash, tip.Height, tip.BranchLen, tip.Status, tip.Work)
+	}
+}
+
 // createWallet instantiates current Wallets and adds a new Wallet to it, then prints out the address
-func createWallet() {
-	ws, _ := wallet.InitWallets()
-	fmt.Println(ws.CreateWallet())
+func createWallet(compressed bool) {
+	ws, err := wallet.InitWallets()
+	if err != nil && !os.IsNotExist(err) {
+		errutil.Handle(err) // don't overwrite a wallet file that failed to load
+	}
+	fmt.Println(ws.CreateWallet(compressed))
+	ws.SaveToFile()
+}
+
+// deleteWallet wipes the key of an address and removes it from the current Wallets
+func deleteWallet(address string) {
+	ws, err := wallet.InitWallets()
+	errutil.Handle(err)
+	defer ws.Zero()
+
+	errutil.Handle(ws.DeleteWallet(address))
+	ws.SaveToFile()
+	fmt.Printf("Deleted wallet %s\n", address)
+}
+
+// mergeWallets adds the Wallets in another wallet file to the current Wallets, printing any conflicting addresses
+func mergeWallets(path string) {
+	ws, err := wallet.InitWallets()
+	if err != nil && !os.IsNotExist(err) {
+		errutil.Handle(err)
+	}
+	other, err := wallet.ReadWalletsFileAt(path)
+	errutil.Handle(err)
+
+	added, conflicts := ws.Merge(other)
+	for _, address := range conflicts {
+		fmt.Println("Conflicting wallet not merged:", address)
+	}
+	ws.SaveToFile()
+	fmt.Printf("Merged %d wallets\n", added)
+}
+
+// setLabel attaches a label to an address in the current Wallets
+func setLabel(address, label string) {
+	ws, err := wallet.InitWallets()
+	if err != nil && !os.IsNotExist(err) {
+		errutil.Handle(err)
+	}
+	errutil.Handle(ws.SetLabel(address, label))
 	ws.SaveToFile()
 }
 
@@ -160,6 +361,7 @@ func initChain(address string) {
 	}
 	bc := core.InitBlockChain(address)
 	defer bc.ChainDB.CloseDB()
+	fmt.Printf("Genesis hash: %x\n", bc.LastHash)
 }
 
 // printChain prints the chain from newest to oldest Block
@@ -170,14 +372,8 @@ func printChain() {
 	for {
 		currBlock := iter.Next()
 
-		fmt.Printf("Block\t %d\n", currBlock.Index)
-		fmt.Println("----------")
-		fmt.Printf("Hash: %x\n", currBlock.Hash)
-		fmt.Printf("Mined Date: %s\n", currBlock.TimeStamp)
-		fmt.Println("Verified:", currBlock.ValidateProof())
-		for _, tx := range currBlock.Transactions {
-			fmt.Println(tx)
-		}
+		fmt.Println(currBlock)
+		fmt.Println("Verified:", currBlock.ValidateProof(bc.Hasher))
 		fmt.Println()
 
 		// Reached the beginning of the chain
@@ -192,7 +388,7 @@ func printHelp() {
 	fmt.Println("Usage: go run main.go <command>")
 	fmt.Println()
 	fmt.Println("where <command> is one of:")
-	fmt.Println("\taddress-list, balance, create-wallet, help, init-chain, print-chain, reindex, send")
+	fmt.Println("\taddress-list, balance, block-time, chain-tips, create-wallet, delete-wallet, help, init-chain, label, merge-wallets, print-chain, reindex, reindextx, reindexutxo, rpc-server, send, startmining, validate-wallet, watch-xpub")
 	fmt.Println()
 	//fmt.Println("./main.go <command> h\t\tquick help on <command>")
 
@@ -200,14 +396,44 @@ func printHelp() {
 
 // reindex reindexes UTXO set
 func reindex() {
+	checkDBUnlocked()
 	bc := core.GetBlockChain()
 	defer bc.ChainDB.CloseDB()
-	bc.Reindex()
+	err := bc.Reindex(func(done, total int) {
+		fmt.Printf("\rReindexing: %d/%d blocks", done, total)
+	})
+	fmt.Println()
+	errutil.Handle(err)
 
 	count := bc.CountUTX()
 	fmt.Printf("Reindex complete! There are %d transactions in the UTXO set.\n", count)
 }
 
+// reindexTx rebuilds the index of the Block containing each Transaction
+func reindexTx() {
+	checkDBUnlocked()
+	bc := core.GetBlockChain()
+	defer bc.ChainDB.CloseDB()
+	count, err := bc.ReindexTxs(func(done, total int) {
+		fmt.Printf("\rReindexing: %d/%d blocks", done, total)
+	})
+	fmt.Println()
+	errutil.Handle(err)
+
+	fmt.Printf("Reindex complete! There are %d transactions in the tx index.\n", count)
+}
+
+// checkDBUnlocked exits if another process (e.g. a running node) has the db open, as it can't be rebuilt underneath it
+func checkDBUnlocked() {
+	db, err := chaindb.OpenDB(chaindb.Dir, 0)
+	if err == chaindb.ErrDBLocked {
+		fmt.Printf("%s is in use by another process. Stop it before reindexing.\n", chaindb.Dir)
+		os.Exit(1)
+	}
+	errutil.Handle(err)
+	db.CloseDB()
+}
+
 // send initiates the addition of a Transaction to the chain given a sender, reciever, and amount
 func send(from, to string, amount int) {
 	if !wallet.ValidateAddress(from) {
@@ -219,6 +445,58 @@ func send(from, to string, amount int) {
 	var txns []*types.Transaction
 	bc := core.GetBlockChain()
 	defer bc.ChainDB.CloseDB()
-	txns = append(txns, types.CoinbaseTx(from), bc.CreateTransaction(from, to, amount))
-	bc.AddBlock(txns)
+	txns = append(txns, types.CoinbaseTx(from, bc.Height), bc.CreateTransaction(from, to, amount))
+	err := bc.AddBlock(txns)
+	errutil.Handle(err)
+}
+
+// startMining mines Blocks rewarding an address until interrupted
+func startMining(address string, threads int) {
+	bc := core.GetBlockChain()
+	bc.Mempool.StartExpiry(core.DefaultExpiryInterval)
+	miner := core.InitMiner(bc)
+	errutil.Handle(miner.Start(address, threads))
+	fmt.Printf("Mining on %d threads, press Ctrl+C to stop\n", threads)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	<-interrupt
+
+	miner.Stop()
+	errutil.Handle(bc.Shutdown(context.Background()))
+	fmt.Printf("Stopped mining with %d blocks in the chain\n", bc.Height)
+}
+
+// rpcServer serves the BlockChain over JSON-RPC until interrupted
+func rpcServer(addr string) {
+	bc := core.GetBlockChain()
+	bc.Mempool.StartExpiry(core.DefaultExpiryInterval)
+	handler := rpc.InitServer(bc)
+	server := &http.Server{Addr: addr, Handler: handler}
+	server.RegisterOnShutdown(handler.Close)
+
+	go func() {
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		<-interrupt
+		server.Shutdown(context.Background())
+	}()
+
+	fmt.Printf("Serving JSON-RPC on %s, press Ctrl+C to stop\n", addr)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Println(err)
+	}
+	errutil.Handle(bc.Shutdown(context.Background()))
+}
+
+// validateWallet checks every Wallet in the wallet file without loading the chain, printing each bad one
+func validateWallet() {
+	ws, err := wallet.ReadWalletsFile()
+	errutil.Handle(err)
+
+	errs := ws.Validate()
+	for _, err := range errs {
+		fmt.Println("Invalid wallet:", err)
+	}
+	fmt.Printf("%d of %d wallets are valid\n", len(ws.Wallets)-len(errs), len(ws.Wallets))
 }
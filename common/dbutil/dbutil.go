@@ -0,0 +1,31 @@
+package dbutil
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// IntLen is the length of an integer encoded by EncodeInt
+const IntLen = 8
+
+// EncodeInt converts an integer, e.g. a Block index, into IntLen big-endian bytes with the sign bit flipped, so db keys
+// holding integers sort in numeric order (negative ones first) as badger compares them byte by byte. Decimal strings
+// and little-endian bytes don't, e.g. 1, 10, 100, 2
+func EncodeInt(num int) []byte {
+	buf := make([]byte, IntLen)
+	binary.BigEndian.PutUint64(buf, uint64(num)^(1<<63))
+	return buf
+}
+
+// DecodeInt converts IntLen bytes written by EncodeInt back into the integer
+func DecodeInt(data []byte) (int, error) {
+	if len(data) != IntLen {
+		return 0, fmt.Errorf("Encoded integer must be %d bytes, not %d", IntLen, len(data))
+	}
+	return int(binary.BigEndian.Uint64(data) ^ (1 << 63)), nil
+}
+
+// IntKey creates the db key of an integer under a prefix, with the integer encoded by EncodeInt
+func IntKey(prefix []byte, num int) []byte {
+	return append(append([]byte{}, prefix...), EncodeInt(num)...)
+}
@@ -2,47 +2,151 @@ package core
 
 import (
 	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/danitello/go-blockchain/common/errutil"
 	"github.com/danitello/go-blockchain/wallet"
 
 	"github.com/danitello/go-blockchain/chaindb"
 	"github.com/danitello/go-blockchain/core/types"
+	"github.com/dgraph-io/badger"
 )
 
 const (
 	genesisData = "Genesis"
+
+	// DefaultMaxReorgDepth is the most Blocks Reorganize will disconnect by default
+	DefaultMaxReorgDepth = 100
+)
+
+// networkKey is the db key -> value is the name of the network of the chain in the db, DefaultNetwork if missing
+var networkKey = []byte("network")
+
+var (
+	// ErrTxNotFound is returned when a Transaction cannot be found in the BlockChain
+	ErrTxNotFound = errors.New("Transaction not found")
+	// ErrOutputNotFound is returned for an outpoint whose Transaction has no txo at its idx
+	ErrOutputNotFound = errors.New("Transaction has no output at that index")
+	// ErrShuttingDown is returned when writing to a BlockChain that has begun to Shutdown
+	ErrShuttingDown = errors.New("BlockChain is shutting down")
+	// ErrWrongNetwork is returned when the db holds the chain of a network other than the Config's, by name or by
+	// GenesisHash
+	ErrWrongNetwork = errors.New("BlockChain in db belongs to a different network")
+	// ErrNoCoinbase is returned by ValidateBlock for a Block whose first tx is not its only coinbase tx
+	ErrNoCoinbase = errors.New("Block must start with its only coinbase transaction")
+	// ErrBadCoinbaseAmount is returned by ValidateBlock for a Block whose coinbase tx pays more than the block subsidy
+	// plus the fees of its other Transactions, or has a txo of a negative amount
+	ErrBadCoinbaseAmount = errors.New("Coinbase transaction pays more than the block subsidy and fees")
+	// ErrBadCoinbaseHeight is returned by ValidateBlock for a Block whose coinbase tx does not encode the Block's index
+	ErrBadCoinbaseHeight = errors.New("Coinbase transaction does not encode the block height")
+	// ErrReorgTooDeep is returned by Reorganize for a branch that would disconnect more than MaxReorgDepth Blocks
+	ErrReorgTooDeep = errors.New("Reorganization is deeper than the max reorg depth")
+	// ErrNotMoreWork is returned by Reorganize for a branch without more CumulativeWork than the chain it would replace
+	ErrNotMoreWork = errors.New("Branch does not have more work than the chain it would replace")
+	// ErrMissingUTXO is the Err of the OutpointError returned for a txin that doesn't spend an existing, unspent txo
+	// owned by its PubKey
+	ErrMissingUTXO = errors.New("Input does not spend an unspent output of its key")
+	// ErrBlockTooLarge is returned by ValidateBlock for a Block of more than MaxBlockSize bytes
+	ErrBlockTooLarge = errors.New("Block is larger than the max block size")
+	// ErrBadSignature is returned for a Transaction with a txin whose Signature does not verify
+	ErrBadSignature = errors.New("Transaction failed signature verification")
+	// ErrCheckpointMismatch is returned for a Block whose hash differs from the Checkpoint at its index
+	ErrCheckpointMismatch = errors.New("Block conflicts with a checkpoint")
 )
 
-// BlockChain is a complete blockchain
+// BlockChain is a complete blockchain -
+// Difficulty - difficulty new Blocks are mined at, or with RetargetInterval set the least they may be, see
+// NextDifficulty
+// Hasher - hashes the proof data of Blocks, both those mined and those validated
+// AddressParams - address scheme of the network, which addresses given to the BlockChain are validated under and
+// the Wallets it loads make addresses under
+// MinConfirmations - number of confirmations a utxo needs before GetUTXOWithPubKey selects it to spend
+// MaxReorgDepth - most Blocks Reorganize may disconnect
+// MaxBlockSize - most bytes a serialized Block may have
+// PrioritySize - bytes of each mined Block kept for Transactions of at least HighPriority, chosen by Priority before
+// any others, 0 for none
+// TargetBlockInterval - time Blocks are meant to take to mine, which the difficulty is adjusted towards every
+// RetargetInterval Blocks unless it is 0
+// MiningAddress - address rewarded by the coinbase tx of Blocks mined through GetWork
 type BlockChain struct {
-	Height   int
-	LastHash []byte
-	ChainDB  *chaindb.ChainDB
+	Height              int
+	LastHash            []byte
+	Difficulty          int
+	Hasher              types.Hasher
+	AddressParams       wallet.AddressParams
+	MinConfirmations    int
+	MaxReorgDepth       int
+	MaxBlockSize        int
+	PrioritySize        int
+	TargetBlockInterval time.Duration
+	RetargetInterval    int
+	MiningAddress       string
+	ChainDB             *chaindb.ChainDB
+	Mempool             *Mempool
+
+	mempoolFile string // path Shutdown saves the Mempool to, in the DataDir
+
+	// tipMu is held to read the tip consistently, and for writing while Blocks are connected or disconnected and the
+	// UTXO set is updated, so a Block mined on a tip can't be connected after another Block replaces it
+	tipMu sync.RWMutex
+
+	closeMu  sync.Mutex
+	closing  bool
+	inFlight sync.WaitGroup // db writes underway
+
+	hooksMu         sync.Mutex
+	connectHooks    []func(*types.Block)
+	disconnectHooks []func(*types.Block)
+
+	workMu   sync.Mutex
+	work     *WorkTemplate  // most recent result of GetWork
+	template *BlockTemplate // most recent result of GetBlockTemplate
+
+	syncMu     sync.Mutex
+	syncPeer   Peer               // Peer of the most recent SyncHeaders, which DownloadBodies gets Blocks from
+	bestHeader *types.BlockHeader // BlockHeader with the most CumulativeWork stored by SyncHeaders
 }
 
 // InitBlockChain instantiates a new instance of a BlockChain
 func InitBlockChain(address string) *BlockChain {
+	return InitBlockChainWithDifficulty(address, types.DefaultDifficulty)
+}
+
+// InitBlockChainWithDifficulty instantiates a new instance of a BlockChain whose Blocks are mined at a given difficulty
+func InitBlockChainWithDifficulty(address string, difficulty int) *BlockChain {
+	cfg := DefaultConfig()
+	cfg.Difficulty = difficulty
+	return InitBlockChainWithConfig(address, cfg)
+}
+
+// InitBlockChainWithConfig instantiates a new instance of a BlockChain in the DataDir of a Config, using its parameters
+func InitBlockChainWithConfig(address string, cfg *Config) *BlockChain {
+	errutil.Handle(cfg.Validate())
 
-	db := chaindb.InitDB()
-	resChain := &BlockChain{
-		Height:   0,
-		LastHash: []byte{0},
-		ChainDB:  db}
+	db := chaindb.InitDBWithCodec(cfg.DataDir, cfg.BlockCacheSize, cfg.codec())
+	resChain := newBlockChain(db, cfg)
 
 	// If a BlockChain can be found, use it, otherwise make a new one
 	if db.HasChain() {
-		log.Panic(fmt.Sprintf("BlockChain already exists in %s", chaindb.Dir))
+		log.Panic(fmt.Sprintf("BlockChain already exists in %s", cfg.DataDir))
 	} else {
-		genesisBlock := createGenesisBlock(address)
+		genesisBlock, err := createGenesisBlock(address, cfg.Difficulty, cfg.hasher())
+		errutil.Handle(err)
 		fmt.Println("Genesis block signed")
 
-		resChain.saveNewLastBlock(genesisBlock)
+		errutil.Handle(resChain.saveNewLastBlock(genesisBlock))
+		errutil.Handle(resChain.setSchemaVersion(SchemaVersion))
+		errutil.Handle(setNetwork(db, cfg.Network))
 	}
 
 	return resChain
@@ -51,56 +155,630 @@ func InitBlockChain(address string) *BlockChain {
 
 // GetBlockChain gets an existing BlockChain from the database
 func GetBlockChain() *BlockChain {
-	db := chaindb.InitDB()
+	return GetBlockChainWithConfig(DefaultConfig())
+}
+
+// GetBlockChainWithConfig gets an existing BlockChain from the DataDir of a Config, using its parameters. A db from an
+// older binary is upgraded with Migrate
+func GetBlockChainWithConfig(cfg *Config) *BlockChain {
+	errutil.Handle(cfg.Validate())
+
+	db := chaindb.InitDBWithCodec(cfg.DataDir, cfg.BlockCacheSize, cfg.codec())
 
 	if !db.HasChain() {
 		log.Panic("Error: No BlockChain exists")
 	}
-	resChain := &BlockChain{
-		Height:   0,
-		LastHash: []byte{0},
-		ChainDB:  db}
-	resChain.LastHash = db.ReadLastHash()
-	resChain.Height = db.ReadBlockWithHash(resChain.LastHash).Index + 1
+	resChain := newBlockChain(db, cfg)
+	if err := checkNetwork(db, cfg); err != nil {
+		db.CloseDB() // so the db can be opened again with the right Config
+		log.Panic(err)
+	}
+	lastHash, err := db.ReadLastHash()
+	errutil.Handle(err)
+	resChain.LastHash = lastHash
+	lastBlock, err := db.ReadBlockWithHash(resChain.LastHash)
+	errutil.Handle(err)
+	resChain.Height = lastBlock.Index + 1
+
+	// Upgrade a db written by an older binary, refusing one written by a newer binary
+	_, err = resChain.Migrate()
+	errutil.Handle(err)
+
+	// Finish a reindex that was interrupted so the UTXO set isn't left half built
+	if resChain.ReindexInProgress() {
+		log.Println("Resuming interrupted reindex")
+		errutil.Handle(resChain.Reindex(nil))
+	}
+	if resChain.TxReindexInProgress() {
+		log.Println("Resuming interrupted tx reindex")
+		_, err := resChain.ReindexTxs(nil)
+		errutil.Handle(err)
+	}
+
+	// Restore unconfirmed Transactions saved by Shutdown. Losing them isn't fatal, as they can be submitted again
+	if _, err := os.Stat(resChain.mempoolFile); err == nil {
+		discarded, err := resChain.Mempool.LoadFromFile(resChain.mempoolFile, func(tx *types.Transaction) (int, error) {
+			return resChain.checkTransaction(tx, resChain.Height, resChain.Mempool.pending())
+		})
+		if err != nil {
+			log.Printf("Could not load saved mempool %s: %s\n", resChain.mempoolFile, err)
+		} else if discarded > 0 {
+			log.Printf("Discarded %d mempool transactions that are no longer valid\n", discarded)
+		}
+	}
 
 	return resChain
 }
 
+// newBlockChain creates an empty BlockChain on a db with the parameters of a Config
+func newBlockChain(db *chaindb.ChainDB, cfg *Config) *BlockChain {
+	mempool := InitMempool()
+	mempool.MinRelayFee = cfg.MinRelayFee
+	mempool.DustThreshold = cfg.DustThreshold
+
+	return &BlockChain{
+		Height:              0,
+		LastHash:            []byte{0},
+		Difficulty:          cfg.Difficulty,
+		Hasher:              cfg.hasher(),
+		AddressParams:       cfg.addressParams(),
+		MinConfirmations:    cfg.MinConfirmations,
+		MaxReorgDepth:       cfg.MaxReorgDepth,
+		MaxBlockSize:        cfg.MaxBlockSize,
+		PrioritySize:        cfg.PrioritySize,
+		TargetBlockInterval: cfg.TargetBlockInterval,
+		RetargetInterval:    cfg.RetargetInterval,
+		ChainDB:             db,
+		Mempool:             mempool,
+		mempoolFile:         filepath.Join(cfg.DataDir, MempoolFile)}
+}
+
+// checkNetwork makes sure the chain in a db belongs to the Network of a Config, and starts with its GenesisHash if
+// one is given
+func checkNetwork(db *chaindb.ChainDB, cfg *Config) error {
+	network := DefaultNetwork // dbs written before networks were recorded
+	err := db.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(networkKey)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		v, err := item.Value()
+		network = string(v)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if network != cfg.Network {
+		return ErrWrongNetwork
+	}
+
+	expected := cfg.genesisHash()
+	if expected == nil {
+		return nil
+	}
+	genesisHash, err := db.ReadGenesisHash()
+	if err != nil {
+		return err
+	}
+	if bytes.Compare(genesisHash, expected) != 0 {
+		return ErrWrongNetwork
+	}
+
+	return nil
+}
+
+// setNetwork records the name of the network of the chain in a db
+func setNetwork(db *chaindb.ChainDB, network string) error {
+	return db.Database.Update(func(txn *badger.Txn) error {
+		return txn.Set(networkKey, []byte(network))
+	})
+}
+
 // AddBlock adds a new Block to a given BlockChain
-func (bc *BlockChain) AddBlock(txns []*types.Transaction) {
+func (bc *BlockChain) AddBlock(txns []*types.Transaction) error {
+	if err := bc.beginWrite(); err != nil {
+		return err
+	}
+	defer bc.inFlight.Done()
+	bc.tipMu.Lock()
+	defer bc.tipMu.Unlock()
+
 	// Create a new block and save it
-	newBlock := types.InitBlock(txns, bc.LastHash, bc.Height-1)
-	bc.saveNewLastBlock(newBlock)
+	difficulty, err := bc.nextDifficulty()
+	if err != nil {
+		return err
+	}
+	newBlock, err := types.InitBlockWithDifficulty(txns, bc.LastHash, bc.Height-1, difficulty, bc.Hasher)
+	if err != nil {
+		return err
+	}
+	if err := bc.ValidateBlock(newBlock); err != nil {
+		return err
+	}
+
+	return bc.saveNewLastBlock(newBlock)
+}
+
+// connectBlock validates a Block mined elsewhere and adds it as the next Block of the BlockChain
+func (bc *BlockChain) connectBlock(block *types.Block) error {
+	if err := bc.beginWrite(); err != nil {
+		return err
+	}
+	defer bc.inFlight.Done()
+	bc.tipMu.Lock()
+	defer bc.tipMu.Unlock()
+
+	return bc.connectTip(block)
+}
+
+// connectTip does the work of connectBlock for callers already registered with beginWrite and holding tipMu
+func (bc *BlockChain) connectTip(block *types.Block) error {
+	if err := bc.ValidateBlock(block); err != nil {
+		return err
+	}
+
+	return bc.saveNewLastBlock(block)
+}
+
+// ValidateBlock determines whether a Block can be added as the next Block of the BlockChain
+func (bc *BlockChain) ValidateBlock(block *types.Block) error {
+	if block.Index != bc.Height {
+		return fmt.Errorf("Block index %d does not follow height %d", block.Index, bc.Height)
+	}
+	if bytes.Compare(block.PrevHash, bc.LastHash) != 0 {
+		return errors.New("Block does not link to the most recent block")
+	}
+
+	// The header is cheap to check, so an invalid one is turned away before the Transactions are looked at
+	prev, err := bc.ChainDB.ReadHeaderWithHash(bc.LastHash)
+	if err != nil {
+		return err
+	}
+	difficulty, err := bc.difficultyAfter(prev, bc.ChainDB.ReadHeaderWithHash)
+	if err != nil {
+		return err
+	}
+	if block.Difficulty != difficulty {
+		return ErrBadDifficulty
+	}
+	if err := types.ValidateBlockHeader(block.Header(), prev, bc.Hasher); err != nil {
+		return err
+	}
+	if block.Size() > bc.MaxBlockSize {
+		return ErrBlockTooLarge
+	}
+	if ok, _ := checkCheckpoint(block.Index, block.Hash); !ok {
+		return ErrCheckpointMismatch
+	}
+
+	if err := validateCoinbase(block); err != nil {
+		return err
+	}
+
+	// Every other tx must be valid against the UTXO set and the txs before it in the Block, and not spend the same txo
+	// as another tx in the Block
+	spent := make(map[string]bool)
+	pending := make(map[string]*types.Transaction)
+	fees := 0
+	for _, tx := range block.Transactions[1:] {
+		fee, err := bc.checkTransaction(tx, block.Index, pending)
+		if err != nil {
+			return fmt.Errorf("Transaction %x: %s", tx.ID, err)
+		}
+		for _, txin := range tx.Inputs {
+			if spent[outpoint(txin)] {
+				return fmt.Errorf("Transaction %x: %s", tx.ID, ErrMempoolConflict)
+			}
+			spent[outpoint(txin)] = true
+		}
+		pending[hex.EncodeToString(tx.ID)] = tx
+		fees += fee
+	}
+
+	// The coinbase tx may claim the fees
+	return validateCoinbaseAmount(block.Transactions[0], types.BlockSubsidy(block.Index)+fees)
+}
+
+// validateCoinbaseAmount determines whether a coinbase tx pays at most allowed. Each txo is checked against what is
+// left, so negative amounts can't offset an over-claim and the sum can't overflow
+func validateCoinbaseAmount(coinbase *types.Transaction, allowed int) error {
+	left := allowed
+	for _, txo := range coinbase.Outputs {
+		if txo.Amount < 0 || txo.Amount > left {
+			return ErrBadCoinbaseAmount
+		}
+		left -= txo.Amount
+	}
+
+	return nil
+}
+
+// validateCoinbase determines whether the first tx of a Block is its only coinbase tx and encodes the Block's index
+func validateCoinbase(block *types.Block) error {
+	if len(block.Transactions) == 0 || !block.Transactions[0].IsCoinbase() {
+		return ErrNoCoinbase
+	}
+	for _, tx := range block.Transactions[1:] {
+		if tx.IsCoinbase() {
+			return ErrNoCoinbase
+		}
+	}
+
+	if height, err := block.Transactions[0].CoinbaseHeight(); err != nil || height != block.Index {
+		return ErrBadCoinbaseHeight
+	}
+
+	return nil
+}
+
+// Tip gets the hash and index of the most recent Block, read together so they agree even while Blocks are being added
+func (bc *BlockChain) Tip() ([]byte, int) {
+	bc.tipMu.RLock()
+	defer bc.tipMu.RUnlock()
+
+	return bc.LastHash, bc.Height - 1
+}
+
+// beginWrite registers a db write so Shutdown waits for it, failing if Shutdown has begun or the db is read-only.
+// Callers must call bc.inFlight.Done() when the write is finished
+func (bc *BlockChain) beginWrite() error {
+	if bc.ChainDB.IsReadOnly() {
+		return chaindb.ErrReadOnly
+	}
+
+	bc.closeMu.Lock()
+	defer bc.closeMu.Unlock()
+
+	if bc.closing {
+		return ErrShuttingDown
+	}
+	bc.inFlight.Add(1)
+
+	return nil
 }
 
-// saveNewLastBlock saves the new Block to db, and updates BlockChain struct
-func (bc *BlockChain) saveNewLastBlock(newBlock *types.Block) {
+// Shutdown stops the BlockChain from accepting new Blocks, waits for writes underway to finish, stops Mempool expiry,
+// saves the Mempool, and closes the db.
+// If ctx is done before the writes finish, the db is left open and an error is returned
+func (bc *BlockChain) Shutdown(ctx context.Context) error {
+	bc.closeMu.Lock()
+	bc.closing = true
+	bc.closeMu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		bc.inFlight.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		return fmt.Errorf("Shutdown did not complete: %s", ctx.Err())
+	}
+
+	bc.Mempool.StopExpiry()
+	if err := bc.Mempool.SaveToFile(bc.mempoolFile); err != nil {
+		return err
+	}
+	bc.ChainDB.CloseDB()
 
-	// Update DB
-	bc.ChainDB.WriteNewLastBlock(newBlock)
+	return nil
+}
+
+// saveNewLastBlock saves the new Block to db, and updates BlockChain struct. The Block, the last hash, the UTXO set
+// and every index are written in one db transaction, so a failure leaves none of them changed, and the BlockChain
+// struct is only updated once it has been committed
+func (bc *BlockChain) saveNewLastBlock(newBlock *types.Block) error {
+	if bc.ChainDB.IsReadOnly() {
+		return chaindb.ErrReadOnly
+	}
+	work, err := bc.headerWork(newBlock.Header())
+	if err != nil {
+		return err
+	}
+
+	// The UTXO set is updated incrementally, as pruned Blocks can't be reindexed
+	err = bc.ChainDB.Database.Update(func(txn *badger.Txn) error {
+		if err := bc.ChainDB.WriteNewLastBlockWithTxn(txn, newBlock); err != nil {
+			return err
+		}
+		if err := bc.updateUTXOSet(txn, newBlock); err != nil {
+			return err
+		}
+		if err := storeWork(txn, newBlock.Hash, work); err != nil {
+			return err
+		}
+		if err := recordFeeRate(txn, newBlock); err != nil {
+			return err
+		}
+		if err := indexHeight(txn, newBlock); err != nil {
+			return err
+		}
+		return indexTransactions(txn, newBlock)
+	})
+	if err != nil {
+		return err
+	}
 
 	// Update chain
 	bc.LastHash = newBlock.Hash
-	//bc.UpdateUTXOSet(newBlock)
-	bc.Reindex()
+	bc.Height = newBlock.Index + 1
+	bc.Mempool.RemoveForBlock(newBlock)
+
+	bc.runHooks(&bc.connectHooks, newBlock)
+
+	return nil
+}
+
+// DisconnectTip rolls the BlockChain back by one Block, undoing its changes to the UTXO set. The Block stays in the
+// db, but is no longer part of the chain. Returns the disconnected Block
+func (bc *BlockChain) DisconnectTip() (*types.Block, error) {
+	if err := bc.beginWrite(); err != nil {
+		return nil, err
+	}
+	defer bc.inFlight.Done()
+	bc.tipMu.Lock()
+	defer bc.tipMu.Unlock()
+
+	return bc.disconnectTip()
+}
+
+// disconnectTip does the work of DisconnectTip for callers already registered with beginWrite and holding tipMu
+func (bc *BlockChain) disconnectTip() (*types.Block, error) {
+	block, err := bc.ChainDB.ReadBlockWithHash(bc.LastHash)
+	if err != nil {
+		return nil, err
+	}
+	if len(block.PrevHash) == 0 {
+		return nil, errors.New("Cannot disconnect the genesis block")
+	}
+
+	err = bc.ChainDB.Database.Update(func(txn *badger.Txn) error {
+		if err := bc.undoUTXOSet(txn, block); err != nil {
+			return err
+		}
+		if err := unindexTransactions(txn, block); err != nil {
+			return err
+		}
+		if err := dropFeeRates(txn, block.Index); err != nil {
+			return err
+		}
+		if err := unindexHeight(txn, block.Index); err != nil {
+			return err
+		}
+		if err := addSideBlock(txn, block.Header(), false); err != nil {
+			return err
+		}
+		return txn.Set([]byte(chaindb.LastHashKey), block.PrevHash)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bc.LastHash = block.PrevHash
+	bc.Height = block.Index
+	bc.ChainDB.InvalidateCache(block.Hash) // off the chain, so unlikely to be read again
+	bc.runHooks(&bc.disconnectHooks, block)
+
+	return block, nil
+}
+
+// Reorganize switches the BlockChain to a competing branch - Blocks following one of the BlockChain's Blocks (the
+// fork point), oldest first. The branch must have more CumulativeWork than the Blocks it replaces, though it may be
+// shorter, and may not replace more than MaxReorgDepth of them, or it is recorded as a fork for ChainTips. If a branch
+// Block is invalid the BlockChain is restored to how it was. Otherwise the Transactions of the replaced Blocks that
+// aren't on the branch go back into the Mempool (see returnToMempool)
+func (bc *BlockChain) Reorganize(branch []*types.Block) error {
+	if len(branch) == 0 {
+		return errors.New("Branch is empty")
+	}
+	if err := bc.beginWrite(); err != nil {
+		return err
+	}
+	defer bc.inFlight.Done()
+	bc.tipMu.Lock()
+	defer bc.tipMu.Unlock()
+
+	forkPoint, err := bc.ChainDB.ReadHeaderWithHash(branch[0].PrevHash)
+	if err != nil {
+		return fmt.Errorf("Branch does not connect to the chain: %s", err)
+	}
+	depth := bc.Height - 1 - forkPoint.Index
+	branchWork, err := bc.CumulativeWork(forkPoint.Hash)
+	if err != nil {
+		return err
+	}
+	// The branch isn't stored yet, so the ancestors of its Blocks are looked for on it before the db
+	branchHeaders := make(map[string]*types.BlockHeader)
+	branchHeader := func(hash []byte) (*types.BlockHeader, error) {
+		if header, ok := branchHeaders[string(hash)]; ok {
+			return header, nil
+		}
+		return bc.ChainDB.ReadHeaderWithHash(hash)
+	}
+	prev := forkPoint
+	for _, block := range branch {
+		// Checked before the work is counted, so a Block can't claim more work than the chain requires
+		difficulty, err := bc.difficultyAfter(prev, branchHeader)
+		if err != nil {
+			return err
+		}
+		if block.Difficulty != difficulty {
+			return fmt.Errorf("Branch block %x is invalid: %s", block.Hash, ErrBadDifficulty)
+		}
+		branchWork.Add(branchWork, BlockWork(block.Difficulty))
+		prev = block.Header()
+		branchHeaders[string(block.Hash)] = prev
+	}
+	if depth > bc.MaxReorgDepth {
+		log.Printf("Rejected branch forking %d blocks below the tip\n", depth)
+		if err := bc.addSideBranch(forkPoint, branch); err != nil {
+			return err
+		}
+		return ErrReorgTooDeep
+	}
+	chainWork, err := bc.CumulativeWork(bc.LastHash)
+	if err != nil {
+		return err
+	}
+	if branchWork.Cmp(chainWork) <= 0 {
+		if err := bc.addSideBranch(forkPoint, branch); err != nil {
+			return err
+		}
+		return ErrNotMoreWork
+	}
+
+	// The fork point must be on the chain, not on some other branch
+	iter := bc.iterator()
+	for i := 0; i < depth; i++ {
+		iter.Next()
+	}
+	if bytes.Compare(iter.currentHash, forkPoint.Hash) != 0 {
+		return errors.New("Branch does not connect to the chain")
+	}
+
+	var disconnected []*types.Block // newest first
+	for i := 0; i < depth; i++ {
+		block, err := bc.disconnectTip()
+		if err != nil {
+			return err
+		}
+		disconnected = append(disconnected, block)
+	}
+
+	for connected, block := range branch {
+		if err := bc.ValidateBlock(block); err != nil {
+			errutil.Handle(bc.ChainDB.Database.Update(func(txn *badger.Txn) error {
+				return addSideBlock(txn, block.Header(), true)
+			}))
+
+			bc.restoreBlocks(connected, disconnected)
+			return fmt.Errorf("Branch block %x is invalid: %s", block.Hash, err)
+		}
+		if err := bc.saveNewLastBlock(block); err != nil {
+			bc.restoreBlocks(connected, disconnected)
+			return err
+		}
+	}
+	bc.returnToMempool(disconnected)
+
+	return nil
+}
+
+// restoreBlocks puts back the Blocks Reorganize disconnected (newest first) once connected Blocks of the branch have
+// been connected in their place
+func (bc *BlockChain) restoreBlocks(connected int, disconnected []*types.Block) {
+	for i := 0; i < connected; i++ {
+		_, err := bc.disconnectTip()
+		errutil.Handle(err)
+	}
+	for i := len(disconnected) - 1; i >= 0; i-- {
+		errutil.Handle(bc.saveNewLastBlock(disconnected[i]))
+	}
+}
+
+// returnToMempool puts the Transactions of Blocks disconnected by Reorganize (newest first) back into the Mempool,
+// oldest first so a Transaction comes after those whose txos it spends. Those confirmed again on the new branch stay
+// out, as they were taken out of the Mempool when it was connected. Those no longer valid are dropped along with the
+// Mempool Transactions spending their txos, as are those spending the txos of the disconnected coinbase txs.
+// Returns the number of Transactions put back
+func (bc *BlockChain) returnToMempool(disconnected []*types.Block) int {
+	returned := 0
+
+	for i := len(disconnected) - 1; i >= 0; i-- {
+		for _, tx := range disconnected[i].Transactions {
+			if _, ok := bc.findTransaction(tx.ID); ok {
+				continue // on the new branch
+			}
+
+			var err error
+			if tx.IsCoinbase() {
+				err = errors.New("Coinbase transactions cannot be submitted")
+			} else {
+				var fee int
+				if fee, err = bc.checkTransaction(tx, bc.Height, bc.Mempool.pending()); err == nil {
+					err = bc.Mempool.Add(tx, fee)
+				}
+			}
+
+			if err != nil {
+				if dropped := bc.Mempool.RemoveDescendants(tx.ID); len(dropped) > 0 {
+					log.Printf("Dropped %d mempool transactions spending %x, which is no longer valid\n",
+						len(dropped), tx.ID)
+				}
+				continue
+			}
+			returned++
+		}
+	}
+
+	return returned
+}
+
+// OnConnect registers a func to be called with each Block added to the BlockChain, after the UTXO set is updated.
+// Hooks run synchronously, in the order Blocks are connected and disconnected, while no other Block can be, so they
+// must not add or disconnect Blocks themselves
+func (bc *BlockChain) OnConnect(hook func(*types.Block)) {
+	bc.hooksMu.Lock()
+	defer bc.hooksMu.Unlock()
 
+	bc.connectHooks = append(bc.connectHooks, hook)
+}
+
+// OnDisconnect registers a func to be called with each Block rolled back by DisconnectTip
+func (bc *BlockChain) OnDisconnect(hook func(*types.Block)) {
+	bc.hooksMu.Lock()
+	defer bc.hooksMu.Unlock()
+
+	bc.disconnectHooks = append(bc.disconnectHooks, hook)
+}
+
+// runHooks calls each of the registered hooks with a Block
+func (bc *BlockChain) runHooks(registered *[]func(*types.Block), block *types.Block) {
+	bc.hooksMu.Lock()
+	hooks := append([]func(*types.Block){}, *registered...) // copied, as hooks may register more hooks
+	bc.hooksMu.Unlock()
+
+	for _, hook := range hooks {
+		hook(block)
+	}
 }
 
 // createGenesisBlock creates the first Block
-func createGenesisBlock(address string) *types.Block {
-	cbtx := types.CoinbaseTx(address)
-	return types.InitBlock([]*types.Transaction{cbtx}, []byte{}, -1) // prevHash empty
+func createGenesisBlock(address string, difficulty int, hasher types.Hasher) (*types.Block, error) {
+	cbtx := types.CoinbaseTx(address, 0)
+	return types.InitBlockWithDifficulty([]*types.Transaction{cbtx}, []byte{}, -1, difficulty, hasher) // prevHash empty
+}
+
+// GetUTXO gets the all the utxos in the chain. Fails with ErrBlockPruned if the chain has been pruned
+func (bc *BlockChain) GetUTXO() (map[string]types.TxOutputs, error) {
+	bc.tipMu.RLock()
+	defer bc.tipMu.RUnlock()
+
+	return bc.getUTXO(nil)
 }
 
-// GetUTXO gets the all the utxos in the chain
-func (bc *BlockChain) GetUTXO() map[string]types.TxOutputs {
+// getUTXO gets all the utxos in the chain, calling progress (if not nil) after each Block is scanned. Callers must
+// hold tipMu
+func (bc *BlockChain) getUTXO(progress func(done, total int)) (map[string]types.TxOutputs, error) {
+	done := 0
 	UTXO := make(map[string]types.TxOutputs)
 	spentTXO := make(map[string][]int)
-	iter := bc.Iterator()
+	iter := bc.iterator()
 
 	for {
 		block := iter.Next()
 
+		// Every full Block has at least a coinbase tx, so this one was pruned
+		if len(block.Transactions) == 0 {
+			return nil, chaindb.ErrBlockPruned
+		}
+
 		for _, tx := range block.Transactions {
 			txID := hex.EncodeToString(tx.ID)
 
@@ -115,7 +793,8 @@ func (bc *BlockChain) GetUTXO() map[string]types.TxOutputs {
 					}
 				}
 				txos := UTXO[txID]
-				txos.Outputs = append(txos.Outputs, txo)
+				txos.Height = block.Index
+				txos.Add(txo, outIdx)
 				UTXO[txID] = txos
 			}
 
@@ -127,39 +806,279 @@ func (bc *BlockChain) GetUTXO() map[string]types.TxOutputs {
 			}
 		}
 
+		done++
+		if progress != nil {
+			progress(done, bc.Height)
+		}
+
 		if len(block.PrevHash) == 0 {
 			break
 		}
 	}
 
-	return UTXO
+	return UTXO, nil
+}
+
+// VerifySupply audits the UTXO set to confirm no more than MaxSupply coins exist
+func (bc *BlockChain) VerifySupply() error {
+	if supply := bc.GetSupply(); supply > types.MaxSupply {
+		return fmt.Errorf("Supply of %d exceeds max supply of %d", supply, types.MaxSupply)
+	}
+
+	return nil
 }
 
 // CreateTransaction makes a new Transaction to be added to a Block
 func (bc *BlockChain) CreateTransaction(from, to string, amount int) *types.Transaction {
 	// Get wallet info using address
-	wallets, err := wallet.InitWallets()
+	wallets, err := wallet.InitWalletsWithParams(bc.AddressParams)
 	errutil.Handle(err)
 	w := wallets.GetWallet(from)
-	pubKeyHash := wallet.HashPubKey(w.PublicKey)
+	pubKeyHash := wallet.HashPubKey(w.GetPubKey())
 
 	utxos, txoSum := bc.GetUTXOWithPubKey(pubKeyHash, amount)
-	newTx := types.CreateTransaction(from, to, pubKeyHash, amount, txoSum, utxos)
-	bc.SignTransaction(newTx, w.PrivateKey)
+	newTx := types.CreateTransactionWithFee(from, to, w.GetPubKey(), amount, bc.foldDust(txoSum, amount, 0), txoSum, utxos)
+	bc.SignTransactionWithKey(newTx, w.PrivateKey)
 	return newTx
 }
 
-// SignTransaction gathers necessary data and initiates the flow for signing a tx
-func (bc *BlockChain) SignTransaction(tx *types.Transaction, privKey ecdsa.PrivateKey) {
-	prevTxs := make(map[string]types.Transaction)
+// foldDust gets the fee for a Transaction spending txoSum to pay amount and fee, raised to include the change if the
+// change would be a txo below the Mempool's DustThreshold
+func (bc *BlockChain) foldDust(txoSum, amount, fee int) int {
+	if change := txoSum - amount - fee; change > 0 && change < bc.Mempool.DustThreshold {
+		return fee + change
+	}
+	return fee
+}
+
+// CreateTransactionFromInputs makes a new Transaction to be added to a Block that spends exactly the chosen txos,
+// which must be unspent and owned by from, and must cover amount plus fee
+func (bc *BlockChain) CreateTransactionFromInputs(from, to string, amount, fee int, chosen []types.Outpoint) (*types.Transaction, error) {
+	wallets, err := wallet.InitWalletsWithParams(bc.AddressParams)
+	if err != nil {
+		return nil, err
+	}
+
+	return bc.createTransactionFromInputs(wallets, from, to, amount, fee, chosen)
+}
+
+// createTransactionFromInputs is CreateTransactionFromInputs with the Wallets holding from already loaded
+func (bc *BlockChain) createTransactionFromInputs(wallets *wallet.Wallets, from, to string, amount, fee int, chosen []types.Outpoint) (*types.Transaction, error) {
+	if !bc.AddressParams.ValidateAddress(to) {
+		return nil, errors.New("Invalid to address")
+	}
+	if amount <= 0 || fee < 0 {
+		return nil, errors.New("Amount must be positive and fee must not be negative")
+	}
+	if amount < bc.Mempool.DustThreshold {
+		return nil, ErrDustOutput
+	}
+
+	if _, ok := wallets.Wallets[from]; !ok {
+		return nil, fmt.Errorf("No wallet for address %s", from)
+	}
+	w := wallets.GetWallet(from)
+	pubKeyHash := wallet.HashPubKey(w.GetPubKey())
+
+	utxos := make(map[string][]int)
+	txoSum := 0
+	seen := make(map[string]bool)
+	for _, op := range chosen {
+		key := fmt.Sprintf("%x:%d", op.TxID, op.OutputIdx)
+		if seen[key] {
+			return nil, types.ErrDuplicateInput
+		}
+		seen[key] = true
+
+		txo, ok := bc.GetUTXOWithOutpoint(op.TxID, op.OutputIdx)
+		if !ok {
+			return nil, fmt.Errorf("Output %d of transaction %x is spent or does not exist", op.OutputIdx, op.TxID)
+		}
+		if !txo.IsLockedWithKey(pubKeyHash) {
+			return nil, fmt.Errorf("Output %d of transaction %x is not owned by %s", op.OutputIdx, op.TxID, from)
+		}
+
+		txID := hex.EncodeToString(op.TxID)
+		utxos[txID] = append(utxos[txID], op.OutputIdx)
+		txoSum += txo.Amount
+	}
+	if txoSum < amount+fee {
+		return nil, fmt.Errorf("Chosen outputs of %d do not cover amount %d plus fee %d", txoSum, amount, fee)
+	}
+
+	newTx := types.CreateTransactionWithFee(from, to, w.GetPubKey(), amount, bc.foldDust(txoSum, amount, fee), txoSum, utxos)
+	bc.SignTransactionWithKey(newTx, w.PrivateKey)
+	return newTx, nil
+}
+
+// CreateTransactionToHash makes a new Transaction to be added to a Block paying amount plus fee from the utxos of
+// from, locking the txo paid directly with a pub key hash, e.g. for tooling that has no address
+func (bc *BlockChain) CreateTransactionToHash(from string, toPubKeyHash []byte, amount, fee int) (*types.Transaction, error) {
+	wallets, err := wallet.InitWalletsWithParams(bc.AddressParams)
+	if err != nil {
+		return nil, err
+	}
+
+	return bc.createTransactionToHash(wallets, from, toPubKeyHash, amount, fee)
+}
 
+// createTransactionToHash does the work of CreateTransactionToHash with the keys in ws
+func (bc *BlockChain) createTransactionToHash(ws *wallet.Wallets, from string, toPubKeyHash []byte, amount, fee int) (*types.Transaction, error) {
+	if len(toPubKeyHash) != types.PubKeyHashLen {
+		return nil, fmt.Errorf("Pub key hash must be %d bytes, not %d", types.PubKeyHashLen, len(toPubKeyHash))
+	}
+	if amount <= 0 || fee < 0 {
+		return nil, errors.New("Amount must be positive and fee must not be negative")
+	}
+	if amount < bc.Mempool.DustThreshold {
+		return nil, ErrDustOutput
+	}
+	if _, ok := ws.Wallets[from]; !ok {
+		return nil, fmt.Errorf("No wallet for address %s", from)
+	}
+	w := ws.GetWallet(from)
+
+	utxos, txoSum := bc.GetUTXOWithPubKey(wallet.HashPubKey(w.GetPubKey()), amount+fee)
+	if txoSum < amount+fee {
+		return nil, fmt.Errorf("Funds of %d do not cover amount %d plus fee %d", txoSum, amount, fee)
+	}
+
+	newTx := types.CreateTransactionToHash(from, toPubKeyHash, w.GetPubKey(), amount, bc.foldDust(txoSum, amount, fee), txoSum, utxos)
+	bc.SignTransactionWithKey(newTx, w.PrivateKey)
+	return newTx, nil
+}
+
+// RotateKey creates a new Wallet in ws and a Transaction, signed with the key of oldAddress, sweeping every utxo of
+// oldAddress to it less a fee of Mempool.MinRelayFee per byte. Returns the new address and the Transaction to submit.
+// ws is not saved, which the caller must do before submitting so the new key can't be lost
+func (bc *BlockChain) RotateKey(ws *wallet.Wallets, oldAddress string) (string, *types.Transaction, error) {
+	old, ok := ws.Wallets[oldAddress]
+	if !ok {
+		return "", nil, fmt.Errorf("No wallet for address %s", oldAddress)
+	}
+	pubKeyHash := wallet.HashPubKey(old.GetPubKey())
+
+	utxos, txoSum := bc.GetUTXOWithPubKey(pubKeyHash, math.MaxInt32)
+	if txoSum == 0 {
+		return "", nil, fmt.Errorf("No funds to move from %s", oldAddress)
+	}
+
+	newAddress := ws.CreateWallet(old.Compressed)
+
+	// Size the fee with the whole sum going to the new address, which can only shrink once the fee comes out
+	sweep := types.CreateTransaction(oldAddress, newAddress, old.GetPubKey(), txoSum, txoSum, utxos)
+	fee := bc.Mempool.MinRelayFee * sweep.EstimateSize()
+	if fee >= txoSum {
+		delete(ws.Wallets, newAddress)
+		return "", nil, fmt.Errorf("Funds of %d do not cover the fee of %d", txoSum, fee)
+	}
+
+	newTx := types.CreateTransactionWithFee(oldAddress, newAddress, old.GetPubKey(), txoSum-fee, fee, txoSum, utxos)
+	bc.SignTransactionWithKey(newTx, old.PrivateKey)
+
+	return newAddress, newTx, nil
+}
+
+// CreateCPFPTransaction makes a new Transaction that speeds up a Transaction stuck in the Mempool (child pays for
+// parent) by spending its change txo back to the same address with a given fee, so the two together pay more per byte.
+// The change txo must be locked with the key that signed the parent, held in the wallet file, and not yet spent
+func (bc *BlockChain) CreateCPFPTransaction(parentTxID []byte, changeIndex, extraFee int) (*types.Transaction, error) {
+	wallets, err := wallet.InitWalletsWithParams(bc.AddressParams)
+	if err != nil {
+		return nil, err
+	}
+
+	return bc.createCPFPTransaction(wallets, parentTxID, changeIndex, extraFee)
+}
+
+// createCPFPTransaction does the work of CreateCPFPTransaction with the keys in ws
+func (bc *BlockChain) createCPFPTransaction(ws *wallet.Wallets, parentTxID []byte, changeIndex, extraFee int) (*types.Transaction, error) {
+	parent, ok := bc.Mempool.Get(parentTxID)
+	if !ok {
+		return nil, fmt.Errorf("Transaction %x is not in the mempool", parentTxID)
+	}
+	if changeIndex < 0 || changeIndex >= len(parent.Outputs) {
+		return nil, fmt.Errorf("Output %d of transaction %x does not exist", changeIndex, parentTxID)
+	}
+	if extraFee <= 0 {
+		return nil, errors.New("Fee must be positive")
+	}
+
+	change := parent.Outputs[changeIndex]
+	if !parent.Inputs[0].UsesKey(change.PubKeyHash) {
+		return nil, fmt.Errorf("Output %d of transaction %x is not change", changeIndex, parentTxID)
+	}
+	if bc.Mempool.IsSpent(parentTxID, changeIndex) {
+		return nil, fmt.Errorf("Output %d of transaction %x is already spent", changeIndex, parentTxID)
+	}
+	if change.Amount-extraFee < bc.Mempool.DustThreshold {
+		return nil, fmt.Errorf("Change of %d does not cover the fee of %d", change.Amount, extraFee)
+	}
+
+	for _, address := range ws.GetAddresses() {
+		w := ws.GetWallet(address)
+		if !change.IsLockedWithKey(wallet.HashPubKey(w.GetPubKey())) {
+			continue
+		}
+
+		utxos := map[string][]int{hex.EncodeToString(parentTxID): {changeIndex}}
+		newTx := types.CreateTransactionWithFee(address, address, w.GetPubKey(), change.Amount-extraFee, extraFee, change.Amount, utxos)
+		bc.SignTransactionWithKey(newTx, w.PrivateKey)
+		return newTx, nil
+	}
+
+	return nil, fmt.Errorf("No wallet holds the key of output %d of transaction %x", changeIndex, parentTxID)
+}
+
+// SignTransactionWithKey gathers necessary data and initiates the flow for signing a tx with a private key
+func (bc *BlockChain) SignTransactionWithKey(tx *types.Transaction, privKey ecdsa.PrivateKey) {
+	prevTxs, err := bc.getPrevTransactionsFromUTXO(tx, bc.Mempool.pending())
+	errutil.Handle(err)
+
+	tx.Sign(privKey, prevTxs, types.SigHashAll)
+}
+
+// SignTransaction signs every txin of a tx with the key of fromAddress in ws, finding the Transactions whose
+// txos they spend in the Mempool or through the tx index. Fails without signing if fromAddress has no Wallet in ws, its
+// key was wiped with Wallet.Zero, or a txin spends a txo that can't be found or isn't locked with that key
+func (bc *BlockChain) SignTransaction(tx *types.Transaction, ws *wallet.Wallets, fromAddress string) error {
+	w, ok := ws.Wallets[fromAddress]
+	if !ok {
+		return fmt.Errorf("No wallet for address %s", fromAddress)
+	}
+	if w.Zeroed() {
+		return fmt.Errorf("Wallet for address %s has been wiped", fromAddress)
+	}
+
+	prevTxs := make(map[string]types.Transaction)
+	pending := bc.Mempool.pending()
 	for _, txin := range tx.Inputs {
-		prevTx, err := bc.GetTransactionWithID(txin.TxID)
-		errutil.Handle(err)
-		prevTxs[hex.EncodeToString(prevTx.ID)] = prevTx
+		txID := hex.EncodeToString(txin.TxID)
+		outpoint := types.Outpoint{TxID: txin.TxID, OutputIdx: txin.OutputIdx}
+
+		prevTx, ok := prevTxs[txID]
+		if !ok {
+			if pendingTx, inMempool := pending[txID]; inMempool {
+				prevTx = *pendingTx
+			} else {
+				var err error
+				if prevTx, err = bc.GetTransactionWithID(txin.TxID); err != nil {
+					return &OutpointError{outpoint, err}
+				}
+			}
+			prevTxs[txID] = prevTx
+		}
+
+		if txin.OutputIdx < 0 || txin.OutputIdx >= len(prevTx.Outputs) {
+			return &OutpointError{outpoint, ErrMissingUTXO}
+		}
+		if !prevTx.Outputs[txin.OutputIdx].CanBeSpentBy(txin, tx.LockTime) || !txin.UsesKey(wallet.HashPubKey(w.GetPubKey())) {
+			return &OutpointError{outpoint, fmt.Errorf("Output is not spendable by %s", fromAddress)}
+		}
 	}
 
-	tx.Sign(privKey, prevTxs)
+	tx.Sign(w.PrivateKey, prevTxs, types.SigHashAll)
+	return nil
 }
 
 // VerifyTransaction gathers necessary data and initiates the flow for verifying a tx
@@ -168,19 +1087,192 @@ func (bc *BlockChain) VerifyTransaction(tx *types.Transaction) bool {
 		return true
 	}
 
+	prevTxs, err := bc.getPrevTransactions(tx)
+	errutil.Handle(err)
+
+	return tx.Verify(prevTxs)
+}
+
+// getPrevTransactions gets the Transactions containing the txos referenced by the txins of a given tx
+func (bc *BlockChain) getPrevTransactions(tx *types.Transaction) (map[string]types.Transaction, error) {
 	prevTxs := make(map[string]types.Transaction)
 
 	for _, txin := range tx.Inputs {
 		prevTx, err := bc.GetTransactionWithID(txin.TxID)
-		errutil.Handle(err)
+		if err != nil {
+			return nil, err
+		}
 		prevTxs[hex.EncodeToString(prevTx.ID)] = prevTx
 	}
 
-	return tx.Verify(prevTxs)
+	return prevTxs, nil
+}
+
+// getPrevTransactionsFromUTXO builds stand-ins for the Transactions containing the txos referenced by the txins of
+// a given tx from the UTXO set, holding just the referenced txos. Unlike getPrevTransactions this works on a pruned
+// chain, but only for txins spending utxos -
+// pending - Transactions not yet in the BlockChain (keyed by hex ID) whose txos may also be spent, e.g. those in the
+// Mempool
+func (bc *BlockChain) getPrevTransactionsFromUTXO(tx *types.Transaction, pending map[string]*types.Transaction) (map[string]types.Transaction, error) {
+	prevTxs := make(map[string]types.Transaction)
+
+	for _, txin := range tx.Inputs {
+		txID := hex.EncodeToString(txin.TxID)
+		missing := &OutpointError{types.Outpoint{TxID: txin.TxID, OutputIdx: txin.OutputIdx}, ErrMissingUTXO}
+		if pendingTx, ok := pending[txID]; ok {
+			if txin.OutputIdx < 0 || txin.OutputIdx >= len(pendingTx.Outputs) {
+				return nil, missing
+			}
+			if !pendingTx.Outputs[txin.OutputIdx].CanBeSpentBy(txin, tx.LockTime) {
+				return nil, missing
+			}
+			prevTxs[txID] = *pendingTx
+			continue
+		}
+
+		txo, ok := bc.GetUTXOWithOutpoint(txin.TxID, txin.OutputIdx)
+		if !ok || !txo.CanBeSpentBy(txin, tx.LockTime) {
+			return nil, missing
+		}
+
+		prevTx := prevTxs[txID]
+		prevTx.ID = txin.TxID
+		for len(prevTx.Outputs) <= txin.OutputIdx {
+			prevTx.Outputs = append(prevTx.Outputs, types.TxOutput{})
+		}
+		prevTx.Outputs[txin.OutputIdx] = txo
+		prevTxs[txID] = prevTx
+	}
+
+	return prevTxs, nil
 }
 
-// GetTransactionWithID searches the bc for a Transaction with a given ID
+// SubmitRawTransaction decodes a hex encoded, signed Transaction and submits it with SubmitTransaction. Returns the
+// tx ID
+func (bc *BlockChain) SubmitRawTransaction(hexStr string) ([]byte, error) {
+	tx, err := types.DecodeRawTransaction(hexStr)
+	if err != nil {
+		return nil, err
+	}
+	if err := bc.SubmitTransaction(tx); err != nil {
+		return nil, err
+	}
+
+	return tx.ID, nil
+}
+
+// SubmitTransaction checks a signed Transaction and adds it to the Mempool
+func (bc *BlockChain) SubmitTransaction(tx *types.Transaction) error {
+	_, tip := bc.Tip()
+	fee, err := bc.checkTransaction(tx, tip+1, bc.Mempool.pending())
+	if err != nil {
+		return err
+	}
+
+	return bc.Mempool.Add(tx, fee)
+}
+
+// IsPermanentTxError determines whether an error from SubmitTransaction means no Transaction with the same ID can ever
+// be accepted, as with a SanityCheck failure. Others may pass later or with different Signatures, which the ID doesn't
+// cover, e.g. a txin spending the txo of a Transaction that hasn't arrived yet, or a bad Signature
+func IsPermanentTxError(err error) bool {
+	for _, permanent := range []error{types.ErrNoInputs, types.ErrNoOutputs, types.ErrNegativeAmount,
+		types.ErrDuplicateInput, types.ErrTooManyInputs, types.ErrTooManyOutputs, types.ErrCoinbaseDataTooLong,
+		types.ErrBadHTLC, types.ErrDataTooLong, ErrDustOutput} {
+		if err == permanent {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CheckTransaction determines whether a Transaction would be accepted by SubmitRawTransaction, without adding it to the
+// Mempool. Returns the first problem found - the SanityCheck, a txin not spending a utxo (an OutpointError), an unmet
+// RelativeLock, txos exceeding txins, a bad Signature, or a Mempool rule such as a conflict or ErrFeeTooLow
+func (bc *BlockChain) CheckTransaction(tx *types.Transaction) error {
+	_, tip := bc.Tip()
+	fee, err := bc.checkTransaction(tx, tip+1, bc.Mempool.pending())
+	if err != nil {
+		return err
+	}
+
+	return bc.Mempool.Check(tx, fee)
+}
+
+// checkTransaction determines whether a Transaction could be added to the Block at a given height, returning its fee
+// (the amount by which its txins exceed its txos) -
+// pending - Transactions that would come before it (keyed by hex ID), whose txos it may spend
+func (bc *BlockChain) checkTransaction(tx *types.Transaction, height int, pending map[string]*types.Transaction) (int, error) {
+	if tx.IsCoinbase() {
+		return 0, errors.New("Coinbase transactions cannot be submitted")
+	}
+	if err := tx.SanityCheck(); err != nil {
+		return 0, err
+	}
+	if !tx.IsFinal(height) {
+		return 0, types.ErrNotFinal
+	}
+
+	// Make sure the txos are unspent and cover the txos being created
+	prevTxs, err := bc.getPrevTransactionsFromUTXO(tx, pending)
+	if err != nil {
+		return 0, err
+	}
+	if !tx.SequenceLocksMet(bc.prevHeights(tx), height) {
+		return 0, types.ErrSequenceLocked
+	}
+
+	inputSum, outputSum := 0, 0
+	for _, txin := range tx.Inputs {
+		inputSum += prevTxs[hex.EncodeToString(txin.TxID)].Outputs[txin.OutputIdx].Amount
+	}
+	for _, txo := range tx.Outputs {
+		outputSum += txo.Amount
+	}
+	if outputSum > inputSum {
+		return 0, fmt.Errorf("Transaction outputs of %d exceed inputs of %d", outputSum, inputSum)
+	}
+
+	if !tx.Verify(prevTxs) {
+		return 0, ErrBadSignature
+	}
+
+	return inputSum - outputSum, nil
+}
+
+// TransactionPriority gets the Priority a Transaction spending utxos would have in the next Block. Txins spending
+// txos of Transactions in the Mempool have an age of 0, and a Transaction spending txos that aren't available has none
+func (bc *BlockChain) TransactionPriority(tx *types.Transaction) float64 {
+	prevTxs, err := bc.getPrevTransactionsFromUTXO(tx, bc.Mempool.pending())
+	if err != nil {
+		return 0
+	}
+
+	_, tip := bc.Tip()
+	return tx.Priority(prevTxs, bc.prevHeights(tx), tip+1)
+}
+
+// prevHeights gets the index of the Block containing each Transaction with utxos spent by the txins of a tx, keyed by
+// hex ID. Those without utxos in the UTXO set, e.g. Transactions in the Mempool, are left out
+func (bc *BlockChain) prevHeights(tx *types.Transaction) map[string]int {
+	prevHeights := make(map[string]int)
+	for _, txin := range tx.Inputs {
+		if height, ok := bc.getUTXOHeight(txin.TxID); ok {
+			prevHeights[hex.EncodeToString(txin.TxID)] = height
+		}
+	}
+
+	return prevHeights
+}
+
+// GetTransactionWithID searches the bc for a Transaction with a given ID, using the tx index when it can
 func (bc *BlockChain) GetTransactionWithID(id []byte) (types.Transaction, error) {
+	if tx, ok := bc.findTransaction(id); ok {
+		return tx, nil
+	}
+
+	// Not indexed, e.g. added before the tx index was kept
 	iter := bc.Iterator()
 
 	for {
@@ -197,5 +1289,321 @@ func (bc *BlockChain) GetTransactionWithID(id []byte) (types.Transaction, error)
 		}
 	}
 
-	return types.Transaction{}, errors.New("Transaction not found")
+	return types.Transaction{}, ErrTxNotFound
+}
+
+// OutputInfo describes a txo of a Transaction in the BlockChain -
+// Index - idx of the txo in the Transaction
+// Amount - amount held by the txo
+// PubKeyHash - pub key hash of the recipient
+// Spent - whether a txin in the BlockChain references the txo
+type OutputInfo struct {
+	Index      int
+	Amount     int
+	PubKeyHash []byte
+	Spent      bool
+}
+
+// OutputStatus gets the txos of the Transaction with a given ID along with whether each has been spent
+func (bc *BlockChain) OutputStatus(txID []byte) ([]OutputInfo, error) {
+	var found *types.Transaction
+	spent := make(map[int]bool)
+	iter := bc.Iterator()
+
+	for {
+		block := iter.Next()
+
+		// Blocks are visited newest first, so every txin that can spend the txos is seen by the time the tx is found
+		for _, tx := range block.Transactions {
+			if bytes.Compare(tx.ID, txID) == 0 {
+				found = tx
+			}
+
+			if tx.IsCoinbase() {
+				continue
+			}
+			for _, txin := range tx.Inputs {
+				if bytes.Compare(txin.TxID, txID) == 0 {
+					spent[txin.OutputIdx] = true
+				}
+			}
+		}
+
+		if found != nil || len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	if found == nil {
+		return nil, ErrTxNotFound
+	}
+
+	var infos []OutputInfo
+	for txoIdx, txo := range found.Outputs {
+		infos = append(infos, OutputInfo{txoIdx, txo.Amount, txo.PubKeyHash, spent[txoIdx]})
+	}
+
+	return infos, nil
+}
+
+// RescanWallet scans the Blocks from a given height up to the most recent one for txos locked to an address,
+// e.g. one whose key was just imported, and gets the total of those still unspent
+func (bc *BlockChain) RescanWallet(address string, startHeight int) (int, error) {
+	if !bc.AddressParams.ValidateAddress(address) {
+		return 0, errors.New("Invalid address")
+	}
+	pubKeyHash := wallet.GetPubKeyHashFromAddress(address)
+
+	balance := 0
+	spent := make(map[string]bool)
+	iter := bc.Iterator()
+
+	for {
+		block := iter.Next()
+		if block.Index < startHeight {
+			break
+		}
+		if len(block.Transactions) == 0 {
+			return 0, chaindb.ErrBlockPruned
+		}
+
+		// Blocks are visited newest first, so every txin that can spend a txo is seen before the txo
+		for _, tx := range block.Transactions {
+			for txoIdx, txo := range tx.Outputs {
+				if txo.IsLockedWithKey(pubKeyHash) && !spent[fmt.Sprintf("%x:%d", tx.ID, txoIdx)] {
+					balance += txo.Amount
+				}
+			}
+
+			if tx.IsCoinbase() {
+				continue
+			}
+			for _, txin := range tx.Inputs {
+				spent[outpoint(txin)] = true
+			}
+		}
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	return balance, nil
+}
+
+// GetHDWatchBalance gets the total of the utxos locked to each of the first count receive addresses of an
+// HDWatchWallet, along with the sum of them all. An index that gives no key (wallet.ErrInvalidChild) has a total of 0
+func (bc *BlockChain) GetHDWatchBalance(hd *wallet.HDWatchWallet, count uint32) ([]int, int, error) {
+	balances := make([]int, count)
+	total := 0
+
+	for i := uint32(0); i < count; i++ {
+		pubKeyHash, err := hd.ReceivePubKeyHash(i)
+		if err == wallet.ErrInvalidChild {
+			continue
+		} else if err != nil {
+			return nil, 0, err
+		}
+
+		_, balances[i] = bc.GetUTXOWithPubKey(pubKeyHash, math.MaxInt32)
+		total += balances[i]
+	}
+
+	return balances, total, nil
+}
+
+// ScanHDWallet finds the receive addresses of an HDWallet used on the chain, e.g. to restore them from its seed, see
+// ScanHDWatchWallet
+func (bc *BlockChain) ScanHDWallet(hd *wallet.HDWallet, gapLimit int) ([]uint32, error) {
+	return bc.ScanHDWatchWallet(hd.Watch(), gapLimit)
+}
+
+// ScanHDWatchWallet derives the receive addresses of an HDWatchWallet in order, getting the indices of those with a
+// txo locked to them or a txin signed by them in any Block, and stops after gapLimit unused addresses in a row. An
+// index that gives no key (wallet.ErrInvalidChild) is skipped without counting towards the gap. Fails with
+// ErrBlockPruned if the chain has been pruned, as the history of an address could be missing
+func (bc *BlockChain) ScanHDWatchWallet(hd *wallet.HDWatchWallet, gapLimit int) ([]uint32, error) {
+	if gapLimit < 1 {
+		return nil, errors.New("Gap limit must be positive")
+	}
+
+	// Every pub key hash with activity is gathered in one pass, so each derived address is a lookup
+	used := make(map[string]bool)
+	iter := bc.Iterator()
+	for {
+		block := iter.Next()
+		if len(block.Transactions) == 0 {
+			return nil, chaindb.ErrBlockPruned
+		}
+
+		for _, tx := range block.Transactions {
+			for _, txo := range tx.Outputs {
+				used[hex.EncodeToString(txo.PubKeyHash)] = true
+			}
+			if tx.IsCoinbase() {
+				continue
+			}
+			for _, txin := range tx.Inputs {
+				used[hex.EncodeToString(wallet.HashPubKey(txin.PubKey))] = true
+			}
+		}
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	var usedIndices []uint32
+	gap := 0
+	for i := uint32(0); i < wallet.HardenedKeyStart && gap < gapLimit; i++ {
+		pubKeyHash, err := hd.ReceivePubKeyHash(i)
+		if err == wallet.ErrInvalidChild {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if used[hex.EncodeToString(pubKeyHash)] {
+			usedIndices = append(usedIndices, i)
+			gap = 0
+		} else {
+			gap++
+		}
+	}
+
+	return usedIndices, nil
+}
+
+// Prune drops the Transactions of every Block more than keepDepth Blocks below the most recent one.
+// The rest of each Block is kept so the chain can still be walked, and the UTXO set is unaffected
+func (bc *BlockChain) Prune(keepDepth int) error {
+	if keepDepth < 1 {
+		return errors.New("Prune must keep at least the most recent Block")
+	}
+	if err := bc.beginWrite(); err != nil {
+		return err
+	}
+	defer bc.inFlight.Done()
+	bc.tipMu.Lock()
+	defer bc.tipMu.Unlock()
+
+	iter := bc.iterator()
+	for depth := 0; ; depth++ {
+		block := iter.Next()
+
+		if depth >= keepDepth {
+			// Everything older was pruned by an earlier call
+			if len(block.Transactions) == 0 {
+				break
+			}
+			if err := bc.ChainDB.PruneBlock(block.Hash); err != nil {
+				return err
+			}
+		}
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	return nil
+}
+
+// OutpointError describes what is wrong with the txo a txin spends -
+// Outpoint - the txo
+// Err - what is wrong with it, e.g. ErrMissingUTXO
+type OutpointError struct {
+	Outpoint types.Outpoint
+	Err      error
+}
+
+func (e *OutpointError) Error() string {
+	return fmt.Sprintf("Output %d of transaction %x: %s", e.Outpoint.OutputIdx, e.Outpoint.TxID, e.Err)
+}
+
+// VerifyError describes why Verify rejected a Block -
+// Hash - hash of the first Block that failed
+// Reason - what was wrong with it
+type VerifyError struct {
+	Hash   []byte
+	Reason string
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("Block %x failed verification: %s", e.Hash, e.Reason)
+}
+
+// Verify checks the integrity of the entire BlockChain, from the most recent Block back to the genesis Block
+// (or the first pruned Block). Each Block must have a valid proof and hash, link to the previous Block, agree with the
+// Checkpoints, and contain only correctly signed Transactions whose LockTime it meets. Signatures below a Checkpoint
+// are trusted rather than checked
+func (bc *BlockChain) Verify() error {
+	pruned := bc.ChainDB.HasPrunedBlocks()
+	belowCheckpoint := false
+	expectedHash, _ := bc.Tip()
+	iter := &BlockChainIterator{expectedHash, bc.ChainDB}
+	var next *types.Block // the Block visited before the current one, i.e. its successor
+
+	for {
+		block := iter.Next()
+
+		if bytes.Compare(block.Hash, expectedHash) != 0 {
+			return &VerifyError{expectedHash, fmt.Sprintf("stored block has hash %x", block.Hash)}
+		}
+
+		// The contents of pruned Blocks are gone, so there is nothing further back to check
+		if len(block.Transactions) == 0 {
+			break
+		}
+		if next != nil && block.Index != next.Index-1 {
+			reason := fmt.Sprintf("index %d does not follow previous index %d", next.Index, block.Index)
+			return &VerifyError{next.Hash, reason}
+		}
+		if !block.ValidateHash(bc.Hasher) {
+			return &VerifyError{block.Hash, "hash does not match contents"}
+		}
+		if !block.ValidateProof(bc.Hasher) {
+			return &VerifyError{block.Hash, "invalid proof of work"}
+		}
+		if err := validateCoinbase(block); err != nil {
+			return &VerifyError{block.Hash, err.Error()}
+		}
+		ok, matched := checkCheckpoint(block.Index, block.Hash)
+		if !ok {
+			return &VerifyError{block.Hash, ErrCheckpointMismatch.Error()}
+		}
+
+		for _, tx := range block.Transactions {
+			if !tx.IsFinal(block.Index) {
+				reason := fmt.Sprintf("transaction %x is locked until block %d", tx.ID, tx.LockTime)
+				return &VerifyError{block.Hash, reason}
+			}
+			if tx.IsCoinbase() || belowCheckpoint {
+				continue
+			}
+
+			prevTxs, err := bc.getPrevTransactions(tx)
+			if err == ErrTxNotFound && pruned {
+				continue // spends a Transaction that was pruned, so its signatures can't be checked
+			} else if err != nil {
+				return &VerifyError{block.Hash, fmt.Sprintf("transaction %x spends missing transaction", tx.ID)}
+			}
+			if !tx.Verify(prevTxs) {
+				return &VerifyError{block.Hash, fmt.Sprintf("transaction %x has an invalid signature", tx.ID)}
+			}
+		}
+
+		// Reached the beginning of the chain
+		if len(block.PrevHash) == 0 {
+			if block.Index != 0 {
+				return &VerifyError{block.Hash, "chain does not end at a genesis block"}
+			}
+			break
+		}
+		next = block
+		expectedHash = block.PrevHash
+		belowCheckpoint = belowCheckpoint || matched // the Checkpoint's hash commits to every Block before it
+	}
+
+	return nil
 }
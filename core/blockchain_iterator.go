@@ -2,6 +2,7 @@ package core
 
 import (
 	"github.com/danitello/go-blockchain/chaindb"
+	"github.com/danitello/go-blockchain/common/errutil"
 	"github.com/danitello/go-blockchain/core/types"
 )
 
@@ -11,15 +12,24 @@ type BlockChainIterator struct {
 	db          *chaindb.ChainDB
 }
 
-// Iterator creates a new BlockChainIterator for a BlockChain instance
+// Iterator creates a new BlockChainIterator for a BlockChain instance, starting from the current tip
 func (bc *BlockChain) Iterator() *BlockChainIterator {
+	lastHash, _ := bc.Tip()
+	return &BlockChainIterator{lastHash, bc.ChainDB}
+}
+
+// iterator is Iterator for callers already holding tipMu
+func (bc *BlockChain) iterator() *BlockChainIterator {
 	return &BlockChainIterator{bc.LastHash, bc.ChainDB}
 }
 
-// Next retrievies the next (older) Block in the chain
+// Next retrievies the next (older) Block in the chain. Pruned Blocks are returned without Transactions
 func (iter *BlockChainIterator) Next() (resBlock *types.Block) {
 	// Get the Block represented by the CurrentHash
-	resBlock = iter.db.ReadBlockWithHash(iter.currentHash)
+	resBlock, err := iter.db.ReadBlockWithHash(iter.currentHash)
+	if err != chaindb.ErrBlockPruned {
+		errutil.Handle(err)
+	}
 
 	// Update iterator
 	iter.currentHash = resBlock.PrevHash
@@ -0,0 +1,847 @@
+package core_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/danitello/go-blockchain/chaindb"
+	"github.com/danitello/go-blockchain/core"
+	"github.com/danitello/go-blockchain/core/testutil"
+	"github.com/danitello/go-blockchain/core/types"
+	"github.com/danitello/go-blockchain/wallet"
+	"github.com/dgraph-io/badger"
+)
+
+// mineBlock mines a Block of txns at a given difficulty for bc
+func mineBlock(t *testing.T, bc *core.BlockChain, txns []*types.Transaction, prevHash []byte, prevIndex, difficulty int) *types.Block {
+	t.Helper()
+
+	block, err := types.InitBlockWithDifficulty(txns, prevHash, prevIndex, difficulty, bc.Hasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return block
+}
+
+// pay makes a Transaction signed by the Wallet of from in ws paying amount to the address to, with change back to from
+func pay(t *testing.T, bc *core.BlockChain, ws *wallet.Wallets, from, to string, amount int) *types.Transaction {
+	t.Helper()
+	w := ws.Wallets[from]
+
+	utxos, txoSum := bc.GetUTXOWithPubKey(wallet.HashPubKey(w.GetPubKey()), amount)
+	if txoSum < amount {
+		t.Fatalf("%s has %d, can't pay %d", from, txoSum, amount)
+	}
+	tx := types.CreateTransaction(from, to, w.GetPubKey(), amount, txoSum, utxos)
+	if err := bc.SignTransaction(tx, ws, from); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+// addBlock adds a Block of txns after a coinbase tx paying address to bc
+func addBlock(t *testing.T, bc *core.BlockChain, address string, txns ...*types.Transaction) {
+	t.Helper()
+	_, tip := bc.Tip()
+	if err := bc.AddBlock(append([]*types.Transaction{types.CoinbaseTx(address, tip+1)}, txns...)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateBlockWrongDifficulty(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 2)
+	lastHash, tip := bc.Tip()
+
+	cbtx := types.CoinbaseTx(ws.GetAddresses()[0], tip+1)
+	block := mineBlock(t, bc, []*types.Transaction{cbtx}, lastHash, tip, bc.Difficulty+1)
+
+	if err := bc.ValidateBlock(block); err != core.ErrBadDifficulty {
+		t.Fatalf("got %v, want ErrBadDifficulty", err)
+	}
+}
+
+func TestReorganizeWrongDifficulty(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 3)
+	lastHash, tip := bc.Tip()
+	forkPoint, err := bc.ChainDB.ReadHeaderWithHash(lastHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forkPoint, err = bc.ChainDB.ReadHeaderWithHash(forkPoint.PrevHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A single Block claiming far more work than the two it would replace
+	cbtx := types.CoinbaseTx(ws.GetAddresses()[0], forkPoint.Index+1)
+	block := mineBlock(t, bc, []*types.Transaction{cbtx}, forkPoint.Hash, forkPoint.Index, 8)
+
+	err = bc.Reorganize([]*types.Block{block})
+	if err == nil || !strings.Contains(err.Error(), core.ErrBadDifficulty.Error()) {
+		t.Fatalf("got %v, want ErrBadDifficulty", err)
+	}
+	if newHash, newTip := bc.Tip(); newTip != tip || bytes.Compare(newHash, lastHash) != 0 {
+		t.Fatal("rejected branch changed the tip")
+	}
+}
+
+// dbSnapshot gets every key and value in the db of bc
+func dbSnapshot(t *testing.T, bc *core.BlockChain) map[string]string {
+	t.Helper()
+	snapshot := make(map[string]string)
+
+	err := bc.ChainDB.Database.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			v, err := it.Item().Value()
+			if err != nil {
+				return err
+			}
+			snapshot[string(it.Item().Key())] = string(v)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return snapshot
+}
+
+func TestSaveNewLastBlockFailureChangesNothing(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 4)
+	lastHash, tip := bc.Tip()
+	before := dbSnapshot(t, bc)
+
+	// Valid spends followed by one of a txo that doesn't exist, so the UTXO update fails partway through the Block
+	from := ws.GetAddresses()[0]
+	spends := testutil.SpendEach(t, bc, ws)
+	if len(spends) < 2 {
+		t.Fatalf("only %d spends", len(spends))
+	}
+	missing := types.CreateTransaction(from, from, ws.Wallets[from].GetPubKey(), 1, 2, map[string][]int{"00ff": {0}})
+	txns := append([]*types.Transaction{types.CoinbaseTx(from, tip+1)}, spends...)
+	txns = append(txns, missing)
+	block := mineBlock(t, bc, txns, lastHash, tip, bc.Difficulty)
+
+	err := bc.SaveNewLastBlock(block)
+	var outpointErr *core.OutpointError
+	if !errors.As(err, &outpointErr) {
+		t.Fatalf("got %v, want an OutpointError", err)
+	}
+
+	if newHash, newTip := bc.Tip(); newTip != tip || bytes.Compare(newHash, lastHash) != 0 {
+		t.Fatal("failed save moved the tip")
+	}
+	if !reflect.DeepEqual(dbSnapshot(t, bc), before) {
+		t.Fatal("failed save changed the db")
+	}
+	if err := bc.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without the bad tx the same Block is saved
+	block = mineBlock(t, bc, txns[:len(txns)-1], lastHash, tip, bc.Difficulty)
+	if err := bc.SaveNewLastBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	if _, newTip := bc.Tip(); newTip != tip+1 {
+		t.Fatalf("tip is %d, want %d", newTip, tip+1)
+	}
+}
+
+func TestTransactionPriority(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 1)
+	addresses := ws.GetAddresses()
+	from := ws.Wallets[addresses[0]]
+
+	// A coinbase tx mined at height 2, then aged by 3 more Blocks
+	coinbase := types.CoinbaseTx(addresses[0], 2)
+	if err := bc.AddBlock([]*types.Transaction{coinbase}); err != nil {
+		t.Fatal(err)
+	}
+	for height := 3; height <= 5; height++ {
+		if err := bc.AddBlock([]*types.Transaction{types.CoinbaseTx(addresses[1], height)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	amount := coinbase.Outputs[0].Amount
+	utxos := map[string][]int{hex.EncodeToString(coinbase.ID): {0}}
+	tx := types.CreateTransaction(addresses[0], addresses[1], from.GetPubKey(), amount/2, amount, utxos)
+	bc.SignTransactionWithKey(tx, from.PrivateKey)
+
+	// It goes in Block 6, where the coinbase tx is 4 Blocks old
+	if got, want := bc.TransactionPriority(tx), float64(amount*4)/float64(tx.Size()); got != want {
+		t.Fatalf("Got priority %v, want %v", got, want)
+	}
+
+	// Spending a txo of a Mempool Transaction gives no age
+	if err := bc.SubmitTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	child := types.CreateTransaction(addresses[1], addresses[0], ws.Wallets[addresses[1]].GetPubKey(), 1, amount/2,
+		map[string][]int{hex.EncodeToString(tx.ID): {0}})
+	bc.SignTransactionWithKey(child, ws.Wallets[addresses[1]].PrivateKey)
+	if got := bc.TransactionPriority(child); got != 0 {
+		t.Fatalf("Got priority %v spending an unconfirmed transaction", got)
+	}
+}
+
+func TestOutputStatus(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 2)
+	addresses := ws.GetAddresses()
+
+	tx := pay(t, bc, ws, addresses[0], addresses[1], 3)
+	addBlock(t, bc, addresses[2], tx)
+	if len(tx.Outputs) != 2 {
+		t.Fatalf("Payment has %d outputs, want a payment and change", len(tx.Outputs))
+	}
+
+	// Only the payment is spent
+	utxos := map[string][]int{hex.EncodeToString(tx.ID): {0}}
+	w := ws.Wallets[addresses[1]]
+	spend := types.CreateTransaction(addresses[1], addresses[2], w.GetPubKey(), 3, 3, utxos)
+	if err := bc.SignTransaction(spend, ws, addresses[1]); err != nil {
+		t.Fatal(err)
+	}
+	addBlock(t, bc, addresses[2], spend)
+	addBlock(t, bc, addresses[2])
+
+	infos, err := bc.OutputStatus(tx.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []core.OutputInfo{
+		{Index: 0, Amount: 3, PubKeyHash: tx.Outputs[0].PubKeyHash, Spent: true},
+		{Index: 1, Amount: tx.Outputs[1].Amount, PubKeyHash: tx.Outputs[1].PubKeyHash, Spent: false},
+	}
+	if !reflect.DeepEqual(infos, want) {
+		t.Fatalf("Got %+v, want %+v", infos, want)
+	}
+	if bytes.Compare(want[0].PubKeyHash, wallet.GetPubKeyHashFromAddress(addresses[1])) != 0 {
+		t.Fatal("Payment not locked to its recipient")
+	}
+
+	if _, err := bc.OutputStatus([]byte("missing")); err != core.ErrTxNotFound {
+		t.Fatalf("Got %v for a missing transaction, want ErrTxNotFound", err)
+	}
+}
+
+func TestCoinbaseWrongHeightRejected(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 2)
+	address := ws.GetAddresses()[0]
+	lastHash, tip := bc.Tip()
+
+	for _, height := range []int{tip, tip + 2} {
+		block := mineBlock(t, bc, []*types.Transaction{types.CoinbaseTx(address, height)}, lastHash, tip, bc.Difficulty)
+		if err := bc.ValidateBlock(block); err != core.ErrBadCoinbaseHeight {
+			t.Fatalf("Got %v for a coinbase of height %d in block %d, want ErrBadCoinbaseHeight", err, height, tip+1)
+		}
+	}
+}
+
+func TestCoinbaseOverClaimRejected(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 2)
+	address := ws.GetAddresses()[0]
+	lastHash, tip := bc.Tip()
+
+	cbtx, err := types.CoinbaseTxWithValue(address, tip+1, types.BlockSubsidy(tip+1)+1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := mineBlock(t, bc, []*types.Transaction{cbtx}, lastHash, tip, bc.Difficulty)
+	if err := bc.ValidateBlock(block); err != core.ErrBadCoinbaseAmount {
+		t.Fatalf("Got %v for a coinbase minting more than the subsidy, want ErrBadCoinbaseAmount", err)
+	}
+
+	if err := bc.VerifySupply(); err != nil {
+		t.Fatal(err)
+	}
+	if supply, want := bc.GetSupply(), 3*types.Reward; supply != want {
+		t.Fatalf("Supply is %d, want %d", supply, want)
+	}
+}
+
+func TestCoinbaseClaimsFees(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 2)
+	addresses := ws.GetAddresses()
+	w := ws.Wallets[addresses[0]]
+	const fee = 2
+
+	utxos, txoSum := bc.GetUTXOWithPubKey(wallet.HashPubKey(w.GetPubKey()), 3+fee)
+	tx := types.CreateTransactionWithFee(addresses[0], addresses[1], w.GetPubKey(), 3, fee, txoSum, utxos)
+	if err := bc.SignTransaction(tx, ws, addresses[0]); err != nil {
+		t.Fatal(err)
+	}
+	lastHash, tip := bc.Tip()
+	claim := types.BlockSubsidy(tip+1) + fee
+
+	for _, test := range []struct {
+		amount int
+		want   error
+	}{{claim + 1, core.ErrBadCoinbaseAmount}, {claim, nil}} {
+		cbtx, err := types.CoinbaseTxWithValue(addresses[2], tip+1, test.amount, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block := mineBlock(t, bc, []*types.Transaction{cbtx, tx}, lastHash, tip, bc.Difficulty)
+		if err := bc.ValidateBlock(block); err != test.want {
+			t.Fatalf("Got %v for a coinbase claiming %d of %d, want %v", err, test.amount, claim, test.want)
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 4)
+	addresses := ws.GetAddresses()
+
+	if err := bc.Prune(0); err == nil {
+		t.Fatal("Pruned every Block")
+	}
+	if err := bc.Prune(2); err != nil {
+		t.Fatal(err)
+	}
+
+	hashes := chainHashes(t, bc)
+	for i, hash := range hashes {
+		block, err := bc.ChainDB.ReadBlockWithHash(hash)
+		if i < len(hashes)-2 {
+			if err != chaindb.ErrBlockPruned {
+				t.Fatalf("Got %v reading pruned block %d, want ErrBlockPruned", err, i)
+			}
+			if len(block.Transactions) != 0 {
+				t.Fatalf("Pruned block %d still has transactions", i)
+			}
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		header, err := bc.ChainDB.ReadHeaderWithHash(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Index != i {
+			t.Fatalf("Header of block %d has index %d", i, header.Index)
+		}
+	}
+
+	if _, err := bc.GetUTXO(); err != chaindb.ErrBlockPruned {
+		t.Fatalf("Got %v rebuilding the UTXO set of a pruned chain, want ErrBlockPruned", err)
+	}
+
+	// New Blocks are still validated against the UTXO set, which spends from pruned Blocks
+	addBlock(t, bc, addresses[0], testutil.SpendEach(t, bc, ws)...)
+	if err := bc.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.VerifySupply(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWrongNetwork(t *testing.T) {
+	w, err := wallet.InitWalletFromReader(rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "networkchain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := core.DefaultConfig()
+	cfg.DataDir = dir
+	cfg.Difficulty = types.TestDifficulty
+	bc := core.InitBlockChainWithConfig(string(w.GetAddress()), cfg)
+	genesisHash, err := bc.ChainDB.ReadGenesisHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.GenesisHash = hex.EncodeToString(genesisHash) // before genesisHash goes with the db
+	bc.ChainDB.CloseDB()
+
+	bc = core.GetBlockChainWithConfig(cfg)
+	bc.ChainDB.CloseDB()
+
+	for _, network := range []struct{ name, genesisHash string }{
+		{cfg.Network, strings.Repeat("00", 32)},
+		{"test", ""},
+		{"test", cfg.GenesisHash},
+	} {
+		other := *cfg
+		other.Network, other.GenesisHash = network.name, network.genesisHash
+		func() {
+			defer func() {
+				if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), core.ErrWrongNetwork.Error()) {
+					t.Fatalf("Got %v opening a db of another network as %+v, want ErrWrongNetwork", r, network)
+				}
+			}()
+			core.GetBlockChainWithConfig(&other)
+		}()
+	}
+	// Refusing the db closes it, so it can be opened again as its own network
+	core.GetBlockChainWithConfig(cfg).ChainDB.CloseDB()
+}
+
+func TestCreateTransactionFromInputs(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 3)
+	addresses := ws.GetAddresses()
+	outpointOf := func(address string) types.Outpoint {
+		utxos, _ := bc.GetUTXOWithPubKey(wallet.GetPubKeyHashFromAddress(address), 1)
+		for txID, idxs := range utxos {
+			id, err := hex.DecodeString(txID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return types.Outpoint{TxID: id, OutputIdx: idxs[0]}
+		}
+		t.Fatalf("%s has no utxos", address)
+		return types.Outpoint{}
+	}
+	own, other := outpointOf(addresses[0]), outpointOf(addresses[1])
+	txo, _ := bc.GetUTXOWithOutpoint(own.TxID, own.OutputIdx)
+
+	tx, err := bc.CreateTransactionFromInputsWithWallets(ws, addresses[0], addresses[2], 2, 1, []types.Outpoint{own})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.Inputs) != 1 || bytes.Compare(tx.Inputs[0].TxID, own.TxID) != 0 || tx.Inputs[0].OutputIdx != own.OutputIdx {
+		t.Fatal("Transaction does not spend just the chosen output")
+	}
+	if err := bc.CheckTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := bc.CreateTransactionFromInputsWithWallets(ws, addresses[0], addresses[2], 2, 1, []types.Outpoint{own, other}); err == nil ||
+		!strings.Contains(err.Error(), "not owned") {
+		t.Fatalf("Got %v spending an output of another address", err)
+	}
+	if _, err := bc.CreateTransactionFromInputsWithWallets(ws, addresses[0], addresses[2], 2, 1, []types.Outpoint{own, own}); err != types.ErrDuplicateInput {
+		t.Fatalf("Got %v choosing an output twice, want ErrDuplicateInput", err)
+	}
+	if _, err := bc.CreateTransactionFromInputsWithWallets(ws, addresses[0], addresses[2], txo.Amount, 1, []types.Outpoint{own}); err == nil {
+		t.Fatal("Created a transaction whose fee the chosen outputs don't cover")
+	}
+}
+
+func TestReorganizeHooks(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 3)
+	hashes := chainHashes(t, bc)
+
+	var events []string
+	bc.OnConnect(func(block *types.Block) { events = append(events, fmt.Sprintf("connect %x", block.Hash)) })
+	bc.OnDisconnect(func(block *types.Block) { events = append(events, fmt.Sprintf("disconnect %x", block.Hash)) })
+
+	branch := branchFrom(t, bc, ws.GetAddresses()[0], hashes[1], 1, 3)
+	if err := bc.Reorganize(branch); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{fmt.Sprintf("disconnect %x", hashes[3]), fmt.Sprintf("disconnect %x", hashes[2])}
+	for _, block := range branch {
+		want = append(want, fmt.Sprintf("connect %x", block.Hash))
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("Got hooks\n%s\nwant\n%s", strings.Join(events, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestReorganizeReturnsToMempool(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 3)
+	address := ws.GetAddresses()[0]
+	spends := testutil.SpendEach(t, bc, ws)
+	if len(spends) < 3 {
+		t.Fatalf("Got %d spends, want at least 3", len(spends))
+	}
+	orphaned, confirmed, pending := spends[0], spends[1], spends[2]
+	forkHash, forkIndex := bc.Tip()
+
+	addBlock(t, bc, address, orphaned, confirmed)
+	if err := bc.SubmitTransaction(pending); err != nil {
+		t.Fatal(err)
+	}
+
+	// The branch confirms one Transaction of the replaced Block along with the one in the Mempool
+	first := mineBlock(t, bc, []*types.Transaction{types.CoinbaseTx(address, forkIndex+1), confirmed, pending}, forkHash, forkIndex, bc.Difficulty)
+	branch := append([]*types.Block{first}, branchFrom(t, bc, address, first.Hash, first.Index, 1)...)
+	if err := bc.Reorganize(branch); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := bc.Mempool.Get(orphaned.ID); !ok {
+		t.Fatal("Transaction of the replaced block not returned to the mempool")
+	}
+	for name, tx := range map[string]*types.Transaction{"replaced": confirmed, "pending": pending} {
+		if _, ok := bc.Mempool.Get(tx.ID); ok {
+			t.Fatalf("%s transaction confirmed on the branch is in the mempool", name)
+		}
+	}
+	if bc.Mempool.Size() != 1 {
+		t.Fatalf("Got %d transactions in the mempool, want 1", bc.Mempool.Size())
+	}
+
+	addBlock(t, bc, address, orphaned)
+	if err := bc.Verify(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMaxReorgDepth(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 4)
+	address := ws.GetAddresses()[0]
+	hashes := chainHashes(t, bc)
+	bc.MaxReorgDepth = 2
+
+	// Replacing Blocks 2 to 4 is one too many
+	deep := branchFrom(t, bc, address, hashes[1], 1, 4)
+	if err := bc.Reorganize(deep); err != core.ErrReorgTooDeep {
+		t.Fatalf("Got %v for a fork 3 blocks deep, want ErrReorgTooDeep", err)
+	}
+	if lastHash, _ := bc.Tip(); bytes.Compare(lastHash, hashes[4]) != 0 {
+		t.Fatal("Tip changed by a rejected reorg")
+	}
+
+	shallow := branchFrom(t, bc, address, hashes[2], 2, 3)
+	if err := bc.Reorganize(shallow); err != nil {
+		t.Fatal(err)
+	}
+	if lastHash, tip := bc.Tip(); bytes.Compare(lastHash, shallow[2].Hash) != 0 || tip != 5 {
+		t.Fatalf("Tip is block %d, want the end of the shallow fork", tip)
+	}
+}
+
+func TestLockTime(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 2)
+	addresses := ws.GetAddresses()
+	lastHash, tip := bc.Tip()
+
+	w := ws.Wallets[addresses[0]]
+	utxos, txoSum := bc.GetUTXOWithPubKey(wallet.HashPubKey(w.GetPubKey()), 3)
+	tx := types.CreateTransaction(addresses[0], addresses[1], w.GetPubKey(), 3, txoSum, utxos)
+	tx.SetLockTime(tip + 2)
+	if err := bc.SignTransaction(tx, ws, addresses[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bc.SubmitTransaction(tx); err != types.ErrNotFinal {
+		t.Fatalf("Got %v submitting before the lock time, want ErrNotFinal", err)
+	}
+	cbtx := types.CoinbaseTx(addresses[2], tip+1)
+	block := mineBlock(t, bc, []*types.Transaction{cbtx, tx}, lastHash, tip, bc.Difficulty)
+	if err := bc.ValidateBlock(block); err == nil {
+		t.Fatal("Block below the lock time of a transaction is valid")
+	}
+
+	// The lock time is signed, so can't be lowered
+	early := tx.Copy()
+	early.LockTime = 0
+	if err := bc.CheckTransaction(early); err == nil {
+		t.Fatal("Transaction with its lock time lowered after signing accepted")
+	}
+
+	addBlock(t, bc, addresses[2])
+	if err := bc.SubmitTransaction(tx); err != nil {
+		t.Fatalf("Got %v submitting at the lock time", err)
+	}
+	addBlock(t, bc, addresses[2], tx)
+	if err := bc.Verify(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRelativeLock(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 1)
+	addresses := ws.GetAddresses()
+	from := addresses[3]
+	const lock = 3
+
+	_, tip := bc.Tip()
+	coinbase := types.CoinbaseTx(from, tip+1)
+	if err := bc.AddBlock([]*types.Transaction{coinbase}); err != nil {
+		t.Fatal(err)
+	}
+	amount := coinbase.Outputs[0].Amount
+	tx := types.CreateTransaction(from, addresses[0], ws.Wallets[from].GetPubKey(), amount, amount,
+		map[string][]int{hex.EncodeToString(coinbase.ID): {0}})
+	tx.SetSequence(0, lock)
+	if err := bc.SignTransaction(tx, ws, from); err != nil {
+		t.Fatal(err)
+	}
+
+	// The coinbase tx is at tip+1, so tx may go in the Block at tip+1+lock at the earliest
+	for i := 1; i < lock; i++ {
+		if err := bc.SubmitTransaction(tx); err != types.ErrSequenceLocked {
+			t.Fatalf("Got %v submitting %d blocks after the spent output, want ErrSequenceLocked", err, i)
+		}
+		lastHash, tip := bc.Tip()
+		block := mineBlock(t, bc, []*types.Transaction{types.CoinbaseTx(from, tip+1), tx}, lastHash, tip, bc.Difficulty)
+		if err := bc.ValidateBlock(block); err == nil {
+			t.Fatalf("Block %d blocks after the spent output with a relative locked spend is valid", i)
+		}
+		addBlock(t, bc, from)
+	}
+
+	// The Sequence is signed, so the lock can't be lifted
+	unlocked := tx.Copy()
+	unlocked.Inputs[0].Sequence = types.SequenceDisableFlag
+	if err := bc.CheckTransaction(unlocked); err == nil {
+		t.Fatal("Transaction with its relative lock lifted after signing accepted")
+	}
+
+	if err := bc.SubmitTransaction(tx); err != nil {
+		t.Fatalf("Got %v submitting once the spent output is buried", err)
+	}
+	addBlock(t, bc, from, tx)
+	if err := bc.Verify(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScanHDWallet(t *testing.T) {
+	bc, _ := testutil.BuildTestChain(t, 1)
+	master, err := wallet.InitHDWallet(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hd, err := master.Child(wallet.HardenedKeyStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Index 8 is used too, but past a gap of 3 after index 4
+	for _, index := range []uint32{0, 1, 4, 8} {
+		address, err := hd.ReceiveAddress(index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addBlock(t, bc, address)
+	}
+
+	for gapLimit, want := range map[int][]uint32{3: {0, 1, 4}, 2: {0, 1}, 4: {0, 1, 4, 8}} {
+		used, err := bc.ScanHDWallet(hd, gapLimit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(used, want) {
+			t.Fatalf("Got used indices %v with a gap limit of %d, want %v", used, gapLimit, want)
+		}
+	}
+	if _, err := bc.ScanHDWatchWallet(hd.Watch(), 0); err == nil {
+		t.Fatal("Scanned with a gap limit of 0")
+	}
+}
+
+func TestCreateCPFPTransaction(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 2)
+	addresses := ws.GetAddresses()
+	w := ws.Wallets[addresses[0]]
+
+	utxos, txoSum := bc.GetUTXOWithPubKey(wallet.HashPubKey(w.GetPubKey()), 3)
+	parent := types.CreateTransactionWithFee(addresses[0], addresses[1], w.GetPubKey(), 3, 1, txoSum, utxos)
+	if err := bc.SignTransaction(parent, ws, addresses[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.SubmitTransaction(parent); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := bc.CreateCPFPTransactionWithWallets(ws, parent.ID, 0, 10); err == nil {
+		t.Fatal("Bumped the fee by spending the payment rather than the change")
+	}
+	child, err := bc.CreateCPFPTransactionWithWallets(ws, parent.ID, 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.SubmitTransaction(child); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.CreateCPFPTransactionWithWallets(ws, parent.ID, 1, 10); err == nil {
+		t.Fatal("Bumped the fee again with the change already spent")
+	}
+
+	fees := make(map[string]core.MempoolEntry)
+	for _, entry := range bc.Mempool.Dump() {
+		fees[hex.EncodeToString(entry.TxID)] = entry
+	}
+	p, c := fees[hex.EncodeToString(parent.ID)], fees[hex.EncodeToString(child.ID)]
+	if c.Fee != 10 {
+		t.Fatalf("Child pays %d, want 10", c.Fee)
+	}
+	if packageRate := float64(p.Fee+c.Fee) / float64(p.Size+c.Size); packageRate <= p.FeeRate {
+		t.Fatalf("Package fee rate %v is not above the parent's %v", packageRate, p.FeeRate)
+	}
+}
+
+func TestRotateKey(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 0)
+	rich := ws.GetAddresses()[0]
+	addBlocks(t, bc, rich, 24)
+
+	// Several utxos big enough to cover a fee of a coin per byte, which block subsidies aren't
+	old := ws.CreateWallet(true)
+	for i := 0; i < 3; i++ {
+		addBlock(t, bc, rich, pay(t, bc, ws, rich, old, 700))
+	}
+	balance := func(address string) int {
+		_, sum := bc.GetUTXOWithPubKey(wallet.HashPubKey(ws.Wallets[address].GetPubKey()), math.MaxInt32)
+		return sum
+	}
+
+	bc.Mempool.MinRelayFee = 1
+	newAddress, tx, err := bc.RotateKey(ws, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ws.Wallets[newAddress]; !ok || newAddress == old {
+		t.Fatalf("Got new address %s, not a new wallet in ws", newAddress)
+	}
+	if len(tx.Inputs) != 3 || len(tx.Outputs) != 1 {
+		t.Fatalf("Got %d inputs and %d outputs, want every utxo swept to one output", len(tx.Inputs), len(tx.Outputs))
+	}
+	fee := 3*700 - tx.Outputs[0].Amount
+	if fee <= 0 || fee != bc.Mempool.MinRelayFee*tx.EstimateSize() {
+		t.Fatalf("Got fee %d for %d bytes at %d per byte", fee, tx.EstimateSize(), bc.Mempool.MinRelayFee)
+	}
+
+	if err := bc.SubmitTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	addBlock(t, bc, rich, tx)
+	if got := balance(old); got != 0 {
+		t.Fatalf("Got %d left at the old address", got)
+	}
+	if got := balance(newAddress); got != 3*700-fee {
+		t.Fatalf("Got %d at the new address, want %d", got, 3*700-fee)
+	}
+
+	// Nothing left to move, and funds that don't cover the fee, are errors that leave no new wallet behind
+	wallets := len(ws.Wallets)
+	if _, _, err := bc.RotateKey(ws, old); err == nil {
+		t.Fatal("Rotated an address without utxos")
+	}
+	bc.Mempool.MinRelayFee = 1000
+	if _, _, err := bc.RotateKey(ws, newAddress); err == nil {
+		t.Fatal("Rotated funds that don't cover the fee")
+	}
+	if len(ws.Wallets) != wallets {
+		t.Fatalf("Got %d wallets after failed rotations, want %d", len(ws.Wallets), wallets)
+	}
+	if _, _, err := bc.RotateKey(ws, "nosuchaddress"); err == nil {
+		t.Fatal("Rotated an address without a wallet")
+	}
+}
+
+func TestCreateTransactionToHash(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 0)
+	from := ws.GetAddresses()[0]
+	addBlock(t, bc, from)
+	to := ws.CreateWallet(false)
+	toPubKeyHash := wallet.HashPubKey(ws.Wallets[to].GetPubKey())
+
+	tx, err := bc.CreateTransactionToHashWithWallets(ws, from, toPubKeyHash, 60, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addBlock(t, bc, from, tx)
+
+	// Found by the hash, and spendable by the key it is the hash of
+	utxos, sum := bc.GetUTXOWithPubKey(toPubKeyHash, 60)
+	if sum != 60 || len(utxos[hex.EncodeToString(tx.ID)]) != 1 {
+		t.Fatalf("Got %d in %v for the hash, want the 60 paid by %x", sum, utxos, tx.ID)
+	}
+	addBlock(t, bc, from, pay(t, bc, ws, to, from, 60))
+	if _, sum := bc.GetUTXOWithPubKey(toPubKeyHash, 60); sum != 0 {
+		t.Fatalf("Got %d left after spending", sum)
+	}
+
+	for _, size := range []int{0, types.PubKeyHashLen - 1, types.PubKeyHashLen + 1, 32} {
+		if _, err := bc.CreateTransactionToHashWithWallets(ws, from, make([]byte, size), 10, 0); err == nil {
+			t.Fatalf("Paid a pub key hash of %d bytes", size)
+		}
+	}
+}
+
+func TestRescanWallet(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 0)
+	rich := ws.GetAddresses()[0]
+	imported := ws.CreateWallet(false)
+	for i := 0; i < 2; i++ {
+		addBlock(t, bc, rich)
+	}
+
+	// Pays 8 at height 3, 1 at 4, 2 at 5 and 4 at 6, then spends the 1 at 7
+	var spentTx *types.Transaction
+	for _, amount := range []int{8, 1, 2, 4} {
+		tx := pay(t, bc, ws, rich, imported, amount)
+		addBlock(t, bc, rich, tx)
+		if amount == 1 {
+			spentTx = tx
+		}
+	}
+	spentIdx := -1
+	for txoIdx, txo := range spentTx.Outputs {
+		if txo.Amount == 1 {
+			spentIdx = txoIdx
+		}
+	}
+	spend, err := bc.CreateTransactionFromInputsWithWallets(ws, imported, rich, 1, 0,
+		[]types.Outpoint{{TxID: spentTx.ID, OutputIdx: spentIdx}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addBlock(t, bc, rich, spend)
+
+	for startHeight, want := range map[int]int{0: 14, 3: 14, 4: 6, 5: 6, 6: 4, 7: 0, 8: 0} {
+		if got, err := bc.RescanWallet(imported, startHeight); err != nil || got != want {
+			t.Fatalf("Got %d (%v) from height %d, want %d", got, err, startHeight, want)
+		}
+	}
+
+	if _, err := bc.RescanWallet("nope", 0); err == nil {
+		t.Fatal("Rescanned an invalid address")
+	}
+}
+
+func TestReorganizeInvalidatesCache(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 2)
+	replaced, err := bc.ChainDB.ReadBlockWithHash(bc.LastHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fork, err := bc.ChainDB.ReadHeaderWithHash(replaced.PrevHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.ChainDB.ReadBlockWithHash(replaced.Hash); err != nil {
+		t.Fatal(err)
+	}
+	before := bc.ChainDB.CacheStats()
+	if before.Hits == 0 {
+		t.Fatalf("Got %+v, want the Block cache Config enables", before)
+	}
+
+	if err := bc.Reorganize(branchFrom(t, bc, ws.GetAddresses()[1], fork.Hash, fork.Index, 2)); err != nil {
+		t.Fatal(err)
+	}
+	before = bc.ChainDB.CacheStats()
+	if _, err := bc.ChainDB.ReadBlockWithHash(replaced.Hash); err != nil {
+		t.Fatal(err)
+	}
+	if after := bc.ChainDB.CacheStats(); after.Misses != before.Misses+1 {
+		t.Fatalf("Got %+v then %+v reading the replaced Block, want a miss", before, after)
+	}
+}
@@ -0,0 +1,171 @@
+package core
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"math/big"
+
+	"github.com/danitello/go-blockchain/common/byteutil"
+	"github.com/danitello/go-blockchain/core/types"
+
+	"github.com/dgraph-io/badger"
+)
+
+const (
+	// TipActive is the Status of the tip of the BlockChain
+	TipActive = "active"
+	// TipValidFork is the Status of the tip of a branch of valid Blocks that were disconnected from the chain, or whose
+	// headers are valid but which Reorganize turned away as too deep or without enough work to replace the chain
+	TipValidFork = "valid-fork"
+	// TipInvalid is the Status of the tip of a branch ending in a Block that failed validation
+	TipInvalid = "invalid"
+)
+
+// sidePrefix prefixes the db key of a Block known to be off the chain -> value is the serialized sideBlock
+var sidePrefix = []byte("side-")
+
+// sideBlock is a Block seen off the chain, kept so its branch shows up in ChainTips -
+// Header - of the Block, as an invalid Block is not itself in the db
+// Invalid - whether the Block failed validation
+type sideBlock struct {
+	Header  types.BlockHeader
+	Invalid bool
+}
+
+// TipInfo describes the tip of a branch known to the BlockChain -
+// Hash - hash of the tip Block
+// Height - index of the tip Block
+// BranchLen - number of Blocks from the tip back to the chain, 0 for the active tip
+// Status - TipActive, TipValidFork, or TipInvalid
+// Work - CumulativeWork of the branch, which decides between branches rather than Height
+type TipInfo struct {
+	Hash      []byte
+	Height    int
+	BranchLen int
+	Status    string
+	Work      *big.Int
+}
+
+// addSideBlock records a Block as off the chain within a db transaction
+func addSideBlock(txn *badger.Txn, header *types.BlockHeader, invalid bool) error {
+	return txn.Set(append(sidePrefix, header.Hash...), byteutil.Serialize(sideBlock{*header, invalid}))
+}
+
+// addSideBranch records a branch Reorganize turned away, forking from the Block with header forkPoint, as off the
+// chain. Its headers and CumulativeWork are stored too, as its Blocks aren't, so ChainTips can walk it back to the
+// chain. Only Blocks whose headers are valid are recorded, the rest of the branch being left out from the first
+// that isn't
+func (bc *BlockChain) addSideBranch(forkPoint *types.BlockHeader, branch []*types.Block) error {
+	work, err := bc.CumulativeWork(forkPoint.Hash)
+	if err != nil {
+		return err
+	}
+
+	return bc.ChainDB.Database.Update(func(txn *badger.Txn) error {
+		prev := forkPoint
+		for _, block := range branch {
+			header := block.Header()
+			if types.ValidateBlockHeader(header, prev, bc.Hasher) != nil {
+				return nil
+			}
+			work = new(big.Int).Add(work, BlockWork(header.Difficulty))
+
+			if err := bc.ChainDB.WriteHeaderWithTxn(txn, header); err != nil {
+				return err
+			}
+			if err := storeWork(txn, header.Hash, work); err != nil {
+				return err
+			}
+			if err := addSideBlock(txn, header, false); err != nil {
+				return err
+			}
+			prev = header
+		}
+		return nil
+	})
+}
+
+// ChainTips gets the tip of every branch the BlockChain knows about, starting with the active tip. A branch is known
+// once its Blocks are disconnected, e.g. by Reorganize, or Reorganize turns it away, whether for a Block of it being
+// invalid, forking too deep or not having enough work
+func (bc *BlockChain) ChainTips() ([]TipInfo, error) {
+	bc.tipMu.RLock()
+	defer bc.tipMu.RUnlock()
+
+	work, err := bc.CumulativeWork(bc.LastHash)
+	if err != nil {
+		return nil, err
+	}
+	tips := []TipInfo{{bc.LastHash, bc.Height - 1, 0, TipActive, work}}
+
+	onChain, err := bc.chainHashes()
+	if err != nil {
+		return nil, err
+	}
+	isOnChain := func(header *types.BlockHeader) bool {
+		return header.Index >= 0 && header.Index < len(onChain) && bytes.Compare(onChain[header.Index], header.Hash) == 0
+	}
+
+	var side []sideBlock
+	err = bc.ChainDB.Database.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(sidePrefix); it.ValidForPrefix(sidePrefix); it.Next() {
+			v, err := it.Item().Value()
+			if err != nil {
+				return err
+			}
+			var sb sideBlock
+			if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&sb); err != nil {
+				return err
+			}
+			side = append(side, sb)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// A side Block is only a tip if no other side Block builds on it
+	extended := make(map[string]bool)
+	for _, sb := range side {
+		if !isOnChain(&sb.Header) {
+			extended[hex.EncodeToString(sb.Header.PrevHash)] = true
+		}
+	}
+
+	for _, sb := range side {
+		if isOnChain(&sb.Header) || extended[hex.EncodeToString(sb.Header.Hash)] {
+			continue
+		}
+
+		// Walk back to the Block the branch forks from
+		branchLen := 1
+		header := &sb.Header
+		for {
+			header, err = bc.ChainDB.ReadHeaderWithHash(header.PrevHash)
+			if err != nil {
+				return nil, err
+			}
+			if isOnChain(header) {
+				break
+			}
+			branchLen++
+		}
+
+		status := TipValidFork
+		if sb.Invalid {
+			status = TipInvalid
+		}
+		work, err := bc.headerWork(&sb.Header)
+		if err != nil {
+			return nil, err
+		}
+		tips = append(tips, TipInfo{sb.Header.Hash, sb.Header.Index, branchLen, status, work})
+	}
+
+	return tips, nil
+}
@@ -0,0 +1,114 @@
+package core_test
+
+import (
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"github.com/danitello/go-blockchain/core"
+	"github.com/danitello/go-blockchain/core/testutil"
+	"github.com/danitello/go-blockchain/core/types"
+)
+
+// branchFrom mines n Blocks holding only a coinbase tx, the first following the Block with forkHash at forkIndex
+func branchFrom(t *testing.T, bc *core.BlockChain, address string, forkHash []byte, forkIndex, n int) []*types.Block {
+	t.Helper()
+	var branch []*types.Block
+	for i := 0; i < n; i++ {
+		cbtx := types.CoinbaseTx(address, forkIndex+1)
+		block := mineBlock(t, bc, []*types.Transaction{cbtx}, forkHash, forkIndex, bc.Difficulty)
+		branch = append(branch, block)
+		forkHash, forkIndex = block.Hash, block.Index
+	}
+	return branch
+}
+
+// chainHashes gets the hash of each Block of bc by index
+func chainHashes(t *testing.T, bc *core.BlockChain) [][]byte {
+	t.Helper()
+	lastHash, tip := bc.Tip()
+	hashes := make([][]byte, tip+1)
+	for hash := lastHash; len(hash) > 0; {
+		header, err := bc.ChainDB.ReadHeaderWithHash(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes[header.Index] = hash
+		hash = header.PrevHash
+	}
+	return hashes
+}
+
+func TestChainTips(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 3)
+	address := ws.GetAddresses()[0]
+	hashes := chainHashes(t, bc)
+
+	// Branches turned away without being connected still show up as forks
+	equal := branchFrom(t, bc, address, hashes[1], 1, 2)
+	if err := bc.Reorganize(equal); err != core.ErrNotMoreWork {
+		t.Fatalf("Got %v for a branch with equal work, want ErrNotMoreWork", err)
+	}
+	bc.MaxReorgDepth = 1
+	deep := branchFrom(t, bc, address, hashes[0], 0, 4)
+	if err := bc.Reorganize(deep); err != core.ErrReorgTooDeep {
+		t.Fatalf("Got %v for a deep branch, want ErrReorgTooDeep", err)
+	}
+	bc.MaxReorgDepth = core.DefaultMaxReorgDepth
+
+	// A branch with more work replaces Block 3, which becomes a fork
+	longer := branchFrom(t, bc, address, hashes[2], 2, 2)
+	if err := bc.Reorganize(longer); err != nil {
+		t.Fatal(err)
+	}
+
+	// A branch whose last Block is invalid is rolled back. It pays another address, so its first Block isn't longer[1]
+	invalid := branchFrom(t, bc, ws.GetAddresses()[1], longer[0].Hash, longer[0].Index, 2)
+	badCoinbase := types.CoinbaseTx(address, 0)
+	invalid = append(invalid, mineBlock(t, bc, []*types.Transaction{badCoinbase}, invalid[1].Hash, invalid[1].Index, bc.Difficulty))
+	if err := bc.Reorganize(invalid); err == nil {
+		t.Fatal("Branch with an invalid block adopted")
+	}
+
+	tips, err := bc.ChainTips()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tips) == 0 || tips[0].Status != core.TipActive || hex.EncodeToString(tips[0].Hash) != hex.EncodeToString(longer[1].Hash) {
+		t.Fatalf("Got %+v first, want the active tip", tips)
+	}
+
+	type tip struct {
+		height, branchLen int
+		status            string
+	}
+	want := map[string]tip{
+		hex.EncodeToString(longer[1].Hash):  {4, 0, core.TipActive},
+		hex.EncodeToString(equal[1].Hash):   {3, 2, core.TipValidFork},
+		hex.EncodeToString(deep[3].Hash):    {4, 4, core.TipValidFork},
+		hex.EncodeToString(hashes[3]):       {3, 1, core.TipValidFork},
+		hex.EncodeToString(invalid[2].Hash): {6, 3, core.TipInvalid},
+	}
+	if len(tips) != len(want) {
+		t.Fatalf("Got %d tips, want %d: %+v", len(tips), len(want), tips)
+	}
+	for _, info := range tips {
+		hash := hex.EncodeToString(info.Hash)
+		if got := (tip{info.Height, info.BranchLen, info.Status}); got != want[hash] {
+			t.Fatalf("Tip %s is %+v, want %+v", hash, got, want[hash])
+		}
+	}
+
+	// The work of a branch turned away is counted from the chain it forks from
+	genesisWork, err := bc.CumulativeWork(hashes[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	deepWork := new(big.Int).Mul(core.BlockWork(bc.Difficulty), big.NewInt(4))
+	deepWork.Add(deepWork, genesisWork)
+	for _, info := range tips {
+		if hex.EncodeToString(info.Hash) == hex.EncodeToString(deep[3].Hash) && info.Work.Cmp(deepWork) != 0 {
+			t.Fatalf("Deep branch has work %s, want %s", info.Work, deepWork)
+		}
+	}
+}
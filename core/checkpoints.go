@@ -0,0 +1,59 @@
+package core
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+)
+
+// checkpoints are Blocks known to be part of the chain - height -> expected Block hash
+var (
+	checkpointsMu sync.RWMutex
+	checkpoints   = make(map[int][]byte)
+)
+
+// Checkpoint is the expected hash of the Block at a given height
+type Checkpoint struct {
+	Height int
+	Hash   []byte
+}
+
+// AddCheckpoint requires the Block at a given height to have a given hash. Blocks conflicting with it are rejected,
+// and Verify trusts the Blocks below it without checking their signatures
+func AddCheckpoint(height int, hash []byte) {
+	checkpointsMu.Lock()
+	defer checkpointsMu.Unlock()
+
+	checkpoints[height] = hash
+}
+
+// Checkpoints gets every Checkpoint, lowest height first
+func Checkpoints() []Checkpoint {
+	checkpointsMu.RLock()
+	defer checkpointsMu.RUnlock()
+
+	var res []Checkpoint
+	for height, hash := range checkpoints {
+		res = append(res, Checkpoint{height, hash})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Height < res[j].Height })
+
+	return res
+}
+
+// checkCheckpoint determines whether a Block with a given index and hash agrees with the Checkpoints -
+// matched - whether there is a Checkpoint at the index (and it agrees)
+func checkCheckpoint(index int, hash []byte) (ok, matched bool) {
+	checkpointsMu.RLock()
+	defer checkpointsMu.RUnlock()
+
+	expected, found := checkpoints[index]
+	if !found {
+		return true, false
+	}
+	if bytes.Compare(expected, hash) != 0 {
+		return false, false
+	}
+
+	return true, true
+}
@@ -0,0 +1,45 @@
+package core_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/danitello/go-blockchain/core"
+	"github.com/danitello/go-blockchain/core/testutil"
+	"github.com/danitello/go-blockchain/core/types"
+)
+
+func TestCheckpoints(t *testing.T) {
+	t.Cleanup(core.ClearCheckpoints)
+	bc, ws := testutil.BuildTestChain(t, 3)
+	hashes := chainHashes(t, bc)
+	lastHash, tip := bc.Tip()
+
+	core.AddCheckpoint(2, hashes[2])
+	core.AddCheckpoint(1, hashes[1])
+	want := []core.Checkpoint{{Height: 1, Hash: hashes[1]}, {Height: 2, Hash: hashes[2]}}
+	if got := core.Checkpoints(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Got checkpoints %v, want %v", got, want)
+	}
+	if err := bc.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	block := mineBlock(t, bc, []*types.Transaction{types.CoinbaseTx(ws.GetAddresses()[0], tip+1)}, lastHash, tip, bc.Difficulty)
+	core.AddCheckpoint(block.Index, hashes[0])
+	if err := bc.ValidateBlock(block); err != core.ErrCheckpointMismatch {
+		t.Fatalf("Got %v for a block conflicting with a checkpoint, want ErrCheckpointMismatch", err)
+	}
+	core.AddCheckpoint(block.Index, block.Hash)
+	if err := bc.ValidateBlock(block); err != nil {
+		t.Fatal(err)
+	}
+
+	// A checkpoint the chain already conflicts with fails verification
+	core.AddCheckpoint(1, hashes[0])
+	var verifyErr *core.VerifyError
+	if err := bc.Verify(); !errors.As(err, &verifyErr) || verifyErr.Reason != core.ErrCheckpointMismatch.Error() {
+		t.Fatalf("Got %v verifying a chain conflicting with a checkpoint", err)
+	}
+}
@@ -0,0 +1,93 @@
+package core
+
+import (
+	"github.com/danitello/go-blockchain/chaindb"
+	"github.com/danitello/go-blockchain/wallet"
+)
+
+// ClusterAddresses groups the addresses seen in the BlockChain by likely owner, using the common-input-ownership
+// heuristic - every txin of a Transaction is assumed to be signed by the same entity, so their addresses share a
+// cluster. Returns a cluster ID for each address, numbered from 0 in the order clusters first appear from the genesis
+// Block. Addresses that only ever receive are in clusters of their own. Fails with ErrBlockPruned if the chain has
+// been pruned
+func (bc *BlockChain) ClusterAddresses() (map[string]int, error) {
+	bc.tipMu.RLock()
+	hashes, err := bc.chainHashes()
+	bc.tipMu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	uf := newUnionFind()
+	for _, hash := range hashes {
+		block, err := bc.ChainDB.ReadBlockWithHash(hash)
+		if err != nil {
+			return nil, err
+		}
+		if len(block.Transactions) == 0 {
+			return nil, chaindb.ErrBlockPruned
+		}
+
+		for _, tx := range block.Transactions {
+			if !tx.IsCoinbase() {
+				first := string(bc.AddressParams.Address(wallet.HashPubKey(tx.Inputs[0].PubKey)))
+				for _, txin := range tx.Inputs[1:] {
+					uf.union(first, string(bc.AddressParams.Address(wallet.HashPubKey(txin.PubKey))))
+				}
+				uf.find(first)
+			}
+			for _, txo := range tx.Outputs {
+				uf.find(string(bc.AddressParams.Address(txo.PubKeyHash)))
+			}
+		}
+	}
+
+	clusters := make(map[string]int)
+	ids := make(map[string]int) // root address -> cluster ID
+	for _, address := range uf.order {
+		root := uf.find(address)
+		if _, ok := ids[root]; !ok {
+			ids[root] = len(ids)
+		}
+		clusters[address] = ids[root]
+	}
+
+	return clusters, nil
+}
+
+// unionFind is a disjoint set forest of addresses -
+// order - every address in the order it was first seen
+type unionFind struct {
+	parent map[string]string
+	order  []string
+}
+
+// newUnionFind creates an empty unionFind
+func newUnionFind() *unionFind {
+	return &unionFind{parent: make(map[string]string)}
+}
+
+// find gets the root address of the set holding an address, adding it in a set of its own if it is new
+func (uf *unionFind) find(address string) string {
+	parent, ok := uf.parent[address]
+	if !ok {
+		uf.parent[address] = address
+		uf.order = append(uf.order, address)
+		return address
+	}
+	if parent == address {
+		return address
+	}
+
+	root := uf.find(parent)
+	uf.parent[address] = root // path compression
+	return root
+}
+
+// union merges the sets holding two addresses
+func (uf *unionFind) union(a, b string) {
+	rootA, rootB := uf.find(a), uf.find(b)
+	if rootA != rootB {
+		uf.parent[rootB] = rootA
+	}
+}
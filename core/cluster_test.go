@@ -0,0 +1,71 @@
+package core_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/danitello/go-blockchain/core/testutil"
+	"github.com/danitello/go-blockchain/core/types"
+)
+
+func TestClusterAddresses(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 0)
+	addresses := ws.GetAddresses()
+	a0, a1, a2, a3 := addresses[0], addresses[1], addresses[2], addresses[3]
+
+	var coinbases []*types.Transaction
+	for _, to := range []string{a1, a2} {
+		_, tip := bc.Tip()
+		coinbase := types.CoinbaseTx(to, tip+1)
+		if err := bc.AddBlock([]*types.Transaction{coinbase}); err != nil {
+			t.Fatal(err)
+		}
+		coinbases = append(coinbases, coinbase)
+	}
+
+	// One Transaction spending the coinbase txos of both a1 and a2, each txin signed by its own key
+	total := coinbases[0].Outputs[0].Amount + coinbases[1].Outputs[0].Amount
+	utxos := map[string][]int{hex.EncodeToString(coinbases[0].ID): {0}, hex.EncodeToString(coinbases[1].ID): {0}}
+	joint := types.CreateTransaction(a1, a3, ws.Wallets[a1].GetPubKey(), total, total, utxos)
+	for i, txin := range joint.Inputs {
+		if bytes.Equal(txin.TxID, coinbases[1].ID) {
+			joint.Inputs[i].PubKey = ws.Wallets[a2].GetPubKey()
+		}
+	}
+	joint.SetOutputs(joint.Outputs) // updates the ID for the changed PubKey
+	prevTxs := map[string]types.Transaction{
+		hex.EncodeToString(coinbases[0].ID): *coinbases[0],
+		hex.EncodeToString(coinbases[1].ID): *coinbases[1]}
+	signed := make(map[string]*types.Transaction)
+	for _, address := range []string{a1, a2} {
+		signed[address] = joint.Copy()
+		signed[address].Sign(ws.Wallets[address].PrivateKey, prevTxs, types.SigHashAll)
+	}
+	for i, txin := range joint.Inputs {
+		signer := a1
+		if bytes.Equal(txin.TxID, coinbases[1].ID) {
+			signer = a2
+		}
+		joint.Inputs[i].Signature = signed[signer].Inputs[i].Signature
+	}
+	addBlock(t, bc, a0, joint)
+
+	clusters, err := bc.ClusterAddresses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clusters) != 4 {
+		t.Fatalf("Got %d clustered addresses, want 4", len(clusters))
+	}
+	if clusters[a1] != clusters[a2] {
+		t.Fatalf("Got clusters %d and %d for addresses spent together", clusters[a1], clusters[a2])
+	}
+	if clusters[a0] == clusters[a1] || clusters[a3] == clusters[a1] || clusters[a0] == clusters[a3] {
+		t.Fatalf("Got clusters %v, want a0 and a3 on their own", clusters)
+	}
+	// Numbered from 0 in the order clusters first appear, and a3 receives after a1 and a2
+	if clusters[a0]+clusters[a1]+clusters[a3] != 0+1+2 || clusters[a1] > clusters[a3] {
+		t.Fatalf("Got clusters %v, want them numbered by first appearance", clusters)
+	}
+}
@@ -0,0 +1,89 @@
+package core
+
+import (
+	"github.com/danitello/go-blockchain/core/types"
+)
+
+// ShortIDLen is the number of leading bytes of a Transaction ID used to identify it in a CompactBlock
+const ShortIDLen = 6
+
+// CompactBlock announces a Block by its BlockHeader and the short IDs of its Transactions, so a peer can rebuild it
+// from its Mempool rather than receive every Transaction again -
+// Coinbase - sent in full, as no Mempool holds it
+// ShortIDs - short IDs of the other Transactions, in Block order
+type CompactBlock struct {
+	Header   *types.BlockHeader
+	Coinbase *types.Transaction
+	ShortIDs [][]byte
+}
+
+// BuildCompactBlock creates the CompactBlock announcing a Block
+func BuildCompactBlock(b *types.Block) CompactBlock {
+	cb := CompactBlock{Header: b.Header()}
+
+	for i, tx := range b.Transactions {
+		if i == 0 && tx.IsCoinbase() {
+			cb.Coinbase = tx
+			continue
+		}
+		cb.ShortIDs = append(cb.ShortIDs, shortID(tx.ID))
+	}
+
+	return cb
+}
+
+// ReconstructBlock rebuilds the Block announced by a CompactBlock from the Transactions in a Mempool. If any are
+// missing the Block is nil and the short IDs to request are returned. If the rebuilt Block doesn't match the
+// BlockHeader (short IDs can collide) every short ID is returned, as the whole Block must be requested. hasher is
+// the Hasher of the BlockChain the Block is for
+func ReconstructBlock(cb CompactBlock, mp *Mempool, hasher types.Hasher) (*types.Block, [][]byte) {
+	// Index the Mempool by short ID, leaving out any that are ambiguous
+	byShortID := make(map[string]*types.Transaction)
+	ambiguous := make(map[string]bool)
+	for _, tx := range mp.Transactions() {
+		key := string(shortID(tx.ID))
+		if _, ok := byShortID[key]; ok {
+			ambiguous[key] = true
+		}
+		byShortID[key] = tx
+	}
+
+	var txns []*types.Transaction
+	var missing [][]byte
+	if cb.Coinbase != nil {
+		txns = append(txns, cb.Coinbase)
+	}
+	for _, id := range cb.ShortIDs {
+		tx, ok := byShortID[string(id)]
+		if !ok || ambiguous[string(id)] {
+			missing = append(missing, id)
+			continue
+		}
+		txns = append(txns, tx)
+	}
+	if len(missing) > 0 {
+		return nil, missing
+	}
+
+	block := &types.Block{
+		Index:        cb.Header.Index,
+		Nonce:        cb.Header.Nonce,
+		Difficulty:   cb.Header.Difficulty,
+		Hash:         cb.Header.Hash,
+		PrevHash:     cb.Header.PrevHash,
+		TimeStamp:    cb.Header.TimeStamp,
+		Transactions: txns}
+	if !block.ValidateHash(hasher) {
+		return nil, cb.ShortIDs
+	}
+
+	return block, nil
+}
+
+// shortID gets the short ID of a Transaction with a given ID
+func shortID(txID []byte) []byte {
+	if len(txID) < ShortIDLen {
+		return txID
+	}
+	return txID[:ShortIDLen]
+}
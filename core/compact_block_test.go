@@ -0,0 +1,67 @@
+package core_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/danitello/go-blockchain/core"
+	"github.com/danitello/go-blockchain/core/testutil"
+	"github.com/danitello/go-blockchain/core/types"
+)
+
+func TestReconstructBlock(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 4)
+	spends := testutil.SpendEach(t, bc, ws)
+	if len(spends) < 2 {
+		t.Fatalf("%d spends, want at least 2", len(spends))
+	}
+	lastHash, tip := bc.Tip()
+	txns := append([]*types.Transaction{types.CoinbaseTx(ws.GetAddresses()[0], tip+1)}, spends...)
+	block := mineBlock(t, bc, txns, lastHash, tip, bc.Difficulty)
+
+	cb := core.BuildCompactBlock(block)
+	if cb.Coinbase != txns[0] || len(cb.ShortIDs) != len(spends) {
+		t.Fatalf("Compact block has %d short IDs, want %d and the coinbase", len(cb.ShortIDs), len(spends))
+	}
+
+	// The Mempool only has the first half of the spends
+	half := len(spends) / 2
+	mp := core.InitMempool()
+	for _, tx := range spends[:half] {
+		if err := mp.Add(tx, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rebuilt, missing := core.ReconstructBlock(cb, mp, bc.Hasher)
+	if rebuilt != nil || len(missing) != len(spends)-half {
+		t.Fatalf("Got %d missing, want %d", len(missing), len(spends)-half)
+	}
+	for i, id := range missing {
+		if !bytes.HasPrefix(spends[half+i].ID, id) || len(id) != core.ShortIDLen {
+			t.Fatalf("Missing short ID %x is not of spend %d", id, half+i)
+		}
+	}
+
+	for _, tx := range spends[half:] {
+		if err := mp.Add(tx, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rebuilt, missing = core.ReconstructBlock(cb, mp, bc.Hasher)
+	if len(missing) != 0 {
+		t.Fatalf("%d still missing", len(missing))
+	}
+	if !reflect.DeepEqual(rebuilt.Hash, block.Hash) || !reflect.DeepEqual(rebuilt.Transactions, block.Transactions) {
+		t.Fatal("Rebuilt block differs")
+	}
+	if err := bc.ValidateBlock(rebuilt); err != nil {
+		t.Fatal(err)
+	}
+
+	// A header the Transactions don't hash to means the whole Block must be requested
+	cb.Header.Nonce++
+	if rebuilt, missing := core.ReconstructBlock(cb, mp, bc.Hasher); rebuilt != nil || len(missing) != len(spends) {
+		t.Fatalf("Got %d missing for a mismatched header, want all %d", len(missing), len(spends))
+	}
+}
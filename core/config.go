@@ -0,0 +1,205 @@
+package core
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/danitello/go-blockchain/chaindb"
+	"github.com/danitello/go-blockchain/core/types"
+	"github.com/danitello/go-blockchain/wallet"
+)
+
+const (
+	// DefaultNetwork is the network a node joins unless configured otherwise
+	DefaultNetwork = "main"
+	// DefaultMaxBlockSize is the most bytes a serialized Block may have unless configured otherwise
+	DefaultMaxBlockSize = 1000000
+	// DefaultBlockCacheSize is the number of recently read Blocks a node keeps in memory unless configured otherwise
+	DefaultBlockCacheSize = 100
+	// DefaultCodec is the name of the chaindb.Codec a node stores Blocks with unless configured otherwise
+	DefaultCodec = "gob"
+	// DefaultTargetBlockInterval is the time Blocks are meant to take to mine unless configured otherwise
+	DefaultTargetBlockInterval = 10 * time.Minute
+	// DefaultPowHash is the name of the types.Hasher Blocks are mined with unless configured otherwise
+	DefaultPowHash = "sha256d"
+)
+
+// Config holds the node parameters that can be set without recompiling -
+// Network - name of the network the node belongs to, recorded in the db of a new chain, which is refused by a node of
+// another network
+// GenesisHash - hex hash of the network's genesis Block, which the chain in the db must start with unless empty
+// DataDir - directory of the ChainDB, which also holds the Mempool saved by Shutdown
+// Codec - name of the chaindb.Codec the ChainDB stores Blocks with, see chaindb.CodecByName
+// BlockCacheSize - number of recently read Blocks the ChainDB keeps in memory, 0 for none
+// Difficulty - difficulty of the genesis Block, which every Block is mined at unless RetargetInterval is set, when it
+// is the least difficulty a Block may have
+// PowHash - name of the types.Hasher Blocks are mined and validated with, see types.HasherByName
+// Hasher - a types.Hasher to use instead of PowHash, set in code, e.g. to experiment with a new hash function
+// MaxBlockSize - most bytes a serialized Block may have
+// PrioritySize - bytes of each mined Block kept for high Priority Transactions
+// TargetBlockInterval - time Blocks are meant to take to mine, in nanoseconds in JSON
+// RetargetInterval - number of Blocks after which the difficulty is adjusted towards TargetBlockInterval, 0 to keep
+// it at Difficulty
+// MinRelayFee, DustThreshold, MinConfirmations, MaxReorgDepth - as for the Mempool and BlockChain fields
+// AddressChecksumLen, AddressChecksumHash - the network's wallet.AddressParams
+type Config struct {
+	Network             string        `json:"network"`
+	GenesisHash         string        `json:"genesisHash"`
+	DataDir             string        `json:"dataDir"`
+	Codec               string        `json:"codec"`
+	BlockCacheSize      int           `json:"blockCacheSize"`
+	Difficulty          int           `json:"difficulty"`
+	PowHash             string        `json:"powHash"`
+	Hasher              types.Hasher  `json:"-"`
+	MaxBlockSize        int           `json:"maxBlockSize"`
+	PrioritySize        int           `json:"prioritySize"`
+	TargetBlockInterval time.Duration `json:"targetBlockInterval"`
+	RetargetInterval    int           `json:"retargetInterval"`
+	MinRelayFee         int           `json:"minRelayFee"`
+	DustThreshold       int           `json:"dustThreshold"`
+	MinConfirmations    int           `json:"minConfirmations"`
+	MaxReorgDepth       int           `json:"maxReorgDepth"`
+	AddressChecksumLen  int           `json:"addressChecksumLen"`
+	AddressChecksumHash string        `json:"addressChecksumHash"`
+}
+
+// ConfigError describes why a Config was rejected -
+// Field - the JSON name of the bad field
+// Reason - what is wrong with its value
+type ConfigError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("Invalid config field %s: %s", e.Field, e.Reason)
+}
+
+// DefaultConfig creates a Config with the values a node uses when none are given
+func DefaultConfig() *Config {
+	return &Config{
+		Network:             DefaultNetwork,
+		DataDir:             chaindb.Dir,
+		Codec:               DefaultCodec,
+		BlockCacheSize:      DefaultBlockCacheSize,
+		Difficulty:          types.DefaultDifficulty,
+		PowHash:             DefaultPowHash,
+		MaxBlockSize:        DefaultMaxBlockSize,
+		TargetBlockInterval: DefaultTargetBlockInterval,
+		DustThreshold:       DefaultDustThreshold,
+		MaxReorgDepth:       DefaultMaxReorgDepth,
+		AddressChecksumLen:  wallet.DefaultAddressParams.ChecksumLen,
+		AddressChecksumHash: wallet.DefaultAddressParams.ChecksumHash}
+}
+
+// LoadConfig reads a Config from a JSON file. Fields the file leaves out keep their DefaultConfig values, and fields
+// it doesn't know about are an error
+func LoadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cfg := DefaultConfig()
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("Invalid config file %s: %s", path, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Validate checks the value of every field, returning a ConfigError for the first bad one
+func (cfg *Config) Validate() error {
+	if cfg.Network == "" {
+		return &ConfigError{"network", "must not be empty"}
+	}
+	if cfg.GenesisHash != "" {
+		if hash, err := hex.DecodeString(cfg.GenesisHash); err != nil || len(hash) != 32 {
+			return &ConfigError{"genesisHash", "must be a 32 byte hex hash"}
+		}
+	}
+	if cfg.DataDir == "" {
+		return &ConfigError{"dataDir", "must not be empty"}
+	}
+	if _, err := chaindb.CodecByName(cfg.Codec); err != nil {
+		return &ConfigError{"codec", err.Error()}
+	}
+	if cfg.BlockCacheSize < 0 {
+		return &ConfigError{"blockCacheSize", "must not be negative"}
+	}
+	if cfg.Difficulty < 1 || cfg.Difficulty > maxDifficulty {
+		return &ConfigError{"difficulty", "must be between 1 and 255"}
+	}
+	if _, err := types.HasherByName(cfg.PowHash); err != nil && cfg.Hasher == nil {
+		return &ConfigError{"powHash", err.Error()}
+	}
+	if cfg.MaxBlockSize <= 0 {
+		return &ConfigError{"maxBlockSize", "must be positive"}
+	}
+	if cfg.PrioritySize < 0 || cfg.PrioritySize > cfg.MaxBlockSize {
+		return &ConfigError{"prioritySize", "must be between 0 and maxBlockSize"}
+	}
+	if cfg.TargetBlockInterval <= 0 {
+		return &ConfigError{"targetBlockInterval", "must be positive"}
+	}
+	if cfg.RetargetInterval < 0 {
+		return &ConfigError{"retargetInterval", "must not be negative"}
+	}
+	if cfg.MinRelayFee < 0 {
+		return &ConfigError{"minRelayFee", "must not be negative"}
+	}
+	if cfg.DustThreshold < 0 {
+		return &ConfigError{"dustThreshold", "must not be negative"}
+	}
+	if cfg.MinConfirmations < 0 {
+		return &ConfigError{"minConfirmations", "must not be negative"}
+	}
+	if cfg.MaxReorgDepth < 0 {
+		return &ConfigError{"maxReorgDepth", "must not be negative"}
+	}
+	if err := cfg.addressParams().Validate(); err != nil {
+		return &ConfigError{"addressChecksumLen/addressChecksumHash", err.Error()}
+	}
+
+	return nil
+}
+
+// codec gets the chaindb.Codec named by Codec
+func (cfg *Config) codec() chaindb.Codec {
+	codec, _ := chaindb.CodecByName(cfg.Codec) // checked by Validate
+	return codec
+}
+
+// hasher gets the types.Hasher Blocks are mined and validated with, Hasher if set or else the one named by PowHash
+func (cfg *Config) hasher() types.Hasher {
+	if cfg.Hasher != nil {
+		return cfg.Hasher
+	}
+
+	hasher, _ := types.HasherByName(cfg.PowHash) // checked by Validate
+	return hasher
+}
+
+// addressParams gets the wallet.AddressParams of AddressChecksumLen and AddressChecksumHash
+func (cfg *Config) addressParams() wallet.AddressParams {
+	return wallet.AddressParams{ChecksumLen: cfg.AddressChecksumLen, ChecksumHash: cfg.AddressChecksumHash}
+}
+
+// genesisHash gets the genesis hash the Config expects, nil to accept any
+func (cfg *Config) genesisHash() []byte {
+	if cfg.GenesisHash == "" {
+		return nil
+	}
+
+	hash, _ := hex.DecodeString(cfg.GenesisHash) // checked by Validate
+	return hash
+}
@@ -0,0 +1,120 @@
+package core_test
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/danitello/go-blockchain/core"
+	"github.com/danitello/go-blockchain/core/testutil"
+	"github.com/danitello/go-blockchain/core/types"
+	"github.com/danitello/go-blockchain/wallet"
+)
+
+// writeConfig writes a config file holding json to a temporary directory, returning its path
+func writeConfig(t *testing.T, json string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(path, []byte(json), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(t, `{"network": "test", "dataDir": "./testdata", "maxBlockSize": 5000, "targetBlockInterval": 60000000000}`)
+
+	cfg, err := core.LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := core.DefaultConfig()
+	want.Network = "test"
+	want.DataDir = "./testdata"
+	want.MaxBlockSize = 5000
+	want.TargetBlockInterval = time.Minute
+	if !reflect.DeepEqual(cfg, want) {
+		t.Fatalf("Got %+v, want %+v", cfg, want)
+	}
+
+	if _, err := core.LoadConfig(filepath.Join(filepath.Dir(path), "missing.json")); !os.IsNotExist(err) {
+		t.Fatalf("Got %v for a missing file", err)
+	}
+	if _, err := core.LoadConfig(writeConfig(t, `{"blockSize": 5000}`)); err == nil {
+		t.Fatal("Loaded a config with an unknown field")
+	}
+}
+
+func TestLoadConfigBadField(t *testing.T) {
+	tests := map[string]string{
+		`{"network": ""}`:                    "network",
+		`{"genesisHash": "abcd"}`:            "genesisHash",
+		`{"codec": "xml"}`:                   "codec",
+		`{"blockCacheSize": -1}`:             "blockCacheSize",
+		`{"difficulty": 0}`:                  "difficulty",
+		`{"maxBlockSize": 0}`:                "maxBlockSize",
+		`{"prioritySize": 2000000}`:          "prioritySize",
+		`{"targetBlockInterval": -1}`:        "targetBlockInterval",
+		`{"retargetInterval": -1}`:           "retargetInterval",
+		`{"minRelayFee": -1}`:                "minRelayFee",
+		`{"dustThreshold": -1}`:              "dustThreshold",
+		`{"minConfirmations": -1}`:           "minConfirmations",
+		`{"maxReorgDepth": -1}`:              "maxReorgDepth",
+		`{"addressChecksumHash": "md5"}`:     "addressChecksumLen/addressChecksumHash",
+		`{"addressChecksumLen": 33}`:         "addressChecksumLen/addressChecksumHash",
+		`{"dataDir": "", "network": "test"}`: "dataDir",
+	}
+
+	for json, field := range tests {
+		_, err := core.LoadConfig(writeConfig(t, json))
+		if cfgErr, ok := err.(*core.ConfigError); !ok || cfgErr.Field != field {
+			t.Errorf("%s: got %v, want a %s ConfigError", json, err, field)
+		}
+	}
+}
+
+func TestConfigAddressParams(t *testing.T) {
+	defaultChain, _ := testutil.BuildTestChain(t, 0)
+	w, err := wallet.InitWalletFromReader(rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "paramschain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := core.DefaultConfig()
+	cfg.DataDir = dir
+	cfg.Difficulty = types.TestDifficulty
+	cfg.AddressChecksumLen, cfg.AddressChecksumHash = 6, "sha256"
+	address := string(w.GetAddressWithParams(wallet.AddressParams{ChecksumLen: 6, ChecksumHash: "sha256"}))
+	bc := core.InitBlockChainWithConfig(address, cfg)
+	defer bc.ChainDB.CloseDB()
+
+	// Each BlockChain validates addresses under its own Config, however many are open
+	defaultAddress := string(w.GetAddress())
+	if _, err := bc.ExportAddressUTXOs(address); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.ExportAddressUTXOs(defaultAddress); err == nil {
+		t.Fatal("Exported an address of the default scheme")
+	}
+	if _, err := defaultChain.ExportAddressUTXOs(defaultAddress); err != nil {
+		t.Fatal(err)
+	}
+	if wallet.ValidateAddress(address) || !wallet.ValidateAddress(defaultAddress) {
+		t.Fatal("Opening a BlockChain changed the address scheme of the wallet package")
+	}
+}
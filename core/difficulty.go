@@ -0,0 +1,164 @@
+package core
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/danitello/go-blockchain/core/types"
+)
+
+// maxDifficulty is the most leading zero bits a proof can have, as it is a sha256 sized hash
+const maxDifficulty = 255
+
+// ErrHeightNotOnChain is returned when asking for a Block index the BlockChain doesn't have
+var ErrHeightNotOnChain = errors.New("Height is not on the chain")
+
+// DifficultyAt gets the difficulty of the Block at a given index as a multiple of the genesis Block's, i.e. how many
+// times as many hashes finding its proof takes on average. Each extra leading zero bit doubles it
+func (bc *BlockChain) DifficultyAt(height int) (float64, error) {
+	history, err := bc.DifficultyHistory(height, height)
+	if err != nil {
+		return 0, err
+	}
+
+	return history[0], nil
+}
+
+// DifficultyHistory gets the difficulty, as for DifficultyAt, of every Block from index fromHeight to toHeight
+// inclusive
+func (bc *BlockChain) DifficultyHistory(fromHeight, toHeight int) ([]float64, error) {
+	bc.tipMu.RLock()
+	hashes, err := bc.chainHashes()
+	bc.tipMu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	if fromHeight < 0 || toHeight >= len(hashes) || fromHeight > toHeight {
+		return nil, ErrHeightNotOnChain
+	}
+
+	genesis, err := bc.ChainDB.ReadHeaderWithHash(hashes[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var history []float64
+	for _, hash := range hashes[fromHeight : toHeight+1] {
+		header, err := bc.ChainDB.ReadHeaderWithHash(hash)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, relativeDifficulty(header.Difficulty, genesis.Difficulty))
+	}
+
+	return history, nil
+}
+
+// relativeDifficulty gets how many times harder a proof at difficulty is than one at base, the ratio of their targets
+func relativeDifficulty(difficulty, base int) float64 {
+	return math.Pow(2, float64(difficulty-base))
+}
+
+// AverageBlockTime gets the mean time between the timestamps of the most recent lastN+1 Blocks, i.e. over the lastN
+// most recent Blocks, to compare with TargetBlockInterval. Fewer are used if the chain is shorter, and a chain of just
+// the genesis Block gives 0
+func (bc *BlockChain) AverageBlockTime(lastN int) (time.Duration, error) {
+	if lastN < 1 {
+		return 0, errors.New("Must average over at least one block")
+	}
+
+	bc.tipMu.RLock()
+	hashes, err := bc.chainHashes()
+	bc.tipMu.RUnlock()
+	if err != nil {
+		return 0, err
+	}
+	if lastN > len(hashes)-1 {
+		lastN = len(hashes) - 1
+	}
+	if lastN == 0 {
+		return 0, nil
+	}
+
+	first, err := bc.ChainDB.ReadHeaderWithHash(hashes[len(hashes)-1-lastN])
+	if err != nil {
+		return 0, err
+	}
+	last, err := bc.ChainDB.ReadHeaderWithHash(hashes[len(hashes)-1])
+	if err != nil {
+		return 0, err
+	}
+	start, err := first.Time()
+	if err != nil {
+		return 0, err
+	}
+	end, err := last.Time()
+	if err != nil {
+		return 0, err
+	}
+
+	return end.Sub(start) / time.Duration(lastN), nil
+}
+
+// NextDifficulty gets the difficulty the next Block added to the BlockChain must have. With RetargetInterval set this
+// is adjusted every RetargetInterval Blocks, see difficultyAfter, otherwise it is always Difficulty
+func (bc *BlockChain) NextDifficulty() (int, error) {
+	bc.tipMu.RLock()
+	defer bc.tipMu.RUnlock()
+
+	return bc.nextDifficulty()
+}
+
+// nextDifficulty does the work of NextDifficulty for callers already holding tipMu
+func (bc *BlockChain) nextDifficulty() (int, error) {
+	prev, err := bc.ChainDB.ReadHeaderWithHash(bc.LastHash)
+	if err != nil {
+		return 0, err
+	}
+
+	return bc.difficultyAfter(prev, bc.ChainDB.ReadHeaderWithHash)
+}
+
+// difficultyAfter gets the difficulty of the Block following prev, reading the ancestors of prev with header. Unless
+// that Block starts a new RetargetInterval it is prev's. Otherwise prev's is raised by one (doubling it) if the most
+// recent RetargetInterval Blocks took less than half the time TargetBlockInterval gives them, or lowered by one
+// (halving it, though not below Difficulty) if they took more than twice as long
+func (bc *BlockChain) difficultyAfter(prev *types.BlockHeader, header func(hash []byte) (*types.BlockHeader, error)) (int, error) {
+	if bc.RetargetInterval == 0 {
+		return bc.Difficulty, nil
+	}
+	if (prev.Index+1)%bc.RetargetInterval != 0 {
+		return prev.Difficulty, nil
+	}
+
+	first := prev
+	for i := 0; i < bc.RetargetInterval && len(first.PrevHash) > 0; i++ {
+		var err error
+		if first, err = header(first.PrevHash); err != nil {
+			return 0, err
+		}
+	}
+	if first.Index == prev.Index {
+		return prev.Difficulty, nil
+	}
+
+	start, err := first.Time()
+	if err != nil {
+		return 0, err
+	}
+	end, err := prev.Time()
+	if err != nil {
+		return 0, err
+	}
+	actual := end.Sub(start)
+	target := bc.TargetBlockInterval * time.Duration(prev.Index-first.Index)
+
+	switch {
+	case actual < target/2 && prev.Difficulty < maxDifficulty:
+		return prev.Difficulty + 1, nil
+	case actual > target*2 && prev.Difficulty > bc.Difficulty:
+		return prev.Difficulty - 1, nil
+	}
+	return prev.Difficulty, nil
+}
@@ -0,0 +1,178 @@
+package core_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danitello/go-blockchain/core"
+	"github.com/danitello/go-blockchain/core/testutil"
+	"github.com/danitello/go-blockchain/core/types"
+)
+
+func TestDifficultyHistory(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 1)
+	address := ws.GetAddresses()[0]
+
+	// Each extra leading zero bit over the genesis Block's doubles the difficulty
+	for _, difficulty := range []int{types.TestDifficulty + 2, types.TestDifficulty + 4, types.TestDifficulty} {
+		bc.Difficulty = difficulty
+		_, tip := bc.Tip()
+		if err := bc.AddBlock([]*types.Transaction{types.CoinbaseTx(address, tip+1)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	history, err := bc.DifficultyHistory(0, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []float64{1, 1, 4, 16, 1}; !reflect.DeepEqual(history, want) {
+		t.Fatalf("Got %v, want %v", history, want)
+	}
+	if difficulty, err := bc.DifficultyAt(3); err != nil || difficulty != 16 {
+		t.Fatalf("Got %v, %v at height 3, want 16", difficulty, err)
+	}
+
+	for _, heights := range [][2]int{{-1, 0}, {0, 5}, {3, 2}} {
+		if _, err := bc.DifficultyHistory(heights[0], heights[1]); err != core.ErrHeightNotOnChain {
+			t.Fatalf("Got %v for heights %v, want ErrHeightNotOnChain", err, heights)
+		}
+	}
+}
+
+// blockAt makes a Block holding only a coinbase tx following the Block with prevHash at prevIndex, with a given
+// difficulty and TimeStamp, searching for its Nonce without changing the TimeStamp as RunParallel would
+func blockAt(t *testing.T, bc *core.BlockChain, address string, prevHash []byte, prevIndex, difficulty int, stamp time.Time) *types.Block {
+	t.Helper()
+	txns := []*types.Transaction{types.CoinbaseTx(address, prevIndex+1)}
+
+	for nonce := 0; ; nonce++ {
+		block := types.AssembleBlock(txns, prevHash, prevIndex, difficulty, nonce, []byte(stamp.String()), bc.Hasher)
+		if block.ValidateProof(bc.Hasher) {
+			return block
+		}
+	}
+}
+
+// addBlockAt adds a Block at the difficulty NextDifficulty requires with a given TimeStamp to the tip of bc
+func addBlockAt(t *testing.T, bc *core.BlockChain, address string, stamp time.Time) *types.Block {
+	t.Helper()
+	difficulty, err := bc.NextDifficulty()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lastHash, tip := bc.Tip()
+
+	block := blockAt(t, bc, address, lastHash, tip, difficulty, stamp)
+	if err := bc.ValidateBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.SaveNewLastBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+// genesisTime gets the time of the genesis Block of a BlockChain that has only that Block
+func genesisTime(t *testing.T, bc *core.BlockChain) time.Time {
+	t.Helper()
+	lastHash, _ := bc.Tip()
+	genesis, err := bc.ChainDB.ReadHeaderWithHash(lastHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start, err := genesis.Time()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return start
+}
+
+func TestAverageBlockTime(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 0)
+	address := ws.GetAddresses()[0]
+	start := genesisTime(t, bc)
+
+	if average, err := bc.AverageBlockTime(10); err != nil || average != 0 {
+		t.Fatalf("Got %s, %v for just the genesis block", average, err)
+	}
+	for _, seconds := range []int{10, 30, 60, 100} {
+		addBlockAt(t, bc, address, start.Add(time.Duration(seconds)*time.Second))
+	}
+
+	for lastN, want := range map[int]time.Duration{
+		1:  40 * time.Second,
+		2:  35 * time.Second,
+		4:  25 * time.Second,
+		10: 25 * time.Second, // only 4 to average over
+	} {
+		if average, err := bc.AverageBlockTime(lastN); err != nil || average != want {
+			t.Fatalf("Got %s, %v over %d blocks, want %s", average, err, lastN, want)
+		}
+	}
+	if _, err := bc.AverageBlockTime(0); err == nil {
+		t.Fatal("No error averaging over no blocks")
+	}
+}
+
+func TestRetarget(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 0)
+	address := ws.GetAddresses()[0]
+	start := genesisTime(t, bc)
+	bc.RetargetInterval = 4
+	bc.TargetBlockInterval = time.Second
+	base := bc.Difficulty
+
+	// The first interval takes no time at all, so the difficulty doubles
+	for i := 0; i < 3; i++ {
+		addBlockAt(t, bc, address, start)
+	}
+	if next, err := bc.NextDifficulty(); err != nil || next != base+1 {
+		t.Fatalf("Got %d, %v after a fast interval, want %d", next, err, base+1)
+	}
+	lastHash, tip := bc.Tip()
+	if err := bc.ValidateBlock(blockAt(t, bc, address, lastHash, tip, base, start)); err != core.ErrBadDifficulty {
+		t.Fatalf("Got %v for a block at the old difficulty, want ErrBadDifficulty", err)
+	}
+
+	// The second takes 10 times as long as the target, so it halves again, and within an interval it doesn't change
+	var blocks []*types.Block
+	for i := 1; i <= 4; i++ {
+		blocks = append(blocks, addBlockAt(t, bc, address, start.Add(time.Duration(i)*10*time.Second)))
+		if blocks[i-1].Difficulty != base+1 {
+			t.Fatalf("Block %d has difficulty %d, want %d", blocks[i-1].Index, blocks[i-1].Difficulty, base+1)
+		}
+	}
+	if next, err := bc.NextDifficulty(); err != nil || next != base {
+		t.Fatalf("Got %d, %v after a slow interval, want %d", next, err, base)
+	}
+
+	// A branch from Block 5 is held to the same rules while it isn't stored yet
+	fork := blocks[1]
+	branch := []*types.Block{blockAt(t, bc, address, fork.Hash, fork.Index, base+1, start.Add(25*time.Second))}
+	branch = append(branch, blockAt(t, bc, address, branch[0].Hash, branch[0].Index, base+1, start.Add(40*time.Second)))
+	bad := blockAt(t, bc, address, branch[1].Hash, branch[1].Index, base+1, start.Add(41*time.Second))
+	err := bc.Reorganize(append(branch, bad))
+	if err == nil || !strings.Contains(err.Error(), core.ErrBadDifficulty.Error()) {
+		t.Fatalf("Got %v for a branch block at the wrong difficulty, want ErrBadDifficulty", err)
+	}
+
+	branch = append(branch, blockAt(t, bc, address, branch[1].Hash, branch[1].Index, base, start.Add(41*time.Second)))
+	branch = append(branch, blockAt(t, bc, address, branch[2].Hash, branch[2].Index, base, start.Add(42*time.Second)))
+	if err := bc.Reorganize(branch); err != nil {
+		t.Fatal(err)
+	}
+	if lastHash, _ := bc.Tip(); string(lastHash) != string(branch[3].Hash) {
+		t.Fatal("Branch not adopted")
+	}
+
+	// An interval taking the target time leaves it alone
+	for i := 1; i <= 2; i++ {
+		addBlockAt(t, bc, address, start.Add(time.Duration(42+i)*time.Second))
+	}
+	if next, err := bc.NextDifficulty(); err != nil || next != base {
+		t.Fatalf("Got %d, %v after an interval on target, want %d", next, err, base)
+	}
+}
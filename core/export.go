@@ -0,0 +1,143 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/danitello/go-blockchain/chaindb"
+	"github.com/danitello/go-blockchain/core/types"
+)
+
+// ExportJSON writes every Block of the BlockChain to w from the genesis Block to the tip, as one JSON object per line.
+// A pruned chain can't be exported, as its Blocks can't be validated again on import
+func (bc *BlockChain) ExportJSON(w io.Writer) error {
+	var hashes [][]byte
+	iter := bc.Iterator()
+	for {
+		block := iter.Next()
+		if len(block.Transactions) == 0 {
+			return chaindb.ErrBlockPruned
+		}
+		hashes = append(hashes, block.Hash)
+
+		// Reached the beginning of the chain
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	for i := len(hashes) - 1; i >= 0; i-- {
+		block, err := bc.ChainDB.ReadBlockWithHash(hashes[i])
+		if err != nil {
+			return err
+		}
+		if err := encoder.Encode(block); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ImportJSON reads Blocks written by ExportJSON from r, oldest first, and adds each to the BlockChain after validating
+// it. Blocks already on the chain are skipped, so the stream must start with this chain's genesis Block
+func (bc *BlockChain) ImportJSON(r io.Reader) error {
+	return bc.importJSON(r, 1)
+}
+
+// importJSON does the work of ImportJSON for a stream whose first line is the given line of the whole stream
+func (bc *BlockChain) importJSON(r io.Reader, firstLine int) error {
+	decoder := json.NewDecoder(r)
+	var onChain [][]byte // hash of each Block on the chain by index, read when first needed
+
+	for line := firstLine; ; line++ {
+		var block types.Block
+		if err := decoder.Decode(&block); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("Invalid block on line %d: %s", line, err)
+		}
+
+		if _, tip := bc.Tip(); block.Index <= tip {
+			if onChain == nil {
+				var err error
+				if onChain, err = bc.chainHashes(); err != nil {
+					return err
+				}
+			}
+			if block.Index < 0 || bytes.Compare(onChain[block.Index], block.Hash) != 0 {
+				return fmt.Errorf("Block on line %d conflicts with block %d of the chain", line, block.Index)
+			}
+			continue
+		}
+
+		if err := bc.connectBlock(&block); err != nil {
+			return fmt.Errorf("Block on line %d: %s", line, err)
+		}
+		onChain = nil
+	}
+}
+
+// ImportBlockChainJSON creates a BlockChain in the DataDir of a Config from Blocks written by ExportJSON, e.g. to move
+// a chain to another node. The first Block must be a genesis Block, and match the Config's genesis hash if it has one.
+// If a later Block is invalid, the BlockChain holding the Blocks before it is returned along with the error
+func ImportBlockChainJSON(r io.Reader, cfg *Config) (*BlockChain, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(r)
+	var genesis types.Block
+	if err := decoder.Decode(&genesis); err != nil {
+		return nil, fmt.Errorf("Invalid block on line 1: %s", err)
+	}
+	if err := types.ValidateBlockHeader(genesis.Header(), nil, cfg.hasher()); err != nil {
+		return nil, err
+	}
+	if err := validateCoinbase(&genesis); err != nil {
+		return nil, err
+	}
+	if expected := cfg.genesisHash(); expected != nil && bytes.Compare(genesis.Hash, expected) != 0 {
+		return nil, ErrWrongNetwork
+	}
+
+	db := chaindb.InitDBWithCodec(cfg.DataDir, cfg.BlockCacheSize, cfg.codec())
+	if db.HasChain() {
+		db.CloseDB()
+		return nil, fmt.Errorf("BlockChain already exists in %s", cfg.DataDir)
+	}
+	bc := newBlockChain(db, cfg)
+	if err := bc.saveNewLastBlock(&genesis); err != nil {
+		db.CloseDB()
+		return nil, err
+	}
+	if err := setNetwork(db, cfg.Network); err != nil {
+		db.CloseDB()
+		return nil, err
+	}
+
+	// The decoder may have buffered past the genesis Block
+	err := bc.importJSON(io.MultiReader(decoder.Buffered(), r), 2)
+
+	return bc, err
+}
+
+// chainHashes gets the hash of every Block on the chain, indexed by Block index
+func (bc *BlockChain) chainHashes() ([][]byte, error) {
+	hash, tip := bc.Tip()
+	hashes := make([][]byte, tip+1)
+
+	for i := tip; i >= 0; i-- {
+		hashes[i] = hash
+		header, err := bc.ChainDB.ReadHeaderWithHash(hash)
+		if err != nil {
+			return nil, err
+		}
+		hash = header.PrevHash
+	}
+
+	return hashes, nil
+}
@@ -0,0 +1,93 @@
+package core_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/danitello/go-blockchain/core"
+	"github.com/danitello/go-blockchain/core/testutil"
+	"github.com/danitello/go-blockchain/core/types"
+)
+
+func TestExportImportJSON(t *testing.T) {
+	bc, _ := testutil.BuildTestChain(t, 4)
+	var exported bytes.Buffer
+	if err := bc.ExportJSON(&exported); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(exported.String(), "\n"); lines != 5 {
+		t.Fatalf("Exported %d lines, want 5", lines)
+	}
+
+	// Importing its own Blocks changes nothing
+	if err := bc.ImportJSON(bytes.NewReader(exported.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "importchain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := core.DefaultConfig()
+	cfg.DataDir = dir
+	cfg.Difficulty = types.TestDifficulty
+	imported, err := core.ImportBlockChainJSON(bytes.NewReader(exported.Bytes()), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer imported.ChainDB.CloseDB()
+
+	if !reflect.DeepEqual(chainHashes(t, imported), chainHashes(t, bc)) {
+		t.Fatal("Imported chain has different blocks")
+	}
+	if !reflect.DeepEqual(utxoSet(t, imported), utxoSet(t, bc)) {
+		t.Fatal("Imported chain has a different UTXO set")
+	}
+	if err := imported.Verify(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImportJSONInvalidBlock(t *testing.T) {
+	bc, _ := testutil.BuildTestChain(t, 2)
+	var exported bytes.Buffer
+	if err := bc.ExportJSON(&exported); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(exported.String(), "\n")
+
+	dir, err := ioutil.TempDir("", "importchain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := core.DefaultConfig()
+	cfg.DataDir = dir
+	cfg.Difficulty = types.TestDifficulty
+
+	// The last Block mints more than it hashes to
+	var last types.Block
+	if err := json.Unmarshal([]byte(lines[2]), &last); err != nil {
+		t.Fatal(err)
+	}
+	last.Transactions[0].Outputs[0].Amount++
+	tampered, err := json.Marshal(&last)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := lines[0] + lines[1] + string(tampered) + "\n"
+	imported, err := core.ImportBlockChainJSON(strings.NewReader(stream), cfg)
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Fatalf("Got %v, want an error for line 3", err)
+	}
+	defer imported.ChainDB.CloseDB()
+	if _, tip := imported.Tip(); tip != 1 {
+		t.Fatalf("Imported up to block %d, want 1", tip)
+	}
+}
@@ -0,0 +1,205 @@
+package core
+
+import (
+	"bytes"
+	"encoding/gob"
+	"sort"
+
+	"github.com/danitello/go-blockchain/common/byteutil"
+	"github.com/danitello/go-blockchain/common/errutil"
+	"github.com/danitello/go-blockchain/core/types"
+
+	"github.com/dgraph-io/badger"
+)
+
+const (
+	// FeeHistoryLen is the number of recent Blocks whose fee rates EstimateSmartFee keeps
+	FeeHistoryLen = 100
+	// minFeeWindow is the fewest recent Blocks EstimateSmartFee takes the median fee rate of, so a single Block of
+	// unusual fees doesn't move the estimate
+	minFeeWindow = 6
+	// feeRateScale is the number of bytes fee rates are kept per, as fees per byte are mostly fractions
+	feeRateScale = 1000
+)
+
+// feeHistoryKey is the db key -> value is the serialized []feeSample of the most recent Blocks, oldest first
+var feeHistoryKey = []byte("fee-history")
+
+// feeSample is the fee rate paid in a Block -
+// Height - index of the Block
+// Rate - median fee per feeRateScale bytes of the Block's Transactions other than the coinbase tx
+type feeSample struct {
+	Height int
+	Rate   int
+}
+
+// EstimateSmartFee gets a fee per byte, as Mempool.MinRelayFee, likely to get a Transaction into one of the next
+// targetBlocks Blocks. It is the median rate paid in recent Blocks - the last minFeeWindow times targetBlocks, up to
+// FeeHistoryLen - raised to what the Mempool's backlog calls for if Transactions paying more already fill those
+// Blocks. The median follows a change in rates once it lasts for half the Blocks, but ignores the odd Block of high
+// or low fees. The rates of Blocks are kept in the db, so survive restarts. Never less than Mempool.MinRelayFee
+func (bc *BlockChain) EstimateSmartFee(targetBlocks int) int {
+	// Round up to whole fees per byte
+	fee := (bc.estimateFeeRate(targetBlocks) + feeRateScale - 1) / feeRateScale
+	if fee < bc.Mempool.MinRelayFee {
+		fee = bc.Mempool.MinRelayFee
+	}
+	return fee
+}
+
+// estimateFeeRate does the work of EstimateSmartFee, getting the fee per feeRateScale bytes
+func (bc *BlockChain) estimateFeeRate(targetBlocks int) int {
+	if targetBlocks < 1 {
+		targetBlocks = 1
+	}
+
+	history, err := bc.feeHistory()
+	errutil.Handle(err)
+
+	window := minFeeWindow * targetBlocks
+	if window > FeeHistoryLen {
+		window = FeeHistoryLen
+	}
+	if len(history) > window {
+		history = history[len(history)-window:]
+	}
+
+	rate := 0
+	if len(history) > 0 {
+		rates := make([]int, len(history))
+		for i, sample := range history {
+			rates[i] = sample.Rate
+		}
+		rate = median(rates)
+	}
+	if backlog := bc.Mempool.backlogRate(targetBlocks * bc.MaxBlockSize); backlog > rate {
+		rate = backlog
+	}
+
+	return rate
+}
+
+// feeHistory gets the fee rates of the most recent Blocks, oldest first
+func (bc *BlockChain) feeHistory() ([]feeSample, error) {
+	var history []feeSample
+	err := bc.ChainDB.Database.View(func(txn *badger.Txn) error {
+		var err error
+		history, err = readFeeHistory(txn)
+		return err
+	})
+
+	return history, err
+}
+
+// readFeeHistory gets the fee rates of the most recent Blocks within a db transaction
+func readFeeHistory(txn *badger.Txn) ([]feeSample, error) {
+	item, err := txn.Get(feeHistoryKey)
+	if err == badger.ErrKeyNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	v, err := item.Value()
+	if err != nil {
+		return nil, err
+	}
+
+	var history []feeSample
+	err = gob.NewDecoder(bytes.NewReader(v)).Decode(&history)
+	return history, err
+}
+
+// recordFeeRate adds the fee rate of a Block just connected to the history within a db transaction, replacing those
+// of any Blocks at or above its index, e.g. from before a reorganization. The fees come from the undo data written by
+// updateUTXOSet, so it must run after it. A Block with only its coinbase tx has no rate
+func recordFeeRate(txn *badger.Txn, block *types.Block) error {
+	spent, err := readUndo(txn, block)
+	if err != nil {
+		return err
+	}
+
+	var rates []int
+	next := 0
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		fee := 0
+		for range tx.Inputs {
+			fee += spent[next].Output.Amount
+			next++
+		}
+		for _, txo := range tx.Outputs {
+			fee -= txo.Amount
+		}
+		rates = append(rates, fee*feeRateScale/tx.Size())
+	}
+
+	history, err := readFeeHistory(txn)
+	if err != nil {
+		return err
+	}
+	history = trimFeeHistory(history, block.Index)
+	if len(rates) > 0 {
+		history = append(history, feeSample{block.Index, median(rates)})
+	}
+	if len(history) > FeeHistoryLen {
+		history = history[len(history)-FeeHistoryLen:]
+	}
+
+	return txn.Set(feeHistoryKey, byteutil.Serialize(history))
+}
+
+// dropFeeRates removes the fee rates of Blocks at or above an index from the history within a db transaction, e.g.
+// when the Block at that index is disconnected
+func dropFeeRates(txn *badger.Txn, height int) error {
+	history, err := readFeeHistory(txn)
+	if err != nil {
+		return err
+	}
+
+	return txn.Set(feeHistoryKey, byteutil.Serialize(trimFeeHistory(history, height)))
+}
+
+// trimFeeHistory gets the fee rates of Blocks below an index
+func trimFeeHistory(history []feeSample, height int) []feeSample {
+	for len(history) > 0 && history[len(history)-1].Height >= height {
+		history = history[:len(history)-1]
+	}
+	return history
+}
+
+// backlogRate gets the fee per feeRateScale bytes a Transaction must beat to be among the best paying size bytes of
+// the Mempool, or 0 if all of it fits in size bytes
+func (mp *Mempool) backlogRate(size int) int {
+	txs, fees := mp.withFees()
+
+	rates := make([]int, len(txs))
+	sizes := make([]int, len(txs))
+	for i, tx := range txs {
+		sizes[i] = tx.Size()
+		rates[i] = fees[i] * feeRateScale / sizes[i]
+	}
+	order := make([]int, len(txs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return rates[order[i]] > rates[order[j]] })
+
+	total := 0
+	for _, i := range order {
+		total += sizes[i]
+		if total > size {
+			return rates[i]
+		}
+	}
+	return 0
+}
+
+// median gets the middle value of a non-empty list, the lower of the two middle values for an even number of them
+func median(values []int) int {
+	sorted := append([]int{}, values...)
+	sort.Ints(sorted)
+	return sorted[(len(sorted)-1)/2]
+}
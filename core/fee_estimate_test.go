@@ -0,0 +1,94 @@
+package core_test
+
+import (
+	"testing"
+
+	"github.com/danitello/go-blockchain/core"
+	"github.com/danitello/go-blockchain/core/testutil"
+	"github.com/danitello/go-blockchain/core/types"
+	"github.com/danitello/go-blockchain/wallet"
+)
+
+// addFeeBlock adds a Block holding one Transaction paying a fee, returning its fee per 1000 bytes
+func addFeeBlock(t *testing.T, bc *core.BlockChain, ws *wallet.Wallets, from, to string, fee int) int {
+	t.Helper()
+	w := ws.Wallets[from]
+
+	utxos, txoSum := bc.GetUTXOWithPubKey(wallet.HashPubKey(w.GetPubKey()), 1+fee)
+	tx := types.CreateTransactionWithFee(from, to, w.GetPubKey(), 1, fee, txoSum, utxos)
+	if err := bc.SignTransaction(tx, ws, from); err != nil {
+		t.Fatal(err)
+	}
+	addBlock(t, bc, from, tx)
+
+	return fee * 1000 / tx.Size()
+}
+
+func TestEstimateSmartFee(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 3)
+	addresses := ws.GetAddresses()
+	from, to := addresses[0], addresses[1]
+	addBlock(t, bc, from) // so from can pay the first fee
+
+	// within determines whether the estimate for the next Block is within the range of a set of rates
+	within := func(rates []int) bool {
+		got := bc.EstimateFeeRate(1)
+		for _, rate := range rates {
+			if rate == got {
+				return true
+			}
+		}
+		return false
+	}
+
+	var low, high []int
+	for i := 0; i < 6; i++ {
+		low = append(low, addFeeBlock(t, bc, ws, from, to, 2))
+	}
+	if !within(low) {
+		t.Fatalf("Got rate %d after low fee blocks %v", bc.EstimateFeeRate(1), low)
+	}
+
+	// A single Block of high fees doesn't move it
+	addFeeBlock(t, bc, ws, from, to, 80)
+	if !within(low) {
+		t.Fatalf("Got rate %d after an outlier, want one of %v", bc.EstimateFeeRate(1), low)
+	}
+
+	// Fees staying high do
+	for i := 0; i < 6; i++ {
+		high = append(high, addFeeBlock(t, bc, ws, from, to, 20))
+	}
+	if !within(high) {
+		t.Fatalf("Got rate %d after high fee blocks %v", bc.EstimateFeeRate(1), high)
+	}
+	// A longer target looks further back, over the low fee Blocks too
+	if rate := bc.EstimateFeeRate(3); rate > bc.EstimateFeeRate(1) {
+		t.Fatalf("Got rate %d for 3 blocks, above the %d for 1", rate, bc.EstimateFeeRate(1))
+	}
+
+	// Disconnecting a Block drops its rate from the history
+	if _, err := bc.DisconnectTip(); err != nil {
+		t.Fatal(err)
+	}
+	if !within(high) {
+		t.Fatalf("Got rate %d after disconnecting, want one of %v", bc.EstimateFeeRate(1), high)
+	}
+
+	// The history is kept in the db, so the estimate survives a restart
+	want := bc.EstimateFeeRate(1)
+	cfg := core.DefaultConfig()
+	cfg.DataDir = bc.DataDir()
+	cfg.Difficulty = types.TestDifficulty
+	bc.ChainDB.CloseDB()
+	reopened := core.GetBlockChainWithConfig(cfg)
+	bc.ChainDB = reopened.ChainDB // for the testutil cleanup to close
+	if got := reopened.EstimateFeeRate(1); got != want {
+		t.Fatalf("Got rate %d after reopening, want %d", got, want)
+	}
+
+	reopened.Mempool.MinRelayFee = 100
+	if fee := reopened.EstimateSmartFee(1); fee != 100 {
+		t.Fatalf("Got fee %d, want the min relay fee", fee)
+	}
+}
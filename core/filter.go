@@ -0,0 +1,15 @@
+package core
+
+import "github.com/danitello/go-blockchain/core/types"
+
+// FilterBlock gets the Block with a given hash as sent to a light wallet with a BloomFilter, holding only the
+// Transactions that match it along with their Merkle proofs, see types.FilterBlock. A pruned Block can't be filtered,
+// giving chaindb.ErrBlockPruned
+func (bc *BlockChain) FilterBlock(hash []byte, bf *types.BloomFilter) (*types.FilteredBlock, error) {
+	block, err := bc.ChainDB.ReadBlockWithHash(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return types.FilterBlock(block, bf)
+}
@@ -0,0 +1,48 @@
+package core_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/danitello/go-blockchain/chaindb"
+	"github.com/danitello/go-blockchain/core/testutil"
+	"github.com/danitello/go-blockchain/core/types"
+	"github.com/danitello/go-blockchain/wallet"
+)
+
+func TestFilterBlock(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 0)
+	addresses := ws.GetAddresses()
+	addBlock(t, bc, addresses[0])
+	tx := pay(t, bc, ws, addresses[0], addresses[1], 10)
+	addBlock(t, bc, addresses[3], tx)
+
+	bf := types.InitBloomFilter(1, 0.0001)
+	bf.Add(wallet.GetPubKeyHashFromAddress(addresses[1]))
+	fb, err := bc.FilterBlock(bc.LastHash, bf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(fb.Header.Hash, bc.LastHash) != 0 || len(fb.Matches) != 1 ||
+		bytes.Compare(fb.Matches[0].Tx.ID, tx.ID) != 0 || fb.Matches[0].Index != 1 {
+		t.Fatalf("Got %+v, want only the payment to the filtered address", fb)
+	}
+	if !fb.Verify() {
+		t.Fatal("Proof of the matched tx fails")
+	}
+	fb.Matches[0].Index = 0
+	if fb.Verify() {
+		t.Fatal("Proof holds at the index of the coinbase tx")
+	}
+
+	// A filter of an address the Block doesn't pay matches nothing
+	bf = types.InitBloomFilter(1, 0.0001)
+	bf.Add(wallet.GetPubKeyHashFromAddress(addresses[2]))
+	if fb, err := bc.FilterBlock(bc.LastHash, bf); err != nil || len(fb.Matches) != 0 {
+		t.Fatalf("Got %+v (%v) for an address the Block doesn't pay", fb, err)
+	}
+
+	if _, err := bc.FilterBlock(make([]byte, 32), bf); err != chaindb.ErrBlockNotFound {
+		t.Fatalf("Got %v for an unknown Block, want ErrBlockNotFound", err)
+	}
+}
@@ -0,0 +1,85 @@
+package core_test
+
+import (
+	"hash/fnv"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/danitello/go-blockchain/core"
+	"github.com/danitello/go-blockchain/core/types"
+	"github.com/danitello/go-blockchain/wallet"
+)
+
+// fnvHasher is a fast, insecure Hasher, padding the 64 bit FNV-1a hash of the data to 32 bytes
+type fnvHasher struct{}
+
+func (fnvHasher) Hash(data []byte) []byte {
+	h := fnv.New64a()
+	h.Write(data)
+
+	return append(h.Sum(nil), make([]byte, 24)...)
+}
+
+func TestCustomHasher(t *testing.T) {
+	w, err := wallet.InitWalletFromReader(rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := string(w.GetAddress())
+	dir, err := ioutil.TempDir("", "hasherchain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := core.DefaultConfig()
+	cfg.DataDir = dir
+	cfg.Difficulty = 8
+	cfg.Hasher = fnvHasher{}
+	bc := core.InitBlockChainWithConfig(address, cfg)
+
+	for i := 0; i < 3; i++ {
+		_, tip := bc.Tip()
+		if err := bc.AddBlock([]*types.Transaction{types.CoinbaseTx(address, tip+1)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bc.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	tip, err := bc.ChainDB.ReadBlockWithHash(bc.LastHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tip.ValidateProof(fnvHasher{}) || !tip.ValidateHash(fnvHasher{}) {
+		t.Fatal("block does not validate with the hasher it was mined with")
+	}
+	if tip.ValidateHash(types.DoubleSHA256Hasher{}) {
+		t.Fatal("block validates with a different hasher")
+	}
+	bc.ChainDB.CloseDB()
+
+	// A node using the default hasher rejects the chain
+	cfg.Hasher = nil
+	other := core.GetBlockChainWithConfig(cfg)
+	defer other.ChainDB.CloseDB()
+	if err := other.Verify(); err == nil {
+		t.Fatal("chain mined with another hasher verified")
+	}
+}
+
+func TestConfigUnknownPowHash(t *testing.T) {
+	cfg := core.DefaultConfig()
+	cfg.PowHash = "scrypt"
+	if err, ok := cfg.Validate().(*core.ConfigError); !ok || err.Field != "powHash" {
+		t.Fatalf("got %v, want a powHash ConfigError", cfg.Validate())
+	}
+
+	cfg.Hasher = fnvHasher{}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("a set Hasher was not used in place of powHash: %s", err)
+	}
+}
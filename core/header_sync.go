@@ -0,0 +1,238 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/danitello/go-blockchain/chaindb"
+	"github.com/danitello/go-blockchain/core/types"
+
+	"github.com/dgraph-io/badger"
+)
+
+// MaxHeadersPerRequest is the most BlockHeaders SyncHeaders asks a Peer for at once
+const MaxHeadersPerRequest = 2000
+
+var (
+	// ErrNoSyncPeer is returned by DownloadBodies before SyncHeaders has found a Peer with more work
+	ErrNoSyncPeer = errors.New("No headers have been synced from a peer")
+	// ErrUnconnectedHeader is returned by SyncHeaders for a BlockHeader whose previous Block is unknown
+	ErrUnconnectedHeader = errors.New("Block header does not connect to a known block")
+)
+
+// Peer is another node a BlockChain downloads from in headers-first sync
+type Peer interface {
+	// GetHeaders gets up to max BlockHeaders of the chain of the Peer, oldest first, following the first hash of
+	// locator that is on it. locator is hashes of a chain from the tip back to the genesis Block
+	GetHeaders(locator [][]byte, max int) ([]*types.BlockHeader, error)
+	// GetBlock gets the Block with a given hash
+	GetBlock(hash []byte) (*types.Block, error)
+}
+
+// SyncHeaders downloads the BlockHeaders of the chain of a Peer and stores those that are valid, without their
+// Blocks. Each is checked with ValidateBlockHeader, against the Difficulty and the Checkpoints, so a Peer on a bad
+// chain is found before any Block is downloaded. If the Peer has a header chain with more CumulativeWork than any seen
+// so far, DownloadBodies gets its Blocks from the Peer. The first bad BlockHeader ends the sync with an error, keeping
+// the ones before it
+func (bc *BlockChain) SyncHeaders(peer Peer) error {
+	bc.syncMu.Lock()
+	defer bc.syncMu.Unlock()
+
+	best, bestWork, err := bc.bestKnownHeader()
+	if err != nil {
+		return err
+	}
+	from := best
+
+	for {
+		locator, err := bc.headerLocator(from)
+		if err != nil {
+			return err
+		}
+		headers, err := peer.GetHeaders(locator, MaxHeadersPerRequest)
+		if err != nil {
+			return err
+		}
+
+		prevFrom := from
+		for _, header := range headers {
+			work, err := bc.storeHeader(header)
+			if err != nil {
+				return fmt.Errorf("Header %x from peer: %s", header.Hash, err)
+			}
+			if work.Cmp(bestWork) > 0 {
+				best, bestWork = header, work
+				bc.syncPeer, bc.bestHeader = peer, header
+			}
+			from = header
+		}
+
+		// A Peer sending the same headers again has nothing more
+		if len(headers) < MaxHeadersPerRequest || bytes.Compare(from.Hash, prevFrom.Hash) == 0 {
+			return nil
+		}
+	}
+}
+
+// bestKnownHeader gets the BlockHeader with the most CumulativeWork known, that of the tip unless SyncHeaders has
+// stored one with more, along with its CumulativeWork. bc.syncMu must be held
+func (bc *BlockChain) bestKnownHeader() (*types.BlockHeader, *big.Int, error) {
+	lastHash, _ := bc.Tip()
+	tip, err := bc.ChainDB.ReadHeaderWithHash(lastHash)
+	if err != nil {
+		return nil, nil, err
+	}
+	tipWork, err := bc.CumulativeWork(lastHash)
+	if err != nil {
+		return nil, nil, err
+	}
+	if bc.bestHeader == nil {
+		return tip, tipWork, nil
+	}
+
+	bestWork, err := bc.CumulativeWork(bc.bestHeader.Hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	if bestWork.Cmp(tipWork) <= 0 {
+		return tip, tipWork, nil
+	}
+
+	return bc.bestHeader, bestWork, nil
+}
+
+// headerLocator gets the hashes a Peer finds where its chain and the header chain ending in from meet - the 10 most
+// recent, then ever further apart back to the genesis Block
+func (bc *BlockChain) headerLocator(from *types.BlockHeader) ([][]byte, error) {
+	var locator [][]byte
+	step := 1
+	header := from
+
+	for {
+		locator = append(locator, header.Hash)
+		if len(header.PrevHash) == 0 {
+			return locator, nil
+		}
+		if len(locator) >= 10 {
+			step *= 2
+		}
+
+		// Step back, stopping at the genesis Block
+		for i := 0; i < step && len(header.PrevHash) > 0; i++ {
+			var err error
+			if header, err = bc.ChainDB.ReadHeaderWithHash(header.PrevHash); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// storeHeader validates a BlockHeader from a Peer and stores it along with its CumulativeWork, which is returned.
+// A BlockHeader already stored is left alone
+func (bc *BlockChain) storeHeader(header *types.BlockHeader) (*big.Int, error) {
+	if _, err := bc.ChainDB.ReadHeaderWithHash(header.Hash); err == nil {
+		return bc.CumulativeWork(header.Hash)
+	}
+
+	prev, err := bc.ChainDB.ReadHeaderWithHash(header.PrevHash)
+	if err == chaindb.ErrBlockNotFound {
+		return nil, ErrUnconnectedHeader
+	} else if err != nil {
+		return nil, err
+	}
+	if err := types.ValidateBlockHeader(header, prev, bc.Hasher); err != nil {
+		return nil, err
+	}
+	difficulty, err := bc.difficultyAfter(prev, bc.ChainDB.ReadHeaderWithHash)
+	if err != nil {
+		return nil, err
+	}
+	if header.Difficulty != difficulty {
+		return nil, ErrBadDifficulty
+	}
+	if ok, _ := checkCheckpoint(header.Index, header.Hash); !ok {
+		return nil, ErrCheckpointMismatch
+	}
+
+	work, err := bc.headerWork(header)
+	if err != nil {
+		return nil, err
+	}
+	if err := bc.beginWrite(); err != nil {
+		return nil, err
+	}
+	defer bc.inFlight.Done()
+	err = bc.ChainDB.Database.Update(func(txn *badger.Txn) error {
+		if err := bc.ChainDB.WriteHeaderWithTxn(txn, header); err != nil {
+			return err
+		}
+		return storeWork(txn, header.Hash, work)
+	})
+
+	return work, err
+}
+
+// DownloadBodies gets the Blocks of the best header chain found by SyncHeaders from its Peer and adds them to the
+// BlockChain, connecting each in turn if the header chain extends the tip, or with Reorganize once all are downloaded
+// if it forks below it. Each Block must match its BlockHeader. Does nothing if the BlockChain already has as much
+// work. Stops with ctx.Err() if ctx is done, keeping the Blocks added so far
+func (bc *BlockChain) DownloadBodies(ctx context.Context) error {
+	bc.syncMu.Lock()
+	defer bc.syncMu.Unlock()
+
+	if bc.syncPeer == nil {
+		return ErrNoSyncPeer
+	}
+	best, _, err := bc.bestKnownHeader()
+	if err != nil {
+		return err
+	}
+
+	// Walk back from the best header to the chain, the first header the chain has the Block of at its index
+	onChain, err := bc.chainHashes()
+	if err != nil {
+		return err
+	}
+	var branch []*types.BlockHeader // newest first
+	for header := best; header.Index >= len(onChain) || bytes.Compare(onChain[header.Index], header.Hash) != 0; {
+		branch = append(branch, header)
+		if header, err = bc.ChainDB.ReadHeaderWithHash(header.PrevHash); err != nil {
+			return err
+		}
+	}
+	if len(branch) == 0 {
+		return nil
+	}
+	extendsTip := branch[len(branch)-1].Index == len(onChain)
+
+	var blocks []*types.Block
+	for i := len(branch) - 1; i >= 0; i-- {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		block, err := bc.syncPeer.GetBlock(branch[i].Hash)
+		if err != nil {
+			return err
+		}
+		if bytes.Compare(block.Hash, branch[i].Hash) != 0 || !block.ValidateHash(bc.Hasher) {
+			return fmt.Errorf("Block %x from peer does not match its header", branch[i].Hash)
+		}
+
+		if extendsTip {
+			if err := bc.connectBlock(block); err != nil {
+				return err
+			}
+		} else {
+			blocks = append(blocks, block)
+		}
+	}
+	if !extendsTip {
+		return bc.Reorganize(blocks)
+	}
+
+	return nil
+}
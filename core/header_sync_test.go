@@ -0,0 +1,227 @@
+package core_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/danitello/go-blockchain/chaindb"
+	"github.com/danitello/go-blockchain/core"
+	"github.com/danitello/go-blockchain/core/testutil"
+	"github.com/danitello/go-blockchain/core/types"
+)
+
+// chainPeer is a core.Peer serving the Blocks of a BlockChain -
+// tamper - if not nil, called on each BlockHeader before it is sent
+// blocks - number of Blocks served
+type chainPeer struct {
+	bc     *core.BlockChain
+	tamper func(*types.BlockHeader)
+	blocks int
+}
+
+func (p *chainPeer) GetHeaders(locator [][]byte, max int) ([]*types.BlockHeader, error) {
+	var headers []*types.BlockHeader // newest first
+	lastHash, _ := p.bc.Tip()
+	for hash := lastHash; len(hash) > 0; {
+		header, err := p.bc.ChainDB.ReadHeaderWithHash(hash)
+		if err != nil {
+			return nil, err
+		}
+		headers = append(headers, header)
+		hash = header.PrevHash
+	}
+
+	var res []*types.BlockHeader
+	for _, hash := range locator {
+		for i, header := range headers {
+			if bytes.Compare(header.Hash, hash) != 0 {
+				continue
+			}
+			for j := i - 1; j >= 0 && len(res) < max; j-- {
+				sent := *headers[j]
+				if p.tamper != nil {
+					p.tamper(&sent)
+				}
+				res = append(res, &sent)
+			}
+			return res, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (p *chainPeer) GetBlock(hash []byte) (*types.Block, error) {
+	p.blocks++
+	return p.bc.ChainDB.ReadBlockWithHash(hash)
+}
+
+// genesisCopy creates a BlockChain in a temporary DataDir holding just the genesis Block of bc
+func genesisCopy(t *testing.T, bc *core.BlockChain) *core.BlockChain {
+	t.Helper()
+
+	var exported bytes.Buffer
+	if err := bc.ExportJSON(&exported); err != nil {
+		t.Fatal(err)
+	}
+	genesis := strings.SplitAfter(exported.String(), "\n")[0]
+
+	dir, err := ioutil.TempDir("", "synccopy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := core.DefaultConfig()
+	cfg.DataDir = dir
+	cfg.Difficulty = bc.Difficulty
+
+	res, err := core.ImportBlockChainJSON(strings.NewReader(genesis), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		res.ChainDB.CloseDB()
+		os.RemoveAll(dir)
+	})
+
+	return res
+}
+
+// addBlocks adds n Blocks of just a coinbase tx to bc
+func addBlocks(t *testing.T, bc *core.BlockChain, address string, n int) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		_, tip := bc.Tip()
+		if err := bc.AddBlock([]*types.Transaction{types.CoinbaseTx(address, tip+1)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestHeadersFirstSync(t *testing.T) {
+	source, _ := testutil.BuildTestChain(t, 5)
+	sourceHash, sourceTip := source.Tip()
+	bc := genesisCopy(t, source)
+	peer := &chainPeer{bc: source}
+
+	if err := bc.DownloadBodies(context.Background()); err != core.ErrNoSyncPeer {
+		t.Fatalf("got %v, want ErrNoSyncPeer", err)
+	}
+
+	// Headers are stored without their Blocks
+	if err := bc.SyncHeaders(peer); err != nil {
+		t.Fatal(err)
+	}
+	if _, tip := bc.Tip(); tip != 0 || peer.blocks != 0 {
+		t.Fatal("SyncHeaders added blocks")
+	}
+	if _, err := bc.ChainDB.ReadHeaderWithHash(sourceHash); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.ChainDB.ReadBlockWithHash(sourceHash); err != chaindb.ErrBlockNotFound {
+		t.Fatalf("got %v, want ErrBlockNotFound", err)
+	}
+
+	if err := bc.DownloadBodies(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if lastHash, tip := bc.Tip(); tip != sourceTip || bytes.Compare(lastHash, sourceHash) != 0 {
+		t.Fatalf("tip %d %x, want %d %x", tip, lastHash, sourceTip, sourceHash)
+	}
+	if peer.blocks != sourceTip {
+		t.Fatalf("%d blocks downloaded, want %d", peer.blocks, sourceTip)
+	}
+	if err := bc.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if bc.CountUTX() != source.CountUTX() {
+		t.Fatal("UTXO set differs from the peer's")
+	}
+
+	// Nothing more to get
+	if err := bc.SyncHeaders(peer); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.DownloadBodies(context.Background()); err != nil || peer.blocks != sourceTip {
+		t.Fatalf("got %v after %d blocks, want nothing more downloaded", err, peer.blocks)
+	}
+}
+
+func TestSyncHeadersBadHeader(t *testing.T) {
+	source, _ := testutil.BuildTestChain(t, 5)
+	bc := genesisCopy(t, source)
+	peer := &chainPeer{bc: source, tamper: func(h *types.BlockHeader) {
+		if h.Index == 3 {
+			h.Nonce++
+		}
+	}}
+
+	err := bc.SyncHeaders(peer)
+	if err == nil || !strings.Contains(err.Error(), types.ErrBadProof.Error()) {
+		t.Fatalf("got %v, want ErrBadProof", err)
+	}
+
+	// The headers before the bad one are kept, and their Blocks can be downloaded
+	if err := bc.DownloadBodies(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, tip := bc.Tip(); tip != 2 {
+		t.Fatalf("tip %d, want 2", tip)
+	}
+}
+
+func TestDownloadBodiesBestChainOnly(t *testing.T) {
+	source, ws := testutil.BuildTestChain(t, 3)
+	address := ws.GetAddresses()[0]
+
+	// A chain with more work than the peer's gets no Blocks from it
+	bc := genesisCopy(t, source)
+	addBlocks(t, bc, address, 5)
+	lastHash, _ := bc.Tip()
+	peer := &chainPeer{bc: source}
+	if err := bc.SyncHeaders(peer); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.DownloadBodies(context.Background()); err != core.ErrNoSyncPeer || peer.blocks != 0 {
+		t.Fatalf("got %v after %d blocks, want ErrNoSyncPeer", err, peer.blocks)
+	}
+	if newHash, _ := bc.Tip(); bytes.Compare(newHash, lastHash) != 0 {
+		t.Fatal("tip changed")
+	}
+
+	// A chain with less work reorganizes onto the peer's
+	bc = genesisCopy(t, source)
+	addBlocks(t, bc, address, 1)
+	peer = &chainPeer{bc: source}
+	if err := bc.SyncHeaders(peer); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.DownloadBodies(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	sourceHash, _ := source.Tip()
+	if newHash, _ := bc.Tip(); bytes.Compare(newHash, sourceHash) != 0 {
+		t.Fatal("did not reorganize onto the peer's chain")
+	}
+}
+
+func TestDownloadBodiesCancelled(t *testing.T) {
+	source, _ := testutil.BuildTestChain(t, 3)
+	bc := genesisCopy(t, source)
+	if err := bc.SyncHeaders(&chainPeer{bc: source}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := bc.DownloadBodies(ctx); err != context.Canceled {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	if _, tip := bc.Tip(); tip != 0 {
+		t.Fatal("blocks added after cancel")
+	}
+}
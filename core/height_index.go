@@ -0,0 +1,75 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/danitello/go-blockchain/chaindb"
+	"github.com/danitello/go-blockchain/common/dbutil"
+	"github.com/danitello/go-blockchain/core/types"
+
+	"github.com/dgraph-io/badger"
+)
+
+// heightPrefix prefixes the db key of the index of a Block on the chain, encoded by dbutil.EncodeInt so keys sort by
+// index -> value is the hash of the Block
+var heightPrefix = []byte("height-")
+
+// indexHeight records the hash of a Block connected to the chain under its index within a db transaction
+func indexHeight(txn *badger.Txn, block *types.Block) error {
+	return txn.Set(dbutil.IntKey(heightPrefix, block.Index), block.Hash)
+}
+
+// unindexHeight removes the hash of the Block disconnected from a given index within a db transaction
+func unindexHeight(txn *badger.Txn, height int) error {
+	return txn.Delete(dbutil.IntKey(heightPrefix, height))
+}
+
+// BlockHashAtHeight gets the hash of the Block on the chain at a given index, or ErrBlockNotFound if there is none
+func (bc *BlockChain) BlockHashAtHeight(height int) ([]byte, error) {
+	var hash []byte
+	err := bc.ChainDB.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(dbutil.IntKey(heightPrefix, height))
+		if err == badger.ErrKeyNotFound {
+			return chaindb.ErrBlockNotFound
+		} else if err != nil {
+			return err
+		}
+
+		v, err := item.Value()
+		hash = append([]byte{}, v...)
+		return err
+	})
+
+	return hash, err
+}
+
+// BlockHashesInRange gets the hashes of the Blocks on the chain with indices from from to to inclusive, in index order.
+// The range is scanned in the order of the db keys, which is the order of the indices as they are encoded by
+// dbutil.EncodeInt
+func (bc *BlockChain) BlockHashesInRange(from, to int) ([][]byte, error) {
+	if from > to {
+		return nil, errors.New("Range must not end before it starts")
+	}
+
+	var hashes [][]byte
+	end := dbutil.IntKey(heightPrefix, to)
+	err := bc.ChainDB.Database.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(dbutil.IntKey(heightPrefix, from)); it.ValidForPrefix(heightPrefix); it.Next() {
+			if bytes.Compare(it.Item().Key(), end) > 0 {
+				break
+			}
+			v, err := it.Item().Value()
+			if err != nil {
+				return err
+			}
+			hashes = append(hashes, append([]byte{}, v...))
+		}
+		return nil
+	})
+
+	return hashes, err
+}
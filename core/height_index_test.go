@@ -0,0 +1,59 @@
+package core_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/danitello/go-blockchain/chaindb"
+	"github.com/danitello/go-blockchain/common/dbutil"
+	"github.com/danitello/go-blockchain/core/testutil"
+	"github.com/danitello/go-blockchain/core/types"
+)
+
+func TestBlockHashesInRange(t *testing.T) {
+	bc, _ := testutil.BuildTestChain(t, 0)
+
+	// Each hash is its height, so the order of the scan can be read back from the hashes
+	var blocks []*types.Block
+	for height := 1; height <= 1000; height++ {
+		blocks = append(blocks, &types.Block{Index: height, Hash: dbutil.EncodeInt(height)})
+	}
+	if err := bc.IndexHeights(blocks); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range []struct{ from, to int }{{1, 1000}, {9, 101}, {-5, 3}, {999, 2000}, {500, 500}} {
+		hashes, err := bc.BlockHashesInRange(r.from, r.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := r.from
+		if want < 1 {
+			want = 0 // genesis
+		}
+		for _, hash := range hashes {
+			if want == 0 {
+				if genesis, _ := bc.ChainDB.ReadGenesisHash(); bytes.Compare(hash, genesis) != 0 {
+					t.Fatalf("Got %x first for %d to %d, want the genesis hash", hash, r.from, r.to)
+				}
+			} else if got, err := dbutil.DecodeInt(hash); err != nil || got != want {
+				t.Fatalf("Got height %d (%v) for %d to %d, want %d", got, err, r.from, r.to, want)
+			}
+			want++
+		}
+		if last, tip := want-1, 1000; last != r.to && (r.to < tip || last != tip) {
+			t.Fatalf("Got %d to %d ending at %d", r.from, r.to, last)
+		}
+	}
+
+	if _, err := bc.BlockHashesInRange(10, 9); err == nil {
+		t.Fatal("Got a range ending before it starts")
+	}
+	if hash, err := bc.BlockHashAtHeight(256); err != nil || bytes.Compare(hash, dbutil.EncodeInt(256)) != 0 {
+		t.Fatalf("Got %x (%v) at height 256", hash, err)
+	}
+	if _, err := bc.BlockHashAtHeight(1001); err != chaindb.ErrBlockNotFound {
+		t.Fatalf("Got %v above the tip, want ErrBlockNotFound", err)
+	}
+}
@@ -0,0 +1,568 @@
+package core
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/danitello/go-blockchain/core/types"
+)
+
+const (
+	// MempoolFile is the name of the file in the DataDir that Shutdown saves the Mempool to
+	MempoolFile = "mempool.dat"
+	// mempoolFileVersion is the first byte of a file written by SaveToFile, identifying the format of the rest
+	mempoolFileVersion = 1
+
+	// DefaultMempoolExpiry is how long a Transaction may wait in the Mempool before being evicted
+	DefaultMempoolExpiry = 72 * time.Hour
+	// DefaultExpiryInterval is how often a running node calls Expire
+	DefaultExpiryInterval = time.Hour
+
+	// MaxAncestors is the most Transactions a Transaction and its unconfirmed ancestors in the Mempool may number
+	MaxAncestors = 25
+	// MaxAncestorSize is the most bytes a Transaction and its unconfirmed ancestors in the Mempool may total
+	MaxAncestorSize = 101000
+
+	// DefaultDustThreshold is the smallest txo amount a Mempool accepts by default, which only turns away empty txos
+	DefaultDustThreshold = 1
+)
+
+var (
+	// ErrTxInMempool is returned when adding a Transaction the Mempool already holds
+	ErrTxInMempool = errors.New("Transaction already in mempool")
+	// ErrMempoolConflict is returned when adding a Transaction that spends a txo already spent by one in the Mempool
+	ErrMempoolConflict = errors.New("Transaction spends an output already spent in mempool")
+	// ErrFeeTooLow is returned when adding a Transaction whose fee is below MinRelayFee per byte
+	ErrFeeTooLow = errors.New("Transaction fee is below the minimum relay fee")
+	// ErrDustOutput is returned when adding a Transaction with a txo of less than DustThreshold
+	ErrDustOutput = errors.New("Transaction has an output below the dust threshold")
+	// ErrTooManyAncestors is returned when adding a Transaction whose chain of unconfirmed ancestors exceeds
+	// MaxAncestors or MaxAncestorSize
+	ErrTooManyAncestors = errors.New("Transaction has too many unconfirmed ancestors")
+	// ErrMempoolFileVersion is returned by LoadFromFile for a file not written by this version of SaveToFile
+	ErrMempoolFileVersion = errors.New("Unsupported mempool file version")
+)
+
+// Mempool holds verified Transactions waiting to be added to a Block -
+// MinRelayFee - fee per byte of its serialized size a Transaction must pay to be added
+// DustThreshold - smallest amount each txo of a Transaction other than a ScriptData txo must have to be added, as
+// smaller ones cost more to spend than they are worth
+type Mempool struct {
+	MinRelayFee   int
+	DustThreshold int
+
+	mu      sync.Mutex
+	expiry  time.Duration
+	entries map[string]*mempoolEntry
+	spent   map[string]string // outpoint -> ID of the Transaction in the Mempool spending it
+	changes uint64            // Transactions added and removed so far
+
+	hooksMu  sync.Mutex
+	addHooks []func(*types.Transaction)
+
+	stopExpiry chan struct{} // closed to stop the goroutine started by StartExpiry
+	expiryDone chan struct{} // closed when that goroutine has returned
+}
+
+// mempoolEntry is a Transaction in the Mempool along with its fee and when it arrived
+type mempoolEntry struct {
+	tx      *types.Transaction
+	fee     int
+	arrived time.Time
+}
+
+// savedEntry is the on disk form of a mempoolEntry
+type savedEntry struct {
+	Tx      *types.Transaction
+	Arrived time.Time
+}
+
+// InitMempool creates a new, empty Mempool using DefaultMempoolExpiry
+func InitMempool() *Mempool {
+	return InitMempoolWithExpiry(DefaultMempoolExpiry)
+}
+
+// InitMempoolWithExpiry creates a new, empty Mempool whose Transactions are evicted by Expire after a given duration
+func InitMempoolWithExpiry(expiry time.Duration) *Mempool {
+	return &Mempool{
+		DustThreshold: DefaultDustThreshold,
+		expiry:        expiry,
+		entries:       make(map[string]*mempoolEntry),
+		spent:         make(map[string]string)}
+}
+
+// Add puts a Transaction paying a given fee into the Mempool, reserving the txos it spends, then calls the hooks
+// registered with OnAdd
+func (mp *Mempool) Add(tx *types.Transaction, fee int) error {
+	if err := mp.add(tx, fee); err != nil {
+		return err
+	}
+
+	mp.hooksMu.Lock()
+	hooks := append([]func(*types.Transaction){}, mp.addHooks...)
+	mp.hooksMu.Unlock()
+
+	for _, hook := range hooks {
+		hook(tx)
+	}
+
+	return nil
+}
+
+// OnAdd registers a func to be called with each Transaction added to the Mempool. Hooks run synchronously once the
+// Transaction is in, without the Mempool locked
+func (mp *Mempool) OnAdd(hook func(*types.Transaction)) {
+	mp.hooksMu.Lock()
+	defer mp.hooksMu.Unlock()
+
+	mp.addHooks = append(mp.addHooks, hook)
+}
+
+// add does the work of Add
+func (mp *Mempool) add(tx *types.Transaction, fee int) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	if err := mp.check(tx, fee); err != nil {
+		return err
+	}
+
+	txID := hex.EncodeToString(tx.ID)
+	mp.entries[txID] = &mempoolEntry{tx, fee, time.Now()}
+	for _, txin := range tx.Inputs {
+		mp.spent[outpoint(txin)] = txID
+	}
+	mp.changes++
+
+	return nil
+}
+
+// Check determines whether Add would accept a Transaction paying a given fee, without adding it
+func (mp *Mempool) Check(tx *types.Transaction, fee int) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	return mp.check(tx, fee)
+}
+
+// check does the work of Check. mp.mu must be held
+func (mp *Mempool) check(tx *types.Transaction, fee int) error {
+	if fee < mp.MinRelayFee*tx.Size() {
+		return ErrFeeTooLow
+	}
+	for _, txo := range tx.Outputs {
+		if txo.Amount < mp.DustThreshold && txo.ScriptType() != types.ScriptData {
+			return ErrDustOutput
+		}
+	}
+
+	txID := hex.EncodeToString(tx.ID)
+	if _, ok := mp.entries[txID]; ok {
+		return ErrTxInMempool
+	}
+	for _, txin := range tx.Inputs {
+		if _, ok := mp.spent[outpoint(txin)]; ok {
+			return ErrMempoolConflict
+		}
+	}
+
+	ancestors := mp.ancestors(tx)
+	size := tx.Size()
+	for _, ancestor := range ancestors {
+		size += ancestor.Size()
+	}
+	if len(ancestors)+1 > MaxAncestors || size > MaxAncestorSize {
+		return ErrTooManyAncestors
+	}
+
+	return nil
+}
+
+// ancestors gets every Transaction in the Mempool that a Transaction spends the txos of, directly or not. mp.mu must be
+// held
+func (mp *Mempool) ancestors(tx *types.Transaction) []*types.Transaction {
+	var res []*types.Transaction
+	visited := make(map[string]bool)
+
+	var visit func(tx *types.Transaction)
+	visit = func(tx *types.Transaction) {
+		for _, txin := range tx.Inputs {
+			parentID := hex.EncodeToString(txin.TxID)
+			entry, ok := mp.entries[parentID]
+			if !ok || visited[parentID] {
+				continue
+			}
+			visited[parentID] = true
+
+			res = append(res, entry.tx)
+			visit(entry.tx)
+		}
+	}
+	visit(tx)
+
+	return res
+}
+
+// MempoolEntry describes a Transaction in the Mempool -
+// TxID - ID of the Transaction
+// Size - bytes of the serialized Transaction
+// Fee - what its txins are worth more than its txos
+// FeeRate - Fee per byte of Size
+// Arrived - when it was added to the Mempool
+// Ancestors - number of Transactions in the Mempool it spends the txos of, directly or not
+type MempoolEntry struct {
+	TxID      []byte
+	Size      int
+	Fee       int
+	FeeRate   float64
+	Arrived   time.Time
+	Ancestors int
+}
+
+// Dump describes every Transaction in the Mempool, highest FeeRate first and then by arrival
+func (mp *Mempool) Dump() []MempoolEntry {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	dump := make([]MempoolEntry, 0, len(mp.entries))
+	for _, entry := range mp.entries {
+		size := entry.tx.Size()
+		dump = append(dump, MempoolEntry{
+			TxID:      entry.tx.ID,
+			Size:      size,
+			Fee:       entry.fee,
+			FeeRate:   float64(entry.fee) / float64(size),
+			Arrived:   entry.arrived,
+			Ancestors: len(mp.ancestors(entry.tx))})
+	}
+
+	sort.Slice(dump, func(i, j int) bool {
+		if dump[i].FeeRate != dump[j].FeeRate {
+			return dump[i].FeeRate > dump[j].FeeRate
+		}
+		if !dump[i].Arrived.Equal(dump[j].Arrived) {
+			return dump[i].Arrived.Before(dump[j].Arrived)
+		}
+		return bytes.Compare(dump[i].TxID, dump[j].TxID) < 0
+	})
+
+	return dump
+}
+
+// Get gets the Transaction in the Mempool with a given ID, if there is one
+func (mp *Mempool) Get(txID []byte) (*types.Transaction, bool) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	entry, ok := mp.entries[hex.EncodeToString(txID)]
+	if !ok {
+		return nil, false
+	}
+	return entry.tx, true
+}
+
+// IsSpent determines whether a Transaction in the Mempool spends the txo at a given idx of the Transaction with a given
+// ID
+func (mp *Mempool) IsSpent(txID []byte, outputIdx int) bool {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	_, ok := mp.spent[outpoint(types.TxInput{TxID: txID, OutputIdx: outputIdx})]
+	return ok
+}
+
+// Size gets the number of Transactions in the Mempool
+func (mp *Mempool) Size() int {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	return len(mp.entries)
+}
+
+// Changes gets the number of times a Transaction has been added to or removed from the Mempool, so a caller can tell
+// how much it has changed since it last looked
+func (mp *Mempool) Changes() uint64 {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	return mp.changes
+}
+
+// Remove takes the Transaction with a given ID out of the Mempool
+func (mp *Mempool) Remove(txID []byte) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	mp.remove(hex.EncodeToString(txID))
+}
+
+// remove takes a Transaction out of the Mempool and releases the txos it spends. mp.mu must be held
+func (mp *Mempool) remove(txID string) {
+	entry, ok := mp.entries[txID]
+	if !ok {
+		return
+	}
+
+	for _, txin := range entry.tx.Inputs {
+		delete(mp.spent, outpoint(txin))
+	}
+	delete(mp.entries, txID)
+	mp.changes++
+}
+
+// RemoveForBlock takes the Transactions of a Block out of the Mempool, along with any that spend the same txos
+// (and those spending theirs), as they can no longer be added to a Block
+func (mp *Mempool) RemoveForBlock(block *types.Block) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			continue
+		}
+		mp.remove(hex.EncodeToString(tx.ID))
+		for _, txin := range tx.Inputs {
+			if conflictID, ok := mp.spent[outpoint(txin)]; ok {
+				mp.removeWithDescendants(conflictID)
+			}
+		}
+	}
+}
+
+// RemoveDescendants takes every Transaction spending the txos of the Transaction with a given ID out of the Mempool,
+// along with those spending theirs, e.g. once that Transaction can no longer be added to a Block. Returns the removed
+// Transactions
+func (mp *Mempool) RemoveDescendants(txID []byte) []*types.Transaction {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	parentID := hex.EncodeToString(txID)
+	var removed []*types.Transaction
+	for childID, child := range mp.entries {
+		for _, txin := range child.tx.Inputs {
+			if hex.EncodeToString(txin.TxID) == parentID {
+				removed = append(removed, mp.removeWithDescendants(childID)...)
+				break
+			}
+		}
+	}
+
+	return removed
+}
+
+// removeWithDescendants takes a Transaction out of the Mempool along with every Transaction spending its txos,
+// as they can no longer be added to a Block. Returns the removed Transactions. mp.mu must be held
+func (mp *Mempool) removeWithDescendants(txID string) []*types.Transaction {
+	entry, ok := mp.entries[txID]
+	if !ok {
+		return nil
+	}
+	mp.remove(txID)
+
+	removed := []*types.Transaction{entry.tx}
+	for childID, child := range mp.entries {
+		for _, txin := range child.tx.Inputs {
+			if hex.EncodeToString(txin.TxID) == txID {
+				removed = append(removed, mp.removeWithDescendants(childID)...)
+				break
+			}
+		}
+	}
+
+	return removed
+}
+
+// Expire evicts every Transaction that has been in the Mempool longer than its expiry, and those spending their txos,
+// returning them
+func (mp *Mempool) Expire() []*types.Transaction {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	var expired []*types.Transaction
+	for txID, entry := range mp.entries {
+		if time.Since(entry.arrived) > mp.expiry {
+			expired = append(expired, mp.removeWithDescendants(txID)...)
+		}
+	}
+
+	return expired
+}
+
+// StartExpiry runs Expire in the background every interval until StopExpiry is called
+func (mp *Mempool) StartExpiry(interval time.Duration) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	if mp.stopExpiry != nil {
+		return
+	}
+	mp.stopExpiry = make(chan struct{})
+	mp.expiryDone = make(chan struct{})
+
+	go func(stop, done chan struct{}) {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if expired := mp.Expire(); len(expired) > 0 {
+					log.Printf("Evicted %d expired mempool transactions\n", len(expired))
+				}
+			case <-stop:
+				return
+			}
+		}
+	}(mp.stopExpiry, mp.expiryDone)
+}
+
+// StopExpiry stops the goroutine started by StartExpiry and waits for it to return. Does nothing if it isn't running
+func (mp *Mempool) StopExpiry() {
+	mp.mu.Lock()
+	stop, done := mp.stopExpiry, mp.expiryDone
+	mp.stopExpiry, mp.expiryDone = nil, nil
+	mp.mu.Unlock()
+
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+}
+
+// Transactions gets every Transaction in the Mempool, ordered so that a Transaction spending the txos of another
+// comes after it, as they must be in a Block
+func (mp *Mempool) Transactions() []*types.Transaction {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	var txs []*types.Transaction
+	for _, entry := range mp.ordered() {
+		txs = append(txs, entry.tx)
+	}
+
+	return txs
+}
+
+// withFees gets the Transactions in the Mempool ordered as by Transactions, along with the fee each pays
+func (mp *Mempool) withFees() ([]*types.Transaction, []int) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	var txs []*types.Transaction
+	var fees []int
+	for _, entry := range mp.ordered() {
+		txs = append(txs, entry.tx)
+		fees = append(fees, entry.fee)
+	}
+
+	return txs, fees
+}
+
+// ordered gets every entry in the Mempool with parents before the children spending their txos. mp.mu must be held
+func (mp *Mempool) ordered() []*mempoolEntry {
+	var res []*mempoolEntry
+	visited := make(map[string]bool)
+
+	var visit func(txID string)
+	visit = func(txID string) {
+		entry, ok := mp.entries[txID]
+		if !ok || visited[txID] {
+			return
+		}
+		visited[txID] = true
+
+		for _, txin := range entry.tx.Inputs {
+			visit(hex.EncodeToString(txin.TxID))
+		}
+		res = append(res, entry)
+	}
+
+	for txID := range mp.entries {
+		visit(txID)
+	}
+
+	return res
+}
+
+// pending gets the Transactions in the Mempool keyed by hex ID, so Transactions spending their txos can be checked
+func (mp *Mempool) pending() map[string]*types.Transaction {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	txs := make(map[string]*types.Transaction)
+	for txID, entry := range mp.entries {
+		txs[txID] = entry.tx
+	}
+
+	return txs
+}
+
+// SaveToFile writes the Transactions in the Mempool to disk, parents first so they can be loaded back in order. The
+// file is mempoolFileVersion followed by the gob encoded entries
+func (mp *Mempool) SaveToFile(path string) error {
+	mp.mu.Lock()
+	var saved []savedEntry
+	for _, entry := range mp.ordered() {
+		saved = append(saved, savedEntry{entry.tx, entry.arrived})
+	}
+	mp.mu.Unlock()
+
+	var data bytes.Buffer
+	data.WriteByte(mempoolFileVersion)
+	encoder := gob.NewEncoder(&data)
+	if err := encoder.Encode(saved); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data.Bytes(), 0644)
+}
+
+// LoadFromFile adds the Transactions saved by SaveToFile back into the Mempool -
+// check - gets the fee of a Transaction, rejecting those no longer valid, e.g. because they were added to a Block in
+// the meantime
+// Returns the number of Transactions discarded. Fails with ErrMempoolFileVersion for a file in another format
+func (mp *Mempool) LoadFromFile(path string, check func(*types.Transaction) (int, error)) (int, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) == 0 || data[0] != mempoolFileVersion {
+		return 0, ErrMempoolFileVersion
+	}
+
+	var saved []savedEntry
+	decoder := gob.NewDecoder(bytes.NewReader(data[1:]))
+	if err := decoder.Decode(&saved); err != nil {
+		return 0, err
+	}
+
+	discarded := 0
+	for _, entry := range saved {
+		fee, err := check(entry.Tx)
+		if err != nil || mp.Add(entry.Tx, fee) != nil {
+			discarded++
+			continue
+		}
+
+		mp.mu.Lock()
+		mp.entries[hex.EncodeToString(entry.Tx.ID)].arrived = entry.Arrived // keep the original age for Expire
+		mp.mu.Unlock()
+	}
+
+	return discarded, nil
+}
+
+// outpoint creates the key identifying the txo a txin spends
+func outpoint(txin types.TxInput) string {
+	return fmt.Sprintf("%x:%d", txin.TxID, txin.OutputIdx)
+}
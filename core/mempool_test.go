@@ -0,0 +1,343 @@
+package core_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/danitello/go-blockchain/core"
+	"github.com/danitello/go-blockchain/core/testutil"
+	"github.com/danitello/go-blockchain/core/types"
+	"github.com/danitello/go-blockchain/wallet"
+)
+
+// spendCoinbases makes a signed Transaction for each coinbase tx paying w that is in the UTXO set of bc, each
+// spending just that one and paying part of it to the address of w
+func spendCoinbases(t *testing.T, bc *core.BlockChain, w *wallet.Wallet) []*types.Transaction {
+	t.Helper()
+	address := string(w.GetAddress())
+	var txs []*types.Transaction
+
+	utxos, _ := bc.GetUTXOWithPubKey(wallet.HashPubKey(w.GetPubKey()), 1<<30)
+	for txID, idxs := range utxos {
+		utxo := map[string][]int{txID: idxs}
+		tx := types.CreateTransaction(address, address, w.GetPubKey(), 1, types.BlockSubsidy(0), utxo)
+		bc.SignTransactionWithKey(tx, w.PrivateKey)
+		txs = append(txs, tx)
+	}
+
+	return txs
+}
+
+func TestMempoolSurvivesRestart(t *testing.T) {
+	w, err := wallet.InitWalletFromReader(rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := string(w.GetAddress())
+	dir, err := ioutil.TempDir("", "mempoolchain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := core.DefaultConfig()
+	cfg.DataDir = dir
+	cfg.Difficulty = types.TestDifficulty
+	bc := core.InitBlockChainWithConfig(address, cfg)
+	_, tip := bc.Tip()
+	if err := bc.AddBlock([]*types.Transaction{types.CoinbaseTx(address, tip+1)}); err != nil {
+		t.Fatal(err)
+	}
+
+	spends := spendCoinbases(t, bc, w)
+	if len(spends) != 2 {
+		t.Fatalf("%d spends, want 2", len(spends))
+	}
+	for _, tx := range spends {
+		if _, err := bc.SubmitRawTransaction(types.EncodeRawTransaction(tx)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bc.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The Mempool is saved in the DataDir, starting with the file version
+	path := filepath.Join(dir, core.MempoolFile)
+	saved, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved[0] != 1 {
+		t.Fatalf("file version %d, want 1", saved[0])
+	}
+
+	// One of the Transactions is mined before the saved Mempool is loaded again
+	bc = core.GetBlockChainWithConfig(cfg)
+	if bc.Mempool.Size() != len(spends) {
+		t.Fatalf("%d transactions reloaded, want %d", bc.Mempool.Size(), len(spends))
+	}
+	_, tip = bc.Tip()
+	if err := bc.AddBlock([]*types.Transaction{types.CoinbaseTx(address, tip+1), spends[0]}); err != nil {
+		t.Fatal(err)
+	}
+	bc.ChainDB.CloseDB()
+	if err := ioutil.WriteFile(path, saved, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	bc = core.GetBlockChainWithConfig(cfg)
+	if bc.Mempool.Size() != 1 {
+		t.Fatalf("%d transactions reloaded, want 1", bc.Mempool.Size())
+	}
+	if _, ok := bc.Mempool.Get(spends[1].ID); !ok {
+		t.Fatal("unmined transaction not reloaded")
+	}
+	bc.ChainDB.CloseDB()
+
+	// A file in another format is left out rather than stopping the node
+	if err := ioutil.WriteFile(path, append([]byte{0xff}, saved[1:]...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := core.InitMempool().LoadFromFile(path, nil); err != core.ErrMempoolFileVersion {
+		t.Fatalf("got %v, want ErrMempoolFileVersion", err)
+	}
+	bc = core.GetBlockChainWithConfig(cfg)
+	defer bc.ChainDB.CloseDB()
+	if bc.Mempool.Size() != 0 {
+		t.Fatalf("%d transactions loaded from a bad file", bc.Mempool.Size())
+	}
+}
+
+// mempoolTx makes an unsigned Transaction spending a txo, which is all a Mempool looks at
+func mempoolTx(id byte, spends []byte) *types.Transaction {
+	return &types.Transaction{
+		ID:      []byte{id},
+		Inputs:  []types.TxInput{{TxID: spends, OutputIdx: 0}},
+		Outputs: []types.TxOutput{{Amount: 10, PubKeyHash: make([]byte, 20)}}}
+}
+
+func TestMempoolExpire(t *testing.T) {
+	mp := core.InitMempoolWithExpiry(50 * time.Millisecond)
+	parent, child := mempoolTx(1, []byte{0}), mempoolTx(2, []byte{1})
+	for _, tx := range []*types.Transaction{parent, child} {
+		if err := mp.Add(tx, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if expired := mp.Expire(); len(expired) != 0 {
+		t.Fatalf("%d expired early", len(expired))
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	young := mempoolTx(3, []byte{9})
+	if err := mp.Add(young, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if expired := mp.Expire(); len(expired) != 2 {
+		t.Fatalf("%d expired, want the parent and its child", len(expired))
+	}
+	if _, ok := mp.Get(young.ID); !ok || mp.Size() != 1 {
+		t.Fatal("young transaction evicted")
+	}
+
+	// The txo the parent spent is free again
+	if err := mp.Add(mempoolTx(4, []byte{0}), 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMempoolStartExpiry(t *testing.T) {
+	mp := core.InitMempoolWithExpiry(20 * time.Millisecond)
+	mp.StopExpiry() // not running
+	if err := mp.Add(mempoolTx(1, []byte{0}), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	mp.StartExpiry(5 * time.Millisecond)
+	mp.StartExpiry(5 * time.Millisecond) // already running
+	for deadline := time.Now().Add(time.Second); mp.Size() > 0; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("transaction not expired in the background")
+		}
+	}
+
+	// Once stopped nothing more is evicted
+	mp.StopExpiry()
+	if err := mp.Add(mempoolTx(2, []byte{0}), 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if mp.Size() != 1 {
+		t.Fatal("transaction expired after StopExpiry")
+	}
+}
+
+func TestMempoolOnAdd(t *testing.T) {
+	mp := core.InitMempool()
+	var added [][]byte
+	mp.OnAdd(func(tx *types.Transaction) {
+		added = append(added, tx.ID)
+		mp.Size() // hooks run unlocked
+	})
+
+	tx := mempoolTx(1, []byte{0})
+	if err := mp.Add(tx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := mp.Add(mempoolTx(2, []byte{0}), 0); err != core.ErrMempoolConflict {
+		t.Fatalf("Got %v adding a conflict", err)
+	}
+
+	if len(added) != 1 || bytes.Compare(added[0], tx.ID) != 0 {
+		t.Fatalf("Hooks called with %x, want only %x", added, tx.ID)
+	}
+}
+
+func TestMempoolMaxAncestors(t *testing.T) {
+	mp := core.InitMempool()
+	// A chain of MaxAncestors Transactions, each spending the one before
+	for id := 1; id <= core.MaxAncestors; id++ {
+		if err := mp.Add(mempoolTx(byte(id), []byte{byte(id - 1)}), 0); err != nil {
+			t.Fatalf("Link %d: %s", id, err)
+		}
+	}
+
+	next := mempoolTx(core.MaxAncestors+1, []byte{core.MaxAncestors})
+	if err := mp.Check(next, 0); err != core.ErrTooManyAncestors {
+		t.Fatalf("Check got %v for link %d, want ErrTooManyAncestors", err, core.MaxAncestors+1)
+	}
+	if err := mp.Add(next, 0); err != core.ErrTooManyAncestors {
+		t.Fatalf("Add got %v for link %d, want ErrTooManyAncestors", err, core.MaxAncestors+1)
+	}
+	if size := mp.Size(); size != core.MaxAncestors {
+		t.Fatalf("Mempool holds %d transactions, want %d", size, core.MaxAncestors)
+	}
+
+	// Spending a txo outside the chain is fine
+	if err := mp.Add(mempoolTx(core.MaxAncestors+2, []byte{0xff}), 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMempoolDump(t *testing.T) {
+	mp := core.InitMempool()
+	// The same size each, so fee rates are in the order of fees. 4 ties with 2 but arrives after it
+	for _, add := range []struct {
+		tx  *types.Transaction
+		fee int
+	}{
+		{mempoolTx(1, []byte{0}), 30},
+		{mempoolTx(2, []byte{1}), 10},
+		{mempoolTx(3, []byte{2}), 50},
+		{mempoolTx(4, []byte{0xff}), 10},
+	} {
+		if err := mp.Add(add.tx, add.fee); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond) // so arrivals differ
+	}
+
+	want := []struct {
+		id        byte
+		fee       int
+		ancestors int
+	}{{3, 50, 2}, {1, 30, 0}, {2, 10, 1}, {4, 10, 0}}
+	dump := mp.Dump()
+	if len(dump) != len(want) {
+		t.Fatalf("Got %d entries, want %d", len(dump), len(want))
+	}
+	for i, entry := range dump {
+		size := mempoolTx(want[i].id, []byte{0}).Size() // each spends a one byte ID
+		if bytes.Compare(entry.TxID, []byte{want[i].id}) != 0 || entry.Fee != want[i].fee ||
+			entry.Ancestors != want[i].ancestors || entry.Size != size ||
+			entry.FeeRate != float64(want[i].fee)/float64(size) {
+			t.Fatalf("Got entry %d %+v, want tx %d with fee %d and %d ancestors", i, entry, want[i].id, want[i].fee,
+				want[i].ancestors)
+		}
+		if i > 0 && entry.FeeRate == dump[i-1].FeeRate && !entry.Arrived.After(dump[i-1].Arrived) {
+			t.Fatalf("Got entry %d arriving %s, before the %s of the one ahead at the same rate", i, entry.Arrived,
+				dump[i-1].Arrived)
+		}
+	}
+}
+
+func TestMempoolMinRelayFee(t *testing.T) {
+	mp := core.InitMempool()
+	mp.MinRelayFee = 2
+	tx := mempoolTx(1, []byte{0})
+	floor := mp.MinRelayFee * tx.Size()
+
+	if err := mp.Add(tx, floor-1); err != core.ErrFeeTooLow {
+		t.Fatalf("Got %v for a fee below the floor, want ErrFeeTooLow", err)
+	}
+	if mp.Size() != 0 {
+		t.Fatal("Transaction below the floor added")
+	}
+	if err := mp.Add(tx, floor); err != nil {
+		t.Fatalf("Got %v for a fee at the floor", err)
+	}
+}
+
+func TestMempoolDustThreshold(t *testing.T) {
+	mp := core.InitMempool()
+	mp.DustThreshold = 5
+
+	dust := mempoolTx(1, []byte{0})
+	dust.Outputs = append(dust.Outputs, types.TxOutput{Amount: 4, PubKeyHash: make([]byte, 20)})
+	if err := mp.Add(dust, 0); err != core.ErrDustOutput {
+		t.Fatalf("Got %v for a sub-dust output, want ErrDustOutput", err)
+	}
+
+	// Data txos carry no amount, so are never dust
+	data, err := types.InitDataOutput([]byte("note"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	withData := mempoolTx(2, []byte{0})
+	withData.Outputs = append(withData.Outputs, *data)
+	if err := mp.Add(withData, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreateTransactionFoldsDustChange(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 2)
+	addresses := ws.GetAddresses()
+	bc.Mempool.DustThreshold = 5
+
+	utxos, _ := bc.GetUTXOWithPubKey(wallet.GetPubKeyHashFromAddress(addresses[0]), 1)
+	var outpoint types.Outpoint
+	for txID, idxs := range utxos {
+		id, err := hex.DecodeString(txID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		outpoint = types.Outpoint{TxID: id, OutputIdx: idxs[0]}
+	}
+	txo, _ := bc.GetUTXOWithOutpoint(outpoint.TxID, outpoint.OutputIdx)
+
+	// Change of 3 is dust, so goes to the fee instead
+	tx, err := bc.CreateTransactionFromInputsWithWallets(ws, addresses[0], addresses[1], txo.Amount-4, 1, []types.Outpoint{outpoint})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.Outputs) != 1 || tx.Outputs[0].Amount != txo.Amount-4 {
+		t.Fatalf("Got outputs %+v, want just the payment", tx.Outputs)
+	}
+	if err := bc.SubmitTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := bc.CreateTransactionFromInputsWithWallets(ws, addresses[0], addresses[1], 4, 0, []types.Outpoint{outpoint}); err != core.ErrDustOutput {
+		t.Fatalf("Got %v for a sub-dust payment, want ErrDustOutput", err)
+	}
+}
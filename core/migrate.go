@@ -0,0 +1,202 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+
+	"github.com/danitello/go-blockchain/chaindb"
+	"github.com/danitello/go-blockchain/common/dbutil"
+	"github.com/danitello/go-blockchain/common/hexutil"
+
+	"github.com/dgraph-io/badger"
+)
+
+// SchemaVersion is the version of the db layout this binary writes. A db without a version predates versioning and
+// is version 1
+const SchemaVersion = 5
+
+// proofRulesVersion is the first schema version whose Blocks are all mined under the current proof of work rules,
+// hashed with the Hasher of the chain Config (double SHA-256 by default) rather than the single SHA-256 of the
+// baseline, over proof data that includes the TimeStamp. A db from before it may hold Blocks mined under the old
+// rules, which no migration can fix as they would have to be mined again
+const proofRulesVersion = 5
+
+// schemaVersionKey is the db key -> value is the schema version of the db as 8 bytes
+var schemaVersionKey = []byte("schema-version")
+
+// ErrSchemaTooNew is returned when opening a db written by a binary with a newer SchemaVersion
+var ErrSchemaTooNew = errors.New("Database schema is newer than this binary understands")
+
+// ErrLegacyBlocks is returned when opening a db holding Blocks mined under the proof of work rules from before
+// proofRulesVersion. The chain has to be synced again into a new DataDir
+var ErrLegacyBlocks = errors.New("Database holds blocks mined under older proof of work rules and must be synced again")
+
+// migration upgrades a db from the version before it to version -
+// desc - what the migration does, for the log
+type migration struct {
+	version int
+	desc    string
+	run     func(bc *BlockChain) error
+}
+
+// migrations upgrade a db one version at a time, in order. Each one must be safe to run again if interrupted
+var migrations = []migration{
+	{2, "build the tx index", migrateTxIndex},
+	{3, "store the cumulative work of each block", migrateCumulativeWork},
+	{4, "index the blocks of the chain by height", migrateHeightIndex},
+	{proofRulesVersion, "check the blocks follow the current proof of work rules", checkProofRules},
+}
+
+// DBSchemaVersion gets the schema version of the db
+func (bc *BlockChain) DBSchemaVersion() (int, error) {
+	version := 1
+	err := bc.ChainDB.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(schemaVersionKey)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		v, err := item.Value()
+		if err != nil {
+			return err
+		}
+		if len(v) != 8 {
+			return fmt.Errorf("Malformed schema version %x", v)
+		}
+		version = int(binary.BigEndian.Uint64(v))
+		return nil
+	})
+
+	return version, err
+}
+
+// setSchemaVersion records the schema version of the db
+func (bc *BlockChain) setSchemaVersion(version int) error {
+	return bc.ChainDB.Database.Update(func(txn *badger.Txn) error {
+		return txn.Set(schemaVersionKey, hexutil.ToHex(int64(version)))
+	})
+}
+
+// Migrate brings the db up to SchemaVersion by running the migrations after its version in order, recording the
+// version after each so an interrupted upgrade resumes where it stopped. Returns the number of migrations run, or
+// ErrSchemaTooNew for a db this binary can't read, or ErrLegacyBlocks for one whose Blocks it can't validate. The
+// latter is checked before any migration writes to the db
+func (bc *BlockChain) Migrate() (int, error) {
+	version, err := bc.DBSchemaVersion()
+	if err != nil {
+		return 0, err
+	}
+	if version > SchemaVersion {
+		return 0, ErrSchemaTooNew
+	}
+	if version < proofRulesVersion {
+		if err := checkProofRules(bc); err != nil {
+			return 0, err
+		}
+	}
+
+	count := 0
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+
+		log.Printf("Migrating database to schema version %d: %s\n", m.version, m.desc)
+		if err := m.run(bc); err != nil {
+			return count, fmt.Errorf("Migration to schema version %d failed: %s", m.version, err)
+		}
+		if err := bc.setSchemaVersion(m.version); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// migrateTxIndex builds the tx index, which dbs from before it was kept lack. A pruned chain can't be indexed, so
+// keeps looking Transactions up by scanning
+func migrateTxIndex(bc *BlockChain) error {
+	if _, err := bc.ReindexTxs(nil); err != nil && err != chaindb.ErrBlockPruned {
+		return err
+	}
+
+	return nil
+}
+
+// migrateCumulativeWork stores the CumulativeWork of each Block on the chain, which dbs from before it was stored
+// would otherwise work out from the headers on every lookup
+func migrateCumulativeWork(bc *BlockChain) error {
+	bc.tipMu.RLock()
+	hashes, err := bc.chainHashes()
+	bc.tipMu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	work := new(big.Int)
+	for _, hash := range hashes {
+		header, err := bc.ChainDB.ReadHeaderWithHash(hash)
+		if err != nil {
+			return err
+		}
+		work.Add(work, BlockWork(header.Difficulty))
+
+		err = bc.ChainDB.Database.Update(func(txn *badger.Txn) error {
+			return storeWork(txn, hash, work)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migrateHeightIndex indexes the hash of each Block on the chain by its index, for BlockHashesInRange
+func migrateHeightIndex(bc *BlockChain) error {
+	bc.tipMu.RLock()
+	hashes, err := bc.chainHashes()
+	bc.tipMu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	for height, hash := range hashes {
+		err := bc.ChainDB.Database.Update(func(txn *badger.Txn) error {
+			return txn.Set(dbutil.IntKey(heightPrefix, height), hash)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkProofRules makes sure the genesis and last Blocks of the chain have the hash the Hasher of the BlockChain gives
+// them, returning ErrLegacyBlocks if either was mined under older proof of work rules. Headers are checked, so pruned
+// Blocks can be too
+func checkProofRules(bc *BlockChain) error {
+	genesisHash, err := bc.ChainDB.ReadGenesisHash()
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range [][]byte{genesisHash, bc.LastHash} {
+		header, err := bc.ChainDB.ReadHeaderWithHash(hash)
+		if err != nil {
+			return err
+		}
+		if !header.ValidateHash(bc.Hasher) {
+			return ErrLegacyBlocks
+		}
+	}
+
+	return nil
+}
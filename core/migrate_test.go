@@ -0,0 +1,122 @@
+package core_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/danitello/go-blockchain/chaindb"
+	"github.com/danitello/go-blockchain/common/byteutil"
+	"github.com/danitello/go-blockchain/core"
+	"github.com/danitello/go-blockchain/core/testutil"
+	"github.com/danitello/go-blockchain/core/types"
+	"github.com/danitello/go-blockchain/wallet"
+
+	"github.com/dgraph-io/badger"
+)
+
+func TestMigrate(t *testing.T) {
+	bc, _ := testutil.BuildTestChain(t, 3)
+	_, tip := bc.Tip()
+
+	if version, err := bc.DBSchemaVersion(); err != nil || version != core.SchemaVersion {
+		t.Fatalf("Got schema version %d, %v for a new db, want %d", version, err, core.SchemaVersion)
+	}
+	if count, err := bc.Migrate(); err != nil || count != 0 {
+		t.Fatalf("Got %d migrations, %v for a current db, want none", count, err)
+	}
+	txs, work, heights, err := bc.IndexSizes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if txs == 0 || work != tip+1 || heights != tip+1 {
+		t.Fatalf("Got index sizes %d, %d, %d for a new db", txs, work, heights)
+	}
+
+	if err := bc.DowngradeToSchemaV1(); err != nil {
+		t.Fatal(err)
+	}
+	if version, err := bc.DBSchemaVersion(); err != nil || version != 1 {
+		t.Fatalf("Got schema version %d, %v for an unversioned db, want 1", version, err)
+	}
+	if count, err := bc.Migrate(); err != nil || count != core.SchemaVersion-1 {
+		t.Fatalf("Got %d migrations, %v for a v1 db, want %d", count, err, core.SchemaVersion-1)
+	}
+	if version, err := bc.DBSchemaVersion(); err != nil || version != core.SchemaVersion {
+		t.Fatalf("Got schema version %d, %v after migrating, want %d", version, err, core.SchemaVersion)
+	}
+	if gotTxs, gotWork, gotHeights, err := bc.IndexSizes(); err != nil || gotTxs != txs || gotWork != work || gotHeights != heights {
+		t.Fatalf("Got index sizes %d, %d, %d, %v after migrating, want %d, %d, %d", gotTxs, gotWork, gotHeights, err, txs, work, heights)
+	}
+	if err := bc.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bc.SetSchemaVersion(core.SchemaVersion + 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.Migrate(); err != core.ErrSchemaTooNew {
+		t.Fatalf("Got %v for a newer db, want ErrSchemaTooNew", err)
+	}
+}
+
+// baselineDB writes a db in a new directory holding a genesis Block mined with the single SHA-256 and proof data of
+// the baseline, stored as the baseline stored Blocks: gob encoded under its hash, with only the last hash beside it
+// and no schema version or header. The directory is removed when the test finishes
+func baselineDB(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "legacychain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	w, err := wallet.InitWalletFromReader(rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	txns := []*types.Transaction{types.CoinbaseTx(string(w.GetAddress()), 0)}
+	// The baseline proof data left out the TimeStamp, as hashing with none does
+	genesis := types.AssembleBlock(txns, []byte{}, -1, types.TestDifficulty, 0, nil, types.SHA256Hasher{})
+	for !genesis.ValidateProof(types.SHA256Hasher{}) {
+		nonce := genesis.Nonce + 1
+		genesis = types.AssembleBlock(txns, []byte{}, -1, types.TestDifficulty, nonce, nil, types.SHA256Hasher{})
+	}
+	genesis.TimeStamp = types.NewTimeStamp()
+
+	db := chaindb.InitDBWithCache(dir, 0)
+	defer db.CloseDB()
+	err = db.Database.Update(func(txn *badger.Txn) error {
+		if err := txn.Set(genesis.Hash, byteutil.Serialize(genesis)); err != nil {
+			return err
+		}
+		return txn.Set([]byte(chaindb.LastHashKey), genesis.Hash)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+func TestMigrateLegacyBlocks(t *testing.T) {
+	// Refused whichever hasher it is opened with, as the proof data has changed too
+	for _, powHash := range []string{core.DefaultPowHash, "sha256"} {
+		t.Run(powHash, func(t *testing.T) {
+			// The db of the panicking open is left for the cleanup of baselineDB, as nothing else uses it
+			cfg := core.DefaultConfig()
+			cfg.DataDir = baselineDB(t)
+			cfg.Difficulty = types.TestDifficulty
+			cfg.PowHash = powHash
+			defer func() {
+				if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), core.ErrLegacyBlocks.Error()) {
+					t.Fatalf("Got %v opening a baseline db, want ErrLegacyBlocks", r)
+				}
+			}()
+			core.GetBlockChainWithConfig(cfg)
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/danitello/go-blockchain/core/types"
+)
+
+const (
+	// tipPollInterval is how often a Miner checks whether the Block it is mining has gone stale
+	tipPollInterval = 100 * time.Millisecond
+
+	// DefaultTemplateRefresh is the number of Mempool changes after which a Miner rebuilds its Block template
+	DefaultTemplateRefresh = 1
+
+	// minRejectBackoff is how long a Miner waits before mining again after a Block it mined is rejected. It doubles
+	// with each rejection in a row, up to maxRejectBackoff
+	minRejectBackoff = 100 * time.Millisecond
+	maxRejectBackoff = 10 * time.Second
+)
+
+// ErrMinerRunning is returned by Miner.Start when the Miner has already been started
+var ErrMinerRunning = errors.New("Miner is already running")
+
+// Miner mines Blocks from the Transactions in the Mempool and adds them to a BlockChain until stopped -
+// TemplateRefresh - number of Mempool changes (Transactions added or removed) after which the cached Block template
+// is rebuilt, abandoning the Block being mined for one with the new Transactions. 0 only rebuilds it for a new tip
+type Miner struct {
+	TemplateRefresh uint64
+
+	bc *BlockChain
+
+	mu     sync.Mutex
+	cancel context.CancelFunc // stops the running mining loop, nil if not running
+	done   chan struct{}      // closed when the mining loop returns
+
+	templateMu      sync.Mutex
+	template        *types.Block // unmined Block last built by Template, nil if none
+	templateAddress string       // address the coinbase tx of template pays
+	templateChanges uint64       // Mempool.Changes when template was built
+}
+
+// InitMiner creates a Miner for a BlockChain, which does nothing until started
+func InitMiner(bc *BlockChain) *Miner {
+	return &Miner{TemplateRefresh: DefaultTemplateRefresh, bc: bc}
+}
+
+// Start begins mining on threads goroutines, rewarding address, in the background. Each Block is built on the tip at
+// the time, and abandoned for a new one if another Block replaces the tip first
+func (m *Miner) Start(address string, threads int) error {
+	if !m.bc.AddressParams.ValidateAddress(address) {
+		return errors.New("Invalid mining address")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cancel != nil {
+		return ErrMinerRunning
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.done = make(chan struct{})
+	go m.run(ctx, address, threads, m.done)
+
+	return nil
+}
+
+// Stop halts mining and waits for the mining goroutines to return. A Block still being mined is dropped, so only
+// complete Blocks are ever added. Does nothing if the Miner isn't running
+func (m *Miner) Stop() {
+	m.mu.Lock()
+	cancel, done := m.cancel, m.done
+	m.cancel, m.done = nil, nil
+	m.mu.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// IsRunning determines whether the Miner has been started and not stopped
+func (m *Miner) IsRunning() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.cancel != nil
+}
+
+// run mines Blocks one after another until ctx is done
+func (m *Miner) run(ctx context.Context, address string, threads int, done chan struct{}) {
+	defer close(done)
+	backoff := minRejectBackoff
+
+	for ctx.Err() == nil {
+		block, err := m.Template(address)
+		if err != nil {
+			log.Println("Miner could not build a block:", err)
+			return
+		}
+
+		if err := m.mine(ctx, block, threads); err != nil {
+			continue // stopped, or the tip was replaced
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		err = m.bc.connectBlock(block)
+		if err == nil {
+			backoff = minRejectBackoff
+			continue
+		}
+		log.Println("Mined block rejected:", err)
+
+		// Unless a new tip replaced the one it builds on, the template would only be rejected again, e.g. for an
+		// invalid Mempool Transaction, so build a new one after waiting
+		m.templateMu.Lock()
+		m.template = nil
+		m.templateMu.Unlock()
+		if lastHash, _ := m.bc.Tip(); bytes.Compare(lastHash, block.PrevHash) != 0 {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxRejectBackoff {
+			backoff = maxRejectBackoff
+		}
+	}
+}
+
+// mine runs the proof for a Block, giving up if ctx is done or the Block goes stale (see stale)
+func (m *Miner) mine(ctx context.Context, block *types.Block, threads int) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		ticker := time.NewTicker(tipPollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				m.templateMu.Lock()
+				stale := m.stale(block)
+				m.templateMu.Unlock()
+				if stale {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	return block.RunParallel(ctx, threads, m.bc.Hasher)
+}
+
+// Template gets an unmined Block paying address to mine next. The Block is cached, and only rebuilt with candidateBlock
+// once the tip it builds on is replaced or the Mempool has changed TemplateRefresh times since. Each call gets its own
+// copy, so mining it leaves the cache alone
+func (m *Miner) Template(address string) (*types.Block, error) {
+	m.templateMu.Lock()
+	defer m.templateMu.Unlock()
+
+	if m.template == nil || m.templateAddress != address || m.stale(m.template) {
+		changes := m.bc.Mempool.Changes() // read first, so a change while building makes the template stale
+		block, err := m.bc.candidateBlock(address)
+		if err != nil {
+			return nil, err
+		}
+		m.template, m.templateAddress, m.templateChanges = block, address, changes
+	}
+
+	block := *m.template
+	return &block, nil
+}
+
+// stale determines whether a Block built by Template should be abandoned - the tip it builds on has been replaced, or
+// the Mempool has changed TemplateRefresh times since the cached template was built. m.templateMu must be held
+func (m *Miner) stale(block *types.Block) bool {
+	if lastHash, _ := m.bc.Tip(); bytes.Compare(lastHash, block.PrevHash) != 0 {
+		return true
+	}
+
+	return m.TemplateRefresh > 0 && m.bc.Mempool.Changes()-m.templateChanges >= m.TemplateRefresh
+}
+
+// candidateBlock builds an unmined Block on the tip from the Transactions in the Mempool, with a coinbase tx paying
+// address the block subsidy plus their fees
+func (bc *BlockChain) candidateBlock(address string) (*types.Block, error) {
+	bc.tipMu.RLock()
+	defer bc.tipMu.RUnlock()
+
+	txs, fees := bc.selectTransactions()
+	value := types.BlockSubsidy(bc.Height)
+	for _, fee := range fees {
+		value += fee
+	}
+
+	cbtx, err := types.CoinbaseTxWithValue(address, bc.Height, value, nil)
+	if err != nil {
+		return nil, err
+	}
+	txns := append([]*types.Transaction{cbtx}, txs...)
+	difficulty, err := bc.nextDifficulty()
+	if err != nil {
+		return nil, err
+	}
+
+	return types.AssembleBlock(txns, bc.LastHash, bc.Height-1, difficulty, 0, types.NewTimeStamp(), bc.Hasher), nil
+}
@@ -0,0 +1,162 @@
+package core_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/danitello/go-blockchain/core"
+	"github.com/danitello/go-blockchain/core/testutil"
+	"github.com/danitello/go-blockchain/core/types"
+)
+
+// waitForTip waits up to a timeout for the tip of bc to pass a given index
+func waitForTip(t *testing.T, bc *core.BlockChain, past int, timeout time.Duration) {
+	t.Helper()
+
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if _, tip := bc.Tip(); tip > past {
+			return
+		}
+	}
+	t.Fatalf("tip did not pass %d within %s", past, timeout)
+}
+
+func TestMinerStop(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 1)
+	_, tip := bc.Tip()
+
+	m := core.InitMiner(bc)
+	if err := m.Start(ws.GetAddresses()[0], 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Start(ws.GetAddresses()[0], 2); err != core.ErrMinerRunning {
+		t.Fatalf("got %v, want ErrMinerRunning", err)
+	}
+	waitForTip(t, bc, tip, 10*time.Second)
+
+	start := time.Now()
+	m.Stop()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Stop took %s", elapsed)
+	}
+	if m.IsRunning() {
+		t.Fatal("Miner running after Stop")
+	}
+
+	// Nothing more is added, and what was added is whole
+	_, stopped := bc.Tip()
+	time.Sleep(200 * time.Millisecond)
+	if _, tip := bc.Tip(); tip != stopped {
+		t.Fatal("Block added after Stop")
+	}
+	if err := bc.Verify(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMinerRebuildsRejectedTemplate(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 2)
+	addresses := ws.GetAddresses()
+
+	// A Transaction already on the chain, put in the Mempool without checks, makes every template holding it invalid
+	spend := testutil.SpendEach(t, bc, ws)[0]
+	_, tip := bc.Tip()
+	if err := bc.AddBlock([]*types.Transaction{types.CoinbaseTx(addresses[0], tip+1), spend}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.Mempool.Add(spend, 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	_, tip = bc.Tip()
+
+	m := core.InitMiner(bc)
+	m.TemplateRefresh = 0 // only a rejection or a new tip rebuilds the template
+	if err := m.Start(addresses[0], 1); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+
+	time.Sleep(300 * time.Millisecond)
+	if _, now := bc.Tip(); now != tip {
+		t.Fatal("Block spending a spent txo was added")
+	}
+
+	bc.Mempool.Remove(spend.ID)
+	waitForTip(t, bc, tip, 10*time.Second)
+}
+
+func TestMinerTemplateCache(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 2)
+	addresses := ws.GetAddresses()
+	spends := testutil.SpendEach(t, bc, ws)
+	m := core.InitMiner(bc)
+	m.TemplateRefresh = 2
+
+	template := func(address string) *types.Block {
+		t.Helper()
+		block, err := m.Template(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return block
+	}
+
+	first := template(addresses[0])
+	if again := template(addresses[0]); again.Transactions[0] != first.Transactions[0] {
+		t.Fatal("Template rebuilt with nothing changed")
+	}
+	if other := template(addresses[1]); other.Transactions[0] == first.Transactions[0] {
+		t.Fatal("Template for another address not rebuilt")
+	}
+	first = template(addresses[0])
+
+	// Fewer Mempool changes than TemplateRefresh keep the template, enough rebuild it with the new Transactions
+	if err := bc.SubmitTransaction(spends[0]); err != nil {
+		t.Fatal(err)
+	}
+	if again := template(addresses[0]); again.Transactions[0] != first.Transactions[0] || len(again.Transactions) != 1 {
+		t.Fatal("Template rebuilt before TemplateRefresh mempool changes")
+	}
+	if err := bc.SubmitTransaction(spends[1]); err != nil {
+		t.Fatal(err)
+	}
+	rebuilt := template(addresses[0])
+	if rebuilt.Transactions[0] == first.Transactions[0] || len(rebuilt.Transactions) != 3 {
+		t.Fatalf("Got a template of %d transactions after the mempool changed, want 3", len(rebuilt.Transactions))
+	}
+
+	// A new tip always rebuilds it
+	addBlock(t, bc, addresses[0])
+	lastHash, _ := bc.Tip()
+	if onTip := template(addresses[0]); string(onTip.PrevHash) != string(lastHash) {
+		t.Fatal("Template not rebuilt on the new tip")
+	}
+}
+
+// BenchmarkMinerTemplate gets a Block template to mine over and over, as the Miner does for each Block it tries, from
+// the cache of one Miner and rebuilt by a new Miner each time
+func BenchmarkMinerTemplate(b *testing.B) {
+	bc, ws := testutil.BuildTestChain(b, 5)
+	for _, tx := range testutil.SpendEach(b, bc, ws) {
+		if err := bc.SubmitTransaction(tx); err != nil {
+			b.Fatal(err)
+		}
+	}
+	address := ws.GetAddresses()[0]
+
+	b.Run("cached", func(b *testing.B) {
+		m := core.InitMiner(bc)
+		for i := 0; i < b.N; i++ {
+			if _, err := m.Template(address); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := core.InitMiner(bc).Template(address); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
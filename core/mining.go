@@ -0,0 +1,314 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"sort"
+
+	"github.com/danitello/go-blockchain/chaindb"
+	"github.com/danitello/go-blockchain/core/types"
+)
+
+const (
+	// blockOverhead is the room in a Block left for its header and coinbase tx when choosing Transactions
+	blockOverhead = 1000
+
+	// HighPriority is the Priority a Transaction needs for the PrioritySize of a Block, that of spending a block
+	// reward held for 144 Blocks in 250 bytes
+	HighPriority = float64(types.Reward) * 144 / 250
+)
+
+var (
+	// ErrNoMiningAddress is returned by GetWork when the BlockChain has no MiningAddress to reward
+	ErrNoMiningAddress = errors.New("No mining address set")
+	// ErrNoWork is returned by SubmitWork when GetWork has not handed out any work
+	ErrNoWork = errors.New("No work has been handed out")
+	// ErrStaleWork is returned by SubmitWork when a Block has been added since the work was handed out
+	ErrStaleWork = errors.New("Work does not build on the most recent block")
+	// ErrBadNonce is returned by SubmitWork for a nonce that does not complete the proof
+	ErrBadNonce = errors.New("Nonce does not meet the difficulty target")
+	// ErrNoTemplate is returned by SubmitBlock when GetBlockTemplate has not handed out a BlockTemplate
+	ErrNoTemplate = errors.New("No block template has been handed out")
+	// ErrWrongDifficulty is returned by SubmitBlock for a Block mined at a different difficulty than its BlockTemplate
+	ErrWrongDifficulty = errors.New("Block difficulty does not match the template")
+	// ErrBadDifficulty is returned by ValidateBlock for a Block mined at a different difficulty than the BlockChain
+	// requires at its index
+	ErrBadDifficulty = errors.New("Block difficulty does not match the chain")
+)
+
+// WorkTemplate is the header of a candidate Block, for an external miner to find a Nonce for -
+// Index - index the Block will have
+// PrevHash - hash of the Block it builds on
+// MerkleRoot - root of the MerkleTree of its Transactions
+// TimeStamp - TimeStamp it will have, which the proof commits to
+// Difficulty - number of leading zero bits its hash needs
+type WorkTemplate struct {
+	Index      int
+	PrevHash   []byte
+	MerkleRoot []byte
+	TimeStamp  []byte
+	Difficulty int
+
+	address string
+	hasher  types.Hasher
+	txs     []*types.Transaction // Mempool Transactions following the coinbase tx
+}
+
+// GetWork creates a candidate Block from the Transactions in the Mempool, rewarding MiningAddress, and hands out its
+// WorkTemplate. It replaces any work handed out before, so SubmitWork only accepts a nonce for the latest one
+func (bc *BlockChain) GetWork() (WorkTemplate, error) {
+	if bc.MiningAddress == "" {
+		return WorkTemplate{}, ErrNoMiningAddress
+	}
+	bc.tipMu.RLock()
+	defer bc.tipMu.RUnlock()
+
+	difficulty, err := bc.nextDifficulty()
+	if err != nil {
+		return WorkTemplate{}, err
+	}
+	work := WorkTemplate{
+		Index:      bc.Height,
+		PrevHash:   bc.LastHash,
+		TimeStamp:  types.NewTimeStamp(),
+		Difficulty: difficulty,
+		address:    bc.MiningAddress,
+		hasher:     bc.Hasher}
+	work.txs, _ = bc.selectTransactions()
+
+	work, err = work.WithExtraNonce(nil)
+	if err != nil {
+		return WorkTemplate{}, err
+	}
+
+	bc.workMu.Lock()
+	bc.work = &work
+	bc.workMu.Unlock()
+
+	return work, nil
+}
+
+// WithExtraNonce gets the WorkTemplate with an extra nonce carried in the coinbase tx, giving a miner that has tried
+// every Nonce a new MerkleRoot to search
+func (w WorkTemplate) WithExtraNonce(extraNonce []byte) (WorkTemplate, error) {
+	txns, err := w.transactions(extraNonce)
+	if err != nil {
+		return WorkTemplate{}, err
+	}
+
+	w.MerkleRoot = (&types.Block{Transactions: txns}).Header().MerkleRoot
+
+	return w, nil
+}
+
+// CheckNonce determines whether a Nonce completes the proof for the WorkTemplate
+func (w WorkTemplate) CheckNonce(nonce int) bool {
+	header := types.BlockHeader{
+		Index:      w.Index,
+		Nonce:      nonce,
+		Difficulty: w.Difficulty,
+		PrevHash:   w.PrevHash,
+		MerkleRoot: w.MerkleRoot,
+		TimeStamp:  w.TimeStamp}
+
+	return header.ValidateProof(w.hasher)
+}
+
+// transactions gets the Transactions of the candidate Block, with a coinbase tx carrying an extra nonce
+func (w WorkTemplate) transactions(extraNonce []byte) ([]*types.Transaction, error) {
+	cbtx, err := types.CoinbaseTxWithData(w.address, w.Index, extraNonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]*types.Transaction{cbtx}, w.txs...), nil
+}
+
+// SubmitWork assembles the Block of the work last handed out by GetWork with a nonce and extra nonce found by a miner,
+// and adds it to the BlockChain. The extra nonce must be the one the miner's MerkleRoot was created with
+func (bc *BlockChain) SubmitWork(nonce int, extraNonce []byte) error {
+	bc.workMu.Lock()
+	work := bc.work
+	bc.workMu.Unlock()
+
+	if work == nil {
+		return ErrNoWork
+	}
+	if err := bc.beginWrite(); err != nil {
+		return err
+	}
+	defer bc.inFlight.Done()
+	bc.tipMu.Lock()
+	defer bc.tipMu.Unlock()
+	if work.Index != bc.Height || bytes.Compare(work.PrevHash, bc.LastHash) != 0 {
+		return ErrStaleWork
+	}
+
+	txns, err := work.transactions(extraNonce)
+	if err != nil {
+		return err
+	}
+	block := types.AssembleBlock(txns, work.PrevHash, work.Index-1, work.Difficulty, nonce, work.TimeStamp, bc.Hasher)
+	if !block.ValidateProof(bc.Hasher) {
+		return ErrBadNonce
+	}
+
+	return bc.connectTip(block)
+}
+
+// BlockTemplate is everything a miner needs to build candidate Blocks itself, choosing its own coinbase tx -
+// Index - index the Block will have
+// PrevHash - hash of the Block it builds on
+// Difficulty - number of leading zero bits its hash needs
+// Target - value its hash must be below
+// Transactions - Mempool Transactions to follow the coinbase tx, in the order they must appear
+// Fees - fee paid by each of Transactions
+// CoinbaseValue - most the coinbase tx may pay, i.e. the block subsidy plus Fees
+type BlockTemplate struct {
+	Index         int
+	PrevHash      []byte
+	Difficulty    int
+	Target        *big.Int
+	Transactions  []*types.Transaction
+	Fees          []int
+	CoinbaseValue int
+}
+
+// GetBlockTemplate creates a BlockTemplate from the Transactions in the Mempool. It replaces any BlockTemplate handed
+// out before, so SubmitBlock only accepts a Block built from the latest one
+func (bc *BlockChain) GetBlockTemplate() (*BlockTemplate, error) {
+	if bc.ChainDB.IsReadOnly() {
+		return nil, chaindb.ErrReadOnly
+	}
+	bc.tipMu.RLock()
+	defer bc.tipMu.RUnlock()
+
+	difficulty, err := bc.nextDifficulty()
+	if err != nil {
+		return nil, err
+	}
+	template := &BlockTemplate{
+		Index:         bc.Height,
+		PrevHash:      bc.LastHash,
+		Difficulty:    difficulty,
+		Target:        types.ProofTarget(difficulty),
+		CoinbaseValue: types.BlockSubsidy(bc.Height)}
+
+	template.Transactions, template.Fees = bc.selectTransactions()
+	for _, fee := range template.Fees {
+		template.CoinbaseValue += fee
+	}
+
+	bc.workMu.Lock()
+	bc.template = template
+	bc.workMu.Unlock()
+
+	return template, nil
+}
+
+// SubmitBlock adds a Block built by a miner from the BlockTemplate last handed out by GetBlockTemplate. Beyond being
+// valid, the Block must build on the BlockTemplate's PrevHash at its Difficulty
+func (bc *BlockChain) SubmitBlock(block *types.Block) error {
+	bc.workMu.Lock()
+	template := bc.template
+	bc.workMu.Unlock()
+
+	if template == nil {
+		return ErrNoTemplate
+	}
+	if err := bc.beginWrite(); err != nil {
+		return err
+	}
+	defer bc.inFlight.Done()
+	bc.tipMu.Lock()
+	defer bc.tipMu.Unlock()
+	if template.Index != bc.Height || bytes.Compare(template.PrevHash, bc.LastHash) != 0 {
+		return ErrStaleWork
+	}
+	if block.Index != template.Index || bytes.Compare(block.PrevHash, template.PrevHash) != 0 {
+		return errors.New("Block does not build on the template")
+	}
+	if block.Difficulty != template.Difficulty {
+		return ErrWrongDifficulty
+	}
+
+	return bc.connectTip(block)
+}
+
+// selectTransactions chooses the Mempool Transactions for the next Block along with their fees, in Block order.
+// The first PrioritySize bytes go to Transactions of at least HighPriority, highest first, and the rest to the others
+// in Mempool order. Transactions that aren't final are left out, as are those that would take the Block past
+// MaxBlockSize and any spending the txos of one left out
+func (bc *BlockChain) selectTransactions() ([]*types.Transaction, []int) {
+	var selected []*types.Transaction
+	var selectedFees []int
+	size := blockOverhead
+	skipped := make(map[string]bool)
+	chosen := make(map[string]bool)
+
+	txs, fees := bc.Mempool.withFees()
+	for _, i := range bc.prioritized(txs) {
+		tx := txs[i]
+		if !tx.IsFinal(bc.Height) || size+tx.Size() > blockOverhead+bc.PrioritySize {
+			continue
+		}
+
+		chosen[hex.EncodeToString(tx.ID)] = true
+		selected = append(selected, tx)
+		selectedFees = append(selectedFees, fees[i])
+		size += tx.Size()
+	}
+
+	for i, tx := range txs {
+		if chosen[hex.EncodeToString(tx.ID)] {
+			continue
+		}
+
+		skip := !tx.IsFinal(bc.Height) || size+tx.Size() > bc.MaxBlockSize
+		for _, txin := range tx.Inputs {
+			skip = skip || skipped[hex.EncodeToString(txin.TxID)]
+		}
+		if skip {
+			skipped[hex.EncodeToString(tx.ID)] = true
+			continue
+		}
+
+		selected = append(selected, tx)
+		selectedFees = append(selectedFees, fees[i])
+		size += tx.Size()
+	}
+
+	return selected, selectedFees
+}
+
+// prioritized gets the positions of the Transactions of at least HighPriority, highest Priority first. Only
+// Transactions spending just utxos are considered, so they can go before any others in a Block
+func (bc *BlockChain) prioritized(txs []*types.Transaction) []int {
+	if bc.PrioritySize == 0 {
+		return nil
+	}
+
+	var res []int
+	priorities := make(map[int]float64)
+	for i, tx := range txs {
+		confirmed := true
+		for _, txin := range tx.Inputs {
+			if _, ok := bc.Mempool.Get(txin.TxID); ok {
+				confirmed = false
+			}
+		}
+
+		if priority := bc.TransactionPriority(tx); confirmed && priority >= HighPriority {
+			res = append(res, i)
+			priorities[i] = priority
+		}
+	}
+
+	sort.SliceStable(res, func(a, b int) bool {
+		return priorities[res[a]] > priorities[res[b]]
+	})
+
+	return res
+}
@@ -0,0 +1,117 @@
+package core_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/danitello/go-blockchain/core/testutil"
+	"github.com/danitello/go-blockchain/core/types"
+)
+
+// minMempool is enough Mempool Transactions that a Block's MerkleTree has more than 4 leaves
+const minMempool = 5
+
+func TestGetBlockTemplateManyTransactions(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 8)
+	for _, tx := range testutil.SpendEach(t, bc, ws) {
+		if _, err := bc.SubmitRawTransaction(types.EncodeRawTransaction(tx)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	template, err := bc.GetBlockTemplate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(template.Transactions) < minMempool {
+		t.Fatalf("template has %d transactions, want at least %d", len(template.Transactions), minMempool)
+	}
+
+	cbtx := types.CoinbaseTx(ws.GetAddresses()[0], template.Index)
+	cbtx.Outputs[0].Amount = template.CoinbaseValue
+	cbtx.ID = cbtx.Hash()
+	txns := append([]*types.Transaction{cbtx}, template.Transactions...)
+	block := mineBlock(t, bc, txns, template.PrevHash, template.Index-1, template.Difficulty)
+
+	if err := bc.SubmitBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	if _, tip := bc.Tip(); tip != template.Index {
+		t.Fatalf("tip is %d, want %d", tip, template.Index)
+	}
+	if err := bc.Verify(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetWorkManyTransactions(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 8)
+	for _, tx := range testutil.SpendEach(t, bc, ws) {
+		if _, err := bc.SubmitRawTransaction(types.EncodeRawTransaction(tx)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bc.MiningAddress = ws.GetAddresses()[0]
+
+	work, err := bc.GetWork()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := 0
+	for !work.CheckNonce(nonce) {
+		nonce++
+	}
+
+	if err := bc.SubmitWork(nonce, nil); err != nil {
+		t.Fatal(err)
+	}
+	block, err := bc.ChainDB.ReadBlockWithHash(bc.LastHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(block.Transactions) < 1+minMempool {
+		t.Fatalf("block has %d transactions, want at least %d", len(block.Transactions), 1+minMempool)
+	}
+}
+
+func TestChainedTransactionsConfirm(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 3)
+	addresses := ws.GetAddresses()
+
+	parent := pay(t, bc, ws, addresses[0], addresses[1], 3)
+	if err := bc.SubmitTransaction(parent); err != nil {
+		t.Fatal(err)
+	}
+	// Spends the payment of parent while it is still in the Mempool
+	child := types.CreateTransaction(addresses[1], addresses[2], ws.Wallets[addresses[1]].GetPubKey(), 2, 3,
+		map[string][]int{hex.EncodeToString(parent.ID): {0}})
+	if err := bc.SignTransaction(child, ws, addresses[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.SubmitTransaction(child); err != nil {
+		t.Fatal(err)
+	}
+
+	template, err := bc.GetBlockTemplate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(template.Transactions) != 2 || bytes.Compare(template.Transactions[0].ID, parent.ID) != 0 ||
+		bytes.Compare(template.Transactions[1].ID, child.ID) != 0 {
+		t.Fatal("Template does not hold the parent then the child")
+	}
+
+	addBlock(t, bc, addresses[0], template.Transactions...)
+	for _, tx := range []*types.Transaction{parent, child} {
+		if _, err := bc.GetTransactionWithID(tx.ID); err != nil {
+			t.Fatalf("Transaction %x not confirmed: %s", tx.ID, err)
+		}
+	}
+	if size := bc.Mempool.Size(); size != 0 {
+		t.Fatalf("%d transactions left in the mempool", size)
+	}
+	if err := bc.Verify(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,67 @@
+package core_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/danitello/go-blockchain/core"
+	"github.com/danitello/go-blockchain/core/testutil"
+	"github.com/danitello/go-blockchain/core/types"
+	"github.com/danitello/go-blockchain/p2p"
+	"github.com/danitello/go-blockchain/wallet"
+)
+
+func TestSeenTxsWithBlockChain(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 3)
+	seen := p2p.InitSeenTxs(p2p.DefaultSeenTxsSize, core.IsPermanentTxError)
+	parent := testutil.SpendEach(t, bc, ws)[0]
+
+	// A child spending the parent's payment, signed while the parent is in the Mempool
+	var to string
+	for address, w := range ws.Wallets {
+		if bytes.Compare(wallet.HashPubKey(w.GetPubKey()), parent.Outputs[0].PubKeyHash) == 0 {
+			to = address
+		}
+	}
+	if err := bc.SubmitTransaction(parent); err != nil {
+		t.Fatal(err)
+	}
+	amount := parent.Outputs[0].Amount
+	child := types.CreateTransaction(to, to, ws.Wallets[to].GetPubKey(), amount/2, amount,
+		map[string][]int{hex.EncodeToString(parent.ID): {0}})
+	bc.SignTransactionWithKey(child, ws.Wallets[to].PrivateKey)
+	bc.Mempool.Remove(parent.ID)
+
+	// The child arrives first, so is rejected for now but not remembered
+	if err := seen.Process(child, bc.SubmitTransaction); err == nil || core.IsPermanentTxError(err) {
+		t.Fatalf("child before parent: got %v, want a temporary error", err)
+	}
+
+	// A copy of the parent with a bad Signature doesn't keep out the real one, which has the same ID
+	forged := *parent
+	forged.Inputs = append([]types.TxInput{}, parent.Inputs...)
+	forged.Inputs[0].Signature = append([]byte{}, parent.Inputs[0].Signature...)
+	forged.Inputs[0].Signature[0] ^= 0xff
+	if err := seen.Process(&forged, bc.SubmitTransaction); err != core.ErrBadSignature {
+		t.Fatalf("forged parent: got %v, want ErrBadSignature", err)
+	}
+
+	for _, tx := range []*types.Transaction{parent, child} {
+		if err := seen.Process(tx, bc.SubmitTransaction); err != nil {
+			t.Fatal(err)
+		}
+		if err := seen.Process(tx, bc.SubmitTransaction); err != p2p.ErrTxAlreadySeen {
+			t.Fatalf("second arrival: got %v, want ErrTxAlreadySeen", err)
+		}
+	}
+
+	// A Transaction no Block can hold is remembered
+	invalid := types.CreateTransaction(to, to, ws.Wallets[to].GetPubKey(), 1, 2, map[string][]int{"00": {0, 0}})
+	if err := seen.Process(invalid, bc.SubmitTransaction); err != types.ErrDuplicateInput {
+		t.Fatalf("got %v, want ErrDuplicateInput", err)
+	}
+	if !seen.Seen(invalid.ID) {
+		t.Fatal("permanently invalid transaction not remembered")
+	}
+}
@@ -0,0 +1,48 @@
+package core_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/danitello/go-blockchain/core"
+	"github.com/danitello/go-blockchain/core/testutil"
+	"github.com/danitello/go-blockchain/core/types"
+	"github.com/danitello/go-blockchain/wallet"
+)
+
+func TestSignTransaction(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 3)
+	addresses := ws.GetAddresses()
+	from, to := addresses[0], addresses[1]
+	w := ws.Wallets[from]
+
+	utxos, txoSum := bc.GetUTXOWithPubKey(wallet.HashPubKey(w.GetPubKey()), math.MaxInt32)
+	if txoSum < 2 {
+		t.Fatalf("%s has only %d to spend", from, txoSum)
+	}
+	tx := types.CreateTransaction(from, to, w.GetPubKey(), txoSum/2, txoSum, utxos)
+
+	if err := bc.SignTransaction(tx, ws, "unknown"); err == nil {
+		t.Fatal("Signed with a wallet that doesn't exist")
+	}
+	if err := bc.SignTransaction(tx, ws, to); err == nil {
+		t.Fatal("Signed with a wallet that doesn't hold the spent outputs")
+	}
+
+	if err := bc.SignTransaction(tx, ws, from); err != nil {
+		t.Fatal(err)
+	}
+	if !bc.VerifyTransaction(tx) {
+		t.Fatal("Signed transaction does not verify")
+	}
+	if err := bc.SubmitTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+
+	// A txin spending a transaction that doesn't exist can't be signed
+	missing := types.CreateTransaction(to, from, ws.Wallets[to].GetPubKey(), 1, 2, map[string][]int{"00": {0}})
+	err := bc.SignTransaction(missing, ws, to)
+	if _, ok := err.(*core.OutpointError); !ok {
+		t.Fatalf("Got %v for a missing previous transaction, want an OutpointError", err)
+	}
+}
@@ -0,0 +1,155 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/danitello/go-blockchain/core/types"
+	"github.com/danitello/go-blockchain/wallet"
+)
+
+// UTXOSnapshot holds the spendable txos of an address, so a Transaction spending them can be built and signed on a
+// machine without the BlockChain, e.g. an air-gapped one holding the key -
+// Address - address owning the txos
+// Height - index of the most recent Block when the snapshot was taken
+// Outputs - the txos, ordered by outpoint
+type UTXOSnapshot struct {
+	Address string
+	Height  int
+	Outputs []SnapshotOutput
+}
+
+// SnapshotOutput is a txo of a UTXOSnapshot -
+// TxID, OutputIdx - outpoint of the txo
+// Amount - amount held by the txo
+// PubKeyHash - pub key hash the txo is locked with
+type SnapshotOutput struct {
+	TxID       []byte
+	OutputIdx  int
+	Amount     int
+	PubKeyHash []byte
+}
+
+// ExportAddressUTXOs serializes a UTXOSnapshot of the utxos of an address that GetUTXOWithPubKey would spend, leaving
+// out those already spent by a Transaction in the Mempool. Read it with CreateTransactionFromSnapshot
+func (bc *BlockChain) ExportAddressUTXOs(address string) ([]byte, error) {
+	if !bc.AddressParams.ValidateAddress(address) {
+		return nil, errors.New("Invalid address")
+	}
+	pubKeyHash := wallet.GetPubKeyHashFromAddress(address)
+
+	_, tip := bc.Tip()
+	snapshot := UTXOSnapshot{Address: address, Height: tip}
+
+	utxos, _ := bc.GetUTXOWithPubKey(pubKeyHash, math.MaxInt64)
+	for txID, outputIdxs := range utxos {
+		id, err := hex.DecodeString(txID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, outputIdx := range outputIdxs {
+			if bc.Mempool.IsSpent(id, outputIdx) {
+				continue
+			}
+			txo, ok := bc.GetUTXOWithOutpoint(id, outputIdx)
+			if !ok {
+				continue
+			}
+			snapshot.Outputs = append(snapshot.Outputs, SnapshotOutput{id, outputIdx, txo.Amount, txo.PubKeyHash})
+		}
+	}
+
+	sort.Slice(snapshot.Outputs, func(i, j int) bool {
+		if cmp := bytes.Compare(snapshot.Outputs[i].TxID, snapshot.Outputs[j].TxID); cmp != 0 {
+			return cmp < 0
+		}
+		return snapshot.Outputs[i].OutputIdx < snapshot.Outputs[j].OutputIdx
+	})
+
+	return json.Marshal(snapshot)
+}
+
+// CreateTransactionFromSnapshot makes a signed Transaction paying amount plus fee from the txos of a snapshot written
+// by ExportAddressUTXOs, with the key of its address in the Wallets on disk. It doesn't need the BlockChain, so works
+// offline; the Transaction is then submitted on the online machine, e.g. with SubmitRawTransaction. Change below
+// DefaultDustThreshold is added to the fee
+func CreateTransactionFromSnapshot(snapshot []byte, to string, amount, fee int) (*types.Transaction, error) {
+	wallets, err := wallet.InitWallets()
+	if err != nil {
+		return nil, err
+	}
+
+	return createTransactionFromSnapshot(wallets, snapshot, to, amount, fee)
+}
+
+// createTransactionFromSnapshot does the work of CreateTransactionFromSnapshot with the keys in ws
+func createTransactionFromSnapshot(ws *wallet.Wallets, snapshot []byte, to string, amount, fee int) (*types.Transaction, error) {
+	var s UTXOSnapshot
+	if err := json.Unmarshal(snapshot, &s); err != nil {
+		return nil, fmt.Errorf("Malformed UTXO snapshot: %s", err)
+	}
+
+	if !ws.AddressParams().ValidateAddress(to) {
+		return nil, errors.New("Invalid to address")
+	}
+	if amount <= 0 || fee < 0 {
+		return nil, errors.New("Amount must be positive and fee must not be negative")
+	}
+	if amount < DefaultDustThreshold {
+		return nil, ErrDustOutput
+	}
+
+	w, ok := ws.Wallets[s.Address]
+	if !ok {
+		return nil, fmt.Errorf("No wallet for address %s", s.Address)
+	}
+	if w.Zeroed() {
+		return nil, fmt.Errorf("Wallet for address %s has been wiped", s.Address)
+	}
+	pubKeyHash := wallet.HashPubKey(w.GetPubKey())
+
+	// Spend txos in order until they cover amount plus fee, standing in for the Transactions that hold them to sign
+	utxos := make(map[string][]int)
+	prevTxs := make(map[string]types.Transaction)
+	txoSum := 0
+	for _, out := range s.Outputs {
+		if txoSum >= amount+fee {
+			break
+		}
+		if bytes.Compare(out.PubKeyHash, pubKeyHash) != 0 {
+			return nil, fmt.Errorf("Output %d of transaction %x is not owned by %s", out.OutputIdx, out.TxID, s.Address)
+		}
+		if out.OutputIdx < 0 {
+			return nil, fmt.Errorf("Output %d of transaction %x has an invalid index", out.OutputIdx, out.TxID)
+		}
+
+		txID := hex.EncodeToString(out.TxID)
+		utxos[txID] = append(utxos[txID], out.OutputIdx)
+		txoSum += out.Amount
+
+		prevTx := prevTxs[txID]
+		prevTx.ID = out.TxID
+		for len(prevTx.Outputs) <= out.OutputIdx {
+			prevTx.Outputs = append(prevTx.Outputs, types.TxOutput{})
+		}
+		prevTx.Outputs[out.OutputIdx] = types.TxOutput{Amount: out.Amount, PubKeyHash: out.PubKeyHash}
+		prevTxs[txID] = prevTx
+	}
+	if txoSum < amount+fee {
+		return nil, fmt.Errorf("Snapshot outputs of %d do not cover amount %d plus fee %d", txoSum, amount, fee)
+	}
+
+	if change := txoSum - amount - fee; change > 0 && change < DefaultDustThreshold {
+		fee += change
+	}
+
+	newTx := types.CreateTransactionWithFee(s.Address, to, w.GetPubKey(), amount, fee, txoSum, utxos)
+	newTx.Sign(w.PrivateKey, prevTxs, types.SigHashAll)
+	return newTx, nil
+}
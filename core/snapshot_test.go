@@ -0,0 +1,67 @@
+package core_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/danitello/go-blockchain/core"
+	"github.com/danitello/go-blockchain/core/testutil"
+)
+
+func TestCreateTransactionFromSnapshot(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 3)
+	addresses := ws.GetAddresses()
+	from, to := addresses[0], addresses[1]
+
+	// An output already spent in the Mempool is left out
+	pending := pay(t, bc, ws, from, to, 1)
+	if err := bc.SubmitTransaction(pending); err != nil {
+		t.Fatal(err)
+	}
+	data, err := bc.ExportAddressUTXOs(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snapshot core.UTXOSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	for _, out := range snapshot.Outputs {
+		if string(out.TxID) == string(pending.Inputs[0].TxID) && out.OutputIdx == pending.Inputs[0].OutputIdx {
+			t.Fatal("Snapshot holds an output spent in the mempool")
+		}
+		total += out.Amount
+	}
+	if snapshot.Address != from || total == 0 {
+		t.Fatalf("Got snapshot %+v", snapshot)
+	}
+
+	// Built offline, it is accepted by the online chain
+	tx, err := core.CreateTransactionFromSnapshotWithWallets(ws, data, to, total-1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.SubmitTransaction(tx); err != nil {
+		t.Fatalf("Got %v submitting a transaction built from a snapshot", err)
+	}
+	addBlock(t, bc, from, pending, tx)
+	if err := bc.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := core.CreateTransactionFromSnapshotWithWallets(ws, data, to, total, 1); err == nil {
+		t.Fatal("Built a transaction spending more than the snapshot holds")
+	}
+	snapshot.Address = to // outputs of another key
+	forged, err := json.Marshal(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := core.CreateTransactionFromSnapshotWithWallets(ws, forged, to, 1, 0); err == nil {
+		t.Fatal("Built a transaction spending outputs of another key")
+	}
+	if _, err := core.CreateTransactionFromSnapshotWithWallets(ws, []byte("{"), to, 1, 0); err == nil {
+		t.Fatal("Built a transaction from a malformed snapshot")
+	}
+}
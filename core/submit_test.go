@@ -0,0 +1,172 @@
+package core_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/danitello/go-blockchain/core"
+	"github.com/danitello/go-blockchain/core/testutil"
+	"github.com/danitello/go-blockchain/core/types"
+	"github.com/danitello/go-blockchain/wallet"
+)
+
+func TestSubmitRawTransaction(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 3)
+	tx := testutil.SpendEach(t, bc, ws)[0]
+
+	id, err := bc.SubmitRawTransaction(types.EncodeRawTransaction(tx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := bc.Mempool.Get(id); !ok {
+		t.Fatal("submitted transaction is not in the mempool")
+	}
+	if _, err := bc.SubmitRawTransaction(types.EncodeRawTransaction(tx)); err != core.ErrTxInMempool {
+		t.Fatalf("resubmitted: got %v, want ErrTxInMempool", err)
+	}
+}
+
+func TestSubmitRawTransactionOutputIdxOutOfRange(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 3)
+	spend := testutil.SpendEach(t, bc, ws)[0]
+	from := ws.GetAddresses()[0]
+	prevID := hex.EncodeToString(spend.Inputs[0].TxID)
+
+	// Left unsigned, as the txo can't be found to sign for, which submitting must fail on rather than panic
+	for _, idx := range []int{-1, 99} {
+		tx := types.CreateTransaction(from, from, ws.Wallets[from].GetPubKey(), 1, 2, map[string][]int{prevID: {idx}})
+
+		_, err := bc.SubmitRawTransaction(types.EncodeRawTransaction(tx))
+		var outpointErr *core.OutpointError
+		if !errors.As(err, &outpointErr) || outpointErr.Err != core.ErrMissingUTXO {
+			t.Errorf("output idx %d: got %v, want an OutpointError for ErrMissingUTXO", idx, err)
+		}
+	}
+}
+
+func TestSubmitRawTransactionBadHex(t *testing.T) {
+	bc, _ := testutil.BuildTestChain(t, 1)
+
+	if _, err := bc.SubmitRawTransaction("not hex"); err == nil {
+		t.Fatal("bad hex was accepted")
+	}
+}
+
+func TestSubmitTooManyInputs(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 3)
+	tx := testutil.SpendEach(t, bc, ws)[0]
+
+	// The extra txins are unsigned and spend nothing, which must not be looked at
+	for i := 0; len(tx.Inputs) <= types.MaxInputs; i++ {
+		tx.Inputs = append(tx.Inputs, types.TxInput{TxID: []byte("missing"), OutputIdx: i})
+	}
+
+	_, err := bc.SubmitRawTransaction(types.EncodeRawTransaction(tx))
+	if err != types.ErrTooManyInputs {
+		t.Fatalf("Got %v, want ErrTooManyInputs", err)
+	}
+	if !core.IsPermanentTxError(err) {
+		t.Fatal("ErrTooManyInputs is not permanent")
+	}
+}
+
+func TestBogusOutpoint(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 3)
+	addresses := ws.GetAddresses()
+	from := addresses[0]
+	pubKey := ws.Wallets[from].GetPubKey()
+	othersUTXOs, _ := bc.GetUTXOWithPubKey(wallet.GetPubKeyHashFromAddress(addresses[1]), 1)
+	var othersTxID string
+	for txID := range othersUTXOs {
+		othersTxID = txID
+	}
+
+	// Left unsigned, as the outpoints are checked first
+	for name, utxos := range map[string]map[string][]int{
+		"fabricated": {hex.EncodeToString(make([]byte, 32)): {0}},
+		"not owned":  {othersTxID: othersUTXOs[othersTxID][:1]},
+	} {
+		tx := types.CreateTransaction(from, from, pubKey, 1, 2, utxos)
+
+		var outpointErr *core.OutpointError
+		err := bc.CheckTransaction(tx)
+		if !errors.As(err, &outpointErr) || outpointErr.Err != core.ErrMissingUTXO ||
+			!bytes.Equal(outpointErr.Outpoint.TxID, tx.Inputs[0].TxID) || outpointErr.Outpoint.OutputIdx != tx.Inputs[0].OutputIdx {
+			t.Fatalf("%s: got %v, want an OutpointError for ErrMissingUTXO", name, err)
+		}
+
+		lastHash, tip := bc.Tip()
+		block := mineBlock(t, bc, []*types.Transaction{types.CoinbaseTx(from, tip+1), tx}, lastHash, tip, bc.Difficulty)
+		if err := bc.ValidateBlock(block); err == nil || !strings.Contains(err.Error(), outpointErr.Error()) {
+			t.Errorf("%s: block validation got %v, want it to name the outpoint", name, err)
+		}
+	}
+}
+
+func TestCheckTransaction(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 3)
+	spends := testutil.SpendEach(t, bc, ws)
+	valid := spends[0]
+	from := ws.GetAddresses()[0]
+	w := ws.Wallets[from]
+	prevID := hex.EncodeToString(valid.Inputs[0].TxID)
+	prev, ok := bc.GetUTXOWithOutpoint(valid.Inputs[0].TxID, valid.Inputs[0].OutputIdx)
+	if !ok {
+		t.Fatal("spent txo is not in the UTXO set")
+	}
+
+	if err := bc.CheckTransaction(valid); err != nil {
+		t.Fatalf("Got %v for a valid transaction", err)
+	}
+	if bc.Mempool.Size() != 0 {
+		t.Fatal("Checked transaction was added to the mempool")
+	}
+
+	noOutputs := *valid
+	noOutputs.Outputs = nil
+	overspend := types.CreateTransaction(from, from, w.GetPubKey(), prev.Amount*2, prev.Amount*2, map[string][]int{prevID: {valid.Inputs[0].OutputIdx}})
+	bc.SignTransactionWithKey(overspend, w.PrivateKey)
+	badSig := *valid
+	badSig.Inputs = append([]types.TxInput{}, valid.Inputs...)
+	badSig.Inputs[0].Signature = append([]byte{}, valid.Inputs[0].Signature...)
+	badSig.Inputs[0].Signature[0] ^= 1
+
+	if err := bc.CheckTransaction(&noOutputs); err != types.ErrNoOutputs {
+		t.Fatalf("Got %v for no outputs, want ErrNoOutputs", err)
+	}
+	missing := types.CreateTransaction(from, from, w.GetPubKey(), 1, 2, map[string][]int{hex.EncodeToString(make([]byte, 32)): {0}})
+	var outpointErr *core.OutpointError
+	if err := bc.CheckTransaction(missing); !errors.As(err, &outpointErr) || outpointErr.Err != core.ErrMissingUTXO {
+		t.Fatalf("Got %v for a missing utxo, want an OutpointError for ErrMissingUTXO", err)
+	}
+	if err := bc.CheckTransaction(overspend); err == nil || !strings.Contains(err.Error(), "exceed inputs") {
+		t.Fatalf("Got %v for outputs exceeding inputs", err)
+	}
+	if err := bc.CheckTransaction(&badSig); err != core.ErrBadSignature {
+		t.Fatalf("Got %v for a bad signature, want ErrBadSignature", err)
+	}
+
+	bc.Mempool.MinRelayFee = 1
+	if err := bc.CheckTransaction(valid); err != core.ErrFeeTooLow {
+		t.Fatalf("Got %v for no fee, want ErrFeeTooLow", err)
+	}
+	bc.Mempool.MinRelayFee = 0
+
+	if err := bc.SubmitTransaction(valid); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.CheckTransaction(valid); err != core.ErrTxInMempool {
+		t.Fatalf("Got %v for a pending transaction, want ErrTxInMempool", err)
+	}
+	conflict := types.CreateTransaction(from, from, w.GetPubKey(), prev.Amount, prev.Amount, map[string][]int{prevID: {valid.Inputs[0].OutputIdx}})
+	bc.SignTransactionWithKey(conflict, w.PrivateKey)
+	if err := bc.CheckTransaction(conflict); err != core.ErrMempoolConflict {
+		t.Fatalf("Got %v for a double spend, want ErrMempoolConflict", err)
+	}
+	if bc.Mempool.Size() != 1 {
+		t.Fatalf("Got %d transactions in the mempool, want 1", bc.Mempool.Size())
+	}
+}
@@ -0,0 +1,152 @@
+package core
+
+import (
+	"path/filepath"
+
+	"github.com/danitello/go-blockchain/core/types"
+	"github.com/danitello/go-blockchain/wallet"
+
+	"github.com/dgraph-io/badger"
+)
+
+// SaveNewLastBlock lets tests in core_test save a Block without validating it first
+func (bc *BlockChain) SaveNewLastBlock(block *types.Block) error {
+	return bc.saveNewLastBlock(block)
+}
+
+// InterruptTxReindex leaves the db as a ReindexTxs that crashed after clearing the old tx index would
+func (bc *BlockChain) InterruptTxReindex() error {
+	err := bc.ChainDB.Database.Update(func(txn *badger.Txn) error {
+		return txn.Set(txReindexKey, []byte{})
+	})
+	if err != nil {
+		return err
+	}
+	bc.DeleteWithKeyPrefix(txIndexPrefix)
+
+	return nil
+}
+
+// InterruptReindex leaves the db as a Reindex that crashed while writing the new UTXO set would, with the marker set
+// and only some of the utxos written
+func (bc *BlockChain) InterruptReindex() error {
+	err := bc.ChainDB.Database.Update(func(txn *badger.Txn) error {
+		return txn.Set(reindexKey, []byte{})
+	})
+	if err != nil {
+		return err
+	}
+
+	return bc.ChainDB.Database.Update(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		var keys [][]byte
+		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
+			keys = append(keys, it.Item().KeyCopy(nil))
+		}
+		for _, key := range keys[len(keys)/2:] {
+			if err := txn.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// CreateTransactionFromInputsWithWallets lets tests in core_test use CreateTransactionFromInputs without a wallet file
+func (bc *BlockChain) CreateTransactionFromInputsWithWallets(wallets *wallet.Wallets, from, to string, amount, fee int, chosen []types.Outpoint) (*types.Transaction, error) {
+	return bc.createTransactionFromInputs(wallets, from, to, amount, fee, chosen)
+}
+
+// ClearCheckpoints removes every Checkpoint, so those added by a test don't reach the next
+func ClearCheckpoints() {
+	checkpointsMu.Lock()
+	defer checkpointsMu.Unlock()
+
+	checkpoints = make(map[int][]byte)
+}
+
+// CreateCPFPTransactionWithWallets lets tests in core_test use CreateCPFPTransaction without a wallet file
+func (bc *BlockChain) CreateCPFPTransactionWithWallets(ws *wallet.Wallets, parentTxID []byte, changeIndex, extraFee int) (*types.Transaction, error) {
+	return bc.createCPFPTransaction(ws, parentTxID, changeIndex, extraFee)
+}
+
+// CreateTransactionFromSnapshotWithWallets lets tests in core_test use CreateTransactionFromSnapshot without a wallet
+// file
+func CreateTransactionFromSnapshotWithWallets(ws *wallet.Wallets, snapshot []byte, to string, amount, fee int) (*types.Transaction, error) {
+	return createTransactionFromSnapshot(ws, snapshot, to, amount, fee)
+}
+
+// DowngradeToSchemaV1 leaves the db as a binary from before schema versions would have, without a version or any of
+// the indexes the migrations build
+func (bc *BlockChain) DowngradeToSchemaV1() error {
+	err := bc.ChainDB.Database.Update(func(txn *badger.Txn) error {
+		return txn.Delete(schemaVersionKey)
+	})
+	if err != nil {
+		return err
+	}
+	for _, prefix := range [][]byte{txIndexPrefix, workPrefix, heightPrefix} {
+		bc.DeleteWithKeyPrefix(prefix)
+	}
+
+	return nil
+}
+
+// SetSchemaVersion lets tests in core_test record any schema version, e.g. one newer than SchemaVersion
+func (bc *BlockChain) SetSchemaVersion(version int) error {
+	return bc.setSchemaVersion(version)
+}
+
+// IndexSizes gets the number of entries of each index the migrations build
+func (bc *BlockChain) IndexSizes() (txs, work, heights int, err error) {
+	err = bc.ChainDB.Database.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for _, index := range []struct {
+			prefix []byte
+			size   *int
+		}{{txIndexPrefix, &txs}, {workPrefix, &work}, {heightPrefix, &heights}} {
+			for it.Seek(index.prefix); it.ValidForPrefix(index.prefix); it.Next() {
+				*index.size++
+			}
+		}
+		return nil
+	})
+
+	return txs, work, heights, err
+}
+
+// BuildWithWallets lets tests in core_test use TxBuilder.Build without a wallet file
+func (b *TxBuilder) BuildWithWallets(ws *wallet.Wallets) (*types.Transaction, error) {
+	return b.build(ws)
+}
+
+// EstimateFeeRate lets tests in core_test see the fee rate EstimateSmartFee rounds up, per feeRateScale bytes
+func (bc *BlockChain) EstimateFeeRate(targetBlocks int) int {
+	return bc.estimateFeeRate(targetBlocks)
+}
+
+// DataDir lets tests in core_test reopen the db of a BlockChain made by testutil
+func (bc *BlockChain) DataDir() string {
+	return filepath.Dir(bc.mempoolFile)
+}
+
+// IndexHeights lets tests in core_test fill the height index without mining a Block at each height
+func (bc *BlockChain) IndexHeights(blocks []*types.Block) error {
+	return bc.ChainDB.Database.Update(func(txn *badger.Txn) error {
+		for _, block := range blocks {
+			if err := indexHeight(txn, block); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// CreateTransactionToHashWithWallets lets tests in core_test use CreateTransactionToHash without a wallet file
+func (bc *BlockChain) CreateTransactionToHashWithWallets(ws *wallet.Wallets, from string, toPubKeyHash []byte, amount, fee int) (*types.Transaction, error) {
+	return bc.createTransactionToHash(ws, from, toPubKeyHash, amount, fee)
+}
@@ -0,0 +1,136 @@
+// Package testutil builds BlockChains for tests of the packages that use them
+package testutil
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/danitello/go-blockchain/core"
+	"github.com/danitello/go-blockchain/core/types"
+	"github.com/danitello/go-blockchain/wallet"
+)
+
+const (
+	// DefaultSeed is the seed BuildTestChain uses
+	DefaultSeed = 1
+	// NumWallets is the number of Wallets a test chain is built with
+	NumWallets = 4
+)
+
+// BuildTestChain is BuildTestChainWithSeed with DefaultSeed
+func BuildTestChain(t testing.TB, blocks int) (*core.BlockChain, *wallet.Wallets) {
+	return BuildTestChainWithSeed(t, blocks, DefaultSeed)
+}
+
+// BuildTestChainWithSeed makes a new BlockChain at types.TestDifficulty in a temporary DataDir along with NumWallets
+// Wallets, then mines blocks Blocks on it, each paying its reward to a random Wallet and holding payments between
+// random Wallets of random amounts of what they have. The whole chain is then checked with Verify and VerifySupply.
+// The same seed gives the same Wallets and payments, though Block hashes differ as signatures and timestamps do. The
+// BlockChain is closed and its DataDir removed when the test finishes
+func BuildTestChainWithSeed(t testing.TB, blocks int, seed int64) (*core.BlockChain, *wallet.Wallets) {
+	t.Helper()
+	r := rand.New(rand.NewSource(seed))
+
+	ws := &wallet.Wallets{Wallets: make(map[string]*wallet.Wallet), Labels: make(map[string]string)}
+	addresses := make([]string, NumWallets)
+	for i := range addresses {
+		w, err := wallet.InitWalletFromReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Compressed = i%2 == 1
+		addresses[i] = string(w.GetAddress())
+		ws.Wallets[addresses[i]] = w
+	}
+
+	dir, err := ioutil.TempDir("", "testchain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := core.DefaultConfig()
+	cfg.DataDir = dir
+	cfg.Difficulty = types.TestDifficulty
+
+	bc := core.InitBlockChainWithConfig(addresses[0], cfg)
+	t.Cleanup(func() {
+		bc.ChainDB.CloseDB()
+		os.RemoveAll(dir)
+	})
+
+	for i := 0; i < blocks; i++ {
+		_, tip := bc.Tip()
+		height := tip + 1
+		txns := []*types.Transaction{types.CoinbaseTx(addresses[r.Intn(NumWallets)], height)}
+
+		// Each sender pays at most once per Block, as its txos aren't spent until the Block is added
+		for _, from := range r.Perm(NumWallets)[:1+r.Intn(NumWallets)] {
+			to := (from + 1 + r.Intn(NumWallets-1)) % NumWallets
+			if tx := payment(bc, ws.Wallets[addresses[from]], addresses[from], addresses[to], r); tx != nil {
+				txns = append(txns, tx)
+			}
+		}
+
+		if err := bc.AddBlock(txns); err != nil {
+			t.Fatalf("Adding Block %d: %s", height, err)
+		}
+	}
+
+	if err := bc.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.VerifySupply(); err != nil {
+		t.Fatal(err)
+	}
+
+	return bc, ws
+}
+
+// payment makes a signed Transaction sending a random amount of what w has to an address, or nil if w has nothing
+func payment(bc *core.BlockChain, w *wallet.Wallet, from, to string, r *rand.Rand) *types.Transaction {
+	utxos, txoSum := bc.GetUTXOWithPubKey(wallet.HashPubKey(w.GetPubKey()), math.MaxInt32)
+	if txoSum == 0 {
+		return nil
+	}
+
+	tx := types.CreateTransaction(from, to, w.GetPubKey(), 1+r.Intn(txoSum), txoSum, utxos)
+	bc.SignTransactionWithKey(tx, w.PrivateKey)
+
+	return tx
+}
+
+// SpendEach makes a signed Transaction for every utxo held by the Wallets, each paying half of it to the next Wallet
+// and the rest back as change. The Transactions spend different txos, so they can all go in the Mempool together
+func SpendEach(t testing.TB, bc *core.BlockChain, ws *wallet.Wallets) []*types.Transaction {
+	t.Helper()
+	var txs []*types.Transaction
+
+	addresses := ws.GetAddresses()
+	for i, from := range addresses {
+		w := ws.Wallets[from]
+		to := addresses[(i+1)%len(addresses)]
+
+		utxos, _ := bc.GetUTXOWithPubKey(wallet.HashPubKey(w.GetPubKey()), math.MaxInt32)
+		for txID, idxs := range utxos {
+			id, err := hex.DecodeString(txID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, idx := range idxs {
+				txo, ok := bc.GetUTXOWithOutpoint(id, idx)
+				if !ok || txo.Amount < 2 {
+					continue
+				}
+
+				tx := types.CreateTransaction(from, to, w.GetPubKey(), txo.Amount/2, txo.Amount, map[string][]int{txID: {idx}})
+				bc.SignTransactionWithKey(tx, w.PrivateKey)
+				txs = append(txs, tx)
+			}
+		}
+	}
+
+	return txs
+}
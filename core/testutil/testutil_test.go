@@ -0,0 +1,38 @@
+package testutil
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuildTestChain(t *testing.T) {
+	bc, ws := BuildTestChain(t, 20)
+
+	if _, tip := bc.Tip(); tip != 20 {
+		t.Fatalf("tip is %d, want 20", tip)
+	}
+	if len(ws.Wallets) != NumWallets {
+		t.Fatalf("%d wallets, want %d", len(ws.Wallets), NumWallets)
+	}
+
+	// Blocks may hold a payment from every Wallet
+	most := 0
+	iter := bc.Iterator()
+	for i := 0; i <= 20; i++ {
+		if n := len(iter.Next().Transactions); n > most {
+			most = n
+		}
+	}
+	if most != 1+NumWallets {
+		t.Errorf("largest block has %d transactions, want %d", most, 1+NumWallets)
+	}
+}
+
+func TestBuildTestChainWithSeedRepeats(t *testing.T) {
+	_, ws1 := BuildTestChainWithSeed(t, 2, 7)
+	_, ws2 := BuildTestChainWithSeed(t, 2, 7)
+
+	if !reflect.DeepEqual(ws1.GetAddresses(), ws2.GetAddresses()) {
+		t.Fatal("the same seed gave different wallets")
+	}
+}
@@ -0,0 +1,73 @@
+package core_test
+
+import (
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/danitello/go-blockchain/core/testutil"
+	"github.com/danitello/go-blockchain/core/types"
+	"github.com/danitello/go-blockchain/wallet"
+)
+
+// Run with -race, Blocks are added by two miners while transactions are submitted and the chain is read
+func TestConcurrentBlocksAndReads(t *testing.T) {
+	const blocksPerMiner = 3
+	bc, ws := testutil.BuildTestChain(t, 2)
+	_, startTip := bc.Tip()
+	addresses := ws.GetAddresses()
+	spends := testutil.SpendEach(t, bc, ws)
+
+	var wg sync.WaitGroup
+	for m := 0; m < 2; m++ {
+		wg.Add(1)
+		go func(address string) {
+			defer wg.Done()
+			// A coinbase tx for a height the other miner just took is rejected, so try again on the new tip
+			for added, tries := 0, 0; added < blocksPerMiner; tries++ {
+				if tries == 10*blocksPerMiner {
+					t.Error("too many Blocks rejected")
+					return
+				}
+				_, tip := bc.Tip()
+				if err := bc.AddBlock([]*types.Transaction{types.CoinbaseTx(address, tip+1)}); err == nil {
+					added++
+				}
+			}
+		}(addresses[m])
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for _, tx := range spends {
+			bc.CheckTransaction(tx)
+			bc.SubmitRawTransaction(types.EncodeRawTransaction(tx))
+			bc.TransactionPriority(tx)
+		}
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < blocksPerMiner; i++ {
+			bc.Confirmations(0)
+			bc.GetUTXOWithPubKey(wallet.HashPubKey(ws.Wallets[addresses[0]].GetPubKey()), math.MaxInt32)
+			iter := bc.Iterator()
+			for block := iter.Next(); len(block.PrevHash) > 0; block = iter.Next() {
+			}
+		}
+	}()
+
+	wg.Wait()
+
+	if _, tip := bc.Tip(); tip != startTip+2*blocksPerMiner {
+		t.Fatalf("tip %d, want %d", tip, startTip+2*blocksPerMiner)
+	}
+	if got := bc.Confirmations(0); got != startTip+2*blocksPerMiner+1 {
+		t.Fatalf("genesis has %d confirmations", got)
+	}
+	if err := bc.Verify(); err != nil {
+		t.Fatal(err)
+	}
+}
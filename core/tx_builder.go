@@ -0,0 +1,144 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/danitello/go-blockchain/core/types"
+	"github.com/danitello/go-blockchain/wallet"
+)
+
+// TxBuilder assembles a Transaction with several txos, e.g.
+//
+//	tx, err := bc.InitTxBuilder().SelectFrom(from).AddOutput(to, 10).AddDataOutput(data).SetFee(2).Build()
+//
+// Each method returns the TxBuilder so calls chain, and the first bad argument is kept and returned by Build -
+// from - address whose utxos are spent and which gets the change
+// outputs - txos paid, in order
+// fee - what the txins are worth more than the txos
+type TxBuilder struct {
+	bc      *BlockChain
+	from    string
+	outputs []types.TxOutput
+	fee     int
+	err     error
+}
+
+// InitTxBuilder creates a new TxBuilder spending utxos of the BlockChain
+func (bc *BlockChain) InitTxBuilder() *TxBuilder {
+	return &TxBuilder{bc: bc}
+}
+
+// AddOutput adds a txo paying amount to an address
+func (b *TxBuilder) AddOutput(address string, amount int) *TxBuilder {
+	if b.err != nil {
+		return b
+	}
+	if !b.bc.AddressParams.ValidateAddress(address) {
+		b.err = fmt.Errorf("Invalid address %s", address)
+		return b
+	}
+	if amount <= 0 {
+		b.err = errors.New("Amount must be positive")
+		return b
+	}
+	if amount < b.bc.Mempool.DustThreshold {
+		b.err = ErrDustOutput
+		return b
+	}
+
+	b.outputs = append(b.outputs, *types.InitTxOutput(amount, address))
+	return b
+}
+
+// AddDataOutput adds a txo carrying data, see types.InitDataOutput
+func (b *TxBuilder) AddDataOutput(data []byte) *TxBuilder {
+	if b.err != nil {
+		return b
+	}
+
+	txo, err := types.InitDataOutput(data)
+	if err != nil {
+		b.err = err
+		return b
+	}
+
+	b.outputs = append(b.outputs, *txo)
+	return b
+}
+
+// SetFee sets the fee, 0 unless set. Change below the Mempool's DustThreshold is added to it
+func (b *TxBuilder) SetFee(fee int) *TxBuilder {
+	if b.err != nil {
+		return b
+	}
+	if fee < 0 {
+		b.err = errors.New("Fee must not be negative")
+		return b
+	}
+
+	b.fee = fee
+	return b
+}
+
+// SelectFrom sets the address whose utxos pay for the Transaction and which gets the change
+func (b *TxBuilder) SelectFrom(address string) *TxBuilder {
+	if b.err != nil {
+		return b
+	}
+	if !b.bc.AddressParams.ValidateAddress(address) {
+		b.err = fmt.Errorf("Invalid address %s", address)
+		return b
+	}
+
+	b.from = address
+	return b
+}
+
+// Build selects utxos of the from address covering the txos and fee, and creates the Transaction with the key of the
+// address in the Wallets on disk. The Transaction is unsigned, so it must be signed, e.g. with SignTransaction, before
+// it is submitted
+func (b *TxBuilder) Build() (*types.Transaction, error) {
+	wallets, err := wallet.InitWalletsWithParams(b.bc.AddressParams)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.build(wallets)
+}
+
+// build does the work of Build with the keys in ws
+func (b *TxBuilder) build(ws *wallet.Wallets) (*types.Transaction, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if b.from == "" {
+		return nil, errors.New("No address to select utxos from, call SelectFrom")
+	}
+	if len(b.outputs) == 0 {
+		return nil, errors.New("Transaction has no outputs, call AddOutput or AddDataOutput")
+	}
+	if len(b.outputs) >= types.MaxOutputs {
+		return nil, types.ErrTooManyOutputs // leaving room for the change
+	}
+	if _, ok := ws.Wallets[b.from]; !ok {
+		return nil, fmt.Errorf("No wallet for address %s", b.from)
+	}
+	w := ws.GetWallet(b.from)
+
+	amount := 0
+	for _, txo := range b.outputs {
+		if txo.Amount > types.MaxSupply-amount {
+			return nil, errors.New("Outputs pay more than the max supply")
+		}
+		amount += txo.Amount
+	}
+
+	utxos, txoSum := b.bc.GetUTXOWithPubKey(wallet.HashPubKey(w.GetPubKey()), amount+b.fee)
+	if txoSum < amount+b.fee {
+		return nil, fmt.Errorf("Funds of %d do not cover amount %d plus fee %d", txoSum, amount, b.fee)
+	}
+
+	fee := b.bc.foldDust(txoSum, amount, b.fee)
+	return types.CreateTransactionWithOutputs(b.from, b.outputs, w.GetPubKey(), fee, txoSum, utxos), nil
+}
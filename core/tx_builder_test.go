@@ -0,0 +1,78 @@
+package core_test
+
+import (
+	"testing"
+
+	"github.com/danitello/go-blockchain/core"
+	"github.com/danitello/go-blockchain/core/testutil"
+	"github.com/danitello/go-blockchain/core/types"
+	"github.com/danitello/go-blockchain/wallet"
+)
+
+func TestTxBuilder(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 3)
+	addresses := ws.GetAddresses()
+	from := addresses[0]
+	const fee = 2
+
+	tx, err := bc.InitTxBuilder().
+		SelectFrom(from).
+		AddOutput(addresses[1], 3).
+		AddOutput(addresses[2], 4).
+		AddDataOutput([]byte("invoice 42")).
+		SetFee(fee).
+		BuildWithWallets(ws)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tx.Outputs) != 4 {
+		t.Fatalf("Got %d outputs, want 3 and the change", len(tx.Outputs))
+	}
+	for i, want := range []struct {
+		address string
+		amount  int
+	}{{addresses[1], 3}, {addresses[2], 4}} {
+		if txo := tx.Outputs[i]; txo.Amount != want.amount || !txo.IsLockedWithKey(wallet.GetPubKeyHashFromAddress(want.address)) {
+			t.Fatalf("Got output %d %+v, want %d to %s", i, txo, want.amount, want.address)
+		}
+	}
+	if data := tx.Outputs[2]; data.ScriptType() != types.ScriptData || string(data.Data()) != "invoice 42" {
+		t.Fatalf("Got output 2 %+v, want the data output", data)
+	}
+	if change := tx.Outputs[3]; !change.IsLockedWithKey(wallet.GetPubKeyHashFromAddress(from)) {
+		t.Fatal("Change not paid back to the from address")
+	}
+	for _, txin := range tx.Inputs {
+		if txin.Signature != nil {
+			t.Fatal("Built transaction is signed")
+		}
+	}
+
+	if err := bc.SignTransaction(tx, ws, from); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.SubmitTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	if dump := bc.Mempool.Dump(); len(dump) != 1 || dump[0].Fee != fee {
+		t.Fatalf("Got mempool %+v, want the transaction with a fee of %d", dump, fee)
+	}
+}
+
+func TestTxBuilderErrors(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 1)
+	addresses := ws.GetAddresses()
+
+	for name, b := range map[string]*core.TxBuilder{
+		"bad address":  bc.InitTxBuilder().SelectFrom(addresses[0]).AddOutput("nope", 1).AddOutput(addresses[1], 1),
+		"no from":      bc.InitTxBuilder().AddOutput(addresses[1], 1),
+		"no outputs":   bc.InitTxBuilder().SelectFrom(addresses[0]),
+		"negative fee": bc.InitTxBuilder().SelectFrom(addresses[0]).AddOutput(addresses[1], 1).SetFee(-1),
+		"overspend":    bc.InitTxBuilder().SelectFrom(addresses[0]).AddOutput(addresses[1], types.MaxSupply),
+	} {
+		if tx, err := b.BuildWithWallets(ws); err == nil {
+			t.Fatalf("%s: built %v", name, tx)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package core
+
+import (
+	"bytes"
+
+	"github.com/danitello/go-blockchain/chaindb"
+	"github.com/danitello/go-blockchain/common/errutil"
+	"github.com/danitello/go-blockchain/core/types"
+
+	"github.com/dgraph-io/badger"
+)
+
+var (
+	// txIndexPrefix prefixes the db key of a Transaction ID -> value is the hash of the Block on the chain containing
+	// it
+	txIndexPrefix = []byte("txindex-")
+
+	// txReindexKey is present in the db while a ReindexTxs is underway
+	txReindexKey = []byte("txReindexInProgress")
+)
+
+// tx_index is additional database functions for BlockChain for finding the Block containing a Transaction
+
+// indexTransactions adds the Transactions of a Block to the tx index within a db transaction
+func indexTransactions(txn *badger.Txn, block *types.Block) error {
+	for _, tx := range block.Transactions {
+		if err := txn.Set(append(txIndexPrefix, tx.ID...), block.Hash); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unindexTransactions removes the Transactions of a Block from the tx index within a db transaction
+func unindexTransactions(txn *badger.Txn, block *types.Block) error {
+	for _, tx := range block.Transactions {
+		if err := txn.Delete(append(txIndexPrefix, tx.ID...)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReindexTxs deletes the current tx index and builds a new one from the Blocks of the chain, returning the number of
+// Transactions indexed. Fails with ErrBlockPruned if the chain has been pruned -
+// progress - if not nil, called with the number of Blocks scanned so far and the total
+// A marker is kept in the db until the new index is written, so an interrupted ReindexTxs is rerun by GetBlockChain
+func (bc *BlockChain) ReindexTxs(progress func(done, total int)) (int, error) {
+	if err := bc.beginWrite(); err != nil {
+		return 0, err
+	}
+	defer bc.inFlight.Done()
+	bc.tipMu.Lock()
+	defer bc.tipMu.Unlock()
+
+	var blocks []*types.Block
+	iter := bc.iterator()
+	for {
+		block := iter.Next()
+		if len(block.Transactions) == 0 {
+			return 0, chaindb.ErrBlockPruned
+		}
+		blocks = append(blocks, block)
+
+		if progress != nil {
+			progress(len(blocks), bc.Height)
+		}
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	err := bc.ChainDB.Database.Update(func(txn *badger.Txn) error {
+		return txn.Set(txReindexKey, []byte{})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	bc.DeleteWithKeyPrefix(txIndexPrefix)
+
+	count := 0
+	for i, block := range blocks {
+		last := i == len(blocks)-1
+		err := bc.ChainDB.Database.Update(func(txn *badger.Txn) error {
+			if err := indexTransactions(txn, block); err != nil {
+				return err
+			}
+			if last {
+				return txn.Delete(txReindexKey)
+			}
+			return nil
+		})
+		if err != nil {
+			return count, err
+		}
+		count += len(block.Transactions)
+	}
+
+	return count, nil
+}
+
+// TxReindexInProgress determines whether a ReindexTxs was started but never completed
+func (bc *BlockChain) TxReindexInProgress() bool {
+	return bc.hasKey(txReindexKey)
+}
+
+// findTransaction gets a Transaction using the tx index, if it is indexed and its Block can be read
+func (bc *BlockChain) findTransaction(id []byte) (types.Transaction, bool) {
+	var blockHash []byte
+	err := bc.ChainDB.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(append(txIndexPrefix, id...))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		blockHash, err = item.Value()
+		return err
+	})
+	errutil.Handle(err)
+	if blockHash == nil {
+		return types.Transaction{}, false
+	}
+
+	block, err := bc.ChainDB.ReadBlockWithHash(blockHash)
+	if err != nil {
+		return types.Transaction{}, false
+	}
+	for _, tx := range block.Transactions {
+		if bytes.Compare(tx.ID, id) == 0 {
+			return *tx, true
+		}
+	}
+
+	return types.Transaction{}, false
+}
@@ -0,0 +1,103 @@
+package core_test
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/danitello/go-blockchain/core"
+	"github.com/danitello/go-blockchain/core/testutil"
+	"github.com/danitello/go-blockchain/core/types"
+	"github.com/danitello/go-blockchain/wallet"
+)
+
+// txIndex gets the tx index entries in the db of bc
+func txIndex(t *testing.T, bc *core.BlockChain) map[string]string {
+	t.Helper()
+	index := make(map[string]string)
+
+	for k, v := range dbSnapshot(t, bc) {
+		if strings.HasPrefix(k, "txindex-") {
+			index[k] = v
+		}
+	}
+
+	return index
+}
+
+func TestReindexTxs(t *testing.T) {
+	bc, _ := testutil.BuildTestChain(t, 5)
+	want := txIndex(t, bc)
+
+	if err := bc.InterruptTxReindex(); err != nil {
+		t.Fatal(err)
+	}
+	if len(txIndex(t, bc)) != 0 || !bc.TxReindexInProgress() {
+		t.Fatal("tx index not cleared")
+	}
+
+	done := 0
+	count, err := bc.ReindexTxs(func(d, total int) { done = d })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, tip := bc.Tip(); done != tip+1 {
+		t.Fatalf("progress reached %d Blocks, want %d", done, tip+1)
+	}
+	if count != len(want) {
+		t.Fatalf("indexed %d transactions, want %d", count, len(want))
+	}
+	if got := txIndex(t, bc); !reflect.DeepEqual(got, want) {
+		t.Fatal("rebuilt tx index differs")
+	}
+	if bc.TxReindexInProgress() {
+		t.Fatal("marker left after ReindexTxs")
+	}
+}
+
+func TestInterruptedReindexTxsResumes(t *testing.T) {
+	w, err := wallet.InitWalletFromReader(rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := string(w.GetAddress())
+	dir, err := ioutil.TempDir("", "txindexchain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := core.DefaultConfig()
+	cfg.DataDir = dir
+	cfg.Difficulty = types.TestDifficulty
+	bc := core.InitBlockChainWithConfig(address, cfg)
+
+	var ids [][]byte
+	for i := 0; i < 3; i++ {
+		_, tip := bc.Tip()
+		cbtx := types.CoinbaseTx(address, tip+1)
+		if err := bc.AddBlock([]*types.Transaction{cbtx}); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, cbtx.ID)
+	}
+	if err := bc.InterruptTxReindex(); err != nil {
+		t.Fatal(err)
+	}
+	bc.ChainDB.CloseDB()
+
+	bc = core.GetBlockChainWithConfig(cfg)
+	defer bc.ChainDB.CloseDB()
+
+	if bc.TxReindexInProgress() {
+		t.Fatal("interrupted ReindexTxs not resumed")
+	}
+	for _, id := range ids {
+		if _, err := bc.GetTransactionWithID(id); err != nil {
+			t.Fatalf("tx %x: %s", id, err)
+		}
+	}
+}
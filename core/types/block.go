@@ -2,25 +2,45 @@ package types
 
 import (
 	"bytes"
-	"crypto/sha256"
+	"context"
+	"encoding/binary"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"math"
 	"math/big"
-	"time"
+	"strings"
+	"sync"
 
 	"github.com/danitello/go-blockchain/common/byteutil"
-	"github.com/danitello/go-blockchain/common/errutil"
 	"github.com/danitello/go-blockchain/common/hexutil"
 )
 
+const (
+	// DefaultDifficulty is the number of leading zero bits a Block hash needs
+	DefaultDifficulty = 12
+	// TestDifficulty makes mining near instant, for tests that need real mined Blocks
+	TestDifficulty = 1
+)
+
+// MaxNonce bounds the Nonces tried for each extra nonce while mining. Once every Nonce below it fails, the extra nonce
+// in the coinbase tx is rolled (see rollExtraNonce), giving a new MerkleRoot to search from Nonce 0
+const MaxNonce = math.MaxInt64
+
+// ErrNonceSpaceExhausted is returned when mining a Block whose extra nonce can't be rolled, as it has no coinbase tx or
+// its coinbase data has no room left, once every Nonce has failed
+var ErrNonceSpaceExhausted = errors.New("Every nonce failed and the extra nonce cannot be rolled")
+
+// extraNonceLen is the length of the extra nonce appended to the coinbase data
+const extraNonceLen = 8
+
 // Block is a block in the blockchain with
 // Index - index of this Block in the BlockChain
 // Nonce - integer that completes hash of Block for successful signing
 // Difficulty - determines the target value to sign the Block
 // Hash - the hash of this block
 // PrevHash - the hash of the previous Block
-// TimeStamp - the time this Blocks proof
+// TimeStamp - the time mining of this Block began, committed to by its proof
 // Transactions - the transactions contained in this Block
 type Block struct {
 	Index        int
@@ -30,96 +50,328 @@ type Block struct {
 	PrevHash     []byte
 	TimeStamp    []byte
 	Transactions []*Transaction
+	size         int // cached result of Size, 0 if not yet computed
 }
 
-// InitBlock initializes a new Block
-func InitBlock(txns []*Transaction, prevHash []byte, prevIndex int) *Block {
+// InitBlock initializes a new Block mined at DefaultDifficulty with a DoubleSHA256Hasher
+func InitBlock(txns []*Transaction, prevHash []byte, prevIndex int) (*Block, error) {
+	return InitBlockWithDifficulty(txns, prevHash, prevIndex, DefaultDifficulty, DoubleSHA256Hasher{})
+}
+
+// InitBlockWithDifficulty initializes a new Block mined at a given difficulty with a given Hasher. Fails with
+// ErrNonceSpaceExhausted if no proof can be found for it
+func InitBlockWithDifficulty(txns []*Transaction, prevHash []byte, prevIndex, difficulty int, hasher Hasher) (*Block, error) {
 	newBlock := &Block{
 		Index:        prevIndex + 1,
 		Nonce:        0,
-		Difficulty:   12,
+		Difficulty:   difficulty,
 		Hash:         []byte{},
 		Transactions: txns,
 		PrevHash:     prevHash,
-		TimeStamp:    []byte(time.Now().String())}
-	newBlock.runProof()
+		TimeStamp:    NewTimeStamp()}
+	if err := newBlock.runProof(hasher, MaxNonce); err != nil {
+		return nil, err
+	}
+
+	return newBlock, nil
+}
+
+// AssembleBlock creates a Block whose Nonce was found elsewhere, e.g. by an external miner, without running the proof.
+// timeStamp must be the one the Nonce was found with, as the proof commits to it, and hasher the one it was found
+// with. The result fails ValidateProof if the Nonce doesn't complete it
+func AssembleBlock(txns []*Transaction, prevHash []byte, prevIndex, difficulty, nonce int, timeStamp []byte, hasher Hasher) *Block {
+	newBlock := &Block{
+		Index:        prevIndex + 1,
+		Nonce:        nonce,
+		Difficulty:   difficulty,
+		Transactions: txns,
+		PrevHash:     prevHash,
+		TimeStamp:    timeStamp}
+	newBlock.Hash, _ = newBlock.computeHash(hasher, false)
+
 	return newBlock
 }
 
-// runProof creates a new proof for the given Block, adding it's Hash and Nonce metadata
-func (b *Block) runProof() {
-	target := new(big.Int).Lsh(big.NewInt(1), uint(256-b.Difficulty)) // Left shift, 256 is number of bits in a hash
-	var hash [32]byte
+// runProof creates a new proof for the given Block, adding it's Hash and Nonce metadata. Once every Nonce below
+// maxNonce fails the extra nonce is rolled and the search starts over. Fails with ErrNonceSpaceExhausted, leaving the
+// Block without a Hash, if the extra nonce can't be rolled
+func (b *Block) runProof(hasher Hasher, maxNonce int) error {
+	target := ProofTarget(b.Difficulty)
+	baseData := b.coinbaseData()
+	var hash []byte
 	var bigIntHash big.Int
 
 	// Block.Nonce was initalized to 0
-	for b.Nonce < math.MaxInt64 {
-		hash, bigIntHash = b.computeHash(true)
+	for extraNonce := uint64(1); ; extraNonce++ {
+		for ; b.Nonce < maxNonce; b.Nonce++ {
+			hash, bigIntHash = b.computeHash(hasher, true)
 
-		// If the bigIntHash is less than the target, we have found the nonce
-		if bigIntHash.Cmp(target) == -1 {
-			b.Hash = hash[:]
-			b.TimeStamp = []byte(time.Now().String())
+			// If the bigIntHash is less than the target, we have found the nonce
+			if bigIntHash.Cmp(target) == -1 {
+				b.Hash = hash
+				fmt.Println()
+				fmt.Println("New block signed")
+				return nil
+			}
+		}
+
+		if !b.rollExtraNonce(baseData, extraNonce) {
 			fmt.Println()
-			break
-		} else {
-			b.Nonce++
+			return ErrNonceSpaceExhausted
 		}
 	}
-	fmt.Println("New block signed")
 }
 
-// ValidateProof confirms that a given Block has been signed correctly and thus is a valid Block in the BlockChain
-// using the Nonce that has been computed for it
-func (b *Block) ValidateProof() bool {
+// RunParallel creates a new proof for the Block like runProof, searching for the Nonce on threads goroutines that each
+// try every threads-th one, and rolling the extra nonce like runProof. The TimeStamp is set to the current time first,
+// as the Block may have been assembled a while before. Returns ctx.Err() if ctx is done first, leaving the Block
+// unsigned though possibly with a rolled extra nonce, or ErrNonceSpaceExhausted
+func (b *Block) RunParallel(ctx context.Context, threads int, hasher Hasher) error {
+	return b.runParallel(ctx, threads, hasher, MaxNonce)
+}
+
+// runParallel does the work of RunParallel, trying Nonces below maxNonce for each extra nonce
+func (b *Block) runParallel(ctx context.Context, threads int, hasher Hasher, maxNonce int) error {
+	if threads < 1 {
+		threads = 1
+	}
+	b.TimeStamp = NewTimeStamp()
+	b.size = 0
+	target := ProofTarget(b.Difficulty)
+	baseData := b.coinbaseData()
+
+	for extraNonce := uint64(1); ; extraNonce++ {
+		nonce, found := b.searchNonce(ctx, threads, target, hasher, maxNonce)
+		if found {
+			b.Nonce = nonce
+			b.Hash, _ = b.computeHash(hasher, false)
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if !b.rollExtraNonce(baseData, extraNonce) {
+			return ErrNonceSpaceExhausted
+		}
+	}
+}
+
+// searchNonce looks for a Nonce below maxNonce completing the proof of the Block on threads goroutines, giving up if
+// ctx is done
+func (b *Block) searchNonce(ctx context.Context, threads int, target *big.Int, hasher Hasher, maxNonce int) (int, bool) {
+	merkleRoot := b.getMerkleTree()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	found := make(chan int, 1)
+	var wg sync.WaitGroup
+
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func(nonce int) {
+			defer wg.Done()
+
+			for ; nonce >= 0 && nonce < maxNonce && ctx.Err() == nil; nonce += threads {
+				var bigIntHash big.Int
+				bigIntHash.SetBytes(hasher.Hash(proofData(b.PrevHash, merkleRoot, b.TimeStamp, nonce, b.Difficulty)))
+				if bigIntHash.Cmp(target) == -1 {
+					select {
+					case found <- nonce:
+						cancel() // stop the other goroutines
+					default:
+					}
+					return
+				}
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	select {
+	case nonce := <-found:
+		return nonce, true
+	default:
+		return 0, false
+	}
+}
+
+// coinbaseData gets the Data of the coinbase txin of the Block, which the extra nonce is appended to, or nil if the
+// Block has no coinbase tx
+func (b *Block) coinbaseData() []byte {
+	if len(b.Transactions) == 0 || !b.Transactions[0].IsCoinbase() {
+		return nil
+	}
+	return b.Transactions[0].Inputs[0].Data
+}
+
+// rollExtraNonce replaces the coinbase tx of the Block with one whose txin Data is baseData followed by extraNonce, and
+// resets the Nonce, so the search can start over on a new MerkleRoot. The coinbase tx is copied rather than changed, as
+// the Transactions may be shared, e.g. with a Miner's template. Returns false if the Block has no coinbase tx or the
+// Data would be longer than MaxCoinbaseDataLen
+func (b *Block) rollExtraNonce(baseData []byte, extraNonce uint64) bool {
+	if len(b.Transactions) == 0 || !b.Transactions[0].IsCoinbase() || len(baseData)+extraNonceLen > MaxCoinbaseDataLen {
+		return false
+	}
+
+	data := make([]byte, len(baseData)+extraNonceLen)
+	copy(data, baseData)
+	binary.BigEndian.PutUint64(data[len(baseData):], extraNonce)
+
+	cbtx := *b.Transactions[0]
+	cbtx.Inputs = []TxInput{cbtx.Inputs[0]}
+	cbtx.Inputs[0].Data = data
+	cbtx.ID = cbtx.Hash()
+
+	b.Transactions = append([]*Transaction{&cbtx}, b.Transactions[1:]...)
+	b.Nonce = 0
+	b.size = 0
+
+	return true
+}
+
+// ValidateProof confirms that a given Block has been signed correctly with hasher and thus is a valid Block in the
+// BlockChain using the Nonce that has been computed for it
+func (b *Block) ValidateProof(hasher Hasher) bool {
 	var bigIntHash big.Int
-	_, bigIntHash = b.computeHash(false)
+	_, bigIntHash = b.computeHash(hasher, false)
 
-	target := new(big.Int).Lsh(big.NewInt(1), uint(256-b.Difficulty))
+	return bigIntHash.Cmp(ProofTarget(b.Difficulty)) == -1
+}
 
-	return bigIntHash.Cmp(target) == -1
+// ProofTarget gets the value a Block hash must be below to have a given number of leading zero bits
+func ProofTarget(difficulty int) *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(256-difficulty)) // Left shift, 256 is number of bits in a hash
 }
 
-// computeHash calculates the Hash for the given Block
-func (b *Block) computeHash(print bool) ([32]byte, big.Int) {
+// ValidateHash confirms that the Hash of a given Block, computed with hasher, matches its contents, including the
+// MerkleTree of its Transactions
+func (b *Block) ValidateHash(hasher Hasher) bool {
+	hash, _ := b.computeHash(hasher, false)
+
+	return bytes.Compare(hash, b.Hash) == 0
+}
+
+// computeHash calculates the Hash for the given Block with hasher
+func (b *Block) computeHash(hasher Hasher, print bool) ([]byte, big.Int) {
 	var bigIntHash big.Int
 
-	hash := sha256.Sum256(b.compileProofData())
+	hash := hasher.Hash(b.compileProofData())
 	if print {
 		fmt.Printf("\rBlock Hash: %x", hash)
 	}
-	bigIntHash.SetBytes(hash[:])
+	bigIntHash.SetBytes(hash)
 
 	return hash, bigIntHash
 }
 
 // compileProofData creates the comprehensive data slice that will be hashed during the POW
 func (b *Block) compileProofData() []byte {
-	return bytes.Join([][]byte{b.PrevHash, b.getMerkleTree(), hexutil.ToHex(int64(b.Nonce)), hexutil.ToHex(int64(b.Difficulty))}, []byte{})
+	return proofData(b.PrevHash, b.getMerkleTree(), b.TimeStamp, b.Nonce, b.Difficulty)
+}
+
+// proofData joins the fields of a Block that are hashed during the POW. The TimeStamp is included so it can't be
+// changed once the Block is mined
+func proofData(prevHash, merkleRoot, timeStamp []byte, nonce, difficulty int) []byte {
+	return bytes.Join([][]byte{prevHash, merkleRoot, timeStamp, hexutil.ToHex(int64(nonce)), hexutil.ToHex(int64(difficulty))}, []byte{})
 }
 
 // getMerkleTree gets the MerkleTree representation of the Transactions in the Block and returns the root
 func (b *Block) getMerkleTree() []byte {
-	var txs [][]byte
+	tree := InitMerkleTree(b.merkleLeaves())
+
+	return tree.Root.Data
+}
 
-	// Get txs
+// TxProof gets the MerkleProof of the Transaction at index in the Block, which VerifyTxProof checks against the
+// MerkleRoot of the Block's header
+func (b *Block) TxProof(index int) ([][]byte, error) {
+	return MerkleProof(b.merkleLeaves(), index)
+}
+
+// VerifyTxProof determines whether a proof from Block.TxProof shows tx is the Transaction at index of the Block with a
+// given Merkle root, e.g. that of a header kept by a light wallet
+func VerifyTxProof(tx *Transaction, index int, proof [][]byte, merkleRoot []byte) bool {
+	return VerifyMerkleProof(byteutil.Serialize(tx), index, proof, merkleRoot)
+}
+
+// merkleLeaves gets the serialized Transactions in the Block, the leaves of its MerkleTree
+func (b *Block) merkleLeaves() [][]byte {
+	var txs [][]byte
 	for _, tx := range b.Transactions {
 		txs = append(txs, byteutil.Serialize(tx))
 	}
 
-	// Create MerkleTree
-	tree := InitMerkleTree(txs)
+	return txs
+}
 
-	return tree.Root.Data
+// Size gets the length in bytes of the serialized Block, i.e. its header plus its Transactions
+func (b *Block) Size() int {
+	if b.size == 0 {
+		header := *b
+		header.Transactions = nil
+		b.size = len(byteutil.Serialize(header))
+
+		for _, tx := range b.Transactions {
+			b.size += tx.Size()
+		}
+	}
+
+	return b.size
 }
 
-// DeserializeBlock converts a []byte into a Block for database compatibility
-func DeserializeBlock(data []byte) *Block {
+// Copy gets a copy of the Block sharing no memory with it, so either can be changed without affecting the other
+func (b *Block) Copy() *Block {
+	res := *b
+	res.Hash = copyBytes(b.Hash)
+	res.PrevHash = copyBytes(b.PrevHash)
+	res.TimeStamp = copyBytes(b.TimeStamp)
+
+	if b.Transactions != nil {
+		res.Transactions = make([]*Transaction, len(b.Transactions))
+		for i, tx := range b.Transactions {
+			res.Transactions[i] = tx.Copy()
+		}
+	}
+
+	return &res
+}
+
+// String creates a string containing information to display about the Block and its Transactions
+func (b Block) String() string {
+	var lines []string
+
+	lines = append(lines, fmt.Sprintf("Block %d", b.Index))
+	lines = append(lines, "----------")
+	lines = append(lines, fmt.Sprintf("Hash:       %s", shortHex(b.Hash)))
+	lines = append(lines, fmt.Sprintf("PrevHash:   %s", shortHex(b.PrevHash)))
+	lines = append(lines, fmt.Sprintf("Nonce:      %d", b.Nonce))
+	lines = append(lines, fmt.Sprintf("Difficulty: %d", b.Difficulty))
+	lines = append(lines, fmt.Sprintf("Mined Date: %s", b.TimeStamp))
+	for _, tx := range b.Transactions {
+		lines = append(lines, tx.String())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// DeserializeBlock converts a []byte into a Block for database compatibility.
+// Malformed data (e.g. from a peer) results in an error, never a panic
+func DeserializeBlock(data []byte) (resBlock *Block, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			resBlock, err = nil, fmt.Errorf("Malformed block data: %v", r)
+		}
+	}()
+
 	var block Block
 
 	decoder := gob.NewDecoder(bytes.NewReader(data))
-	err := decoder.Decode(&block)
-	errutil.Handle(err)
+	if err := decoder.Decode(&block); err != nil {
+		return nil, fmt.Errorf("Malformed block data: %s", err)
+	}
 
-	return &block
+	return &block, nil
 }
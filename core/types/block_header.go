@@ -0,0 +1,136 @@
+package types
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// MaxFutureBlockTime is how far past the current time a BlockHeader's TimeStamp may be
+const MaxFutureBlockTime = 2 * time.Hour
+
+// timeStampLayout is the format of a TimeStamp, i.e. that of time.Time's String
+const timeStampLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+var (
+	// ErrBadProof is returned by ValidateBlockHeader for a BlockHeader whose Nonce does not complete the proof, or
+	// whose Hash does not match
+	ErrBadProof = errors.New("Block header has an invalid proof")
+	// ErrBadLink is returned by ValidateBlockHeader for a BlockHeader that does not follow the previous one
+	ErrBadLink = errors.New("Block header does not follow the previous block")
+	// ErrBadTimeStamp is returned by ValidateBlockHeader for a BlockHeader whose TimeStamp is malformed, before that of
+	// the previous one, or more than MaxFutureBlockTime ahead
+	ErrBadTimeStamp = errors.New("Block header has an invalid timestamp")
+)
+
+// BlockHeader is everything about a Block except its Transactions, which are summarized by MerkleRoot -
+// MerkleRoot - the root of the MerkleTree of the Block's Transactions
+// all other fields match those of the Block
+type BlockHeader struct {
+	Index      int
+	Nonce      int
+	Difficulty int
+	Hash       []byte
+	PrevHash   []byte
+	MerkleRoot []byte
+	TimeStamp  []byte
+}
+
+// Header gets the BlockHeader of the Block
+func (b *Block) Header() *BlockHeader {
+	return &BlockHeader{
+		Index:      b.Index,
+		Nonce:      b.Nonce,
+		Difficulty: b.Difficulty,
+		Hash:       b.Hash,
+		PrevHash:   b.PrevHash,
+		MerkleRoot: b.getMerkleTree(),
+		TimeStamp:  b.TimeStamp}
+}
+
+// ValidateProof confirms that the Nonce of the BlockHeader completes the proof for its MerkleRoot with hasher, so a
+// miner can check a Nonce without the Transactions
+func (h *BlockHeader) ValidateProof(hasher Hasher) bool {
+	var bigIntHash big.Int
+	bigIntHash.SetBytes(h.computeHash(hasher))
+
+	return bigIntHash.Cmp(ProofTarget(h.Difficulty)) == -1
+}
+
+// ValidateHash confirms that the Hash of the BlockHeader, computed with hasher, matches its other fields
+func (h *BlockHeader) ValidateHash(hasher Hasher) bool {
+	return bytes.Compare(h.computeHash(hasher), h.Hash) == 0
+}
+
+// computeHash calculates the Hash of the Block the BlockHeader belongs to with hasher
+func (h *BlockHeader) computeHash(hasher Hasher) []byte {
+	return hasher.Hash(proofData(h.PrevHash, h.MerkleRoot, h.TimeStamp, h.Nonce, h.Difficulty))
+}
+
+// ValidateBlockHeader checks everything about a Block that its BlockHeader commits to, without the Transactions -
+// the proof against Difficulty, the Hash, linkage to and Index following prev, and the TimeStamp.
+// prev is the BlockHeader of the previous Block, or nil for a genesis Block, and hasher the Hasher of the chain
+func ValidateBlockHeader(h *BlockHeader, prev *BlockHeader, hasher Hasher) error {
+	if !h.ValidateProof(hasher) || bytes.Compare(h.computeHash(hasher), h.Hash) != 0 {
+		return ErrBadProof
+	}
+
+	if prev == nil {
+		if h.Index != 0 || len(h.PrevHash) != 0 {
+			return ErrBadLink
+		}
+	} else if h.Index != prev.Index+1 || bytes.Compare(h.PrevHash, prev.Hash) != 0 {
+		return ErrBadLink
+	}
+
+	t, err := h.Time()
+	if err != nil || t.After(time.Now().Add(MaxFutureBlockTime)) {
+		return ErrBadTimeStamp
+	}
+	if prev != nil {
+		prevTime, err := prev.Time()
+		if err == nil && t.Before(prevTime) {
+			return ErrBadTimeStamp
+		}
+	}
+
+	return nil
+}
+
+// NewTimeStamp gets a TimeStamp of the current time
+func NewTimeStamp() []byte {
+	return []byte(time.Now().String())
+}
+
+// Time parses the TimeStamp of the BlockHeader
+func (h *BlockHeader) Time() (time.Time, error) {
+	stamp := string(h.TimeStamp)
+	if i := strings.Index(stamp, " m="); i >= 0 {
+		stamp = stamp[:i] // monotonic clock reading
+	}
+
+	return time.Parse(timeStampLayout, stamp)
+}
+
+// DeserializeBlockHeader converts a []byte into a BlockHeader for database compatibility.
+// Malformed data (e.g. from a peer) results in an error, never a panic
+func DeserializeBlockHeader(data []byte) (resHeader *BlockHeader, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			resHeader, err = nil, fmt.Errorf("Malformed block header data: %v", r)
+		}
+	}()
+
+	var header BlockHeader
+
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&header); err != nil {
+		return nil, fmt.Errorf("Malformed block header data: %s", err)
+	}
+
+	return &header, nil
+}
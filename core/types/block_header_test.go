@@ -0,0 +1,126 @@
+package types
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/danitello/go-blockchain/common/byteutil"
+	"github.com/danitello/go-blockchain/wallet"
+)
+
+// testAddress makes the address of a Wallet that is the same on every run
+func testAddress(t testing.TB) string {
+	t.Helper()
+
+	w, err := wallet.InitWalletFromReader(rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(w.GetAddress())
+}
+
+// mineTestBlock mines a Block at TestDifficulty on prev, or a genesis Block if prev is nil
+func mineTestBlock(t *testing.T, prev *Block) *Block {
+	t.Helper()
+
+	prevHash, prevIndex := []byte{}, -1
+	if prev != nil {
+		prevHash, prevIndex = prev.Hash, prev.Index
+	}
+	cbtx, err := CoinbaseTxWithData(testAddress(t), prevIndex+1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := InitBlockWithDifficulty([]*Transaction{cbtx}, prevHash, prevIndex, TestDifficulty, DoubleSHA256Hasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return block
+}
+
+func TestValidateBlockHeader(t *testing.T) {
+	genesis := mineTestBlock(t, nil)
+	block := mineTestBlock(t, genesis)
+
+	if err := ValidateBlockHeader(genesis.Header(), nil, DoubleSHA256Hasher{}); err != nil {
+		t.Fatalf("genesis: %s", err)
+	}
+	if err := ValidateBlockHeader(block.Header(), genesis.Header(), DoubleSHA256Hasher{}); err != nil {
+		t.Fatalf("block: %s", err)
+	}
+	if err := ValidateBlockHeader(block.Header(), block.Header(), DoubleSHA256Hasher{}); err != ErrBadLink {
+		t.Errorf("unlinked header: got %v, want ErrBadLink", err)
+	}
+}
+
+func TestValidateBlockHeaderBadNonce(t *testing.T) {
+	genesis := mineTestBlock(t, nil)
+	header := mineTestBlock(t, genesis).Header()
+
+	// Find a Nonce that fails the proof. A BlockHeader has no Transactions, so this is all that can be checked
+	for header.ValidateProof(DoubleSHA256Hasher{}) {
+		header.Nonce++
+	}
+	if err := ValidateBlockHeader(header, genesis.Header(), DoubleSHA256Hasher{}); err != ErrBadProof {
+		t.Fatalf("got %v, want ErrBadProof", err)
+	}
+}
+
+func TestValidateBlockHeaderTimeStampCommitted(t *testing.T) {
+	genesis := mineTestBlock(t, nil)
+	header := mineTestBlock(t, genesis).Header()
+
+	// Moving the TimeStamp, however slightly, must break the proof rather than pass as a different time
+	header.TimeStamp = []byte(time.Now().Add(time.Second).String())
+	if err := ValidateBlockHeader(header, genesis.Header(), DoubleSHA256Hasher{}); err != ErrBadProof {
+		t.Fatalf("got %v, want ErrBadProof", err)
+	}
+}
+
+func TestValidateBlockHeaderFutureTimeStamp(t *testing.T) {
+	genesis := mineTestBlock(t, nil)
+
+	cbtx, err := CoinbaseTxWithData(testAddress(t), 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := &Block{
+		Index:        1,
+		Difficulty:   TestDifficulty,
+		PrevHash:     genesis.Hash,
+		TimeStamp:    []byte(time.Now().Add(2 * MaxFutureBlockTime).String()),
+		Transactions: []*Transaction{cbtx}}
+	for !block.ValidateProof(DoubleSHA256Hasher{}) {
+		block.Nonce++
+	}
+	block.Hash, _ = block.computeHash(DoubleSHA256Hasher{}, false)
+
+	if err := ValidateBlockHeader(block.Header(), genesis.Header(), DoubleSHA256Hasher{}); err != ErrBadTimeStamp {
+		t.Fatalf("got %v, want ErrBadTimeStamp", err)
+	}
+}
+
+func TestDeserializeBlockHeader(t *testing.T) {
+	header := mineTestBlock(t, nil).Header()
+	data := byteutil.Serialize(header)
+
+	got, err := DeserializeBlockHeader(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Index != header.Index || got.Nonce != header.Nonce || bytes.Compare(got.Hash, header.Hash) != 0 ||
+		bytes.Compare(got.MerkleRoot, header.MerkleRoot) != 0 || bytes.Compare(got.TimeStamp, header.TimeStamp) != 0 {
+		t.Fatalf("got %+v, want %+v", got, header)
+	}
+
+	for _, bad := range [][]byte{nil, {0xff}, data[:len(data)/2]} {
+		if _, err := DeserializeBlockHeader(bad); err == nil {
+			t.Errorf("%x: malformed data was decoded", bad)
+		}
+	}
+}
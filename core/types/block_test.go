@@ -0,0 +1,118 @@
+package types
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/danitello/go-blockchain/common/byteutil"
+)
+
+// smallNonceSpace is few enough Nonces that every one fails for most extra nonces at difficulty 8
+const smallNonceSpace = 4
+
+func TestRunProofRollsExtraNonce(t *testing.T) {
+	cbtx, err := CoinbaseTxWithData(testAddress(t), 0, []byte("pool"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := &Block{Difficulty: 8, PrevHash: []byte{}, TimeStamp: NewTimeStamp(), Transactions: []*Transaction{cbtx}}
+
+	if err := block.runProof(DoubleSHA256Hasher{}, smallNonceSpace); err != nil {
+		t.Fatal(err)
+	}
+	if block.Nonce >= smallNonceSpace || !block.ValidateProof(DoubleSHA256Hasher{}) || !block.ValidateHash(DoubleSHA256Hasher{}) {
+		t.Fatal("block was not signed within the nonce space")
+	}
+
+	// The Block's own coinbase tx carries the extra nonce after the original data, leaving the one given alone
+	data := block.Transactions[0].Inputs[0].Data
+	if len(data) != len("pool")+extraNonceLen || !bytes.HasPrefix(data, []byte("pool")) {
+		t.Fatalf("coinbase data %x does not carry an extra nonce", data)
+	}
+	if bytes.Compare(cbtx.Inputs[0].Data, []byte("pool")) != 0 {
+		t.Fatal("the original coinbase tx was changed")
+	}
+}
+
+func TestRunParallelRollsExtraNonce(t *testing.T) {
+	cbtx, err := CoinbaseTxWithData(testAddress(t), 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := &Block{Difficulty: 8, PrevHash: []byte{}, Transactions: []*Transaction{cbtx}}
+
+	if err := block.runParallel(context.Background(), 2, DoubleSHA256Hasher{}, smallNonceSpace); err != nil {
+		t.Fatal(err)
+	}
+	if !block.ValidateProof(DoubleSHA256Hasher{}) || !block.ValidateHash(DoubleSHA256Hasher{}) {
+		t.Fatal("block was not signed")
+	}
+}
+
+func TestRunProofExhausted(t *testing.T) {
+	// Without a coinbase tx there is no extra nonce to roll
+	block := &Block{Difficulty: 255, PrevHash: []byte{}, TimeStamp: NewTimeStamp()}
+
+	if err := block.runProof(DoubleSHA256Hasher{}, smallNonceSpace); err != ErrNonceSpaceExhausted {
+		t.Fatalf("got %v, want ErrNonceSpaceExhausted", err)
+	}
+	if len(block.Hash) != 0 {
+		t.Fatal("an unsigned block was given a hash")
+	}
+	if err := block.runParallel(context.Background(), 2, DoubleSHA256Hasher{}, smallNonceSpace); err != ErrNonceSpaceExhausted {
+		t.Fatalf("parallel: got %v, want ErrNonceSpaceExhausted", err)
+	}
+}
+
+func FuzzDeserializeBlock(f *testing.F) {
+	cbtx, err := CoinbaseTxWithData(testAddress(f), 0, nil)
+	if err != nil {
+		f.Fatal(err)
+	}
+	valid := byteutil.Serialize(&Block{Difficulty: 1, PrevHash: []byte{}, TimeStamp: NewTimeStamp(), Transactions: []*Transaction{cbtx}})
+	f.Add(valid)
+	f.Add(valid[:len(valid)/2])
+	f.Add([]byte{})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		block, err := DeserializeBlock(data)
+		if (block == nil) == (err == nil) {
+			t.Fatalf("got block %v and error %v, want exactly one", block, err)
+		}
+	})
+}
+
+func TestDeserializeBlockMalformed(t *testing.T) {
+	for _, data := range [][]byte{nil, {0x00}, {0x7f, 0xff, 0x81, 0x03, 0x01, 0x01}} {
+		if _, err := DeserializeBlock(data); err == nil {
+			t.Errorf("%x: malformed data was decoded", data)
+		}
+	}
+}
+
+func TestBlockCopy(t *testing.T) {
+	block := mineTestBlock(t, nil)
+	want := byteutil.Serialize(block)
+
+	cp := block.Copy()
+	if bytes.Compare(byteutil.Serialize(cp), want) != 0 {
+		t.Fatal("copy differs from the Block")
+	}
+
+	// Changing every part of the copy leaves the Block alone
+	cp.Hash[0] ^= 0xff
+	cp.PrevHash = append(cp.PrevHash, 1)
+	cp.TimeStamp[0] ^= 0xff
+	cp.Transactions[0].ID[0] ^= 0xff
+	cp.Transactions[0].Inputs[0].OutputIdx++
+	cp.Transactions[0].Inputs[0].Data = append(cp.Transactions[0].Inputs[0].Data, 1)
+	cp.Transactions[0].Outputs[0].PubKeyHash[0] ^= 0xff
+	cp.Transactions[0].Outputs[0].Amount++
+	cp.Transactions = append(cp.Transactions, cp.Transactions[0])
+
+	if bytes.Compare(byteutil.Serialize(block), want) != 0 {
+		t.Fatal("changing the copy changed the Block")
+	}
+}
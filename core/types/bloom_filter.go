@@ -0,0 +1,154 @@
+package types
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/danitello/go-blockchain/crypto"
+	"github.com/danitello/go-blockchain/wallet"
+)
+
+// BloomFilter is a probabilistic set a light wallet gives a node so it is only sent the Transactions relevant to it,
+// along with their Merkle proofs, see FilterBlock. Matches never misses data that was added, but may match data that
+// wasn't (false positives) -
+// Bits - the bit array
+// HashFuncs - number of bits set per item
+type BloomFilter struct {
+	Bits      []byte
+	HashFuncs int
+}
+
+// InitBloomFilter creates a BloomFilter sized to hold a given number of items with a given false positive rate
+func InitBloomFilter(items int, fpRate float64) *BloomFilter {
+	if items < 1 {
+		items = 1
+	}
+	if fpRate <= 0 || fpRate >= 1 {
+		fpRate = 0.0001
+	}
+
+	// Optimal bit count and hash func count for the item count and false positive rate
+	numBits := math.Ceil(-float64(items) * math.Log(fpRate) / (math.Ln2 * math.Ln2))
+	hashFuncs := int(math.Max(1, math.Round(numBits/float64(items)*math.Ln2)))
+
+	return &BloomFilter{make([]byte, int(math.Ceil(numBits/8))), hashFuncs}
+}
+
+// Add puts data into the BloomFilter. A BloomFilter without Bits can't hold anything, so is left as it is
+func (bf *BloomFilter) Add(data []byte) {
+	if len(bf.Bits) == 0 {
+		return
+	}
+
+	for _, bit := range bf.bitIndices(data) {
+		bf.Bits[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+// Matches determines whether data may have been added to the BloomFilter
+func (bf *BloomFilter) Matches(data []byte) bool {
+	if len(bf.Bits) == 0 {
+		return false
+	}
+
+	for _, bit := range bf.bitIndices(data) {
+		if bf.Bits[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchesTransaction determines whether a Transaction may be relevant to the owner of the BloomFilter, i.e. the
+// filter matches its ID, the pub key hash of one of its txos, or the pub key hash of one of its txins
+func (bf *BloomFilter) MatchesTransaction(tx *Transaction) bool {
+	if bf.Matches(tx.ID) {
+		return true
+	}
+	for _, txo := range tx.Outputs {
+		if bf.Matches(txo.PubKeyHash) {
+			return true
+		}
+	}
+	if !tx.IsCoinbase() {
+		for _, txin := range tx.Inputs {
+			if bf.Matches(wallet.HashPubKey(txin.PubKey)) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// FilterTransactions gets the Transactions that match a BloomFilter, keeping their order
+func FilterTransactions(txs []*Transaction, bf *BloomFilter) []*Transaction {
+	var res []*Transaction
+	for _, tx := range txs {
+		if bf.MatchesTransaction(tx) {
+			res = append(res, tx)
+		}
+	}
+
+	return res
+}
+
+// FilteredBlock is a Block as sent to a light wallet: its header, and only the Transactions matching the wallet's
+// BloomFilter, each with the proof that it is in the Block
+type FilteredBlock struct {
+	Header  *BlockHeader
+	Matches []MatchedTx
+}
+
+// MatchedTx is a Transaction of a FilteredBlock -
+// Index - the position of the Transaction in the Block
+// Proof - its Block.TxProof
+type MatchedTx struct {
+	Tx    *Transaction
+	Index int
+	Proof [][]byte
+}
+
+// FilterBlock makes the FilteredBlock of a Block for a BloomFilter
+func FilterBlock(b *Block, bf *BloomFilter) (*FilteredBlock, error) {
+	fb := &FilteredBlock{Header: b.Header()}
+	for i, tx := range b.Transactions {
+		if !bf.MatchesTransaction(tx) {
+			continue
+		}
+
+		proof, err := b.TxProof(i)
+		if err != nil {
+			return nil, err
+		}
+		fb.Matches = append(fb.Matches, MatchedTx{tx, i, proof})
+	}
+
+	return fb, nil
+}
+
+// Verify determines whether every Transaction of the FilteredBlock is proven to be in the Block of its header
+func (fb *FilteredBlock) Verify() bool {
+	for _, m := range fb.Matches {
+		if !VerifyTxProof(m.Tx, m.Index, m.Proof, fb.Header.MerkleRoot) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// bitIndices gets the bits data maps to, using double hashing of its sha256 hash
+func (bf *BloomFilter) bitIndices(data []byte) []uint {
+	hash := crypto.Hash256(data)
+	h1 := binary.BigEndian.Uint64(hash[0:8])
+	h2 := binary.BigEndian.Uint64(hash[8:16])
+	numBits := uint64(len(bf.Bits) * 8)
+
+	indices := make([]uint, bf.HashFuncs)
+	for i := range indices {
+		indices[i] = uint((h1 + uint64(i)*h2) % numBits)
+	}
+
+	return indices
+}
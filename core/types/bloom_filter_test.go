@@ -0,0 +1,77 @@
+package types
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/danitello/go-blockchain/wallet"
+)
+
+func TestBloomFilterFalsePositiveRate(t *testing.T) {
+	const items, fpRate = 100, 0.01
+	bf := InitBloomFilter(items, fpRate)
+	for i := 0; i < items; i++ {
+		bf.Add([]byte(fmt.Sprintf("added %d", i)))
+	}
+	for i := 0; i < items; i++ {
+		if !bf.Matches([]byte(fmt.Sprintf("added %d", i))) {
+			t.Fatalf("Item %d missed", i)
+		}
+	}
+
+	const tries = 10000
+	falsePositives := 0
+	for i := 0; i < tries; i++ {
+		if bf.Matches([]byte(fmt.Sprintf("not added %d", i))) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / tries; rate > 3*fpRate {
+		t.Fatalf("False positive rate %v, want about %v", rate, fpRate)
+	}
+
+	if (&BloomFilter{}).Matches([]byte("added 0")) {
+		t.Fatal("Empty filter matched")
+	}
+}
+
+func TestFilterTransactions(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	var addresses []string
+	for i := 0; i < 3; i++ {
+		w, err := wallet.InitWalletFromReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addresses = append(addresses, string(w.GetAddress()))
+	}
+
+	bf := InitBloomFilter(1, 0.0001)
+	bf.Add(wallet.GetPubKeyHashFromAddress(addresses[0]))
+
+	toWatched := CoinbaseTx(addresses[0], 1)
+	toOther := CoinbaseTx(addresses[1], 1)
+	// signedSpend is signed by the first Wallet from seed 1, so by addresses[0], and now pays another address
+	spend, _ := signedSpend(t)
+	spend.Outputs = []TxOutput{*InitTxOutput(1, addresses[2])}
+
+	got := FilterTransactions([]*Transaction{toOther, toWatched, spend}, bf)
+	want := []*Transaction{toWatched, spend}
+	if len(got) != len(want) {
+		t.Fatalf("Got %d transactions, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Transaction %d is not the one wanted", i)
+		}
+	}
+}
+
+func TestBloomFilterEmpty(t *testing.T) {
+	bf := &BloomFilter{HashFuncs: 3}
+	bf.Add([]byte("data")) // no bits to set
+	if bf.Matches([]byte("data")) {
+		t.Fatal("Got a match without bits")
+	}
+}
@@ -0,0 +1,40 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// dataMarker starts the PubKeyHash of a ScriptData txo (the byte of Bitcoin's OP_RETURN)
+	dataMarker = byte(0x6a)
+	// MaxDataLen is the most bytes a ScriptData txo may carry
+	MaxDataLen = 80
+)
+
+// ErrDataTooLong is returned by SanityCheck for a Transaction with a ScriptData txo carrying more than MaxDataLen bytes
+var ErrDataTooLong = errors.New("Transaction has a data output that is too long")
+
+// InitDataOutput creates a txo carrying data, e.g. a commitment to a document. It holds no amount and can never be
+// spent, so it is exempt from the dust threshold
+func InitDataOutput(data []byte) (*TxOutput, error) {
+	if len(data) > MaxDataLen {
+		return nil, fmt.Errorf("Data must be at most %d bytes, not %d", MaxDataLen, len(data))
+	}
+
+	return &TxOutput{PubKeyHash: append([]byte{dataMarker}, data...)}, nil
+}
+
+// Data gets the data carried by a ScriptData txo, nil for any other
+func (txo *TxOutput) Data() []byte {
+	if txo.ScriptType() != ScriptData {
+		return nil
+	}
+	return append([]byte{}, txo.PubKeyHash[1:]...)
+}
+
+// isData determines whether the txo is a ScriptData txo. A txo of any amount could have a PubKeyHash starting with
+// dataMarker, so only one holding nothing counts
+func (txo *TxOutput) isData() bool {
+	return txo.Amount == 0 && len(txo.HashLock) == 0 && len(txo.PubKeyHash) > 0 && txo.PubKeyHash[0] == dataMarker
+}
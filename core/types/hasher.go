@@ -0,0 +1,42 @@
+package types
+
+import (
+	"fmt"
+
+	"github.com/danitello/go-blockchain/crypto"
+)
+
+// Hasher computes the hash of a Block's proof data during the POW. Hash must return 32 bytes, as targets assume
+// a 256 bit hash. Blocks mined with one Hasher fail validation with any other, so every node of a network must use
+// the same one
+type Hasher interface {
+	Hash(data []byte) []byte
+}
+
+// SHA256Hasher hashes with a single sha256
+type SHA256Hasher struct{}
+
+// Hash computes the sha256 hash of data
+func (SHA256Hasher) Hash(data []byte) []byte {
+	return crypto.Hash256(data)
+}
+
+// DoubleSHA256Hasher hashes with sha256 twice, the Hasher Blocks are mined with unless a chain is configured otherwise
+type DoubleSHA256Hasher struct{}
+
+// Hash computes the sha256 hash of the sha256 hash of data
+func (DoubleSHA256Hasher) Hash(data []byte) []byte {
+	return crypto.DoubleHash256(data)
+}
+
+// HasherByName gets the Hasher with a given name - "sha256d" (DoubleSHA256Hasher) or "sha256" (SHA256Hasher)
+func HasherByName(name string) (Hasher, error) {
+	switch name {
+	case "sha256d":
+		return DoubleSHA256Hasher{}, nil
+	case "sha256":
+		return SHA256Hasher{}, nil
+	}
+
+	return nil, fmt.Errorf("Unknown hasher %s", name)
+}
@@ -0,0 +1,24 @@
+package types
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/danitello/go-blockchain/crypto"
+)
+
+func TestHasherByName(t *testing.T) {
+	data := []byte("proof data")
+
+	sha256d, err := HasherByName("sha256d")
+	if err != nil || bytes.Compare(sha256d.Hash(data), crypto.Hash256(crypto.Hash256(data))) != 0 {
+		t.Errorf("sha256d: got %v", err)
+	}
+	sha256, err := HasherByName("sha256")
+	if err != nil || bytes.Compare(sha256.Hash(data), crypto.Hash256(data)) != 0 {
+		t.Errorf("sha256: got %v", err)
+	}
+	if _, err := HasherByName("scrypt"); err == nil {
+		t.Error("unknown hasher was found")
+	}
+}
@@ -0,0 +1,46 @@
+package types
+
+import (
+	"bytes"
+
+	"github.com/danitello/go-blockchain/crypto"
+	"github.com/danitello/go-blockchain/wallet"
+)
+
+// hashLockLen is the length of the HashLock of a ScriptHTLC txo (a sha256 hash)
+const hashLockLen = 32
+
+// InitHTLCOutput creates a hash time locked txo, for e.g. atomic swaps. It can be claimed by the key of to with a txin
+// revealing the Preimage whose sha256 hash is hashLock, or refunded to the key of refund by a Transaction with a
+// LockTime of at least timeout, i.e. once the chain reaches that height. A claim stays possible until the txo is spent
+func InitHTLCOutput(amount int, to, refund string, hashLock []byte, timeout int) *TxOutput {
+	txo := InitTxOutput(amount, to)
+	txo.HashLock = hashLock
+	txo.Timeout = timeout
+	txo.RefundPubKeyHash = wallet.GetPubKeyHashFromAddress(refund)
+
+	return txo
+}
+
+// HashSecret computes the HashLock for a secret to be revealed as the Preimage of a ScriptHTLC claim
+func HashSecret(secret []byte) []byte {
+	return crypto.Hash256(secret)
+}
+
+// isValidHTLC determines whether the fields of a ScriptHTLC txo are well formed
+func (txo *TxOutput) isValidHTLC() bool {
+	return len(txo.HashLock) == hashLockLen && txo.Timeout > 0 &&
+		len(txo.PubKeyHash) == PubKeyHashLen && len(txo.RefundPubKeyHash) == PubKeyHashLen
+}
+
+// canClaim determines whether a txin takes the claim path of a ScriptHTLC txo - revealing the Preimage with the key
+// of the PubKeyHash
+func (txo *TxOutput) canClaim(txin TxInput) bool {
+	return txin.Preimage != nil && bytes.Compare(HashSecret(txin.Preimage), txo.HashLock) == 0 && txin.UsesKey(txo.PubKeyHash)
+}
+
+// canRefund determines whether a txin of a Transaction with a given LockTime takes the refund path of a ScriptHTLC
+// txo - the Timeout has passed and it uses the key of the RefundPubKeyHash
+func (txo *TxOutput) canRefund(txin TxInput, lockTime int) bool {
+	return lockTime >= txo.Timeout && txin.UsesKey(txo.RefundPubKeyHash)
+}
@@ -0,0 +1,71 @@
+package types
+
+import (
+	"encoding/hex"
+	"math/rand"
+	"testing"
+
+	"github.com/danitello/go-blockchain/wallet"
+)
+
+func TestHTLC(t *testing.T) {
+	var ws [2]*wallet.Wallet
+	for i := range ws {
+		w, err := wallet.InitWalletFromReader(rand.New(rand.NewSource(int64(i + 1))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ws[i] = w
+	}
+	claimer, refunder := ws[0], ws[1]
+	secret := []byte("secret")
+	const timeout = 100
+
+	prev := CoinbaseTx(string(refunder.GetAddress()), 0)
+	prev.SetOutputs([]TxOutput{*InitHTLCOutput(Reward, string(claimer.GetAddress()), string(refunder.GetAddress()), HashSecret(secret), timeout)})
+	prevID := hex.EncodeToString(prev.ID)
+	prevTxs := map[string]Transaction{prevID: *prev}
+	if err := prev.SanityCheck(); err != nil {
+		t.Fatal(err)
+	}
+
+	// spend makes a Transaction spending the HTLC txo signed by w, with a LockTime and the Preimage revealed
+	spend := func(w *wallet.Wallet, lockTime int, preimage []byte) *Transaction {
+		address := string(w.GetAddress())
+		tx := CreateTransaction(address, address, w.GetPubKey(), Reward, Reward, map[string][]int{prevID: {0}})
+		tx.SetLockTime(lockTime)
+		tx.Sign(w.PrivateKey, prevTxs, SigHashAll)
+		tx.Inputs[0].Preimage = preimage
+		return tx
+	}
+
+	for _, test := range []struct {
+		name     string
+		w        *wallet.Wallet
+		lockTime int
+		preimage []byte
+		want     bool
+	}{
+		{"claim", claimer, 0, secret, true},
+		{"claim with the wrong preimage", claimer, 0, []byte("guess"), false},
+		{"claim without a preimage", claimer, timeout, nil, false},
+		{"claim by the refunder", refunder, 0, secret, false},
+		{"refund", refunder, timeout, nil, true},
+		{"refund before the timeout", refunder, timeout - 1, nil, false},
+		{"refund by the claimer", claimer, timeout, nil, false},
+	} {
+		tx := spend(test.w, test.lockTime, test.preimage)
+		if got := tx.Verify(prevTxs); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestHTLCSanityCheck(t *testing.T) {
+	tx := CoinbaseTx(testAddress(t), 0)
+	tx.SetOutputs([]TxOutput{*InitHTLCOutput(Reward, testAddress(t), testAddress(t), []byte("short"), 100)})
+
+	if err := tx.SanityCheck(); err != ErrBadHTLC {
+		t.Fatalf("Got %v for a short hash lock, want ErrBadHTLC", err)
+	}
+}
@@ -1,6 +1,6 @@
 package types
 
-import "crypto/sha256"
+import "github.com/danitello/go-blockchain/crypto"
 
 // MerkleNode represents one node in a MerkleTree
 type MerkleNode struct {
@@ -14,12 +14,12 @@ func InitMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
 	node := MerkleNode{}
 
 	if left == nil && right == nil {
-		hash := sha256.Sum256(data)
-		node.Data = hash[:]
+		node.Data = crypto.Hash256(data)
 	} else {
-		prevHashes := append(left.Data, right.Data...)
-		hash := sha256.Sum256(prevHashes)
-		node.Data = hash[:]
+		// Copied, as appending to left.Data could write into the spare capacity of its array
+		prevHashes := make([]byte, 0, len(left.Data)+len(right.Data))
+		prevHashes = append(append(prevHashes, left.Data...), right.Data...)
+		node.Data = crypto.Hash256(prevHashes)
 	}
 
 	node.Left = left
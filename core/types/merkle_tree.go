@@ -1,35 +1,106 @@
 package types
 
+import (
+	"bytes"
+	"errors"
+
+	"github.com/danitello/go-blockchain/crypto"
+)
+
+// ErrLeafNotFound is returned by MerkleProof for an index that isn't one of the leaves
+var ErrLeafNotFound = errors.New("Merkle tree has no leaf at that index")
+
 // MerkleTree holds the root node of the representation
 type MerkleTree struct {
 	Root *MerkleNode
 }
 
-// InitMerkleTree creates an instance of a MerkleTree
+// InitMerkleTree creates an instance of a MerkleTree. Each level with an odd number of nodes has its last node paired
+// with itself, so any number of leaves makes a tree. That includes a single leaf, as it always has been, so the root
+// of a Block holding only a coinbase tx is unchanged. No data makes a single leaf of no data
 func InitMerkleTree(data [][]byte) *MerkleTree {
-	var nodes []MerkleNode
-
-	if len(data)%2 != 0 {
-		data = append(data, data[len(data)-1])
-	}
+	var nodes []*MerkleNode
 
 	// Create nodes for each tx
 	for _, hash := range data {
-		node := InitMerkleNode(nil, nil, hash)
-		nodes = append(nodes, *node)
+		nodes = append(nodes, InitMerkleNode(nil, nil, hash))
+	}
+	if len(nodes) == 0 {
+		nodes = append(nodes, InitMerkleNode(nil, nil, nil))
+	}
+	if len(nodes)%2 != 0 {
+		nodes = append(nodes, nodes[len(nodes)-1])
 	}
 
-	// Create tree structure
-	for i := 0; i < len(data)/2; i++ {
-		var level []MerkleNode
+	// Create tree structure, a level at a time until only the root is left
+	for len(nodes) > 1 {
+		if len(nodes)%2 != 0 {
+			nodes = append(nodes, nodes[len(nodes)-1])
+		}
 
+		var level []*MerkleNode
 		for j := 0; j < len(nodes); j += 2 {
-			node := InitMerkleNode(&nodes[j], &nodes[j+1], nil)
-			level = append(level, *node)
+			level = append(level, InitMerkleNode(nodes[j], nodes[j+1], nil))
 		}
 
 		nodes = level
 	}
 
-	return &MerkleTree{&nodes[0]}
+	return &MerkleTree{nodes[0]}
+}
+
+// MerkleProof gets the hashes paired with the leaf at index on its way up to the root of the MerkleTree of data,
+// lowest first, so anyone with the root can check the leaf is in the tree with VerifyMerkleProof
+func MerkleProof(data [][]byte, index int) ([][]byte, error) {
+	if index < 0 || index >= len(data) {
+		return nil, ErrLeafNotFound
+	}
+
+	var level [][]byte
+	for _, d := range data {
+		level = append(level, crypto.Hash256(d))
+	}
+
+	// Each level is padded as by InitMerkleTree, including a single leaf
+	var proof [][]byte
+	for first := true; first || len(level) > 1; first = false {
+		if len(level)%2 != 0 {
+			level = append(level, level[len(level)-1])
+		}
+		proof = append(proof, level[index^1])
+
+		var next [][]byte
+		for j := 0; j < len(level); j += 2 {
+			next = append(next, hashPair(level[j], level[j+1]))
+		}
+		level = next
+		index /= 2
+	}
+
+	return proof, nil
+}
+
+// VerifyMerkleProof determines whether a proof from MerkleProof shows data is the leaf at index of the MerkleTree with
+// a given root
+func VerifyMerkleProof(data []byte, index int, proof [][]byte, root []byte) bool {
+	if index < 0 {
+		return false
+	}
+
+	hash := crypto.Hash256(data)
+	for _, sibling := range proof {
+		if index%2 == 0 {
+			hash = hashPair(hash, sibling)
+		} else {
+			hash = hashPair(sibling, hash)
+		}
+		index /= 2
+	}
+
+	return index == 0 && bytes.Compare(hash, root) == 0
+}
+
+// hashPair gets the hash of a MerkleNode from those of its children, as InitMerkleNode does
+func hashPair(left, right []byte) []byte {
+	return crypto.Hash256(append(append(make([]byte, 0, len(left)+len(right)), left...), right...))
 }
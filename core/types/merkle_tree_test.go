@@ -0,0 +1,116 @@
+package types
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/danitello/go-blockchain/crypto"
+)
+
+// merkleRoot computes the root of data the long way, as a reference for InitMerkleTree
+func merkleRoot(data [][]byte) []byte {
+	var level [][]byte
+	for _, d := range data {
+		level = append(level, crypto.Hash256(d))
+	}
+
+	for first := true; first || len(level) > 1; first = false {
+		if len(level)%2 != 0 {
+			level = append(level, level[len(level)-1])
+		}
+		var next [][]byte
+		for i := 0; i < len(level); i += 2 {
+			next = append(next, crypto.Hash256(append(append([]byte{}, level[i]...), level[i+1]...)))
+		}
+		level = next
+	}
+
+	return level[0]
+}
+
+func TestInitMerkleTreeLeafCounts(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		t.Run(fmt.Sprintf("%d leaves", n), func(t *testing.T) {
+			var data [][]byte
+			for i := 0; i < n; i++ {
+				data = append(data, []byte(fmt.Sprintf("tx %d", i)))
+			}
+
+			root := InitMerkleTree(data).Root.Data
+			if want := merkleRoot(data); bytes.Compare(root, want) != 0 {
+				t.Fatalf("root %x, want %x", root, want)
+			}
+
+			// Every leaf is committed to
+			for i := range data {
+				changed := append([][]byte{}, data...)
+				changed[i] = []byte("changed")
+				if bytes.Compare(InitMerkleTree(changed).Root.Data, root) == 0 {
+					t.Errorf("changing leaf %d did not change the root", i)
+				}
+			}
+		})
+	}
+}
+
+func TestInitMerkleTreeSingleLeaf(t *testing.T) {
+	// The leaf is paired with itself, giving the root Blocks holding only a coinbase tx have always had
+	leaf := crypto.Hash256([]byte("coinbase"))
+	want := crypto.Hash256(append(append([]byte{}, leaf...), leaf...))
+	if root := InitMerkleTree([][]byte{[]byte("coinbase")}).Root.Data; bytes.Compare(root, want) != 0 {
+		t.Fatalf("root %x, want %x", root, want)
+	}
+}
+
+func TestInitMerkleTreeEmpty(t *testing.T) {
+	leaf := crypto.Hash256(nil)
+	want := crypto.Hash256(append(append([]byte{}, leaf...), leaf...))
+	if root := InitMerkleTree(nil).Root.Data; bytes.Compare(root, want) != 0 {
+		t.Fatalf("root %x, want that of a single leaf of no data", root)
+	}
+}
+
+func TestInitMerkleNodeDoesNotAliasLeft(t *testing.T) {
+	leftData := make([]byte, 32, 64) // spare capacity an append would write into
+	left := &MerkleNode{Data: leftData}
+	right := &MerkleNode{Data: bytes.Repeat([]byte{1}, 32)}
+
+	InitMerkleNode(left, right, nil)
+
+	if bytes.Compare(leftData[:cap(leftData)], make([]byte, 64)) != 0 {
+		t.Fatal("InitMerkleNode wrote into the array of the left node's data")
+	}
+}
+
+func TestMerkleProof(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		var data [][]byte
+		for i := 0; i < n; i++ {
+			data = append(data, []byte(fmt.Sprintf("tx %d", i)))
+		}
+		root := InitMerkleTree(data).Root.Data
+
+		for i := range data {
+			proof, err := MerkleProof(data, i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !VerifyMerkleProof(data[i], i, proof, root) {
+				t.Fatalf("Proof of leaf %d of %d fails", i, n)
+			}
+			if VerifyMerkleProof([]byte("changed"), i, proof, root) {
+				t.Fatalf("Proof of leaf %d of %d holds for other data", i, n)
+			}
+			if other := i ^ 1; other < n && VerifyMerkleProof(data[i], other, proof, root) {
+				t.Fatalf("Proof of leaf %d of %d holds at index %d", i, n, other)
+			}
+		}
+
+		for _, index := range []int{-1, n} {
+			if _, err := MerkleProof(data, index); err != ErrLeafNotFound {
+				t.Fatalf("Got %v for index %d of %d, want ErrLeafNotFound", err, index, n)
+			}
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package types
+
+import "errors"
+
+// SigHashType selects the parts of a Transaction the Signature of a txin commits to
+type SigHashType byte
+
+const (
+	// SigHashAll commits to every txin and txo, so the Transaction can't be changed once signed
+	SigHashAll SigHashType = 1
+	// SigHashNone commits to every txin but no txo, letting the other signers choose where the coins go
+	SigHashNone SigHashType = 2
+	// SigHashSingle commits to every txin and only the txo at the same idx as the txin, letting the other txos change
+	SigHashSingle SigHashType = 3
+)
+
+// ErrNoSingleOutput is returned when signing a txin with SigHashSingle that has no txo at its idx to commit to
+var ErrNoSingleOutput = errors.New("SIGHASH_SINGLE input has no output at its index")
+
+// String gets the name of the SigHashType
+func (t SigHashType) String() string {
+	switch t {
+	case SigHashAll:
+		return "ALL"
+	case SigHashNone:
+		return "NONE"
+	case SigHashSingle:
+		return "SINGLE"
+	}
+	return "UNKNOWN"
+}
+
+// SigHashType gets the SigHashType a txin was signed with, stored as a byte after the r and s of its Signature. A
+// Signature of just r and s was signed with SigHashAll, so Signatures from before SigHashTypes existed stay valid.
+// Returns false for a Signature of the wrong length or with an unknown SigHashType. SigHashAll is never stored, so
+// each Signature has a single encoding
+func (txin *TxInput) SigHashType() (SigHashType, bool) {
+	switch len(txin.Signature) {
+	case sigLen:
+		return SigHashAll, true
+	case sigLen + 1:
+		t := SigHashType(txin.Signature[sigLen])
+		return t, t == SigHashNone || t == SigHashSingle
+	}
+	return 0, false
+}
+
+// signatureHash gets the hash the Signature of the txin at an idx commits to with a SigHashType - a TrimmedCopy of the
+// Transaction with the txin's PubKey set to the pub key hash of the txo it spends. For SigHashNone the copy has no
+// txos, and for SigHashSingle only the one at the txin's idx, with those before it blanked to keep its idx. With
+// either, the Sequence of the other txins is left out so they can be updated, and the txin's Data holds the
+// SigHashType so a Signature can't be passed off as one of another SigHashType
+func (tx *Transaction) signatureHash(txinIdx int, prevPubKeyHash []byte, hashType SigHashType) ([]byte, error) {
+	txCopy := tx.TrimmedCopy()
+	txCopy.Inputs[txinIdx].PubKey = prevPubKeyHash
+
+	switch hashType {
+	case SigHashAll:
+		return txCopy.Hash(), nil
+	case SigHashNone:
+		txCopy.Outputs = nil
+	case SigHashSingle:
+		if txinIdx >= len(tx.Outputs) {
+			return nil, ErrNoSingleOutput
+		}
+		txCopy.Outputs = make([]TxOutput, txinIdx+1)
+		txCopy.Outputs[txinIdx] = tx.Outputs[txinIdx]
+	default:
+		return nil, errors.New("Unknown SIGHASH type")
+	}
+
+	for i := range txCopy.Inputs {
+		if i != txinIdx {
+			txCopy.Inputs[i].Sequence = 0
+		}
+	}
+	txCopy.Inputs[txinIdx].Data = []byte{byte(hashType)}
+
+	return txCopy.Hash(), nil
+}
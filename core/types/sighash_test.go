@@ -0,0 +1,87 @@
+package types
+
+import (
+	"encoding/hex"
+	"math/rand"
+	"testing"
+
+	"github.com/danitello/go-blockchain/wallet"
+)
+
+// signedSpendWith is signedSpend signing with a given SigHashType - a Transaction paying half a coinbase txo to its
+// Wallet at idx 0 with the change at idx 1
+func signedSpendWith(t *testing.T, hashType SigHashType) (*Transaction, map[string]Transaction) {
+	t.Helper()
+
+	w, err := wallet.InitWalletFromReader(rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := string(w.GetAddress())
+
+	prev := CoinbaseTx(address, 0)
+	prevID := hex.EncodeToString(prev.ID)
+	tx := CreateTransaction(address, address, w.GetPubKey(), Reward/2, Reward, map[string][]int{prevID: {0}})
+	prevTxs := map[string]Transaction{prevID: *prev}
+	tx.Sign(w.PrivateKey, prevTxs, hashType)
+
+	return tx, prevTxs
+}
+
+func TestSigHashTypes(t *testing.T) {
+	for _, test := range []struct {
+		hashType         SigHashType
+		committedChanged bool // whether changing txo 0 keeps the Signature valid
+		otherChanged     bool // whether changing txo 1 keeps the Signature valid
+	}{
+		{SigHashAll, false, false},
+		{SigHashNone, true, true},
+		{SigHashSingle, false, true},
+	} {
+		tx, prevTxs := signedSpendWith(t, test.hashType)
+		if got, ok := tx.Inputs[0].SigHashType(); !ok || got != test.hashType {
+			t.Fatalf("%s: got stored type %s", test.hashType, got)
+		}
+		if !tx.Verify(prevTxs) {
+			t.Fatalf("%s: signed transaction failed verification", test.hashType)
+		}
+
+		for idx, want := range []bool{test.committedChanged, test.otherChanged} {
+			changed := tx.Copy()
+			outputs := append([]TxOutput{}, changed.Outputs...)
+			outputs[idx].Amount--
+			changed.SetOutputs(outputs)
+			if got := changed.Verify(prevTxs); got != want {
+				t.Errorf("%s: got %v verifying with txo %d changed, want %v", test.hashType, got, idx, want)
+			}
+		}
+	}
+}
+
+func TestSigHashTypeCommitted(t *testing.T) {
+	tx, prevTxs := signedSpendWith(t, SigHashSingle)
+
+	// Passing a SIGHASH_SINGLE Signature off as SIGHASH_NONE would free txo 0
+	tx.Inputs[0].Signature[sigLen] = byte(SigHashNone)
+	if tx.Verify(prevTxs) {
+		t.Fatal("Signature verified with its stored type changed")
+	}
+
+	tx.Inputs[0].Signature[sigLen] = 0xff
+	if _, ok := tx.Inputs[0].SigHashType(); ok {
+		t.Fatal("Unknown stored type accepted")
+	}
+	if tx.Verify(prevTxs) {
+		t.Fatal("Signature with an unknown type verified")
+	}
+}
+
+func TestSigHashSingleNoOutput(t *testing.T) {
+	tx, prevTxs := signedSpendWith(t, SigHashAll)
+	prevTx := prevTxs[hex.EncodeToString(tx.Inputs[0].TxID)]
+	tx.Outputs = tx.Outputs[:0]
+
+	if _, err := tx.signatureHash(0, prevTx.Outputs[0].PubKeyHash, SigHashSingle); err != ErrNoSingleOutput {
+		t.Fatalf("Got %v, want ErrNoSingleOutput", err)
+	}
+}
@@ -1,42 +1,136 @@
 package types
 
 import (
+	"bytes"
 	"crypto/ecdsa"
-	"crypto/elliptic"
 	"crypto/rand"
-	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"math/big"
+	"sort"
 	"strings"
 
 	"github.com/danitello/go-blockchain/common/byteutil"
 	"github.com/danitello/go-blockchain/common/errutil"
+	"github.com/danitello/go-blockchain/common/hexutil"
+	"github.com/danitello/go-blockchain/crypto"
+	"github.com/danitello/go-blockchain/wallet"
 )
 
-// Transaction placed in Blocks
+const (
+	// Reward is the amount minted by a CoinbaseTx
+	Reward = 100
+	// MaxSupply is the most coins that can ever be minted
+	MaxSupply = 21000000
+	// MaxCoinbaseDataLen is the most bytes of Data a coinbase txin may carry
+	MaxCoinbaseDataLen = 100
+
+	// MaxInputs is the most txins a Transaction may have
+	MaxInputs = 1000
+	// MaxOutputs is the most txos a Transaction may have
+	MaxOutputs = 1000
+
+	// sigLen is the length of a txin Signature (r and s of 32 bytes each)
+	sigLen = 64
+	// pubKeyLen is the length of a txin PubKey (x and y of 32 bytes each)
+	pubKeyLen = 64
+)
+
+var (
+	// ErrNoInputs is returned by SanityCheck for a Transaction without txins
+	ErrNoInputs = errors.New("Transaction has no inputs")
+	// ErrNoOutputs is returned by SanityCheck for a Transaction without txos
+	ErrNoOutputs = errors.New("Transaction has no outputs")
+	// ErrNegativeAmount is returned by SanityCheck for a Transaction with a txo of negative amount
+	ErrNegativeAmount = errors.New("Transaction has an output with a negative amount")
+	// ErrDuplicateInput is returned by SanityCheck for a Transaction spending the same txo twice
+	ErrDuplicateInput = errors.New("Transaction spends the same output more than once")
+	// ErrTooManyInputs is returned by SanityCheck for a Transaction with more than MaxInputs txins
+	ErrTooManyInputs = errors.New("Transaction has too many inputs")
+	// ErrTooManyOutputs is returned by SanityCheck for a Transaction with more than MaxOutputs txos
+	ErrTooManyOutputs = errors.New("Transaction has too many outputs")
+	// ErrCoinbaseDataTooLong is returned for a coinbase tx with more than MaxCoinbaseDataLen bytes of Data
+	ErrCoinbaseDataTooLong = errors.New("Coinbase data is too long")
+	// ErrNotCoinbase is returned when reading the coinbase height of a tx that isn't a coinbase tx
+	ErrNotCoinbase = errors.New("Transaction is not a coinbase")
+	// ErrBadID is returned by SanityCheck for a Transaction whose ID does not match its contents
+	ErrBadID = errors.New("Transaction ID does not match its contents")
+	// ErrBadHTLC is returned by SanityCheck for a Transaction with a malformed ScriptHTLC txo
+	ErrBadHTLC = errors.New("Transaction has a malformed hash time locked output")
+	// ErrNotFinal is returned for a Transaction whose LockTime is above the height of the Block it would go in
+	ErrNotFinal = errors.New("Transaction is locked until a later block")
+	// ErrSequenceLocked is returned for a Transaction with a txin whose RelativeLock is not yet met
+	ErrSequenceLocked = errors.New("Transaction spends an output before its relative lock allows")
+)
+
+// Transaction placed in Blocks -
+// LockTime - lowest index of a Block the Transaction may be placed in, 0 for any
 type Transaction struct {
-	ID      []byte
-	Inputs  []TxInput
-	Outputs []TxOutput
+	ID       []byte
+	Inputs   []TxInput
+	Outputs  []TxOutput
+	LockTime int
+	size     int // cached result of Size, 0 if not yet computed
 }
 
-// initTransaction initializes a new Tranaction
+// initTransaction initializes a new Tranaction -
+// inputs are ordered by (TxID, OutputIdx) so the ID only depends on which txos are spent, not the order they were
+// gathered in.
+// outputs keep the order given (recipient first, then change)
 func initTransaction(inputs []TxInput, outputs []TxOutput) *Transaction {
-	tx := Transaction{nil, inputs, outputs}
+	sort.Slice(inputs, func(i, j int) bool {
+		if cmp := bytes.Compare(inputs[i].TxID, inputs[j].TxID); cmp != 0 {
+			return cmp < 0
+		}
+		return inputs[i].OutputIdx < inputs[j].OutputIdx
+	})
+
+	tx := Transaction{ID: nil, Inputs: inputs, Outputs: outputs}
 	tx.ID = tx.Hash()
 	return &tx
 }
 
 // CreateTransaction creates a Transaction that will be added to a Block in the BlockChain -
+// pubKey - pub key of the sender, used by txins to prove ownership of the txos
 // txoSum - sum of txos being spent
 // utxos - map of txIDs and utxoIdxs
-func CreateTransaction(from, to string, pubKeyHash []byte, amount, txoSum int, utxos map[string][]int) *Transaction {
+func CreateTransaction(from, to string, pubKey []byte, amount, txoSum int, utxos map[string][]int) *Transaction {
+	return CreateTransactionWithFee(from, to, pubKey, amount, 0, txoSum, utxos)
+}
+
+// CreateTransactionWithFee creates a Transaction like CreateTransaction, leaving fee out of the change so the
+// txins are worth fee more than the txos
+func CreateTransactionWithFee(from, to string, pubKey []byte, amount, fee, txoSum int, utxos map[string][]int) *Transaction {
+	return createTransaction(from, []TxOutput{*InitTxOutput(amount, to)}, pubKey, fee, txoSum, utxos)
+}
+
+// CreateTransactionToHash creates a Transaction like CreateTransactionWithFee, locking the txo paid to the
+// recipient directly with their pub key hash rather than decoding it from an address
+func CreateTransactionToHash(from string, toPubKeyHash, pubKey []byte, amount, fee, txoSum int, utxos map[string][]int) *Transaction {
+	return createTransaction(from, []TxOutput{{Amount: amount, PubKeyHash: toPubKeyHash}}, pubKey, fee, txoSum, utxos)
+}
+
+// CreateTransactionWithOutputs creates a Transaction like CreateTransactionWithFee, paying each of a list of txos in
+// order rather than a single recipient
+func CreateTransactionWithOutputs(from string, payments []TxOutput, pubKey []byte, fee, txoSum int, utxos map[string][]int) *Transaction {
+	return createTransaction(from, payments, pubKey, fee, txoSum, utxos)
+}
+
+// createTransaction creates a Transaction paying txos to the recipients, with change of what's left after fee going
+// back to from
+func createTransaction(from string, payments []TxOutput, pubKey []byte, fee, txoSum int, utxos map[string][]int) *Transaction {
 	var newInputs []TxInput
 	var newOutputs []TxOutput
+	amount := 0
+	for _, payment := range payments {
+		amount += payment.Amount
+	}
 
-	if txoSum < amount {
+	if txoSum < amount+fee {
 		pString := fmt.Sprintf("Error: Not enough funds in wallet address: %s", from)
 		log.Panic(pString)
 	}
@@ -47,14 +141,14 @@ func CreateTransaction(from, to string, pubKeyHash []byte, amount, txoSum int, u
 		errutil.Handle(err)
 
 		for _, utxoIdx := range utxoIdxs {
-			newInputs = append(newInputs, TxInput{txID, utxoIdx, nil, pubKeyHash}) // map outputs being spent by TxInputs
+			newInputs = append(newInputs, TxInput{txID, utxoIdx, nil, pubKey, nil, nil, 0}) // map outputs being spent by TxInputs
 		}
 	}
 
 	// New outputs for this Transaction
-	newOutputs = append(newOutputs, *InitTxOutput(amount, to))
-	if txoSum > amount {
-		newOutputs = append(newOutputs, *InitTxOutput(txoSum-amount, from)) // Keep left over
+	newOutputs = append(newOutputs, payments...)
+	if txoSum > amount+fee {
+		newOutputs = append(newOutputs, *InitTxOutput(txoSum-amount-fee, from)) // Keep left over
 	}
 
 	newTx := initTransaction(newInputs, newOutputs)
@@ -62,121 +156,392 @@ func CreateTransaction(from, to string, pubKeyHash []byte, amount, txoSum int, u
 
 }
 
+// SetLockTime sets the lowest index of a Block the Transaction may be placed in and updates the ID.
+// This changes what is signed, so it must be done before Sign
+func (tx *Transaction) SetLockTime(lockTime int) {
+	tx.LockTime = lockTime
+	tx.ID = tx.Hash()
+	tx.size = 0
+}
+
+// SetSequence sets the Sequence of the i-th txin and updates the ID. Like SetLockTime, this must be done before Sign
+func (tx *Transaction) SetSequence(i int, sequence uint32) {
+	tx.Inputs[i].Sequence = sequence
+	tx.ID = tx.Hash()
+	tx.size = 0
+}
+
+// IsFinal determines whether the Transaction may be placed in the Block at a given height
+func (tx *Transaction) IsFinal(height int) bool {
+	return tx.LockTime <= height
+}
+
+// SequenceLocksMet determines whether every txin of tx may be placed in the Block at a given height, given the index of
+// the Block containing each Transaction spent by ID. A Transaction with no index, e.g. one still in the Mempool, counts
+// as being in the same Block
+func (tx *Transaction) SequenceLocksMet(prevHeights map[string]int, height int) bool {
+	if tx.IsCoinbase() {
+		return true
+	}
+
+	for _, txin := range tx.Inputs {
+		prevHeight, ok := prevHeights[hex.EncodeToString(txin.TxID)]
+		if !ok {
+			prevHeight = height
+		}
+		if prevHeight+txin.RelativeLock() > height {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Priority gets the sum over the txins of the Transaction of the amount spent times its age in Blocks, divided by the
+// size of the Transaction. Long held coins have a high Priority, so it can stand in for a fee -
+// prevTxs - containing the txos referenced by the txins
+// prevHeights - index of the Block containing each of prevTxs (keyed by hex ID). Those left out are unconfirmed, with
+// an age of 0. A Transaction doesn't record the Block it is in, so the ages can't come from prevTxs, and only a
+// BlockChain knows them: BlockChain.TransactionPriority finds them in its UTXO set
+// tipHeight - index of the Block the Transaction would be placed in
+func (tx *Transaction) Priority(prevTxs map[string]Transaction, prevHeights map[string]int, tipHeight int) float64 {
+	if tx.IsCoinbase() {
+		return 0
+	}
+
+	var sum float64
+	for _, txin := range tx.Inputs {
+		txID := hex.EncodeToString(txin.TxID)
+		height, ok := prevHeights[txID]
+		if !ok || height >= tipHeight {
+			continue
+		}
+		sum += float64(prevTxs[txID].Outputs[txin.OutputIdx].Amount) * float64(tipHeight-height)
+	}
+
+	return sum / float64(tx.Size())
+}
+
 // Sign computes the signature for each txin in the tx with ecdsa -
 // privKey - of signer
 // prevTxs - containing the txos that will be referenced by new txins
-func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTxs map[string]Transaction) {
+// hashType - the parts of the tx each signature commits to, SigHashAll unless the tx is meant to be changed after
+func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTxs map[string]Transaction, hashType SigHashType) {
 	if tx.IsCoinbase() {
 		return
 	}
 
+	if privKey.D == nil || privKey.D.Sign() == 0 {
+		log.Panic("ERROR: tx.Sign given a private key that is unset or wiped")
+	}
+
 	for _, txin := range tx.Inputs {
 		if prevTxs[hex.EncodeToString(txin.TxID)].ID == nil {
 			log.Panic("ERROR: tx.Sign cannot find previous txn with ID")
 		}
 	}
 
-	txCopy := tx.TrimmedCopy()
-
-	for txinID, txin := range txCopy.Inputs {
+	for txinID, txin := range tx.Inputs {
 		prevTx := prevTxs[hex.EncodeToString(txin.TxID)]
-		txCopy.Inputs[txinID].Signature = nil
-		txCopy.Inputs[txinID].PubKey = prevTx.Outputs[txin.OutputIdx].PubKeyHash
-		txCopy.ID = txCopy.Hash()
+		hash, err := tx.signatureHash(txinID, prevTx.Outputs[txin.OutputIdx].PubKeyHash, hashType)
+		errutil.Handle(err)
 
-		r, s, err := ecdsa.Sign(rand.Reader, &privKey, txCopy.ID)
+		r, s, err := ecdsa.Sign(rand.Reader, &privKey, hash)
 		errutil.Handle(err)
-		signature := append(r.Bytes(), s.Bytes()...)
+		s = lowS(s, privKey.Curve.Params().N)
+
+		// r and s are each left padded to half the signature so it can be split in Verify, followed by the
+		// SigHashType unless it is SigHashAll (see TxInput.SigHashType)
+		signature := make([]byte, sigLen, sigLen+1)
+		rBytes, sBytes := r.Bytes(), s.Bytes()
+		copy(signature[sigLen/2-len(rBytes):sigLen/2], rBytes)
+		copy(signature[sigLen-len(sBytes):], sBytes)
+		if hashType != SigHashAll {
+			signature = append(signature, byte(hashType))
+		}
 
 		tx.Inputs[txinID].Signature = signature // now update the actual tx
-		txCopy.Inputs[txinID].PubKey = nil
+	}
+	tx.size = 0 // signatures changed the serialized form
+}
 
+// lowS gets the lower of s and N - s. Both make a valid signature, so only the low one is accepted
+// to keep others from changing a signed Transaction's bytes (and ID)
+func lowS(s, n *big.Int) *big.Int {
+	if isHighS(s, n) {
+		return new(big.Int).Sub(n, s)
 	}
+	return s
 }
 
-// Verify determines whether txins were signed correctly
+// isHighS determines whether s is above half the curve order N
+func isHighS(s, n *big.Int) bool {
+	return s.Cmp(new(big.Int).Rsh(n, 1)) > 0
+}
+
+// Verify determines whether txins were signed correctly, with a low S value, by the keys the txos they spend are
+// locked with.
+// A txin spending a txo missing from prevTxs, e.g. of a Transaction that isn't there or an idx past its txos, fails
 func (tx *Transaction) Verify(prevTxs map[string]Transaction) bool {
 	if tx.IsCoinbase() {
 		return true
 	}
 
 	for _, txin := range tx.Inputs {
-		if prevTxs[hex.EncodeToString(txin.TxID)].ID == nil {
-			log.Panic("ERROR: tx.Verify cannot find previous txn with ID", prevTxs[hex.EncodeToString(txin.TxID)].ID)
+		prevTx, ok := prevTxs[hex.EncodeToString(txin.TxID)]
+		if !ok || prevTx.ID == nil || txin.OutputIdx < 0 || txin.OutputIdx >= len(prevTx.Outputs) {
+			return false
 		}
 	}
 
-	txCopy := tx.TrimmedCopy()
-	curve := elliptic.P256()
-
 	for txinID, txin := range tx.Inputs {
-		// Get same information as signing flow
+		// Signature information, and the same hash as signing flow
+		hashType, ok := txin.SigHashType()
+		if !ok {
+			return false
+		}
 		prevTx := prevTxs[hex.EncodeToString(txin.TxID)]
-		txCopy.Inputs[txinID].Signature = nil
-		txCopy.Inputs[txinID].PubKey = prevTx.Outputs[txin.OutputIdx].PubKeyHash
-		txCopy.ID = txCopy.Hash()
-
-		// Signature information
+		hash, err := tx.signatureHash(txinID, prevTx.Outputs[txin.OutputIdx].PubKeyHash, hashType)
+		if err != nil {
+			return false
+		}
 		r := big.Int{}
 		s := big.Int{}
-		sigLen := len(txin.Signature)
 		r.SetBytes(txin.Signature[:(sigLen / 2)])
-		s.SetBytes(txin.Signature[(sigLen / 2):])
-
-		// PubKey information
-		x := big.Int{}
-		y := big.Int{}
-		keyLen := len(txin.PubKey)
-		x.SetBytes(txin.PubKey[:(keyLen / 2)])
-		y.SetBytes(txin.PubKey[(keyLen / 2):])
+		s.SetBytes(txin.Signature[(sigLen / 2):sigLen])
 
-		rawPubKey := ecdsa.PublicKey{Curve: curve, X: &x, Y: &y} // reconstruct
-		if ecdsa.Verify(&rawPubKey, txCopy.ID, &r, &s) == false {
+		// PubKey information, which must be a key the txo is locked with
+		if !prevTx.Outputs[txin.OutputIdx].CanBeSpentBy(txin, tx.LockTime) {
+			return false
+		}
+		rawPubKey, err := wallet.ParsePubKey(txin.PubKey) // reconstruct
+		if err != nil {
+			return false
+		}
+		if isHighS(&s, rawPubKey.Curve.Params().N) {
+			return false
+		}
+		if ecdsa.Verify(rawPubKey, hash, &r, &s) == false {
 			return false
 		}
-		txCopy.Inputs[txinID].PubKey = nil
 	}
 
 	return true
 }
 
+// Copy gets a copy of the Transaction sharing no memory with it, so either can be changed without affecting the other
+func (tx *Transaction) Copy() *Transaction {
+	res := *tx
+	res.ID = copyBytes(tx.ID)
+
+	if tx.Inputs != nil {
+		res.Inputs = make([]TxInput, len(tx.Inputs))
+		for i, txin := range tx.Inputs {
+			txin.TxID, txin.Signature, txin.PubKey = copyBytes(txin.TxID), copyBytes(txin.Signature), copyBytes(txin.PubKey)
+			txin.Data, txin.Preimage = copyBytes(txin.Data), copyBytes(txin.Preimage)
+			res.Inputs[i] = txin
+		}
+	}
+	if tx.Outputs != nil {
+		res.Outputs = make([]TxOutput, len(tx.Outputs))
+		for i, txo := range tx.Outputs {
+			txo.PubKeyHash, txo.HashLock = copyBytes(txo.PubKeyHash), copyBytes(txo.HashLock)
+			txo.RefundPubKeyHash = copyBytes(txo.RefundPubKeyHash)
+			res.Outputs[i] = txo
+		}
+	}
+
+	return &res
+}
+
+// copyBytes gets a copy of b, nil if b is nil
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
 // TrimmedCopy sets the Signature and PubKey fields of all txins to nil as these are unecessary for signing (btc spec)
 func (tx *Transaction) TrimmedCopy() Transaction {
 	var inputs []TxInput
 	var outputs []TxOutput
 
 	for _, txin := range tx.Inputs {
-		inputs = append(inputs, TxInput{txin.TxID, txin.OutputIdx, nil, nil})
+		inputs = append(inputs, TxInput{txin.TxID, txin.OutputIdx, nil, nil, nil, nil, txin.Sequence})
 	}
 
 	for _, txo := range tx.Outputs {
-		outputs = append(outputs, TxOutput{txo.Amount, txo.PubKeyHash})
+		outputs = append(outputs, txo)
 	}
 
-	txCopy := Transaction{tx.ID, inputs, outputs}
+	txCopy := Transaction{ID: tx.ID, Inputs: inputs, Outputs: outputs, LockTime: tx.LockTime}
 
 	return txCopy
 }
 
-// Hash computes the hash of the Transaction
-func (tx *Transaction) Hash() []byte {
-	var hash [32]byte
+// Size gets the length in bytes of the serialized Transaction
+func (tx *Transaction) Size() int {
+	if tx.size == 0 {
+		tx.size = len(byteutil.Serialize(tx))
+	}
+
+	return tx.size
+}
+
+// EstimateSize gets the length in bytes the Transaction will have once signed,
+// using placeholders for any txin Signature or PubKey not yet set
+func (tx *Transaction) EstimateSize() int {
+	txCopy := Transaction{ID: tx.ID, Outputs: tx.Outputs, LockTime: tx.LockTime}
+
+	for _, txin := range tx.Inputs {
+		if txin.Signature == nil {
+			txin.Signature = make([]byte, sigLen)
+		}
+		if len(txin.PubKey) < pubKeyLen {
+			txin.PubKey = make([]byte, pubKeyLen)
+		}
+		txCopy.Inputs = append(txCopy.Inputs, txin)
+	}
+
+	return txCopy.Size()
+}
+
+// SanityCheck performs the checks on a Transaction that don't need the BlockChain.
+// These are cheap, so they run before signature verification
+func (tx *Transaction) SanityCheck() error {
+	if len(tx.Inputs) == 0 {
+		return ErrNoInputs
+	}
+	if len(tx.Outputs) == 0 {
+		return ErrNoOutputs
+	}
+	if len(tx.Inputs) > MaxInputs && !tx.IsCoinbase() {
+		return ErrTooManyInputs
+	}
+	if len(tx.Outputs) > MaxOutputs {
+		return ErrTooManyOutputs
+	}
+	if tx.IsCoinbase() && len(tx.Inputs[0].Data) > MaxCoinbaseDataLen {
+		return ErrCoinbaseDataTooLong
+	}
+
+	for _, txo := range tx.Outputs {
+		if txo.Amount < 0 {
+			return ErrNegativeAmount
+		}
+		if txo.ScriptType() == ScriptHTLC && !txo.isValidHTLC() {
+			return ErrBadHTLC
+		}
+		if txo.ScriptType() == ScriptData && len(txo.PubKeyHash)-1 > MaxDataLen {
+			return ErrDataTooLong
+		}
+	}
+
+	if !tx.IsCoinbase() {
+		spent := make(map[string]bool)
+		for _, txin := range tx.Inputs {
+			outpoint := fmt.Sprintf("%x:%d", txin.TxID, txin.OutputIdx)
+			if spent[outpoint] {
+				return ErrDuplicateInput
+			}
+			spent[outpoint] = true
+		}
+	}
+
+	if bytes.Compare(tx.ID, tx.unsignedHash()) != 0 {
+		return ErrBadID
+	}
+
+	return nil
+}
+
+// unsignedHash computes the ID of the Transaction, which is set before signing so leaves out every Signature
+func (tx *Transaction) unsignedHash() []byte {
+	unsigned := Transaction{Outputs: tx.Outputs, LockTime: tx.LockTime}
+	for _, txin := range tx.Inputs {
+		unsigned.Inputs = append(unsigned.Inputs, TxInput{txin.TxID, txin.OutputIdx, nil, txin.PubKey, txin.Data, nil, txin.Sequence})
+	}
 
+	return unsigned.Hash()
+}
+
+// SetOutputs replaces the txos of a possibly signed Transaction, updating its ID. Signatures made with SigHashNone,
+// or with SigHashSingle by a txin whose txo is unchanged, stay valid; those made with SigHashAll don't
+func (tx *Transaction) SetOutputs(outputs []TxOutput) {
+	tx.Outputs = outputs
+	tx.ID = tx.unsignedHash()
+	tx.size = 0
+}
+
+// Hash computes the hash of the Transaction, leaving out any txin Preimage so it can be revealed after the ID is set
+func (tx *Transaction) Hash() []byte {
 	txCopy := *tx
 	txCopy.ID = []byte{}
+	for i, txin := range tx.Inputs {
+		if txin.Preimage != nil {
+			txCopy.Inputs = append([]TxInput{}, tx.Inputs...)
+			for j := i; j < len(txCopy.Inputs); j++ {
+				txCopy.Inputs[j].Preimage = nil
+			}
+			break
+		}
+	}
 
-	hash = sha256.Sum256(byteutil.Serialize(txCopy))
+	return crypto.Hash256(byteutil.Serialize(txCopy))
+}
 
-	return hash[:]
+// BlockSubsidy gets the amount the coinbase tx of the Block at a given height mints.
+// This is Reward until the total would pass MaxSupply, then whatever remains, then nothing
+func BlockSubsidy(height int) int {
+	remaining := MaxSupply - height*Reward
+	if remaining < 0 {
+		return 0
+	} else if remaining < Reward {
+		return remaining
+	}
+	return Reward
 }
 
-// CoinbaseTx is the transaction in each Block that rewards the miner
-func CoinbaseTx(to string) *Transaction {
-	amount := 100
-	txin := TxInput{[]byte{}, -1, nil, []byte(fmt.Sprintf("CoinbaseTx: %d coins to %s", amount, to))} // referencing no output
+// CoinbaseTx is the transaction in each Block that rewards the miner -
+// height - index of the Block the tx will be in
+func CoinbaseTx(to string, height int) *Transaction {
+	tx, err := CoinbaseTxWithData(to, height, nil)
+	errutil.Handle(err)
+	return tx
+}
+
+// CoinbaseTxWithData creates a CoinbaseTx whose txin carries arbitrary data of the miner's choosing,
+// e.g. to identify a pool or as extra nonce space. data may be at most MaxCoinbaseDataLen bytes
+func CoinbaseTxWithData(to string, height int, data []byte) (*Transaction, error) {
+	return CoinbaseTxWithValue(to, height, BlockSubsidy(height), data)
+}
+
+// CoinbaseTxWithValue creates a CoinbaseTxWithData paying a given amount, e.g. the block subsidy plus the fees of the
+// Block's other Transactions
+func CoinbaseTxWithValue(to string, height, amount int, data []byte) (*Transaction, error) {
+	if len(data) > MaxCoinbaseDataLen {
+		return nil, ErrCoinbaseDataTooLong
+	}
+
+	txin := TxInput{[]byte{}, -1, nil, hexutil.ToHex(int64(height)), data, nil, 0} // referencing no output, height makes the ID unique
 	txout := InitTxOutput(amount, to)
 	newTx := initTransaction([]TxInput{txin}, []TxOutput{*txout})
-	return newTx
+	return newTx, nil
+}
+
+// CoinbaseHeight gets the height of the Block a coinbase tx was created for, which is encoded in its txin
+func (tx *Transaction) CoinbaseHeight() (int, error) {
+	if !tx.IsCoinbase() {
+		return 0, ErrNotCoinbase
+	}
+
+	encoded := tx.Inputs[0].PubKey
+	if len(encoded) != 8 {
+		return 0, errors.New("Coinbase does not encode a height")
+	}
+
+	return int(binary.BigEndian.Uint64(encoded)), nil
 }
 
 // IsCoinbase determines whether a Transaction is a coinbase tx
@@ -184,24 +549,57 @@ func (tx *Transaction) IsCoinbase() bool {
 	return len(tx.Inputs) == 1 && len(tx.Inputs[0].TxID) == 0 && tx.Inputs[0].OutputIdx == -1
 }
 
+// EncodeRawTransaction converts a Transaction into the hex string of its serialized form
+func EncodeRawTransaction(tx *Transaction) string {
+	return hex.EncodeToString(byteutil.Serialize(tx))
+}
+
+// DecodeRawTransaction converts the hex string of a serialized Transaction back into a Transaction
+func DecodeRawTransaction(hexStr string) (*Transaction, error) {
+	data, err := hex.DecodeString(hexStr)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid transaction hex: %s", err)
+	}
+
+	var tx Transaction
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&tx); err != nil {
+		return nil, fmt.Errorf("Invalid transaction encoding: %s", err)
+	}
+
+	return &tx, nil
+}
+
 // String creates a string containing information to display about the tx
 func (tx Transaction) String() string {
 	var lines []string
 
-	lines = append(lines, fmt.Sprintf("--- Transaction %x:", tx.ID))
+	lines = append(lines, fmt.Sprintf("--- Transaction %s:", shortHex(tx.ID)))
+	if tx.LockTime > 0 {
+		lines = append(lines, fmt.Sprintf("     Locked until block %d", tx.LockTime))
+	}
 
 	for i, txin := range tx.Inputs {
-		lines = append(lines, fmt.Sprintf("     Input %d:", i))
-		lines = append(lines, fmt.Sprintf("       TxID:      %x", txin.TxID))
-		lines = append(lines, fmt.Sprintf("       OutputIdx:       %d", txin.OutputIdx))
-		lines = append(lines, fmt.Sprintf("       Signature: %x", txin.Signature))
-		lines = append(lines, fmt.Sprintf("       PubKey:    %x", txin.PubKey))
+		if tx.IsCoinbase() {
+			lines = append(lines, fmt.Sprintf("     Input %d:  coinbase", i))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("     Input %d:  %s:%d", i, shortHex(txin.TxID), txin.OutputIdx))
+		if lock := txin.RelativeLock(); lock > 0 {
+			lines = append(lines, fmt.Sprintf("       Locked for %d blocks", lock))
+		}
 	}
 	for i, txo := range tx.Outputs {
-		lines = append(lines, fmt.Sprintf("     Output %d:", i))
-		lines = append(lines, fmt.Sprintf("       amount:  %d", txo.Amount))
-		lines = append(lines, fmt.Sprintf("       PubKeyHash: %x", txo.PubKeyHash))
+		lines = append(lines, fmt.Sprintf("     Output %d: %d -> %s", i, txo.Amount, shortHex(txo.PubKeyHash)))
 	}
 
 	return strings.Join(lines, "\n")
 }
+
+// shortHex converts a []byte to hex, truncated to its first 8 bytes
+func shortHex(data []byte) string {
+	if len(data) > 8 {
+		return fmt.Sprintf("%x...", data[:8])
+	}
+	return fmt.Sprintf("%x", data)
+}
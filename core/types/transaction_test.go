@@ -0,0 +1,263 @@
+package types
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/hex"
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/danitello/go-blockchain/wallet"
+)
+
+// signedSpend makes a coinbase tx paying a Wallet and a Transaction signed by it spending the coinbase tx's txo
+func signedSpend(t *testing.T) (*Transaction, map[string]Transaction) {
+	t.Helper()
+
+	w, err := wallet.InitWalletFromReader(rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := string(w.GetAddress())
+
+	prev := CoinbaseTx(address, 0)
+	prevID := hex.EncodeToString(prev.ID)
+	tx := CreateTransaction(address, address, w.GetPubKey(), Reward/2, Reward, map[string][]int{prevID: {0}})
+	prevTxs := map[string]Transaction{prevID: *prev}
+	tx.Sign(w.PrivateKey, prevTxs, SigHashAll)
+
+	return tx, prevTxs
+}
+
+func TestVerify(t *testing.T) {
+	tx, prevTxs := signedSpend(t)
+
+	if !tx.Verify(prevTxs) {
+		t.Fatal("signed transaction failed verification")
+	}
+}
+
+func TestVerifyMissingPrevTx(t *testing.T) {
+	tx, _ := signedSpend(t)
+
+	if tx.Verify(map[string]Transaction{}) {
+		t.Fatal("transaction spending a missing transaction passed verification")
+	}
+}
+
+func TestVerifyOutputIdxOutOfRange(t *testing.T) {
+	for _, idx := range []int{-1, 1, 99} {
+		tx, prevTxs := signedSpend(t)
+		tx.Inputs[0].OutputIdx = idx
+
+		if tx.Verify(prevTxs) {
+			t.Errorf("output idx %d: passed verification", idx)
+		}
+	}
+}
+
+func TestPriority(t *testing.T) {
+	prevTxs := map[string]Transaction{
+		"01": {ID: []byte{1}, Outputs: []TxOutput{{Amount: 100}, {Amount: 50}}},
+		"02": {ID: []byte{2}, Outputs: []TxOutput{{Amount: 30}}},
+		"03": {ID: []byte{3}, Outputs: []TxOutput{{Amount: 1000}}},
+	}
+	prevHeights := map[string]int{"01": 4, "02": 9} // 03 is unconfirmed
+	tx := &Transaction{
+		ID:      []byte{9},
+		Inputs:  []TxInput{{TxID: []byte{1}, OutputIdx: 1}, {TxID: []byte{2}, OutputIdx: 0}, {TxID: []byte{3}, OutputIdx: 0}},
+		Outputs: []TxOutput{{Amount: 10, PubKeyHash: make([]byte, 20)}}}
+	size := float64(tx.Size())
+
+	for tipHeight, want := range map[int]float64{
+		10: (50*6 + 30*1) / size,
+		9:  50 * 5 / size, // the input mined at 9 has no age yet
+		4:  0,
+	} {
+		if got := tx.Priority(prevTxs, prevHeights, tipHeight); got != want {
+			t.Errorf("Tip %d: got priority %v, want %v", tipHeight, got, want)
+		}
+	}
+
+	if got := CoinbaseTx("address", 1).Priority(prevTxs, prevHeights, 10); got != 0 {
+		t.Fatalf("Coinbase tx has priority %v", got)
+	}
+}
+
+func TestCreateTransactionDeterministicID(t *testing.T) {
+	w, err := wallet.InitWalletFromReader(rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := string(w.GetAddress())
+
+	utxos := make(map[string][]int)
+	reversed := make(map[string][]int)
+	for i := byte(0); i < 8; i++ {
+		txID := hex.EncodeToString([]byte{i, 0xff - i})
+		utxos[txID] = []int{0, 1, 2}
+		reversed[txID] = []int{2, 1, 0}
+	}
+
+	want := CreateTransaction(address, address, w.GetPubKey(), 10, 80, utxos)
+	for i := 1; i < len(want.Inputs); i++ {
+		prev, in := want.Inputs[i-1], want.Inputs[i]
+		if cmp := bytes.Compare(prev.TxID, in.TxID); cmp > 0 || cmp == 0 && prev.OutputIdx > in.OutputIdx {
+			t.Fatalf("Inputs %d and %d are out of order", i-1, i)
+		}
+	}
+
+	// Map iteration order differs between calls, but the ID doesn't
+	for i := 0; i < 20; i++ {
+		for _, spent := range []map[string][]int{utxos, reversed} {
+			if tx := CreateTransaction(address, address, w.GetPubKey(), 10, 80, spent); bytes.Compare(tx.ID, want.ID) != 0 {
+				t.Fatalf("Got ID %x, want %x", tx.ID, want.ID)
+			}
+		}
+	}
+}
+
+func TestBlockSubsidyCapsSupply(t *testing.T) {
+	last := MaxSupply / Reward
+	for height, want := range map[int]int{0: Reward, last - 1: Reward, last: 0, last + 1: 0} {
+		if got := BlockSubsidy(height); got != want {
+			t.Errorf("Height %d: got subsidy %d, want %d", height, got, want)
+		}
+	}
+
+	total := 0
+	for height := 0; height <= last+10; height++ {
+		total += BlockSubsidy(height)
+	}
+	if total != MaxSupply {
+		t.Fatalf("Total supply %d, want %d", total, MaxSupply)
+	}
+}
+
+func TestSanityCheckLimits(t *testing.T) {
+	tx, _ := signedSpend(t)
+	for len(tx.Inputs) < MaxInputs {
+		tx.Inputs = append(tx.Inputs, TxInput{TxID: []byte{1}, OutputIdx: len(tx.Inputs)})
+	}
+	for len(tx.Outputs) < MaxOutputs {
+		tx.Outputs = append(tx.Outputs, tx.Outputs[0])
+	}
+	tx.ID = tx.unsignedHash()
+	if err := tx.SanityCheck(); err != nil {
+		t.Fatalf("Got %v at the limits", err)
+	}
+
+	tooManyInputs := *tx
+	tooManyInputs.Inputs = append(tooManyInputs.Inputs, TxInput{TxID: []byte{1}, OutputIdx: MaxInputs})
+	if err := tooManyInputs.SanityCheck(); err != ErrTooManyInputs {
+		t.Fatalf("Got %v for %d inputs, want ErrTooManyInputs", err, len(tooManyInputs.Inputs))
+	}
+
+	tooManyOutputs := *tx
+	tooManyOutputs.Outputs = append(tooManyOutputs.Outputs, tx.Outputs[0])
+	if err := tooManyOutputs.SanityCheck(); err != ErrTooManyOutputs {
+		t.Fatalf("Got %v for %d outputs, want ErrTooManyOutputs", err, len(tooManyOutputs.Outputs))
+	}
+}
+
+func TestCoinbaseHeight(t *testing.T) {
+	address := "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa"
+	ids := make(map[string]int)
+	for _, height := range []int{0, 1, 2, 1 << 40} {
+		cbtx := CoinbaseTx(address, height)
+		if prev, ok := ids[string(cbtx.ID)]; ok {
+			t.Fatalf("Coinbases at heights %d and %d have the same ID", prev, height)
+		}
+		ids[string(cbtx.ID)] = height
+
+		if got, err := cbtx.CoinbaseHeight(); err != nil || got != height {
+			t.Fatalf("Got height %d, %v, want %d", got, err, height)
+		}
+	}
+
+	tx, _ := signedSpend(t)
+	if _, err := tx.CoinbaseHeight(); err != ErrNotCoinbase {
+		t.Fatalf("Got %v for a spend, want ErrNotCoinbase", err)
+	}
+}
+
+func TestVerifyHighS(t *testing.T) {
+	tx, prevTxs := signedSpend(t)
+	n := elliptic.P256().Params().N
+	sig := tx.Inputs[0].Signature
+	s := new(big.Int).SetBytes(sig[sigLen/2 : sigLen])
+	if isHighS(s, n) {
+		t.Fatal("Sign made a high S signature")
+	}
+
+	// N - s makes a signature ecdsa accepts just the same
+	highS := new(big.Int).Sub(n, s).Bytes()
+	malleated := append([]byte{}, sig...)
+	copy(malleated[sigLen/2:sigLen], make([]byte, sigLen/2))
+	copy(malleated[sigLen-len(highS):sigLen], highS)
+	tx.Inputs[0].Signature = malleated
+
+	pubKey, err := wallet.ParsePubKey(tx.Inputs[0].PubKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prevTx := prevTxs[hex.EncodeToString(tx.Inputs[0].TxID)]
+	hash, err := tx.signatureHash(0, prevTx.Outputs[0].PubKeyHash, SigHashAll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := new(big.Int).SetBytes(sig[:sigLen/2])
+	if !ecdsa.Verify(pubKey, hash, r, new(big.Int).SetBytes(highS)) {
+		t.Fatal("High S signature is not valid ECDSA")
+	}
+
+	if tx.Verify(prevTxs) {
+		t.Fatal("High S signature passed verification")
+	}
+}
+
+func TestSignZeroedKey(t *testing.T) {
+	w, err := wallet.InitWalletFromReader(rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := string(w.GetAddress())
+	prev := CoinbaseTx(address, 0)
+	prevID := hex.EncodeToString(prev.ID)
+	tx := CreateTransaction(address, address, w.GetPubKey(), Reward, Reward, map[string][]int{prevID: {0}})
+
+	w.Zero()
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Signed with a zeroed key")
+		}
+		if tx.Inputs[0].Signature != nil {
+			t.Fatal("Zeroed key left a signature")
+		}
+	}()
+	tx.Sign(w.PrivateKey, map[string]Transaction{prevID: *prev}, SigHashAll)
+}
+
+func TestSequenceLocksMet(t *testing.T) {
+	prevID := []byte{1}
+	tx := &Transaction{Inputs: []TxInput{{TxID: prevID, Sequence: 3}, {TxID: []byte{2}, Sequence: SequenceDisableFlag | 99}}}
+	prevHeights := map[string]int{hex.EncodeToString(prevID): 10}
+
+	if tx.Inputs[0].RelativeLock() != 3 || tx.Inputs[1].RelativeLock() != 0 {
+		t.Fatalf("Got relative locks %d and %d, want 3 and 0", tx.Inputs[0].RelativeLock(), tx.Inputs[1].RelativeLock())
+	}
+	if tx.SequenceLocksMet(prevHeights, 12) {
+		t.Fatal("Relative lock met before its maturity")
+	}
+	if !tx.SequenceLocksMet(prevHeights, 13) {
+		t.Fatal("Relative lock not met at its maturity")
+	}
+
+	// A spent Transaction with no height counts as in the same Block
+	tx.Inputs[0].TxID = []byte{3}
+	if tx.SequenceLocksMet(prevHeights, 13) {
+		t.Fatal("Relative lock on an unconfirmed output met")
+	}
+}
@@ -11,11 +11,42 @@ import (
 // OutputIdx - idx of the TxOutput in the Transaction
 // Signature - signs the txin as unlocking the txo
 // PubKey - the pub key used
+// Data - arbitrary data set by the miner, only used by the txin of a coinbase tx
+// Preimage - reveals the secret of a ScriptHTLC txo to claim it, left out of the ID
+// Sequence - relative lock of the txin, see RelativeLock
 type TxInput struct {
 	TxID      []byte
 	OutputIdx int
 	Signature []byte
 	PubKey    []byte
+	Data      []byte
+	Preimage  []byte
+	Sequence  uint32
+}
+
+const (
+	// SequenceDisableFlag is set in the Sequence of a txin that has no relative lock
+	SequenceDisableFlag = uint32(1) << 31
+	// SequenceLockMask masks the number of Blocks of a relative lock from a Sequence
+	SequenceLockMask = uint32(0x0000ffff)
+)
+
+// Outpoint identifies a txo -
+// TxID - ID of Transaction that the TxOutput resides in
+// OutputIdx - idx of the TxOutput in the Transaction
+type Outpoint struct {
+	TxID      []byte
+	OutputIdx int
+}
+
+// RelativeLock gets the number of Blocks that must be added after the one containing the txo spent by the txin before
+// the txin may be placed in a Block, e.g. 1 for the next Block. This is the low bits of Sequence (SequenceLockMask),
+// unless SequenceDisableFlag is set, giving 0
+func (txin *TxInput) RelativeLock() int {
+	if txin.Sequence&SequenceDisableFlag != 0 {
+		return 0
+	}
+	return int(txin.Sequence & SequenceLockMask)
 }
 
 // UsesKey determines whether the pubKeyHash provided is the owner of the output referenced by txin
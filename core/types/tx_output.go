@@ -2,27 +2,88 @@ package types
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/gob"
+	"errors"
 
 	"github.com/danitello/go-blockchain/common/errutil"
 	"github.com/danitello/go-blockchain/wallet"
-	"github.com/danitello/go-blockchain/wallet/walletutil"
 )
 
-// TxOutput specifies amount being made available in a block to a wallet
+// compactTxOutputs is the first byte of TxOutputs serialized by Serialize. A gob stream never starts with 0, so it
+// tells them apart from TxOutputs written with gob before Serialize existed
+const compactTxOutputs = byte(0x00)
+
+// ErrBadOutputEncoding is returned when decoding a txo or TxOutputs that was not written by Serialize
+var ErrBadOutputEncoding = errors.New("Malformed output encoding")
+
+// PubKeyHashLen is the length of the PubKeyHash of a txo locked to a single key (a ripemd160 hash)
+const PubKeyHashLen = 20
+
+// ScriptType is the kind of lock on a txo, which determines what can spend it
+type ScriptType int
+
+const (
+	// ScriptP2PKH is a txo locked to the key with its PubKeyHash, spendable by a single Wallet
+	ScriptP2PKH ScriptType = iota
+	// ScriptNonstandard is a txo no Wallet can spend
+	ScriptNonstandard
+	// ScriptHTLC is a hash time locked txo, see InitHTLCOutput
+	ScriptHTLC
+	// ScriptData is an unspendable txo carrying data, see InitDataOutput
+	ScriptData
+)
+
+// TxOutput specifies amount being made available in a block to a wallet -
+// HashLock, Timeout, RefundPubKeyHash - only set for a ScriptHTLC txo, see InitHTLCOutput
 type TxOutput struct {
-	Amount     int
-	PubKeyHash []byte
+	Amount           int
+	PubKeyHash       []byte
+	HashLock         []byte
+	Timeout          int
+	RefundPubKeyHash []byte
 }
 
-// TxOutputs groups txos (for serialization)
+// TxOutputs groups txos of a Transaction (for serialization) -
+// Outputs - the txos
+// Indices - idx of each txo in the Transaction, as a group may hold only some of them
+// Types - ScriptType of each txo, so it needn't be worked out again
+// Height - index of the Block containing the Transaction
 type TxOutputs struct {
 	Outputs []TxOutput
+	Indices []int
+	Types   []ScriptType
+	Height  int
+}
+
+// Add appends a txo along with its idx in the Transaction
+func (txos *TxOutputs) Add(txo TxOutput, idx int) {
+	txos.Outputs = append(txos.Outputs, txo)
+	txos.Indices = append(txos.Indices, idx)
+	txos.Types = append(txos.Types, txo.ScriptType())
+}
+
+// Type gets the ScriptType of the i-th txo of the group
+func (txos TxOutputs) Type(i int) ScriptType {
+	// Groups written before Types existed
+	if i >= len(txos.Types) {
+		return txos.Outputs[i].ScriptType()
+	}
+	return txos.Types[i]
+}
+
+// Index gets the idx in the Transaction of the i-th txo of the group
+func (txos TxOutputs) Index(i int) int {
+	// Groups written before Indices existed held every txo in order
+	if i >= len(txos.Indices) {
+		return i
+	}
+	return txos.Indices[i]
 }
 
 // InitTxOutput creates a new txo and locks it using a given address
 func InitTxOutput(amount int, address string) *TxOutput {
-	txo := &TxOutput{amount, nil}
+	txo := &TxOutput{Amount: amount}
 	txo.Lock([]byte(address))
 
 	return txo
@@ -30,9 +91,31 @@ func InitTxOutput(amount int, address string) *TxOutput {
 
 // Lock signs the TxOutput with a given address
 func (txo *TxOutput) Lock(address []byte) {
-	pubKeyHash := walletutil.Base58Decode(address)
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-wallet.ChecksumLen]
-	txo.PubKeyHash = pubKeyHash
+	txo.PubKeyHash = wallet.GetPubKeyHashFromAddress(string(address))
+}
+
+// ScriptType works out the kind of lock on the txo
+func (txo *TxOutput) ScriptType() ScriptType {
+	if len(txo.HashLock) > 0 {
+		return ScriptHTLC
+	}
+	if txo.isData() {
+		return ScriptData
+	}
+	if len(txo.PubKeyHash) == PubKeyHashLen {
+		return ScriptP2PKH
+	}
+	return ScriptNonstandard
+}
+
+// CanBeSpentBy determines whether a txin of a Transaction with a given LockTime meets the lock on the txo, other than
+// by its Signature - for a ScriptHTLC txo either the claim or the refund path, otherwise the key of the PubKeyHash
+func (txo *TxOutput) CanBeSpentBy(txin TxInput, lockTime int) bool {
+	if txo.ScriptType() == ScriptHTLC {
+		return txo.canClaim(txin) || txo.canRefund(txin, lockTime)
+	}
+
+	return txin.UsesKey(txo.PubKeyHash)
 }
 
 // IsLockedWithKey determines whether a given pubKeyHash is the one used to lock the txo
@@ -40,13 +123,183 @@ func (txo *TxOutput) IsLockedWithKey(pubKeyHash []byte) bool {
 	return bytes.Compare(txo.PubKeyHash, pubKeyHash) == 0
 }
 
-// DeserializeTxOutputs converts a []byte into []TxOutput
+// Serialize converts the txo into a compact []byte - the varint Amount followed by the uvarint length of the
+// PubKeyHash and the PubKeyHash itself. A ScriptHTLC txo continues with the HashLock, the uvarint Timeout, and the
+// RefundPubKeyHash, each hash written like the PubKeyHash
+func (txo TxOutput) Serialize() []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutVarint(buf, int64(txo.Amount))
+	buf = appendBytes(buf[:n], txo.PubKeyHash)
+
+	if txo.ScriptType() == ScriptHTLC {
+		buf = appendBytes(buf, txo.HashLock)
+		varint := make([]byte, binary.MaxVarintLen64)
+		n := binary.PutUvarint(varint, uint64(txo.Timeout))
+		buf = appendBytes(append(buf, varint[:n]...), txo.RefundPubKeyHash)
+	}
+
+	return buf
+}
+
+// appendBytes appends the uvarint length of b and b itself to buf
+func appendBytes(buf, b []byte) []byte {
+	varint := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(varint, uint64(len(b)))
+
+	return append(append(buf, varint[:n]...), b...)
+}
+
+// DeserializeOutput converts a []byte written by TxOutput.Serialize back into a txo
+func DeserializeOutput(data []byte) (TxOutput, error) {
+	txo, n, err := readOutput(data, false)
+	if err == nil && n < len(data) {
+		txo, n, err = readOutput(data, true)
+	}
+	if err != nil {
+		return TxOutput{}, err
+	}
+	if n != len(data) {
+		return TxOutput{}, ErrBadOutputEncoding
+	}
+
+	return txo, nil
+}
+
+// readOutput decodes the txo at the start of data, returning it along with the number of bytes it took up -
+// htlc - whether the txo is a ScriptHTLC txo, so has more fields to read
+func readOutput(data []byte, htlc bool) (TxOutput, int, error) {
+	amount, n := binary.Varint(data)
+	if n <= 0 {
+		return TxOutput{}, 0, ErrBadOutputEncoding
+	}
+	txo := TxOutput{Amount: int(amount)}
+
+	var m int
+	var err error
+	if txo.PubKeyHash, m, err = readBytes(data[n:]); err != nil {
+		return TxOutput{}, 0, err
+	}
+	n += m
+
+	if htlc {
+		if txo.HashLock, m, err = readBytes(data[n:]); err != nil {
+			return TxOutput{}, 0, err
+		}
+		n += m
+
+		timeout, m := binary.Uvarint(data[n:])
+		if m <= 0 {
+			return TxOutput{}, 0, ErrBadOutputEncoding
+		}
+		txo.Timeout = int(timeout)
+		n += m
+
+		if txo.RefundPubKeyHash, m, err = readBytes(data[n:]); err != nil {
+			return TxOutput{}, 0, err
+		}
+		n += m
+	}
+
+	return txo, n, nil
+}
+
+// readBytes decodes a []byte written by appendBytes at the start of data, returning it (nil if empty) along with the
+// number of bytes it took up
+func readBytes(data []byte) ([]byte, int, error) {
+	length, n := binary.Uvarint(data)
+	if n <= 0 || length > uint64(len(data)-n) {
+		return nil, 0, ErrBadOutputEncoding
+	}
+	if length == 0 {
+		return nil, n, nil
+	}
+
+	return append([]byte{}, data[n:n+int(length)]...), n + int(length), nil
+}
+
+// Serialize converts the group into a compact []byte for the UTXO set - compactTxOutputs and the uvarint Height and
+// number of txos, then for each txo its uvarint idx and ScriptType followed by the txo as written by TxOutput.Serialize
+func (txos TxOutputs) Serialize() []byte {
+	buf := []byte{compactTxOutputs}
+	varint := make([]byte, binary.MaxVarintLen64)
+	putUvarint := func(v int) {
+		n := binary.PutUvarint(varint, uint64(v))
+		buf = append(buf, varint[:n]...)
+	}
+
+	putUvarint(txos.Height)
+	putUvarint(len(txos.Outputs))
+	for i, txo := range txos.Outputs {
+		putUvarint(txos.Index(i))
+		putUvarint(int(txos.Type(i)))
+		buf = append(buf, txo.Serialize()...)
+	}
+
+	return buf
+}
+
+// DeserializeTxOutputs converts a []byte written by TxOutputs.Serialize, or with gob by earlier versions, into
+// TxOutputs
 func DeserializeTxOutputs(data []byte) TxOutputs {
 	var TXO TxOutputs
 
+	if len(data) > 0 && data[0] == compactTxOutputs {
+		TXO, err := readTxOutputs(data[1:])
+		errutil.Handle(err)
+		return TXO
+	}
+
 	decoder := gob.NewDecoder(bytes.NewReader(data))
 	err := decoder.Decode(&TXO)
 	errutil.Handle(err)
 
 	return TXO
 }
+
+// readTxOutputs decodes TxOutputs written by TxOutputs.Serialize, following the compactTxOutputs byte
+func readTxOutputs(data []byte) (TxOutputs, error) {
+	var TXO TxOutputs
+	readUvarint := func() (int, error) {
+		v, n := binary.Uvarint(data)
+		if n <= 0 {
+			return 0, ErrBadOutputEncoding
+		}
+		data = data[n:]
+		return int(v), nil
+	}
+
+	height, err := readUvarint()
+	if err != nil {
+		return TxOutputs{}, err
+	}
+	count, err := readUvarint()
+	if err != nil {
+		return TxOutputs{}, err
+	}
+	TXO.Height = height
+
+	for i := 0; i < count; i++ {
+		idx, err := readUvarint()
+		if err != nil {
+			return TxOutputs{}, err
+		}
+		scriptType, err := readUvarint()
+		if err != nil {
+			return TxOutputs{}, err
+		}
+		txo, n, err := readOutput(data, ScriptType(scriptType) == ScriptHTLC)
+		if err != nil {
+			return TxOutputs{}, err
+		}
+		data = data[n:]
+
+		TXO.Outputs = append(TXO.Outputs, txo)
+		TXO.Indices = append(TXO.Indices, idx)
+		TXO.Types = append(TXO.Types, ScriptType(scriptType))
+	}
+	if len(data) != 0 {
+		return TxOutputs{}, ErrBadOutputEncoding
+	}
+
+	return TXO, nil
+}
@@ -0,0 +1,159 @@
+package types
+
+import (
+	"bytes"
+	"encoding/gob"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/danitello/go-blockchain/wallet"
+)
+
+// testOutputs makes one txo of each ScriptType Serialize handles differently
+func testOutputs(t testing.TB) []TxOutput {
+	t.Helper()
+
+	w, err := wallet.InitWalletFromReader(rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := string(w.GetAddress())
+
+	data, err := InitDataOutput([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return []TxOutput{
+		*InitTxOutput(Reward, address),
+		*InitHTLCOutput(Reward/2, address, address, HashSecret([]byte("secret")), 100),
+		*data,
+		{Amount: 0}, // nonstandard, with no PubKeyHash at all
+	}
+}
+
+func TestOutputRoundTrip(t *testing.T) {
+	for _, txo := range testOutputs(t) {
+		got, err := DeserializeOutput(txo.Serialize())
+		if err != nil {
+			t.Fatalf("Deserializing %+v: %s", txo, err)
+		}
+		if !reflect.DeepEqual(got, txo) {
+			t.Fatalf("Got %+v, want %+v", got, txo)
+		}
+	}
+}
+
+func TestTxOutputsRoundTrip(t *testing.T) {
+	var txos TxOutputs
+	for i, txo := range testOutputs(t) {
+		txos.Add(txo, i*2) // not every idx, as in a partly spent group
+	}
+	txos.Height = 1234
+
+	got := DeserializeTxOutputs(txos.Serialize())
+	if !reflect.DeepEqual(got, txos) {
+		t.Fatalf("Got %+v, want %+v", got, txos)
+	}
+}
+
+func TestDeserializeTxOutputsGob(t *testing.T) {
+	var txos TxOutputs
+	for i, txo := range testOutputs(t)[:2] {
+		txos.Add(txo, i)
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(txos); err != nil {
+		t.Fatal(err)
+	}
+
+	got := DeserializeTxOutputs(buf.Bytes())
+	if !reflect.DeepEqual(got, txos) {
+		t.Fatalf("Got %+v, want %+v", got, txos)
+	}
+}
+
+func TestDeserializeOutputMalformed(t *testing.T) {
+	data := testOutputs(t)[1].Serialize()
+	for _, bad := range [][]byte{nil, data[:len(data)-1], append(data, 0, 0)} {
+		if _, err := DeserializeOutput(bad); err != ErrBadOutputEncoding {
+			t.Fatalf("Got %v deserializing %x, want ErrBadOutputEncoding", err, bad)
+		}
+	}
+
+	txos := TxOutputs{}
+	txos.Add(testOutputs(t)[0], 0)
+	data = txos.Serialize()
+	if _, err := readTxOutputs(data[1 : len(data)-1]); err != ErrBadOutputEncoding {
+		t.Fatalf("Got %v reading truncated TxOutputs, want ErrBadOutputEncoding", err)
+	}
+}
+
+// benchUTXOs is the number of groups in the UTXO set of the serialization benchmarks
+const benchUTXOs = 10000
+
+// benchUTXOSet makes a UTXO set of benchUTXOs groups of two P2PKH txos, as a payment and its change
+func benchUTXOSet(b *testing.B) []TxOutputs {
+	b.Helper()
+
+	txo := testOutputs(b)[0]
+	set := make([]TxOutputs, benchUTXOs)
+	for i := range set {
+		set[i].Add(txo, 0)
+		set[i].Add(txo, 1)
+		set[i].Height = i
+	}
+
+	return set
+}
+
+// BenchmarkSerializeTxOutputs writes a UTXO set with Serialize and with gob, reporting the bytes stored per group
+func BenchmarkSerializeTxOutputs(b *testing.B) {
+	set := benchUTXOSet(b)
+
+	b.Run("compact", func(b *testing.B) {
+		size := 0
+		for i := 0; i < b.N; i++ {
+			size = len(set[i%benchUTXOs].Serialize())
+		}
+		b.ReportMetric(float64(size), "bytes/group")
+	})
+
+	b.Run("gob", func(b *testing.B) {
+		size := 0
+		for i := 0; i < b.N; i++ {
+			var buf bytes.Buffer
+			if err := gob.NewEncoder(&buf).Encode(set[i%benchUTXOs]); err != nil {
+				b.Fatal(err)
+			}
+			size = buf.Len()
+		}
+		b.ReportMetric(float64(size), "bytes/group")
+	})
+}
+
+// BenchmarkDeserializeTxOutputs reads back a UTXO set written with Serialize and with gob
+func BenchmarkDeserializeTxOutputs(b *testing.B) {
+	set := benchUTXOSet(b)
+	compact := make([][]byte, benchUTXOs)
+	gobs := make([][]byte, benchUTXOs)
+	for i, txos := range set {
+		compact[i] = txos.Serialize()
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(txos); err != nil {
+			b.Fatal(err)
+		}
+		gobs[i] = buf.Bytes()
+	}
+
+	for name, data := range map[string][][]byte{"compact": compact, "gob": gobs} {
+		data := data
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				DeserializeTxOutputs(data[i%benchUTXOs])
+			}
+		})
+	}
+}
@@ -2,7 +2,9 @@ package core
 
 import (
 	"bytes"
+	"encoding/gob"
 	"encoding/hex"
+	"fmt"
 
 	"github.com/danitello/go-blockchain/common/byteutil"
 	"github.com/danitello/go-blockchain/common/errutil"
@@ -13,29 +15,95 @@ import (
 
 var (
 	utxoPrefix = []byte("utxo-")
+
+	// reindexKey is present in the db while a Reindex is underway
+	reindexKey = []byte("reindexInProgress")
+
+	// undoPrefix prefixes the db key of the txos spent by a Block -> value is the serialized []spentOutput
+	undoPrefix = []byte("undo-")
 )
 
+// spentOutput is a utxo spent by a Block, kept so it can be restored if the Block is disconnected -
+// TxID - ID of the Transaction the txo resides in
+// Index - idx of the txo in the Transaction
+// Height - index of the Block containing the Transaction
+type spentOutput struct {
+	TxID   []byte
+	Index  int
+	Output types.TxOutput
+	Height int
+}
+
 // utxo_set is additional database functions for BlockChain involving the running collection of current utxos
 
-// Reindex deletes the current UTXOSet and establishes a new one
-func (bc *BlockChain) Reindex() {
+// Reindex deletes the current UTXOSet and establishes a new one -
+// progress - if not nil, called with the number of Blocks scanned so far and the total
+// A marker is kept in the db until the new UTXOSet is written, so an interrupted Reindex is rerun by GetBlockChain
+func (bc *BlockChain) Reindex(progress func(done, total int)) error {
+	if err := bc.beginWrite(); err != nil {
+		return err
+	}
+	defer bc.inFlight.Done()
+	bc.tipMu.Lock()
+	defer bc.tipMu.Unlock()
+
+	return bc.reindex(progress)
+}
+
+// reindex does the work of Reindex for callers already registered with beginWrite
+func (bc *BlockChain) reindex(progress func(done, total int)) error {
+	UTXO, err := bc.getUTXO(progress)
+	if err != nil {
+		return err
+	}
+
+	err = bc.ChainDB.Database.Update(func(txn *badger.Txn) error {
+		return txn.Set(reindexKey, []byte{})
+	})
+	if err != nil {
+		return err
+	}
+
 	bc.DeleteWithKeyPrefix(utxoPrefix)
 
-	err := bc.ChainDB.Database.Update(func(txn *badger.Txn) error {
-		for txID, txos := range bc.GetUTXO() {
+	return bc.ChainDB.Database.Update(func(txn *badger.Txn) error {
+		for txID, txos := range UTXO {
 			key, err := hex.DecodeString(txID)
 			if err != nil {
 				return err
 			}
 			key = append(utxoPrefix, key...)
 
-			err = txn.Set(key, byteutil.Serialize(txos))
-			errutil.Handle(err)
+			err = txn.Set(key, txos.Serialize())
+			if err != nil {
+				return err
+			}
 		}
 
-		return nil
+		return txn.Delete(reindexKey)
+	})
+}
+
+// ReindexInProgress determines whether a Reindex was started but never completed
+func (bc *BlockChain) ReindexInProgress() bool {
+	return bc.hasKey(reindexKey)
+}
+
+// hasKey determines whether a key is present in the db
+func (bc *BlockChain) hasKey(key []byte) bool {
+	found := false
+
+	err := bc.ChainDB.Database.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(key)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		found = err == nil
+		return err
 	})
 	errutil.Handle(err)
+
+	return found
 }
 
 // DeleteWithKeyPrefix deletes all data whose key is prefixed by a given value
@@ -82,56 +150,163 @@ func (bc *BlockChain) DeleteWithKeyPrefix(prefix []byte) {
 	})
 }
 
-// UpdateUTXOSet manages adding and deleting tx references in set resulting from new Block
-func (bc *BlockChain) UpdateUTXOSet(block *types.Block) {
-	err := bc.ChainDB.Database.Update(func(txn *badger.Txn) error {
-		for _, tx := range block.Transactions {
-			if tx.IsCoinbase() == false {
-				for _, txin := range tx.Inputs {
-					updatedTXO := types.TxOutputs{}
-					dbID := append(utxoPrefix, txin.TxID...)
-					item, err := txn.Get(dbID)
-					errutil.Handle(err)
-					v, err := item.Value()
-					errutil.Handle(err)
-
-					TXO := types.DeserializeTxOutputs(v)
-
-					for txoIdx, txo := range TXO.Outputs {
-						if txoIdx != txin.OutputIdx {
-							updatedTXO.Outputs = append(updatedTXO.Outputs, txo)
-						}
-					}
+// UpdateUTXOSet manages adding and deleting tx references in set resulting from new Block.
+// The utxos the Block spends are kept so undoUTXOSet can restore them. The whole Block is applied in one db
+// transaction, so if any of it fails, e.g. a txin spending a txo that isn't in the set, none of it is applied
+func (bc *BlockChain) UpdateUTXOSet(block *types.Block) error {
+	return bc.ChainDB.Database.Update(func(txn *badger.Txn) error {
+		return bc.updateUTXOSet(txn, block)
+	})
+}
+
+// updateUTXOSet does the work of UpdateUTXOSet within a db transaction, which the caller must discard on error
+func (bc *BlockChain) updateUTXOSet(txn *badger.Txn, block *types.Block) error {
+	var spent []spentOutput
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() == false {
+			for _, txin := range tx.Inputs {
+				dbID := append(utxoPrefix, txin.TxID...)
+				item, err := txn.Get(dbID)
+				if err == badger.ErrKeyNotFound {
+					return &OutpointError{types.Outpoint{TxID: txin.TxID, OutputIdx: txin.OutputIdx}, ErrMissingUTXO}
+				} else if err != nil {
+					return err
+				}
+				v, err := item.Value()
+				if err != nil {
+					return err
+				}
 
-					if len(updatedTXO.Outputs) == 0 {
-						err := txn.Delete(dbID) // No more UTXO
-						errutil.Handle(err)
+				TXO := types.DeserializeTxOutputs(v)
+
+				found := false
+				updatedTXO := types.TxOutputs{Height: TXO.Height}
+				for i, txo := range TXO.Outputs {
+					if TXO.Index(i) != txin.OutputIdx {
+						updatedTXO.Add(txo, TXO.Index(i))
 					} else {
-						err := txn.Set(dbID, byteutil.Serialize(updatedTXO))
-						errutil.Handle(err)
+						found = true
+						spent = append(spent, spentOutput{txin.TxID, txin.OutputIdx, txo, TXO.Height})
 					}
 				}
+				if !found {
+					return &OutpointError{types.Outpoint{TxID: txin.TxID, OutputIdx: txin.OutputIdx}, ErrMissingUTXO}
+				}
+
+				if len(updatedTXO.Outputs) == 0 {
+					err = txn.Delete(dbID) // No more UTXO
+				} else {
+					err = txn.Set(dbID, updatedTXO.Serialize())
+				}
+				if err != nil {
+					return err
+				}
 			}
-			newTXO := types.TxOutputs{}
-			for _, txo := range tx.Outputs {
-				newTXO.Outputs = append(newTXO.Outputs, txo) // Just go ahead and add them
+		}
+		newTXO := types.TxOutputs{Height: block.Index}
+		for txoIdx, txo := range tx.Outputs {
+			newTXO.Add(txo, txoIdx) // Just go ahead and add them
+		}
+
+		dbID := append(utxoPrefix, tx.ID...)
+		if err := txn.Set(dbID, newTXO.Serialize()); err != nil {
+			return err
+		}
+	}
+
+	return txn.Set(append(undoPrefix, block.Hash...), byteutil.Serialize(spent))
+}
+
+// undoUTXOSet reverses UpdateUTXOSet for a Block within a db transaction, removing the utxos it created and
+// restoring the ones it spent
+func (bc *BlockChain) undoUTXOSet(txn *badger.Txn, block *types.Block) error {
+	spent, err := readUndo(txn, block)
+	if err != nil {
+		return err
+	}
+
+	// Group the spent txos by the Transaction spending them, in the order UpdateUTXOSet recorded them
+	spentBy := make([][]spentOutput, len(block.Transactions))
+	next := 0
+	for txIdx, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			continue
+		}
+		for range tx.Inputs {
+			spentBy[txIdx] = append(spentBy[txIdx], spent[next])
+			next++
+		}
+	}
+
+	// Newest tx first, so a txo created and spent within the Block is restored and then removed along with its tx
+	for txIdx := len(block.Transactions) - 1; txIdx >= 0; txIdx-- {
+		if err := txn.Delete(append(utxoPrefix, block.Transactions[txIdx].ID...)); err != nil {
+			return err
+		}
+
+		for _, so := range spentBy[txIdx] {
+			dbID := append(utxoPrefix, so.TxID...)
+			TXO := types.TxOutputs{Height: so.Height}
+
+			item, err := txn.Get(dbID)
+			if err == nil {
+				v, err := item.Value()
+				if err != nil {
+					return err
+				}
+				TXO = types.DeserializeTxOutputs(v)
+			} else if err != badger.ErrKeyNotFound {
+				return err
 			}
 
-			dbID := append(utxoPrefix, tx.ID...)
-			err := txn.Set(dbID, byteutil.Serialize(newTXO))
-			errutil.Handle(err)
+			TXO.Add(so.Output, so.Index)
+			if err := txn.Set(dbID, TXO.Serialize()); err != nil {
+				return err
+			}
 		}
+	}
 
-		return nil
-	})
-	errutil.Handle(err)
-	bc.Reindex()
+	return txn.Delete(append(undoPrefix, block.Hash...))
+}
+
+// readUndo gets the txos spent by a Block within a db transaction, in the order updateUTXOSet recorded them, checking
+// there is one for each txin of the Block
+func readUndo(txn *badger.Txn, block *types.Block) ([]spentOutput, error) {
+	item, err := txn.Get(append(undoPrefix, block.Hash...))
+	if err == badger.ErrKeyNotFound {
+		return nil, fmt.Errorf("No undo data for block %x", block.Hash) // connected before undo data was kept
+	} else if err != nil {
+		return nil, err
+	}
+	v, err := item.Value()
+	if err != nil {
+		return nil, err
+	}
+
+	var spent []spentOutput
+	if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&spent); err != nil {
+		return nil, err
+	}
+
+	txins := 0
+	for _, tx := range block.Transactions {
+		if !tx.IsCoinbase() {
+			txins += len(tx.Inputs)
+		}
+	}
+	if len(spent) < txins {
+		return nil, fmt.Errorf("Undo data for block %x is incomplete", block.Hash)
+	}
+
+	return spent, nil
 }
 
-// GetUTXOWithPubKey gets utxos owned by a pub key hash with a total balance up to a given amount
+// GetUTXOWithPubKey gets utxos owned by a pub key hash with a total balance up to a given amount.
+// utxos with fewer than bc.MinConfirmations confirmations, or that aren't ScriptP2PKH, are left out
 func (bc *BlockChain) GetUTXOWithPubKey(pubKeyHash []byte, max int) (map[string][]int, int) {
 	UTXO := make(map[string][]int)
 	balance := 0
+	_, tip := bc.Tip()
 
 	err := bc.ChainDB.Database.View(func(txn *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
@@ -148,11 +323,17 @@ func (bc *BlockChain) GetUTXOWithPubKey(pubKeyHash []byte, max int) (map[string]
 			k = bytes.TrimPrefix(k, utxoPrefix)
 			txID := hex.EncodeToString(k)
 			TXO := types.DeserializeTxOutputs(v)
+			if confirmations(TXO.Height, tip) < bc.MinConfirmations {
+				continue
+			}
 
-			for txoIdx, txo := range TXO.Outputs {
+			for i, txo := range TXO.Outputs {
+				if TXO.Type(i) != types.ScriptP2PKH {
+					continue // a single key can't spend it
+				}
 				if txo.IsLockedWithKey(pubKeyHash) && balance < max {
 					balance += txo.Amount
-					UTXO[txID] = append(UTXO[txID], txoIdx)
+					UTXO[txID] = append(UTXO[txID], TXO.Index(i))
 				}
 			}
 		}
@@ -163,8 +344,118 @@ func (bc *BlockChain) GetUTXOWithPubKey(pubKeyHash []byte, max int) (map[string]
 	return UTXO, balance
 }
 
+// Confirmations gets the number of Blocks from the one with a given index up to the most recent one, inclusive
+func (bc *BlockChain) Confirmations(height int) int {
+	_, tip := bc.Tip()
+	return confirmations(height, tip)
+}
+
+// confirmations gets the number of Blocks from the one with a given index up to the one with index tip, inclusive
+func confirmations(height, tip int) int {
+	return tip + 1 - height
+}
+
+// GetUTXOWithOutpoint gets the txo at a given idx of the Transaction with a given ID, if it is unspent
+func (bc *BlockChain) GetUTXOWithOutpoint(txID []byte, outputIdx int) (types.TxOutput, bool) {
+	var resTxo types.TxOutput
+	found := false
+
+	err := bc.ChainDB.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(append(utxoPrefix, txID...))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		v, err := item.Value()
+		if err != nil {
+			return err
+		}
+
+		TXO := types.DeserializeTxOutputs(v)
+		for i, txo := range TXO.Outputs {
+			if TXO.Index(i) == outputIdx {
+				resTxo, found = txo, true
+			}
+		}
+		return nil
+	})
+	errutil.Handle(err)
+
+	return resTxo, found
+}
+
+// GetOutput resolves an outpoint, getting the txo at a given idx of the Transaction with a given ID and whether it is
+// unspent. Returns ErrTxNotFound if there is no such Transaction, or ErrOutputNotFound if it has no such txo
+func (bc *BlockChain) GetOutput(txID []byte, outputIdx int) (*types.TxOutput, bool, error) {
+	tx, err := bc.GetTransactionWithID(txID)
+	if err != nil {
+		return nil, false, err
+	}
+	if outputIdx < 0 || outputIdx >= len(tx.Outputs) {
+		return nil, false, ErrOutputNotFound
+	}
+
+	_, unspent := bc.GetUTXOWithOutpoint(txID, outputIdx)
+	txo := tx.Outputs[outputIdx]
+
+	return &txo, unspent, nil
+}
+
+// getUTXOHeight gets the index of the Block containing the Transaction with a given ID, if it has any utxos
+func (bc *BlockChain) getUTXOHeight(txID []byte) (int, bool) {
+	height := 0
+	found := false
+
+	err := bc.ChainDB.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(append(utxoPrefix, txID...))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		v, err := item.Value()
+		if err != nil {
+			return err
+		}
+
+		height, found = types.DeserializeTxOutputs(v).Height, true
+		return nil
+	})
+	errutil.Handle(err)
+
+	return height, found
+}
+
+// GetSupply gets the total amount held by utxos, i.e. the number of coins in circulation
+func (bc *BlockChain) GetSupply() int {
+	supply := 0
+
+	err := bc.ChainDB.Database.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
+			v, err := it.Item().Value()
+			errutil.Handle(err)
+
+			for _, txo := range types.DeserializeTxOutputs(v).Outputs {
+				supply += txo.Amount
+			}
+		}
+		return nil
+	})
+	errutil.Handle(err)
+
+	return supply
+}
+
 // CountUTX gets the number of Transactions with UTXO in them
-func (bc BlockChain) CountUTX() int {
+func (bc *BlockChain) CountUTX() int {
 	count := 0
 
 	err := bc.ChainDB.Database.View(func(txn *badger.Txn) error {
@@ -0,0 +1,197 @@
+package core_test
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/danitello/go-blockchain/core"
+	"github.com/danitello/go-blockchain/core/testutil"
+	"github.com/danitello/go-blockchain/core/types"
+	"github.com/danitello/go-blockchain/wallet"
+)
+
+// utxoSet gets the UTXOSet entries in the db of bc
+func utxoSet(t *testing.T, bc *core.BlockChain) map[string]string {
+	t.Helper()
+	set := make(map[string]string)
+
+	for k, v := range dbSnapshot(t, bc) {
+		if strings.HasPrefix(k, "utxo-") {
+			set[k] = v
+		}
+	}
+
+	return set
+}
+
+func TestReindex(t *testing.T) {
+	bc, _ := testutil.BuildTestChain(t, 5)
+	want := utxoSet(t, bc)
+	count := bc.CountUTX()
+
+	bc.DeleteWithKeyPrefix([]byte("utxo-"))
+	if bc.CountUTX() != 0 {
+		t.Fatal("UTXOSet not cleared")
+	}
+
+	var progress [][2]int
+	err := bc.Reindex(func(done, total int) {
+		progress = append(progress, [2]int{done, total})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(progress) != 6 {
+		t.Fatalf("Progress reported %d times, want once for each of 6 blocks", len(progress))
+	}
+	for i, p := range progress {
+		if p != [2]int{i + 1, 6} {
+			t.Fatalf("Progress %d is %v, want %v", i, p, [2]int{i + 1, 6})
+		}
+	}
+	if got := utxoSet(t, bc); !reflect.DeepEqual(got, want) {
+		t.Fatal("rebuilt UTXOSet differs")
+	}
+	if bc.CountUTX() != count || bc.ReindexInProgress() {
+		t.Fatalf("%d transactions in the UTXOSet, want %d", bc.CountUTX(), count)
+	}
+}
+
+func TestInterruptedReindexResumes(t *testing.T) {
+	src, ws := testutil.BuildTestChain(t, 4)
+	dir, err := ioutil.TempDir("", "reindexchain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A copy of the test chain in a DataDir of its own, so it can be reopened
+	cfg := core.DefaultConfig()
+	cfg.DataDir = dir
+	cfg.Difficulty = types.TestDifficulty
+	bc := core.InitBlockChainWithConfig(ws.GetAddresses()[0], cfg)
+	hashes := chainHashes(t, src)
+	for _, hash := range hashes[1:] {
+		block, err := src.ChainDB.ReadBlockWithHash(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bc.AddBlock(block.Transactions); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := utxoSet(t, bc)
+
+	if err := bc.InterruptReindex(); err != nil {
+		t.Fatal(err)
+	}
+	if !bc.ReindexInProgress() || reflect.DeepEqual(utxoSet(t, bc), want) {
+		t.Fatal("Reindex not interrupted")
+	}
+	bc.ChainDB.CloseDB()
+
+	bc = core.GetBlockChainWithConfig(cfg)
+	defer bc.ChainDB.CloseDB()
+	if bc.ReindexInProgress() {
+		t.Fatal("Interrupted Reindex not resumed")
+	}
+	if got := utxoSet(t, bc); !reflect.DeepEqual(got, want) {
+		t.Fatal("Resumed Reindex built a different UTXOSet")
+	}
+}
+
+func TestMinConfirmations(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 2)
+	addresses := ws.GetAddresses()
+	w, err := wallet.InitWalletFromReader(rand.New(rand.NewSource(99)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKeyHash := wallet.HashPubKey(w.GetPubKey())
+	balance := func(minConfirmations int) int {
+		bc.MinConfirmations = minConfirmations
+		_, balance := bc.GetUTXOWithPubKey(pubKeyHash, math.MaxInt32)
+		return balance
+	}
+
+	tx := pay(t, bc, ws, addresses[0], string(w.GetAddress()), 3)
+	if err := bc.SubmitTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	if got := balance(0); got != 0 {
+		t.Fatalf("Spendable balance is %d with the payment unconfirmed", got)
+	}
+
+	addBlock(t, bc, addresses[0], tx)
+	if got := balance(2); got != 0 {
+		t.Fatalf("Spendable balance is %d with 1 confirmation of 2", got)
+	}
+	if got := balance(1); got != 3 {
+		t.Fatalf("Spendable balance is %d with 1 confirmation of 1, want 3", got)
+	}
+
+	addBlock(t, bc, addresses[0])
+	if got := balance(2); got != 3 {
+		t.Fatalf("Spendable balance is %d with 2 confirmations of 2, want 3", got)
+	}
+}
+
+func TestGetUTXOWithPubKeyOnlyP2PKH(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 2)
+	addresses := ws.GetAddresses()
+	w, err := wallet.InitWalletFromReader(rand.New(rand.NewSource(99)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	to := string(w.GetAddress())
+
+	// Pays to the key of w both plainly and behind a hash lock, which its key alone can't spend
+	tx := pay(t, bc, ws, addresses[0], to, 5)
+	htlc := types.InitHTLCOutput(2, to, addresses[0], types.HashSecret([]byte("secret")), 100)
+	change := tx.Outputs[1]
+	change.Amount -= htlc.Amount
+	tx.SetOutputs([]types.TxOutput{tx.Outputs[0], *htlc, change})
+	if err := bc.SignTransaction(tx, ws, addresses[0]); err != nil {
+		t.Fatal(err)
+	}
+	addBlock(t, bc, addresses[0], tx)
+
+	if htlcTxo, ok := bc.GetUTXOWithOutpoint(tx.ID, 1); !ok || htlcTxo.ScriptType() != types.ScriptHTLC {
+		t.Fatal("Hash locked output is not in the UTXO set")
+	}
+	utxos, balance := bc.GetUTXOWithPubKey(wallet.HashPubKey(w.GetPubKey()), math.MaxInt32)
+	if balance != 5 || !reflect.DeepEqual(utxos, map[string][]int{hex.EncodeToString(tx.ID): {0}}) {
+		t.Fatalf("Got %v with balance %d, want just the plain output of 5", utxos, balance)
+	}
+}
+
+func TestGetOutput(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 2)
+	addresses := ws.GetAddresses()
+	tx := pay(t, bc, ws, addresses[0], addresses[1], 5)
+	addBlock(t, bc, addresses[0], tx)
+	spent := tx.Inputs[0]
+
+	txo, unspent, err := bc.GetOutput(tx.ID, 0)
+	if err != nil || !unspent || !reflect.DeepEqual(*txo, tx.Outputs[0]) {
+		t.Fatalf("Got %+v, %v, %v for an unspent output, want %+v", txo, unspent, err, tx.Outputs[0])
+	}
+
+	txo, unspent, err = bc.GetOutput(spent.TxID, spent.OutputIdx)
+	if err != nil || unspent || !txo.IsLockedWithKey(wallet.HashPubKey(spent.PubKey)) {
+		t.Fatalf("Got %+v, %v, %v for a spent output", txo, unspent, err)
+	}
+
+	if _, _, err := bc.GetOutput(tx.ID, len(tx.Outputs)); err != core.ErrOutputNotFound {
+		t.Fatalf("Got %v past the last output, want ErrOutputNotFound", err)
+	}
+	if _, _, err := bc.GetOutput(make([]byte, 32), 0); err != core.ErrTxNotFound {
+		t.Fatalf("Got %v for a missing transaction, want ErrTxNotFound", err)
+	}
+}
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"math/big"
+
+	"github.com/danitello/go-blockchain/core/types"
+
+	"github.com/dgraph-io/badger"
+)
+
+// workPrefix prefixes the db key of a Block's hash -> value is the cumulative work of the chain ending in the Block
+var workPrefix = []byte("work-")
+
+// BlockWork gets the work of a Block at a given difficulty, the number of hashes finding its proof takes on average.
+// Each extra leading zero bit doubles it
+func BlockWork(difficulty int) *big.Int {
+	if difficulty < 0 {
+		difficulty = 0
+	}
+	return new(big.Int).Lsh(big.NewInt(1), uint(difficulty))
+}
+
+// CumulativeWork gets the total BlockWork of the Block with a given hash and every Block before it back to the genesis
+// Block. The BlockChain with the most of it, rather than the most Blocks, is the one to follow, as many easy Blocks
+// are cheaper to mine than a few hard ones. It is stored as each Block is connected, and worked out from the headers
+// for Blocks connected before it was
+func (bc *BlockChain) CumulativeWork(hash []byte) (*big.Int, error) {
+	var work *big.Int
+	err := bc.ChainDB.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(append(workPrefix, hash...))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		v, err := item.Value()
+		if err != nil {
+			return err
+		}
+		work = new(big.Int).SetBytes(v)
+		return nil
+	})
+	if err != nil || work != nil {
+		return work, err
+	}
+
+	header, err := bc.ChainDB.ReadHeaderWithHash(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return bc.headerWork(header)
+}
+
+// headerWork gets the CumulativeWork of the chain ending in the Block of a header, whether or not the Block is stored
+func (bc *BlockChain) headerWork(header *types.BlockHeader) (*big.Int, error) {
+	work := BlockWork(header.Difficulty)
+	if len(header.PrevHash) == 0 {
+		return work, nil
+	}
+
+	prevWork, err := bc.CumulativeWork(header.PrevHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return work.Add(work, prevWork), nil
+}
+
+// storeWork records the CumulativeWork of a Block within a db transaction
+func storeWork(txn *badger.Txn, hash []byte, work *big.Int) error {
+	return txn.Set(append(workPrefix, hash...), work.Bytes())
+}
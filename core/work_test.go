@@ -0,0 +1,50 @@
+package core_test
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/danitello/go-blockchain/core"
+	"github.com/danitello/go-blockchain/core/testutil"
+)
+
+func TestForkChoiceByWork(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 3)
+	address := ws.GetAddresses()[0]
+	hashes := chainHashes(t, bc)
+	easy := bc.Difficulty
+
+	forkWork, err := bc.CumulativeWork(hashes[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	chainWork := new(big.Int).Add(forkWork, new(big.Int).Mul(core.BlockWork(easy), big.NewInt(2)))
+	if work, err := bc.CumulativeWork(hashes[3]); err != nil || work.Cmp(chainWork) != 0 {
+		t.Fatalf("Got work %v, %v for the tip, want %s", work, err, chainWork)
+	}
+
+	// One Block at 4 times the work beats the 2 it replaces
+	bc.Difficulty = easy + 2
+	hard := branchFrom(t, bc, address, hashes[1], 1, 1)
+	if err := bc.Reorganize(hard); err != nil {
+		t.Fatalf("Got %v for a shorter branch with more work", err)
+	}
+	hardWork := new(big.Int).Add(forkWork, core.BlockWork(easy+2))
+	if lastHash, tip := bc.Tip(); !bytes.Equal(lastHash, hard[0].Hash) || tip != 2 {
+		t.Fatal("Shorter branch with more work not adopted")
+	}
+	if work, err := bc.CumulativeWork(hard[0].Hash); err != nil || work.Cmp(hardWork) != 0 {
+		t.Fatalf("Got work %v, %v for the new tip, want %s", work, err, hardWork)
+	}
+
+	// 3 easy Blocks are longer but have less work
+	bc.Difficulty = easy
+	long := branchFrom(t, bc, address, hashes[1], 1, 3)
+	if err := bc.Reorganize(long); err != core.ErrNotMoreWork {
+		t.Fatalf("Got %v for a longer branch with less work, want ErrNotMoreWork", err)
+	}
+	if lastHash, _ := bc.Tip(); !bytes.Equal(lastHash, hard[0].Hash) {
+		t.Fatal("Longer branch with less work adopted")
+	}
+}
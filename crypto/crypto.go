@@ -0,0 +1,15 @@
+package crypto
+
+import "crypto/sha256"
+
+// Hash256 computes the sha256 hash of data
+func Hash256(data []byte) []byte {
+	hash := sha256.Sum256(data)
+
+	return hash[:]
+}
+
+// DoubleHash256 computes the sha256 hash of the sha256 hash of data
+func DoubleHash256(data []byte) []byte {
+	return Hash256(Hash256(data))
+}
@@ -0,0 +1,32 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestHashVectors(t *testing.T) {
+	tests := []struct {
+		data         string
+		hash, double string
+	}{
+		{"",
+			"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			"5df6e0e2761359d30a8275058e299fcc0381534545f55cf43e41983f5d4c9456"},
+		{"abc",
+			"ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+			"4f8b42c22dd3729b519ba6f68d2da7cc5b2d606d05daed5ad5128cc03e6c6358"},
+		{"hello",
+			"2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+			"9595c9df90075148eb06860365df33584b75bff782a510c6cd4883a419833d50"},
+	}
+
+	for _, test := range tests {
+		if got := hex.EncodeToString(Hash256([]byte(test.data))); got != test.hash {
+			t.Errorf("Hash256(%q) = %s, want %s", test.data, got, test.hash)
+		}
+		if got := hex.EncodeToString(DoubleHash256([]byte(test.data))); got != test.double {
+			t.Errorf("DoubleHash256(%q) = %s, want %s", test.data, got, test.double)
+		}
+	}
+}
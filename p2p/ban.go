@@ -0,0 +1,117 @@
+package p2p
+
+import (
+	"sync"
+	"time"
+)
+
+// Ban scores added by Misbehaving for each protocol violation
+const (
+	// ScoreInvalidPoW is for a Block whose hash doesn't meet its difficulty
+	ScoreInvalidPoW = 100
+	// ScoreBadSignature is for a Transaction with a signature that fails to verify
+	ScoreBadSignature = 100
+	// ScoreMalformedMessage is for a message that can't be decoded
+	ScoreMalformedMessage = 20
+)
+
+const (
+	// DefaultBanThreshold is the ban score at which a peer is banned
+	DefaultBanThreshold = 100
+	// DefaultMisbehaviorBanDuration is how long a peer banned for its ban score stays banned
+	DefaultMisbehaviorBanDuration = 24 * time.Hour
+)
+
+// BanList keeps the ban score and any ban of each peer, by address -
+// Threshold - ban score at which Misbehaving bans a peer
+// BanDuration - how long Misbehaving bans a peer for
+type BanList struct {
+	Threshold   int
+	BanDuration time.Duration
+
+	mu     sync.Mutex
+	scores map[string]int
+	bans   map[string]time.Time // expiry of each ban
+	now    func() time.Time
+}
+
+// InitBanList creates a new BanList with DefaultBanThreshold and DefaultMisbehaviorBanDuration
+func InitBanList() *BanList {
+	return &BanList{
+		Threshold:   DefaultBanThreshold,
+		BanDuration: DefaultMisbehaviorBanDuration,
+		scores:      make(map[string]int),
+		bans:        make(map[string]time.Time),
+		now:         time.Now}
+}
+
+// Misbehaving adds score to the ban score of the peer at addr, banning it for BanDuration once the ban score reaches
+// Threshold. Returns whether the peer is banned, so should be disconnected
+func (bl *BanList) Misbehaving(addr string, score int) bool {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+
+	if bl.isBanned(addr) {
+		return true
+	}
+
+	bl.scores[addr] += score
+	if bl.scores[addr] < bl.Threshold {
+		return false
+	}
+
+	bl.ban(addr, bl.BanDuration)
+	return true
+}
+
+// BanScore gets the ban score of the peer at addr, which is reset when it is banned
+func (bl *BanList) BanScore(addr string) int {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+
+	return bl.scores[addr]
+}
+
+// BanPeer bans the peer at addr for duration, replacing any ban it has
+func (bl *BanList) BanPeer(addr string, duration time.Duration) {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+
+	bl.ban(addr, duration)
+}
+
+// ban records the ban of the peer at addr and resets its ban score. bl.mu must be held
+func (bl *BanList) ban(addr string, duration time.Duration) {
+	delete(bl.scores, addr)
+	bl.bans[addr] = bl.now().Add(duration)
+}
+
+// IsBanned determines whether the peer at addr is banned
+func (bl *BanList) IsBanned(addr string) bool {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+
+	return bl.isBanned(addr)
+}
+
+// isBanned determines whether the peer at addr is banned, forgetting its ban if it has expired. bl.mu must be held
+func (bl *BanList) isBanned(addr string) bool {
+	expiry, ok := bl.bans[addr]
+	if !ok {
+		return false
+	}
+	if bl.now().Before(expiry) {
+		return true
+	}
+
+	delete(bl.bans, addr)
+	return false
+}
+
+// Unban lifts any ban of the peer at addr
+func (bl *BanList) Unban(addr string) {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+
+	delete(bl.bans, addr)
+}
@@ -0,0 +1,69 @@
+package p2p
+
+import (
+	"testing"
+	"time"
+)
+
+// testBanList makes a BanList whose time is kept by a testClock
+func testBanList() (*BanList, *testClock) {
+	clock := &testClock{t: time.Unix(0, 0)}
+	bl := InitBanList()
+	bl.now = clock.now
+	return bl, clock
+}
+
+func TestMisbehavingBans(t *testing.T) {
+	bl, clock := testBanList()
+
+	for i := 0; i < 4; i++ {
+		if bl.Misbehaving("peer", ScoreMalformedMessage) {
+			t.Fatalf("Banned after %d malformed messages", i+1)
+		}
+	}
+	if got := bl.BanScore("peer"); got != 4*ScoreMalformedMessage {
+		t.Fatalf("Got ban score %d, want %d", got, 4*ScoreMalformedMessage)
+	}
+	if !bl.Misbehaving("peer", ScoreMalformedMessage) || !bl.IsBanned("peer") {
+		t.Fatal("Not banned at the threshold")
+	}
+	if bl.BanScore("peer") != 0 {
+		t.Fatal("Ban score not reset by the ban")
+	}
+	if bl.IsBanned("other") {
+		t.Fatal("Another peer is banned")
+	}
+
+	clock.advance(bl.BanDuration - time.Second)
+	if !bl.IsBanned("peer") {
+		t.Fatal("Ban expired early")
+	}
+	clock.advance(time.Second)
+	if bl.IsBanned("peer") {
+		t.Fatal("Ban didn't expire")
+	}
+
+	// A single severe violation is enough
+	if !bl.Misbehaving("peer", ScoreInvalidPoW) {
+		t.Fatal("Not banned for invalid PoW")
+	}
+}
+
+func TestBanPeer(t *testing.T) {
+	bl, clock := testBanList()
+
+	bl.BanPeer("peer", time.Minute)
+	if !bl.IsBanned("peer") {
+		t.Fatal("Not banned")
+	}
+	clock.advance(time.Minute)
+	if bl.IsBanned("peer") {
+		t.Fatal("Ban didn't expire")
+	}
+
+	bl.BanPeer("peer", time.Hour)
+	bl.Unban("peer")
+	if bl.IsBanned("peer") {
+		t.Fatal("Unbanned peer is banned")
+	}
+}
@@ -0,0 +1,70 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"time"
+)
+
+const (
+	// DefaultPort is the port peers listen on, given to seeds and resolved addresses without one
+	DefaultPort = "3000"
+	// DefaultSeedTimeout is how long DiscoverPeers waits for each seed to resolve
+	DefaultSeedTimeout = 10 * time.Second
+)
+
+// DefaultSeeds are the seeds a node bootstraps from when none are configured. A network with seed operators lists
+// their hostnames in its config instead; this fallback finds other nodes run on the same host, e.g. for testing
+var DefaultSeeds = []string{"localhost"}
+
+// ErrNoPeers is returned by DiscoverPeers when no seed resolves to an address
+var ErrNoPeers = errors.New("No seed resolved to a peer address")
+
+// Resolver looks up the addresses of a hostname, as net.Resolver does
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// DiscoverPeers resolves seeds with the system resolver, see DiscoverPeersWithResolver
+func DiscoverPeers(seeds []string) ([]string, error) {
+	return DiscoverPeersWithResolver(net.DefaultResolver, seeds)
+}
+
+// DiscoverPeersWithResolver resolves each seed, a hostname or host:port whose DNS records list the addresses of
+// peers, to the host:port addresses of those peers, without duplicates and in the order found. Seeds without a port,
+// and so their peers, use DefaultPort. A seed that can't be resolved is logged and skipped, so ErrNoPeers is only
+// returned if none of them can
+func DiscoverPeersWithResolver(r Resolver, seeds []string) ([]string, error) {
+	var peers []string
+	seen := make(map[string]bool)
+
+	for _, seed := range seeds {
+		host, port, err := net.SplitHostPort(seed)
+		if err != nil {
+			host, port = seed, DefaultPort
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultSeedTimeout)
+		addrs, err := r.LookupHost(ctx, host)
+		cancel()
+		if err != nil {
+			log.Printf("Skipping seed %s: %s\n", seed, err)
+			continue
+		}
+
+		for _, addr := range addrs {
+			peer := net.JoinHostPort(addr, port)
+			if !seen[peer] {
+				seen[peer] = true
+				peers = append(peers, peer)
+			}
+		}
+	}
+
+	if len(peers) == 0 {
+		return nil, ErrNoPeers
+	}
+	return peers, nil
+}
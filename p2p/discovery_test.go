@@ -0,0 +1,45 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// mockResolver resolves the hostnames it has addresses for, failing for any other
+type mockResolver map[string][]string
+
+func (m mockResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	addrs, ok := m[host]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return addrs, nil
+}
+
+func TestDiscoverPeers(t *testing.T) {
+	r := mockResolver{
+		"seed1.example": {"10.0.0.1", "10.0.0.2", "::1"},
+		"seed2.example": {"10.0.0.2", "10.0.0.3"},
+	}
+
+	// The unresolvable seed is skipped, and 10.0.0.2 found by both seeds with DefaultPort is only listed once
+	peers, err := DiscoverPeersWithResolver(r, []string{"down.example", "seed1.example", "seed2.example", "seed2.example:4000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.0.0.1:" + DefaultPort, "10.0.0.2:" + DefaultPort, "[::1]:" + DefaultPort, "10.0.0.3:" + DefaultPort,
+		"10.0.0.2:4000", "10.0.0.3:4000"}
+	if !reflect.DeepEqual(peers, want) {
+		t.Fatalf("Got peers %v, want %v", peers, want)
+	}
+}
+
+func TestDiscoverPeersNoneResolve(t *testing.T) {
+	for _, seeds := range [][]string{nil, {"down.example", "also-down.example:4000"}} {
+		if peers, err := DiscoverPeersWithResolver(mockResolver{}, seeds); err != ErrNoPeers {
+			t.Fatalf("Got %v, %v for seeds %v, want ErrNoPeers", peers, err, seeds)
+		}
+	}
+}
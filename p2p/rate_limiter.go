@@ -0,0 +1,217 @@
+package p2p
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// MessageType is the kind of message a peer sends, each rate limited separately
+type MessageType int
+
+const (
+	// MsgGetBlocks is a request for Blocks or headers
+	MsgGetBlocks MessageType = iota
+	// MsgBlock is a Block submitted by a peer
+	MsgBlock
+	// MsgTx is a Transaction submitted by a peer
+	MsgTx
+)
+
+const (
+	// DefaultMaxViolations is the number of throttled messages after which a peer is banned
+	DefaultMaxViolations = 100
+	// DefaultBanDuration is how long a peer banned for flooding stays banned
+	DefaultBanDuration = 10 * time.Minute
+	// DefaultIdleTimeout is how long a peer may send nothing before a RateLimiter forgets it
+	DefaultIdleTimeout = 10 * time.Minute
+	// DefaultMaxPeers is the most peers a RateLimiter keeps buckets for
+	DefaultMaxPeers = 10000
+)
+
+var (
+	// ErrRateLimited is returned for a message over its peer's limit, which should be dropped
+	ErrRateLimited = errors.New("Peer is over its message rate limit")
+	// ErrPeerBanned is returned for a message from a banned peer, which should be disconnected
+	ErrPeerBanned = errors.New("Peer is banned")
+)
+
+// Limit is the token bucket of a MessageType -
+// Rate - messages per second a peer may send on average
+// Burst - messages a peer may send at once after being idle
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// DefaultLimits gives each MessageType its Limit unless configured otherwise. Blocks are costly to verify, so a peer
+// may request them far more often than submit them
+var DefaultLimits = map[MessageType]Limit{
+	MsgGetBlocks: {Rate: 50, Burst: 100},
+	MsgBlock:     {Rate: 1, Burst: 5},
+	MsgTx:        {Rate: 20, Burst: 50},
+}
+
+// bucket is the token bucket of one peer for one MessageType
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// peerState is what a RateLimiter knows of one peer -
+// violations - messages throttled since the peer was last banned
+// last - time of the peer's last message
+type peerState struct {
+	buckets    map[MessageType]*bucket
+	violations int
+	last       time.Time
+}
+
+// RateLimiter throttles the messages of each peer, by address, with a token bucket per MessageType. A peer throttled
+// MaxViolations times is banned for BanDuration. The node has no peer connections yet, so nothing calls Allow; it is
+// for the message handlers to come -
+// Limits - Limit of each MessageType; a MessageType with none isn't limited
+// Bans - BanList the bans are recorded in, which may be shared with the rest of the node
+// IdleTimeout - time after which a peer that has sent nothing is forgotten, as by Forget, 0 to keep peers until
+// MaxPeers is reached
+// MaxPeers - most peers kept; the one idle longest is forgotten to make room for another, 0 for no limit
+type RateLimiter struct {
+	Limits        map[MessageType]Limit
+	MaxViolations int
+	BanDuration   time.Duration
+	Bans          *BanList
+	IdleTimeout   time.Duration
+	MaxPeers      int
+
+	mu        sync.Mutex
+	peers     map[string]*peerState
+	lastSweep time.Time // when peers idle for IdleTimeout were last forgotten
+	now       func() time.Time
+}
+
+// InitRateLimiter creates a new RateLimiter with given limits, or DefaultLimits if nil, and its own BanList
+func InitRateLimiter(limits map[MessageType]Limit) *RateLimiter {
+	if limits == nil {
+		limits = DefaultLimits
+	}
+
+	return &RateLimiter{
+		Limits:        limits,
+		MaxViolations: DefaultMaxViolations,
+		BanDuration:   DefaultBanDuration,
+		Bans:          InitBanList(),
+		IdleTimeout:   DefaultIdleTimeout,
+		MaxPeers:      DefaultMaxPeers,
+		peers:         make(map[string]*peerState),
+		now:           time.Now}
+}
+
+// Allow takes a token for a message of msgType from the peer at addr. Returns ErrRateLimited if the peer has none left,
+// or ErrPeerBanned if the peer is banned, including by this message
+func (rl *RateLimiter) Allow(addr string, msgType MessageType) error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if rl.Bans.IsBanned(addr) {
+		return ErrPeerBanned
+	}
+
+	now := rl.now()
+	rl.sweep(now)
+	peer, ok := rl.peers[addr]
+	if !ok {
+		rl.makeRoom()
+		peer = &peerState{buckets: make(map[MessageType]*bucket)}
+		rl.peers[addr] = peer
+	}
+	peer.last = now
+
+	limit, ok := rl.Limits[msgType]
+	if !ok {
+		return nil
+	}
+
+	b, ok := peer.buckets[msgType]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		peer.buckets[msgType] = b
+	}
+
+	// Refill for the time since the last message, up to Burst
+	b.tokens += now.Sub(b.last).Seconds() * limit.Rate
+	if b.tokens > float64(limit.Burst) {
+		b.tokens = float64(limit.Burst)
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return nil
+	}
+
+	peer.violations++
+	if rl.MaxViolations > 0 && peer.violations >= rl.MaxViolations {
+		peer.violations = 0
+		rl.Bans.BanPeer(addr, rl.BanDuration)
+		return ErrPeerBanned
+	}
+
+	return ErrRateLimited
+}
+
+// sweep forgets the peers idle for IdleTimeout, at most once every IdleTimeout so each message doesn't scan them all.
+// Must be called with mu held
+func (rl *RateLimiter) sweep(now time.Time) {
+	if rl.IdleTimeout <= 0 || now.Sub(rl.lastSweep) < rl.IdleTimeout {
+		return
+	}
+	rl.lastSweep = now
+
+	for addr, peer := range rl.peers {
+		if now.Sub(peer.last) >= rl.IdleTimeout {
+			delete(rl.peers, addr)
+		}
+	}
+}
+
+// makeRoom forgets the peer idle longest if MaxPeers are kept, so another can be added. Must be called with mu held
+func (rl *RateLimiter) makeRoom() {
+	if rl.MaxPeers <= 0 || len(rl.peers) < rl.MaxPeers {
+		return
+	}
+
+	var oldest string
+	for addr, peer := range rl.peers {
+		if oldest == "" || peer.last.Before(rl.peers[oldest].last) {
+			oldest = addr
+		}
+	}
+	delete(rl.peers, oldest)
+}
+
+// Peers gets the number of peers the RateLimiter keeps buckets for
+func (rl *RateLimiter) Peers() int {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	return len(rl.peers)
+}
+
+// Violations gets the number of messages of the peer at addr throttled since it was last banned
+func (rl *RateLimiter) Violations(addr string) int {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if peer, ok := rl.peers[addr]; ok {
+		return peer.violations
+	}
+	return 0
+}
+
+// Forget drops the buckets of the peer at addr once it disconnects. Any ban is kept in Bans
+func (rl *RateLimiter) Forget(addr string) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	delete(rl.peers, addr)
+}
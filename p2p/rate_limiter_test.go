@@ -0,0 +1,151 @@
+package p2p
+
+import (
+	"testing"
+	"time"
+)
+
+// testClock is a clock for RateLimiter and BanList that only moves when told to
+type testClock struct {
+	t time.Time
+}
+
+func (c *testClock) now() time.Time {
+	return c.t
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+// testRateLimiter makes a RateLimiter with DefaultLimits whose time, and that of its Bans, is kept by a testClock
+func testRateLimiter() (*RateLimiter, *testClock) {
+	clock := &testClock{t: time.Unix(0, 0)}
+	rl := InitRateLimiter(nil)
+	rl.now = clock.now
+	rl.Bans.now = clock.now
+	return rl, clock
+}
+
+func TestRateLimiterThrottlesFlooding(t *testing.T) {
+	rl, clock := testRateLimiter()
+	rl.MaxViolations = 0 // throttle only, to check the bucket on its own
+	limit := DefaultLimits[MsgBlock]
+
+	for i := 0; i < limit.Burst; i++ {
+		if err := rl.Allow("flooder", MsgBlock); err != nil {
+			t.Fatalf("Got %v for block %d of the burst", err, i)
+		}
+	}
+	if err := rl.Allow("flooder", MsgBlock); err != ErrRateLimited {
+		t.Fatalf("Got %v past the burst, want ErrRateLimited", err)
+	}
+
+	// Other peers, and other MessageTypes of the same peer, have their own buckets
+	if err := rl.Allow("polite", MsgBlock); err != nil {
+		t.Fatalf("Got %v for a well-behaved peer", err)
+	}
+	if err := rl.Allow("flooder", MsgGetBlocks); err != nil {
+		t.Fatalf("Got %v for another message type", err)
+	}
+
+	// Tokens come back at Rate
+	clock.advance(time.Duration(float64(time.Second) / limit.Rate))
+	if err := rl.Allow("flooder", MsgBlock); err != nil {
+		t.Fatalf("Got %v once a token refilled", err)
+	}
+	if err := rl.Allow("flooder", MsgBlock); err != ErrRateLimited {
+		t.Fatalf("Got %v with the refilled token used, want ErrRateLimited", err)
+	}
+	if got := rl.Violations("flooder"); got != 2 {
+		t.Fatalf("Got %d violations, want 2", got)
+	}
+}
+
+func TestRateLimiterWellBehavedPeer(t *testing.T) {
+	rl, clock := testRateLimiter()
+	limit := DefaultLimits[MsgTx]
+
+	// Sending at Rate forever never runs out
+	for i := 0; i < 10*limit.Burst; i++ {
+		if err := rl.Allow("polite", MsgTx); err != nil {
+			t.Fatalf("Got %v for message %d at the rate limit", err, i)
+		}
+		clock.advance(time.Duration(float64(time.Second) / limit.Rate))
+	}
+	if rl.Violations("polite") != 0 {
+		t.Fatal("Well-behaved peer has violations")
+	}
+}
+
+func TestRateLimiterBansRepeatOffenders(t *testing.T) {
+	rl, clock := testRateLimiter()
+	rl.MaxViolations = 3
+	limit := DefaultLimits[MsgBlock]
+
+	for i := 0; i < limit.Burst; i++ {
+		rl.Allow("flooder", MsgBlock)
+	}
+	for i := 1; i < rl.MaxViolations; i++ {
+		if err := rl.Allow("flooder", MsgBlock); err != ErrRateLimited {
+			t.Fatalf("Got %v for violation %d, want ErrRateLimited", err, i)
+		}
+	}
+	if err := rl.Allow("flooder", MsgBlock); err != ErrPeerBanned {
+		t.Fatalf("Got %v for the last violation, want ErrPeerBanned", err)
+	}
+	if !rl.Bans.IsBanned("flooder") || rl.Violations("flooder") != 0 {
+		t.Fatal("Peer not banned with its violations reset")
+	}
+	if err := rl.Allow("flooder", MsgGetBlocks); err != ErrPeerBanned {
+		t.Fatalf("Got %v from a banned peer, want ErrPeerBanned", err)
+	}
+
+	clock.advance(rl.BanDuration)
+	if err := rl.Allow("flooder", MsgBlock); err != nil {
+		t.Fatalf("Got %v once the ban expired", err)
+	}
+}
+
+func TestRateLimiterForgetsIdlePeers(t *testing.T) {
+	rl, clock := testRateLimiter()
+	rl.MaxViolations = 0
+	limit := DefaultLimits[MsgBlock]
+
+	for i := 0; i <= limit.Burst; i++ {
+		rl.Allow("idle", MsgBlock)
+	}
+	clock.advance(rl.IdleTimeout / 2)
+	rl.Allow("active", MsgBlock)
+
+	// Only the peer that has sent nothing for IdleTimeout is forgotten, violations and all
+	clock.advance(rl.IdleTimeout / 2)
+	rl.Allow("new", MsgBlock)
+	if got := rl.Peers(); got != 2 {
+		t.Fatalf("Got %d peers, want 2", got)
+	}
+	if got := rl.Violations("idle"); got != 0 {
+		t.Fatalf("Got %d violations for a forgotten peer", got)
+	}
+}
+
+func TestRateLimiterMaxPeers(t *testing.T) {
+	rl, clock := testRateLimiter()
+	rl.MaxPeers = 3
+
+	for _, addr := range []string{"a", "b", "c", "a", "d"} { // b is idle longest when d comes
+		rl.Allow(addr, MsgTx)
+		clock.advance(time.Second)
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if len(rl.peers) != rl.MaxPeers {
+		t.Fatalf("Got %d peers, want %d", len(rl.peers), rl.MaxPeers)
+	}
+	for _, addr := range []string{"a", "c", "d"} {
+		if _, ok := rl.peers[addr]; !ok {
+			t.Fatalf("Got %v, want a, c and d kept", rl.peers)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package p2p
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+
+	"github.com/danitello/go-blockchain/core/types"
+)
+
+// DefaultSeenTxsSize is the number of Transaction IDs a SeenTxs remembers
+const DefaultSeenTxsSize = 10000
+
+// ErrTxAlreadySeen is returned by SeenTxs.Process for a Transaction it has already processed
+var ErrTxAlreadySeen = errors.New("Transaction was recently processed")
+
+// SeenTxs is a least recently used set of the IDs of Transactions processed by a node, so the same Transaction relayed
+// by several peers is only verified once -
+// Permanent - determines whether an error from processing a Transaction means no Transaction with its ID can ever be
+// accepted. Those rejected for other reasons, e.g. spending the txo of a Transaction that hasn't arrived yet, are
+// processed again when they next arrive
+type SeenTxs struct {
+	Permanent func(error) bool
+
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List // front is most recently seen, values are keys
+}
+
+// InitSeenTxs creates a new SeenTxs remembering at most capacity IDs
+func InitSeenTxs(capacity int, permanent func(error) bool) *SeenTxs {
+	return &SeenTxs{
+		Permanent: permanent,
+		capacity:  capacity,
+		entries:   make(map[string]*list.Element),
+		order:     list.New()}
+}
+
+// Process passes a Transaction received from a peer to process, e.g. core.BlockChain.SubmitTransaction, unless its ID
+// has been seen, failing with ErrTxAlreadySeen instead. The ID is remembered once process accepts the Transaction or
+// rejects it with an error that is Permanent
+func (s *SeenTxs) Process(tx *types.Transaction, process func(*types.Transaction) error) error {
+	if s.Seen(tx.ID) {
+		return ErrTxAlreadySeen
+	}
+
+	err := process(tx)
+	if err == nil || (s.Permanent != nil && s.Permanent(err)) {
+		s.add(tx.ID)
+	}
+
+	return err
+}
+
+// Seen determines whether a Transaction ID is remembered
+func (s *SeenTxs) Seen(txID []byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, ok := s.entries[string(txID)]
+	if ok {
+		s.order.MoveToFront(elem)
+	}
+
+	return ok
+}
+
+// add remembers a Transaction ID, forgetting the least recently seen one if full
+func (s *SeenTxs) add(txID []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := string(txID)
+	if elem, ok := s.entries[key]; ok {
+		s.order.MoveToFront(elem)
+		return
+	}
+
+	s.entries[key] = s.order.PushFront(key)
+	if s.order.Len() > s.capacity {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(string))
+	}
+}
+
+// Len gets the number of Transaction IDs remembered
+func (s *SeenTxs) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.order.Len()
+}
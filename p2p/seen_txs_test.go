@@ -0,0 +1,70 @@
+package p2p
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/danitello/go-blockchain/core/types"
+)
+
+var (
+	errPermanent = errors.New("permanent")
+	errTemporary = errors.New("temporary")
+)
+
+// countingProcess gets a process func for SeenTxs.Process failing with err, and the number of times it has been called
+func countingProcess(err error) (func(*types.Transaction) error, *int) {
+	calls := 0
+	return func(*types.Transaction) error {
+		calls++
+		return err
+	}, &calls
+}
+
+func TestSeenTxsProcess(t *testing.T) {
+	s := InitSeenTxs(10, func(err error) bool { return err == errPermanent })
+	tx := &types.Transaction{ID: []byte{1}}
+
+	accept, calls := countingProcess(nil)
+	if err := s.Process(tx, accept); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Process(tx, accept); err != ErrTxAlreadySeen || *calls != 1 {
+		t.Fatalf("second arrival: got %v after %d calls, want ErrTxAlreadySeen after 1", err, *calls)
+	}
+
+	// A Transaction rejected for now is processed each time it arrives, a permanently rejected one only once
+	tx = &types.Transaction{ID: []byte{2}}
+	reject, calls := countingProcess(errTemporary)
+	s.Process(tx, reject)
+	s.Process(tx, reject)
+	if *calls != 2 || s.Seen(tx.ID) {
+		t.Fatalf("temporarily rejected transaction processed %d times, want 2", *calls)
+	}
+
+	tx = &types.Transaction{ID: []byte{3}}
+	reject, calls = countingProcess(errPermanent)
+	s.Process(tx, reject)
+	if err := s.Process(tx, reject); err != ErrTxAlreadySeen || *calls != 1 {
+		t.Fatalf("permanently rejected transaction: got %v after %d calls, want ErrTxAlreadySeen after 1", err, *calls)
+	}
+}
+
+func TestSeenTxsEvicts(t *testing.T) {
+	s := InitSeenTxs(2, nil)
+	accept, _ := countingProcess(nil)
+	for id := byte(1); id <= 3; id++ {
+		s.Process(&types.Transaction{ID: []byte{id}}, accept)
+	}
+
+	if s.Len() != 2 || s.Seen([]byte{1}) || !s.Seen([]byte{2}) || !s.Seen([]byte{3}) {
+		t.Fatal("least recently seen ID not evicted")
+	}
+
+	// Seeing an ID makes it the most recent
+	s.Seen([]byte{2})
+	s.Process(&types.Transaction{ID: []byte{4}}, accept)
+	if s.Seen([]byte{3}) || !s.Seen([]byte{2}) {
+		t.Fatal("recently seen ID evicted")
+	}
+}
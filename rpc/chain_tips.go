@@ -0,0 +1,41 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+)
+
+// chainTip is the JSON form of a core.TipInfo -
+// Work - the CumulativeWork of the branch in decimal, as it may not fit in a JSON number
+type chainTip struct {
+	Hash      string `json:"hash"`
+	Height    int    `json:"height"`
+	BranchLen int    `json:"branchlen"`
+	Status    string `json:"status"`
+	Work      string `json:"work"`
+}
+
+// serveChainTips answers a GET of /chaintips with the tip of every branch the BlockChain knows about, active tip
+// first, as by BlockChain.ChainTips
+func (s *Server) serveChainTips(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Chain tips must be read with GET", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tips, err := s.bc.ChainTips()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result := make([]chainTip, len(tips))
+	for i, tip := range tips {
+		result[i] = chainTip{hex.EncodeToString(tip.Hash), tip.Height, tip.BranchLen, tip.Status, tip.Work.String()}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,36 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/danitello/go-blockchain/core"
+	"github.com/danitello/go-blockchain/core/testutil"
+)
+
+func TestServeChainTips(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 2)
+	if _, err := bc.DisconnectTip(); err != nil {
+		t.Fatal(err)
+	}
+	mine(t, bc, ws.GetAddresses()[0])
+	mine(t, bc, ws.GetAddresses()[0])
+
+	rec := httptest.NewRecorder()
+	InitServer(bc).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/chaintips", nil))
+	var tips []chainTip
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &tips) != nil {
+		t.Fatalf("Got %d %s", rec.Code, rec.Body)
+	}
+
+	lastHash, _ := bc.Tip()
+	if len(tips) != 2 || tips[0].Hash != hex.EncodeToString(lastHash) || tips[0].Height != 3 || tips[0].Status != core.TipActive {
+		t.Fatalf("Got %+v, want the active tip first", tips)
+	}
+	if tips[1].Height != 2 || tips[1].BranchLen != 1 || tips[1].Status != core.TipValidFork || tips[1].Work == "" {
+		t.Fatalf("Got %+v for the disconnected block", tips[1])
+	}
+}
@@ -0,0 +1,137 @@
+package rpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/danitello/go-blockchain/core"
+)
+
+// difficultyPoint is the difficulty of the Block at a height, as a multiple of the genesis Block's as by
+// BlockChain.DifficultyAt
+type difficultyPoint struct {
+	Height     int     `json:"height"`
+	Difficulty float64 `json:"difficulty"`
+}
+
+// serveDifficulty answers a GET of /difficulty with the difficultyPoint of the most recent Block, or with the from
+// query param (and optionally to, defaulting to the most recent Block) those of every Block in that range for charting
+func (s *Server) serveDifficulty(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "The difficulty must be read with GET", http.StatusMethodNotAllowed)
+		return
+	}
+
+	_, tip := s.bc.Tip()
+	query := r.URL.Query()
+	from, to := tip, tip
+	var err error
+	if v := query.Get("from"); v != "" {
+		if from, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "from must be a block height", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := query.Get("to"); v != "" {
+		if to, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "to must be a block height", http.StatusBadRequest)
+			return
+		}
+	}
+
+	history, err := s.bc.DifficultyHistory(from, to)
+	if err == core.ErrHeightNotOnChain {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	points := make([]difficultyPoint, len(history))
+	for i, difficulty := range history {
+		points[i] = difficultyPoint{from + i, difficulty}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if query.Get("from") == "" {
+		json.NewEncoder(w).Encode(points[0])
+		return
+	}
+	json.NewEncoder(w).Encode(points)
+}
+
+// defaultStatsBlocks is the number of recent Blocks serveStats averages the block time over unless asked otherwise
+const defaultStatsBlocks = 100
+
+// stats is the state of mining on the BlockChain, for tuning TargetBlockInterval and RetargetInterval. Difficulties
+// are in leading zero bits and times in seconds
+type stats struct {
+	Height              int     `json:"height"`
+	Difficulty          int     `json:"difficulty"`
+	NextDifficulty      int     `json:"nextDifficulty"`
+	RetargetInterval    int     `json:"retargetInterval"`
+	TargetBlockInterval float64 `json:"targetBlockInterval"`
+	AverageBlockTime    float64 `json:"averageBlockTime"`
+	Blocks              int     `json:"blocks"`
+}
+
+// serveStats answers a GET of /stats with the stats of the BlockChain, averaging the block time over the number of
+// recent Blocks in the blocks query param, or defaultStatsBlocks
+func (s *Server) serveStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Stats must be read with GET", http.StatusMethodNotAllowed)
+		return
+	}
+
+	blocks := defaultStatsBlocks
+	if v := r.URL.Query().Get("blocks"); v != "" {
+		var err error
+		if blocks, err = strconv.Atoi(v); err != nil || blocks < 1 {
+			http.Error(w, "blocks must be a positive number", http.StatusBadRequest)
+			return
+		}
+	}
+
+	result, err := s.stats(blocks)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// stats gets the stats of the BlockChain with the block time averaged over a number of recent Blocks, or all of them
+// if there are fewer
+func (s *Server) stats(blocks int) (*stats, error) {
+	lastHash, tip := s.bc.Tip()
+	header, err := s.bc.ChainDB.ReadHeaderWithHash(lastHash)
+	if err != nil {
+		return nil, err
+	}
+	next, err := s.bc.NextDifficulty()
+	if err != nil {
+		return nil, err
+	}
+	average, err := s.bc.AverageBlockTime(blocks)
+	if err != nil {
+		return nil, err
+	}
+	if blocks > tip {
+		blocks = tip
+	}
+
+	return &stats{
+		Height:              tip,
+		Difficulty:          header.Difficulty,
+		NextDifficulty:      next,
+		RetargetInterval:    s.bc.RetargetInterval,
+		TargetBlockInterval: s.bc.TargetBlockInterval.Seconds(),
+		AverageBlockTime:    average.Seconds(),
+		Blocks:              blocks}, nil
+}
@@ -0,0 +1,87 @@
+package rpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/danitello/go-blockchain/core/testutil"
+	"github.com/danitello/go-blockchain/core/types"
+)
+
+func TestServeDifficulty(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 1)
+	bc.Difficulty = types.TestDifficulty + 1
+	mine(t, bc, ws.GetAddresses()[0])
+	s := InitServer(bc)
+
+	get := func(url string, v interface{}) int {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec.Code
+	}
+
+	var point difficultyPoint
+	if code := get("/difficulty", &point); code != http.StatusOK || point != (difficultyPoint{2, 2}) {
+		t.Fatalf("Got %d %+v for the tip", code, point)
+	}
+
+	var points []difficultyPoint
+	want := []difficultyPoint{{0, 1}, {1, 1}, {2, 2}}
+	if code := get("/difficulty?from=0", &points); code != http.StatusOK || !reflect.DeepEqual(points, want) {
+		t.Fatalf("Got %d %+v for the whole chain", code, points)
+	}
+	if code := get("/difficulty?from=1&to=1", &points); code != http.StatusOK || !reflect.DeepEqual(points, want[1:2]) {
+		t.Fatalf("Got %d %+v for height 1", code, points)
+	}
+
+	for url, want := range map[string]int{
+		"/difficulty?from=3": http.StatusNotFound,
+		"/difficulty?to=x":   http.StatusBadRequest,
+	} {
+		if code := get(url, nil); code != want {
+			t.Fatalf("Got %d for %s, want %d", code, url, want)
+		}
+	}
+}
+
+func TestServeStats(t *testing.T) {
+	bc, _ := testutil.BuildTestChain(t, 3)
+	s := InitServer(bc)
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats?blocks=2", nil))
+	var got stats
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &got) != nil {
+		t.Fatalf("Got %d %s", rec.Code, rec.Body)
+	}
+
+	average, err := bc.AverageBlockTime(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := stats{
+		Height:              3,
+		Difficulty:          types.TestDifficulty,
+		NextDifficulty:      types.TestDifficulty,
+		TargetBlockInterval: bc.TargetBlockInterval.Seconds(),
+		AverageBlockTime:    average.Seconds(),
+		Blocks:              2}
+	if got != want {
+		t.Fatalf("Got %+v, want %+v", got, want)
+	}
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats?blocks=0", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Got %d for blocks=0", rec.Code)
+	}
+}
@@ -0,0 +1,101 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// mempoolEntry is the JSON form of a core.MempoolEntry -
+// Time - when the Transaction arrived, in seconds since the Unix epoch
+type mempoolEntry struct {
+	TxID      string  `json:"txid"`
+	Size      int     `json:"size"`
+	Fee       int     `json:"fee"`
+	FeeRate   float64 `json:"feeRate"`
+	Time      int64   `json:"time"`
+	Ancestors int     `json:"ancestorCount"`
+}
+
+// rawMempool gets the hex IDs of the Transactions in the Mempool, or if verbose a mempoolEntry for each, highest fee
+// rate first as by Mempool.Dump
+func (s *Server) rawMempool(verbose bool) interface{} {
+	dump := s.bc.Mempool.Dump()
+
+	if !verbose {
+		ids := make([]string, len(dump))
+		for i, entry := range dump {
+			ids[i] = hex.EncodeToString(entry.TxID)
+		}
+		return ids
+	}
+
+	entries := make([]mempoolEntry, len(dump))
+	for i, entry := range dump {
+		entries[i] = mempoolEntry{
+			TxID:      hex.EncodeToString(entry.TxID),
+			Size:      entry.Size,
+			Fee:       entry.Fee,
+			FeeRate:   entry.FeeRate,
+			Time:      entry.Arrived.Unix(),
+			Ancestors: entry.Ancestors}
+	}
+	return entries
+}
+
+// getRawMempool gets the Transactions in the Mempool, with details if the optional verbose param is true
+func (s *Server) getRawMempool(params []json.RawMessage) (interface{}, *Error) {
+	verbose := false
+	if params[0] != nil && json.Unmarshal(params[0], &verbose) != nil {
+		return nil, &Error{Code: CodeInvalidParams, Message: "Invalid params", Data: "verbose must be a boolean"}
+	}
+
+	return s.rawMempool(verbose), nil
+}
+
+// serveMempool answers a GET of /mempool with the result of getrawmempool, verbose if the verbose query param is true
+func (s *Server) serveMempool(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "The mempool must be read with GET", http.StatusMethodNotAllowed)
+		return
+	}
+
+	verbose := false
+	if v := r.URL.Query().Get("verbose"); v != "" {
+		var err error
+		if verbose, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "verbose must be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.rawMempool(verbose))
+}
+
+// serveMempoolTx answers a GET of /mempool/{txid} with the Transaction in the Mempool with that hex ID, or 404 if
+// there isn't one
+func (s *Server) serveMempoolTx(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "The mempool must be read with GET", http.StatusMethodNotAllowed)
+		return
+	}
+
+	txID, err := hex.DecodeString(strings.TrimPrefix(r.URL.Path, "/mempool/"))
+	if err != nil {
+		http.Error(w, "Transaction ID must be hex", http.StatusBadRequest)
+		return
+	}
+	tx, ok := s.bc.Mempool.Get(txID)
+	if !ok {
+		http.Error(w, "Transaction not in mempool", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tx)
+}
@@ -0,0 +1,82 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/danitello/go-blockchain/core/testutil"
+	"github.com/danitello/go-blockchain/core/types"
+)
+
+func TestServeMempool(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 3)
+	spends := testutil.SpendEach(t, bc, ws)
+	var ids []string
+	for _, tx := range spends {
+		if err := bc.SubmitTransaction(tx); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, hex.EncodeToString(tx.ID))
+	}
+	s := InitServer(bc)
+
+	get := func(url string, v interface{}) int {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec.Code
+	}
+
+	var got []string
+	if code := get("/mempool", &got); code != http.StatusOK || len(got) != len(ids) {
+		t.Fatalf("Got %d %v, want %d IDs", code, got, len(ids))
+	}
+	sort.Strings(got)
+	sort.Strings(ids)
+	for i := range ids {
+		if got[i] != ids[i] {
+			t.Fatalf("Got IDs %v, want %v", got, ids)
+		}
+	}
+
+	var entries []mempoolEntry
+	if code := get("/mempool?verbose=true", &entries); code != http.StatusOK || len(entries) != len(ids) {
+		t.Fatalf("Got %d %+v, want %d entries", code, entries, len(ids))
+	}
+	for i := 1; i < len(entries); i++ {
+		if entries[i].FeeRate > entries[i-1].FeeRate || entries[i].Size == 0 {
+			t.Fatalf("Got entries %+v, want them by fee rate, highest first", entries)
+		}
+	}
+
+	var tx types.Transaction
+	if code := get("/mempool/"+hex.EncodeToString(spends[0].ID), &tx); code != http.StatusOK ||
+		hex.EncodeToString(tx.ID) != hex.EncodeToString(spends[0].ID) || len(tx.Inputs) != len(spends[0].Inputs) {
+		t.Fatalf("Got %d %+v for a pending transaction", code, tx)
+	}
+
+	for url, want := range map[string]int{
+		"/mempool/" + hex.EncodeToString(make([]byte, 32)): http.StatusNotFound,
+		"/mempool/xyz":          http.StatusBadRequest,
+		"/mempool?verbose=nope": http.StatusBadRequest,
+	} {
+		if code := get(url, nil); code != want {
+			t.Fatalf("Got %d for %s, want %d", code, url, want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/mempool/"+ids[0], nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Got %d for a POST, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
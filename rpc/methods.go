@@ -0,0 +1,144 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"sync"
+
+	"github.com/danitello/go-blockchain/chaindb"
+	"github.com/danitello/go-blockchain/core"
+	"github.com/danitello/go-blockchain/core/types"
+	"github.com/danitello/go-blockchain/p2p"
+	"github.com/danitello/go-blockchain/wallet"
+)
+
+// Server answers JSON-RPC 2.0 requests, single or batched, about a BlockChain. It is an http.Handler, so can be served
+// with http.ListenAndServe. Methods -
+// getblockcount - index of the most recent Block
+// getbestblockhash - hex hash of the most recent Block
+// getblock(hash) - the Block with a hex hash, in the JSON form of ExportJSON, or the CodePruned error if its
+// Transactions were pruned
+// getbalance(address) - total of the utxos of an address
+// sendrawtransaction(hex) - submits a Transaction with SubmitTransaction, getting its hex ID. One already accepted,
+// or permanently rejected, is turned away without being checked again
+// getrawmempool(verbose) - hex IDs of the Transactions in the Mempool, or with verbose their details, see rawMempool
+//
+// The Mempool can also be read with a GET of /mempool, verbose with /mempool?verbose=true, and the difficulty of the
+// most recent Block with a GET of /difficulty, or of a range of Blocks with /difficulty?from=height&to=height. A GET
+// of /stats gets the actual block time against TargetBlockInterval, averaged over the recent Blocks given by
+// /stats?blocks=n, along with the current and next difficulty. A GET of /chaintips gets the tip of every known branch.
+// A hex encoded Transaction POSTed to /tx/check is checked as sendrawtransaction would, without being submitted.
+//
+// A WebSocket client of /ws sends {"subscribe": [topics]} or {"unsubscribe": [topics]} to be pushed each new Block
+// (TopicBlocks) or Mempool Transaction (TopicTxs) as {"topic": topic, "data": Block or Transaction} -
+// SubscriberBuffer - number of events queued for a client not keeping up before further ones are dropped, which it is
+// told of by the "dropped" count of the next event it gets
+type Server struct {
+	SubscriberBuffer int
+
+	bc      *core.BlockChain
+	seen    *p2p.SeenTxs
+	methods map[string]method
+	subsMu  sync.Mutex
+	subs    map[*subscriber]bool
+}
+
+// InitServer creates a new Server for a BlockChain, publishing its new Blocks and Mempool Transactions to WebSocket
+// clients
+func InitServer(bc *core.BlockChain) *Server {
+	s := &Server{
+		SubscriberBuffer: DefaultSubscriberBuffer,
+		bc:               bc,
+		seen:             p2p.InitSeenTxs(p2p.DefaultSeenTxsSize, core.IsPermanentTxError),
+		subs:             make(map[*subscriber]bool)}
+	s.methods = map[string]method{
+		"getblockcount":      {nil, s.getBlockCount},
+		"getbestblockhash":   {nil, s.getBestBlockHash},
+		"getblock":           {[]string{"hash"}, s.getBlock},
+		"getbalance":         {[]string{"address"}, s.getBalance},
+		"sendrawtransaction": {[]string{"hex"}, s.sendRawTransaction},
+		"getrawmempool":      {[]string{"verbose"}, s.getRawMempool},
+	}
+	bc.OnConnect(s.publishBlock)
+	bc.Mempool.OnAdd(s.publishTx)
+
+	return s
+}
+
+// getBlockCount gets the index of the most recent Block
+func (s *Server) getBlockCount(params []json.RawMessage) (interface{}, *Error) {
+	_, tip := s.bc.Tip()
+	return tip, nil
+}
+
+// getBestBlockHash gets the hex hash of the most recent Block
+func (s *Server) getBestBlockHash(params []json.RawMessage) (interface{}, *Error) {
+	lastHash, _ := s.bc.Tip()
+	return hex.EncodeToString(lastHash), nil
+}
+
+// getBlock gets the Block with a hex hash
+func (s *Server) getBlock(params []json.RawMessage) (interface{}, *Error) {
+	hashHex, rpcErr := stringParam(params[0], "hash")
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	hash, err := hex.DecodeString(hashHex)
+	if err != nil {
+		return nil, &Error{Code: CodeInvalidParams, Message: "Invalid params", Data: "hash is not hex"}
+	}
+
+	block, err := s.bc.ChainDB.ReadBlockWithHash(hash)
+	if err == chaindb.ErrBlockNotFound {
+		return nil, &Error{Code: CodeNotFound, Message: "Block not found"}
+	} else if err == chaindb.ErrBlockPruned {
+		return nil, &Error{Code: CodePruned, Message: "Block pruned"}
+	} else if err != nil {
+		return nil, &Error{Code: CodeInternalError, Message: "Internal error", Data: err.Error()}
+	}
+
+	return block, nil
+}
+
+// getBalance gets the total of the utxos of an address
+func (s *Server) getBalance(params []json.RawMessage) (interface{}, *Error) {
+	address, rpcErr := stringParam(params[0], "address")
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	if !s.bc.AddressParams.ValidateAddress(address) {
+		return nil, &Error{Code: CodeInvalidParams, Message: "Invalid params", Data: "Invalid address"}
+	}
+
+	_, balance := s.bc.GetUTXOWithPubKey(wallet.GetPubKeyHashFromAddress(address), math.MaxInt32)
+	return balance, nil
+}
+
+// sendRawTransaction submits a hex encoded, signed Transaction, getting its hex ID
+func (s *Server) sendRawTransaction(params []json.RawMessage) (interface{}, *Error) {
+	rawTx, rpcErr := stringParam(params[0], "hex")
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	tx, err := types.DecodeRawTransaction(rawTx)
+	if err == nil {
+		err = s.seen.Process(tx, s.bc.SubmitTransaction)
+	}
+	if err != nil {
+		return nil, &Error{Code: CodeRejected, Message: "Transaction rejected", Data: err.Error()}
+	}
+
+	return hex.EncodeToString(tx.ID), nil
+}
+
+// stringParam decodes a required string param
+func stringParam(raw json.RawMessage, name string) (string, *Error) {
+	var s string
+	if raw == nil || json.Unmarshal(raw, &s) != nil || s == "" {
+		return "", &Error{Code: CodeInvalidParams, Message: "Invalid params", Data: name + " must be a non-empty string"}
+	}
+
+	return s, nil
+}
@@ -0,0 +1,41 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/danitello/go-blockchain/chaindb"
+)
+
+// serveRawBlock answers a GET of /blocks/{hash}/raw with the Block with that hex hash exactly as the ChainDB stores
+// it, so clients can check its hash themselves. 404 if there is no such Block, 410 if its Transactions were pruned
+func (s *Server) serveRawBlock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Blocks must be read with GET", http.StatusMethodNotAllowed)
+		return
+	}
+
+	hexHash := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/blocks/"), "/raw")
+	hash, err := hex.DecodeString(hexHash)
+	if err != nil || len(hash) != 32 { // other db keys aren't Blocks
+		http.Error(w, "Block hash must be 32 bytes of hex", http.StatusBadRequest)
+		return
+	}
+
+	raw, err := s.bc.ChainDB.ReadRawBlockWithHash(hash)
+	if err == chaindb.ErrBlockNotFound {
+		http.Error(w, "Block not found", http.StatusNotFound)
+		return
+	} else if err == chaindb.ErrBlockPruned {
+		http.Error(w, "Block pruned", http.StatusGone)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", s.bc.ChainDB.RawContentType())
+	w.Write(raw)
+}
@@ -0,0 +1,69 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/danitello/go-blockchain/chaindb"
+	"github.com/danitello/go-blockchain/core/testutil"
+	"github.com/danitello/go-blockchain/core/types"
+)
+
+func TestServeRawBlock(t *testing.T) {
+	bc, _ := testutil.BuildTestChain(t, 3)
+	s := InitServer(bc)
+	lastHash, _ := bc.Tip()
+
+	get := func(method, url string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(method, url, nil))
+		return rec
+	}
+
+	// The stored bytes, which decode to the Block with the hash asked for
+	rec := get(http.MethodGet, "/blocks/"+hex.EncodeToString(lastHash)+"/raw")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/octet-stream" {
+		t.Fatalf("Got %d %s for the tip", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	want, err := bc.ChainDB.ReadRawBlockWithHash(lastHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), want) {
+		t.Fatal("Got bytes other than those stored")
+	}
+	var block types.Block
+	if err := (chaindb.GobCodec{}).Decode(rec.Body.Bytes(), &block); err != nil || !bytes.Equal(block.Hash, lastHash) {
+		t.Fatalf("Got block %x (%v), want %x", block.Hash, err, lastHash)
+	}
+
+	for url, want := range map[string]int{
+		"/blocks/" + hex.EncodeToString(make([]byte, 32)) + "/raw": http.StatusNotFound,
+		"/blocks/xyz/raw": http.StatusBadRequest,
+		"/blocks/" + hex.EncodeToString([]byte(chaindb.LastHashKey)) + "/raw": http.StatusBadRequest,
+	} {
+		if rec := get(http.MethodGet, url); rec.Code != want {
+			t.Fatalf("Got %d for %s, want %d", rec.Code, url, want)
+		}
+	}
+	rec = get(http.MethodPost, "/blocks/"+hex.EncodeToString(lastHash)+"/raw")
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Got %d for a POST, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+
+	// A pruned Block no longer has the bytes it was mined with
+	genesisHash, err := bc.ChainDB.ReadGenesisHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.Prune(1); err != nil {
+		t.Fatal(err)
+	}
+	if rec := get(http.MethodGet, "/blocks/"+hex.EncodeToString(genesisHash)+"/raw"); rec.Code != http.StatusGone {
+		t.Fatalf("Got %d for a pruned block, want %d", rec.Code, http.StatusGone)
+	}
+}
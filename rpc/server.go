@@ -0,0 +1,275 @@
+// Package rpc serves a BlockChain over JSON-RPC 2.0 (https://www.jsonrpc.org/specification) on an HTTP endpoint
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/websocket"
+)
+
+// Version is the jsonrpc member of every request and response
+const Version = "2.0"
+
+// maxBodySize is the largest request body read, in bytes
+const maxBodySize = 4 << 20
+
+// Error codes defined by the spec, and those of this server in the range it reserves for implementations
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+
+	// CodeNotFound is returned for a Block or Transaction that isn't in the BlockChain
+	CodeNotFound = -32001
+	// CodeRejected is returned for a Transaction the BlockChain won't accept
+	CodeRejected = -32002
+	// CodePruned is returned for a Block whose Transactions were dropped by BlockChain.Prune
+	CodePruned = -32003
+)
+
+// Error is the error object of a response -
+// Data - more about the error, e.g. the underlying error message, left out if nil
+type Error struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// Error gets the message of the Error
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (%d)", e.Message, e.Code)
+}
+
+// request is a single call of a request or batch. A call without an ID is a notification, which gets no response
+type request struct {
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// response is the response to a single call, holding either Result (already encoded, so a zero result isn't left out)
+// or Error
+type response struct {
+	Version string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// handler runs a method with its params, which are given by position in the order of the method's param names
+type handler func(params []json.RawMessage) (interface{}, *Error)
+
+// method is a handler along with the names of its params, for calls giving them by name
+type method struct {
+	params []string
+	run    handler
+}
+
+// nullID is the id of a response to a call whose id couldn't be read
+var nullID = json.RawMessage("null")
+
+// ServeHTTP answers a POSTed request or batch with Handle, a GET of /mempool, /mempool/{txid}, /blocks/{hash}/raw,
+// /difficulty, /stats or /chaintips with serveMempool, serveMempoolTx, serveRawBlock, serveDifficulty, serveStats or
+// serveChainTips, a POST of /tx/check with serveTxCheck, or a WebSocket connection to /ws with serveSubscriptions
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/mempool/") {
+		s.serveMempoolTx(w, r)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/blocks/") && strings.HasSuffix(r.URL.Path, "/raw") {
+		s.serveRawBlock(w, r)
+		return
+	}
+	switch r.URL.Path {
+	case "/mempool":
+		s.serveMempool(w, r)
+		return
+	case "/difficulty":
+		s.serveDifficulty(w, r)
+		return
+	case "/stats":
+		s.serveStats(w, r)
+		return
+	case "/chaintips":
+		s.serveChainTips(w, r)
+		return
+	case "/tx/check":
+		s.serveTxCheck(w, r)
+		return
+	case "/ws":
+		// No Handshake, so clients from any origin can subscribe, as they can make requests
+		websocket.Server{Handler: s.serveSubscriptions}.ServeHTTP(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "JSON-RPC requests must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	res := s.Handle(body)
+	if res == nil {
+		w.WriteHeader(http.StatusNoContent) // only notifications
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(res)
+}
+
+// Handle runs a request, or each call of a batch, and gets the encoded response. Returns nil if there is nothing to
+// respond with, as the request held only notifications
+func (s *Server) Handle(body []byte) []byte {
+	body = bytes.TrimSpace(body)
+
+	if len(body) > 0 && body[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil {
+			return encode(errorResponse(nullID, &Error{Code: CodeParseError, Message: "Parse error"}))
+		}
+		if len(batch) == 0 {
+			return encode(errorResponse(nullID, &Error{Code: CodeInvalidRequest, Message: "Invalid Request"}))
+		}
+
+		var responses []*response
+		for _, call := range batch {
+			if res := s.call(call); res != nil {
+				responses = append(responses, res)
+			}
+		}
+		if len(responses) == 0 {
+			return nil
+		}
+		return encode(responses)
+	}
+
+	if !json.Valid(body) {
+		return encode(errorResponse(nullID, &Error{Code: CodeParseError, Message: "Parse error"}))
+	}
+	if res := s.call(body); res != nil {
+		return encode(res)
+	}
+	return nil
+}
+
+// call runs a single call, getting its response or nil for a notification
+func (s *Server) call(raw json.RawMessage) (res *response) {
+	var req request
+	if err := json.Unmarshal(raw, &req); err != nil || req.Version != Version || req.Method == "" || !validID(req.ID) {
+		return errorResponse(nullID, &Error{Code: CodeInvalidRequest, Message: "Invalid Request"})
+	}
+
+	id := req.ID
+	if id == nil {
+		id = nullID
+	}
+	defer func() {
+		// The BlockChain panics on db errors, which shouldn't take the server down
+		if r := recover(); r != nil {
+			res = errorResponse(id, &Error{Code: CodeInternalError, Message: "Internal error", Data: fmt.Sprint(r)})
+		}
+		if req.ID == nil {
+			res = nil // notification
+		}
+	}()
+
+	m, ok := s.methods[req.Method]
+	if !ok {
+		return errorResponse(id, &Error{Code: CodeMethodNotFound, Message: "Method not found", Data: req.Method})
+	}
+	params, rpcErr := positionalParams(req.Params, m.params)
+	if rpcErr != nil {
+		return errorResponse(id, rpcErr)
+	}
+
+	result, rpcErr := m.run(params)
+	if rpcErr != nil {
+		return errorResponse(id, rpcErr)
+	}
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		return errorResponse(id, &Error{Code: CodeInternalError, Message: "Internal error", Data: err.Error()})
+	}
+
+	return &response{Version: Version, Result: encoded, ID: id}
+}
+
+// positionalParams gets the params of a call in the order of names, whether they were given by position or by name.
+// Params not given are nil
+func positionalParams(raw json.RawMessage, names []string) ([]json.RawMessage, *Error) {
+	params := make([]json.RawMessage, len(names))
+	invalid := &Error{Code: CodeInvalidParams, Message: "Invalid params"}
+
+	raw = bytes.TrimSpace(raw)
+	switch {
+	case len(raw) == 0 || bytes.Compare(raw, nullID) == 0:
+		return params, nil
+	case raw[0] == '[':
+		var byPosition []json.RawMessage
+		if err := json.Unmarshal(raw, &byPosition); err != nil || len(byPosition) > len(names) {
+			return nil, invalid
+		}
+		copy(params, byPosition)
+	case raw[0] == '{':
+		var byName map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &byName); err != nil {
+			return nil, invalid
+		}
+		for i, name := range names {
+			params[i] = byName[name]
+			delete(byName, name)
+		}
+		if len(byName) > 0 {
+			return nil, invalid
+		}
+	default:
+		return nil, invalid
+	}
+
+	return params, nil
+}
+
+// validID determines whether the id of a call is absent, or a string, number or null as the spec requires
+func validID(id json.RawMessage) bool {
+	if id == nil {
+		return true
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(id, &v); err != nil {
+		return false
+	}
+	switch v.(type) {
+	case nil, string, float64:
+		return true
+	}
+	return false
+}
+
+// errorResponse creates the response to a call that failed
+func errorResponse(id json.RawMessage, err *Error) *response {
+	return &response{Version: Version, Error: err, ID: id}
+}
+
+// encode marshals a response or batch of them
+func encode(v interface{}) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(errorResponse(nullID, &Error{Code: CodeInternalError, Message: "Internal error", Data: err.Error()}))
+	}
+	return data
+}
@@ -0,0 +1,146 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danitello/go-blockchain/core/testutil"
+)
+
+// decodeResponses unmarshals the response to a batch, keeping each result raw
+func decodeResponses(t *testing.T, data []byte) []response {
+	t.Helper()
+	var responses []response
+	if err := json.Unmarshal(data, &responses); err != nil {
+		t.Fatalf("Bad batch response %s: %s", data, err)
+	}
+	return responses
+}
+
+func TestBatchMixedResults(t *testing.T) {
+	bc, _ := testutil.BuildTestChain(t, 2)
+	s := InitServer(bc)
+	lastHash, _ := bc.Tip()
+
+	batch := fmt.Sprintf(`[
+		{"jsonrpc": "2.0", "method": "getblockcount", "id": 1},
+		{"jsonrpc": "2.0", "method": "getbestblockhash", "id": "best"},
+		{"jsonrpc": "2.0", "method": "nosuchmethod", "id": 3},
+		{"jsonrpc": "2.0", "method": "getblock", "params": {"hash": "zz"}, "id": 4},
+		{"jsonrpc": "2.0", "method": "getblock", "params": ["%x"], "id": 5},
+		{"jsonrpc": "2.0", "method": "getblockcount"},
+		1
+	]`, make([]byte, 32))
+
+	responses := decodeResponses(t, s.Handle([]byte(batch)))
+	if len(responses) != 6 {
+		t.Fatalf("Got %d responses, want one for each call but the notification", len(responses))
+	}
+
+	want := []struct {
+		id     string
+		result string
+		code   int
+	}{
+		{"1", "2", 0},
+		{`"best"`, fmt.Sprintf(`"%s"`, hex.EncodeToString(lastHash)), 0},
+		{"3", "", CodeMethodNotFound},
+		{"4", "", CodeInvalidParams},
+		{"5", "", CodeNotFound},
+		{"null", "", CodeInvalidRequest},
+	}
+	for i, res := range responses {
+		if res.Version != Version || string(res.ID) != want[i].id {
+			t.Fatalf("Response %d has version %s and id %s, want id %s", i, res.Version, res.ID, want[i].id)
+		}
+		if want[i].code == 0 {
+			if res.Error != nil || string(res.Result) != want[i].result {
+				t.Fatalf("Response %d is %s %v, want %s", i, res.Result, res.Error, want[i].result)
+			}
+		} else if res.Error == nil || res.Error.Code != want[i].code || res.Result != nil {
+			t.Fatalf("Response %d is %s %v, want error %d", i, res.Result, res.Error, want[i].code)
+		}
+	}
+}
+
+func TestHandleMalformed(t *testing.T) {
+	bc, _ := testutil.BuildTestChain(t, 0)
+	s := InitServer(bc)
+
+	for body, code := range map[string]int{
+		`{"jsonrpc": "2.0", "method"`:       CodeParseError,
+		`[`:                                 CodeParseError,
+		`[]`:                                CodeInvalidRequest,
+		`{"jsonrpc": "1.0", "method": "x"}`: CodeInvalidRequest,
+	} {
+		var res response
+		if err := json.Unmarshal(s.Handle([]byte(body)), &res); err != nil || res.Error == nil || res.Error.Code != code {
+			t.Fatalf("Got %+v for %s, want error %d", res.Error, body, code)
+		}
+	}
+
+	// Only notifications, so nothing to respond with
+	if res := s.Handle([]byte(`[{"jsonrpc": "2.0", "method": "getblockcount"}]`)); res != nil {
+		t.Fatalf("Got %s for a batch of notifications", res)
+	}
+}
+
+func TestGetBlockPruned(t *testing.T) {
+	bc, _ := testutil.BuildTestChain(t, 3)
+	hashes := make([]string, 0, 4)
+	for iter := bc.Iterator(); ; {
+		block := iter.Next()
+		hashes = append(hashes, hex.EncodeToString(block.Hash))
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+	if err := bc.Prune(1); err != nil {
+		t.Fatal(err)
+	}
+	s := InitServer(bc)
+
+	call := func(hash string) *response {
+		var res response
+		body := fmt.Sprintf(`{"jsonrpc": "2.0", "method": "getblock", "params": ["%s"], "id": 1}`, hash)
+		if err := json.Unmarshal(s.Handle([]byte(body)), &res); err != nil {
+			t.Fatal(err)
+		}
+		return &res
+	}
+
+	if res := call(hashes[0]); res.Error != nil || res.Result == nil {
+		t.Fatalf("Got %v for the tip", res.Error)
+	}
+	if res := call(hashes[len(hashes)-1]); res.Error == nil || res.Error.Code != CodePruned {
+		t.Fatalf("Got %s %v for a pruned block, want CodePruned", res.Result, res.Error)
+	}
+}
+
+func TestServeHTTPMethods(t *testing.T) {
+	bc, _ := testutil.BuildTestChain(t, 0)
+	s := InitServer(bc)
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Got %d for a GET", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"jsonrpc": "2.0", "method": "getblockcount", "id": 1}`)))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"result":0`) {
+		t.Fatalf("Got %d %s for a POST", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"jsonrpc": "2.0", "method": "getblockcount"}`)))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("Got %d for a notification", rec.Code)
+	}
+}
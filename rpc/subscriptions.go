@@ -0,0 +1,173 @@
+package rpc
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+
+	"github.com/danitello/go-blockchain/core/types"
+	"golang.org/x/net/websocket"
+)
+
+// Topics a WebSocket client can subscribe to
+const (
+	// TopicBlocks gets each Block added to the BlockChain
+	TopicBlocks = "blocks"
+	// TopicTxs gets each Transaction added to the Mempool
+	TopicTxs = "txs"
+)
+
+// DefaultSubscriberBuffer is the number of events queued for a WebSocket client before further ones are dropped
+const DefaultSubscriberBuffer = 256
+
+// subscription is a message from a WebSocket client choosing its topics, e.g. {"subscribe": ["blocks", "txs"]}
+type subscription struct {
+	Subscribe   []string `json:"subscribe"`
+	Unsubscribe []string `json:"unsubscribe"`
+}
+
+// event is a message to a WebSocket client -
+// Topic - the topic Data is from, a Block or Transaction in the JSON form of getblock
+// Dropped - number of events dropped just before this one as the client wasn't keeping up
+// Subscribed - the topics of the client, in answer to a subscription
+// Error - why a subscription was refused
+type event struct {
+	Topic      string          `json:"topic,omitempty"`
+	Data       json.RawMessage `json:"data,omitempty"`
+	Dropped    int             `json:"dropped,omitempty"`
+	Subscribed []string        `json:"subscribed,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// subscriber is a connected WebSocket client. topics and dropped are guarded by Server.subsMu
+type subscriber struct {
+	conn    *websocket.Conn
+	topics  map[string]bool
+	dropped int
+	events  chan *event
+}
+
+// queue gives an event to the goroutine writing to the client, dropping it if the client already has a full buffer of
+// them. Server.subsMu must be held
+func (sub *subscriber) queue(ev *event) {
+	ev.Dropped = sub.dropped
+	select {
+	case sub.events <- ev:
+		sub.dropped = 0
+	default:
+		sub.dropped++
+	}
+}
+
+// serveSubscriptions reads the subscriptions of a WebSocket client while writing it the events of its topics, until
+// either side closes the connection
+func (s *Server) serveSubscriptions(conn *websocket.Conn) {
+	sub := &subscriber{conn: conn, topics: make(map[string]bool), events: make(chan *event, s.SubscriberBuffer)}
+	s.subsMu.Lock()
+	s.subs[sub] = true
+	s.subsMu.Unlock()
+
+	stop := make(chan struct{})
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		for {
+			select {
+			case ev := <-sub.events:
+				if err := websocket.JSON.Send(conn, ev); err != nil {
+					conn.Close() // so the read below fails too
+					return
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	for {
+		var msg []byte
+		if err := websocket.Message.Receive(conn, &msg); err != nil {
+			break
+		}
+		s.subscribe(sub, msg)
+	}
+
+	s.subsMu.Lock()
+	delete(s.subs, sub)
+	s.subsMu.Unlock()
+	close(stop)
+	<-writerDone
+}
+
+// subscribe changes the topics of a client as a subscription message asks, answering with its topics or an error
+func (s *Server) subscribe(sub *subscriber, msg []byte) {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+
+	var req subscription
+	if err := json.Unmarshal(msg, &req); err != nil {
+		sub.queue(&event{Error: "Invalid subscription: " + err.Error()})
+		return
+	}
+	for _, topic := range append(req.Subscribe, req.Unsubscribe...) {
+		if topic != TopicBlocks && topic != TopicTxs {
+			sub.queue(&event{Error: "Unknown topic " + topic})
+			return
+		}
+	}
+
+	for _, topic := range req.Subscribe {
+		sub.topics[topic] = true
+	}
+	for _, topic := range req.Unsubscribe {
+		delete(sub.topics, topic)
+	}
+
+	topics := []string{}
+	for topic := range sub.topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	sub.queue(&event{Subscribed: topics})
+}
+
+// publish queues an event holding v for each client subscribed to a topic
+func (s *Server) publish(topic string, v interface{}) {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+
+	var data json.RawMessage
+	for sub := range s.subs {
+		if !sub.topics[topic] {
+			continue
+		}
+		if data == nil {
+			var err error
+			if data, err = json.Marshal(v); err != nil {
+				log.Printf("Encoding %s event: %s\n", topic, err)
+				return
+			}
+		}
+		sub.queue(&event{Topic: topic, Data: data})
+	}
+}
+
+// publishBlock is the OnConnect hook publishing each Block added to the BlockChain
+func (s *Server) publishBlock(block *types.Block) {
+	s.publish(TopicBlocks, block)
+}
+
+// publishTx is the Mempool.OnAdd hook publishing each Transaction added to the Mempool
+func (s *Server) publishTx(tx *types.Transaction) {
+	s.publish(TopicTxs, tx)
+}
+
+// Close disconnects every WebSocket client. http.Server.Shutdown doesn't, as their connections are hijacked
+func (s *Server) Close() {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+
+	for sub := range s.subs {
+		sub.conn.Close()
+	}
+}
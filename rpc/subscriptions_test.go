@@ -0,0 +1,143 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danitello/go-blockchain/core"
+	"github.com/danitello/go-blockchain/core/testutil"
+	"github.com/danitello/go-blockchain/core/types"
+	"golang.org/x/net/websocket"
+)
+
+// dialSubscriptions connects a WebSocket client to the /ws endpoint of s
+func dialSubscriptions(t *testing.T, s *Server) *websocket.Conn {
+	t.Helper()
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+
+	conn, err := websocket.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", "", ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+// receive reads the next event sent to a client
+func receive(t *testing.T, conn *websocket.Conn) *event {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var ev event
+	if err := websocket.JSON.Receive(conn, &ev); err != nil {
+		t.Fatal(err)
+	}
+	return &ev
+}
+
+// subscribeTo sends a subscription, checking the topics the client has after it
+func subscribeTo(t *testing.T, conn *websocket.Conn, msg string, want ...string) {
+	t.Helper()
+	if _, err := conn.Write([]byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+	if ev := receive(t, conn); !reflect.DeepEqual(ev.Subscribed, want) {
+		t.Fatalf("Got %+v subscribing with %s, want topics %v", ev, msg, want)
+	}
+}
+
+// mine adds a Block holding only a coinbase tx to bc
+func mine(t *testing.T, bc *core.BlockChain, address string) {
+	t.Helper()
+	_, tip := bc.Tip()
+	if err := bc.AddBlock([]*types.Transaction{types.CoinbaseTx(address, tip+1)}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSubscriptions(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 2)
+	address := ws.GetAddresses()[0]
+	conn := dialSubscriptions(t, InitServer(bc))
+
+	subscribeTo(t, conn, `{"subscribe": ["txs", "blocks"]}`, TopicBlocks, TopicTxs)
+
+	txs := testutil.SpendEach(t, bc, ws)
+	if err := bc.SubmitTransaction(txs[0]); err != nil {
+		t.Fatal(err)
+	}
+	ev := receive(t, conn)
+	var tx types.Transaction
+	if ev.Topic != TopicTxs || json.Unmarshal(ev.Data, &tx) != nil || bytes.Compare(tx.ID, txs[0].ID) != 0 {
+		t.Fatalf("Got %s %s, want transaction %x", ev.Topic, ev.Data, txs[0].ID)
+	}
+
+	mine(t, bc, address)
+	ev = receive(t, conn)
+	var block types.Block
+	lastHash, _ := bc.Tip()
+	if ev.Topic != TopicBlocks || json.Unmarshal(ev.Data, &block) != nil || bytes.Compare(block.Hash, lastHash) != 0 {
+		t.Fatalf("Got %s %s, want block %x", ev.Topic, ev.Data, lastHash)
+	}
+
+	// Only Blocks once unsubscribed from txs
+	subscribeTo(t, conn, `{"unsubscribe": ["txs"]}`, TopicBlocks)
+	if err := bc.SubmitTransaction(txs[1]); err != nil {
+		t.Fatal(err)
+	}
+	mine(t, bc, address)
+	if ev := receive(t, conn); ev.Topic != TopicBlocks {
+		t.Fatalf("Got a %s event after unsubscribing", ev.Topic)
+	}
+
+	for _, msg := range []string{`{"subscribe": ["headers"]}`, `subscribe`} {
+		if _, err := conn.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		if ev := receive(t, conn); ev.Error == "" {
+			t.Fatalf("No error for subscription %s", msg)
+		}
+	}
+}
+
+func TestSubscriptionsClose(t *testing.T) {
+	bc, _ := testutil.BuildTestChain(t, 1)
+	s := InitServer(bc)
+	conn := dialSubscriptions(t, s)
+	subscribeTo(t, conn, `{"subscribe": ["blocks"]}`, TopicBlocks)
+
+	s.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var ev event
+	if err := websocket.JSON.Receive(conn, &ev); err == nil {
+		t.Fatalf("Got %+v after Close", ev)
+	}
+}
+
+func TestSubscriberQueueBounded(t *testing.T) {
+	sub := &subscriber{events: make(chan *event, 2)}
+	for i := 0; i < 5; i++ {
+		sub.queue(&event{Topic: TopicBlocks})
+	}
+	if len(sub.events) != 2 || sub.dropped != 3 {
+		t.Fatalf("%d queued and %d dropped, want 2 and 3", len(sub.events), sub.dropped)
+	}
+
+	// The next event to get through tells the client how many it missed
+	<-sub.events
+	sub.queue(&event{Topic: TopicTxs})
+	<-sub.events
+	if ev := <-sub.events; ev.Topic != TopicTxs || ev.Dropped != 3 {
+		t.Fatalf("Got %+v, want a txs event with 3 dropped", ev)
+	}
+	if sub.dropped != 0 {
+		t.Fatalf("%d still counted as dropped", sub.dropped)
+	}
+}
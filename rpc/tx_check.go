@@ -0,0 +1,46 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/danitello/go-blockchain/core/types"
+)
+
+// txCheck is the answer to a POST of /tx/check -
+// Error - the first problem BlockChain.CheckTransaction found, left out if the Transaction would be accepted
+type txCheck struct {
+	Valid bool   `json:"valid"`
+	Error string `json:"error,omitempty"`
+}
+
+// serveTxCheck answers a POST of /tx/check, whose body is a hex encoded, signed Transaction, with whether
+// sendrawtransaction would accept it. Nothing is added to the Mempool or relayed
+func (s *Server) serveTxCheck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Transactions must be POSTed to be checked", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	tx, err := types.DecodeRawTransaction(string(bytes.TrimSpace(body)))
+	if err != nil {
+		http.Error(w, "Invalid transaction: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result := txCheck{Valid: true}
+	if err := s.bc.CheckTransaction(tx); err != nil {
+		result = txCheck{Error: err.Error()}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
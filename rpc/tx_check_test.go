@@ -0,0 +1,52 @@
+package rpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danitello/go-blockchain/core"
+	"github.com/danitello/go-blockchain/core/testutil"
+	"github.com/danitello/go-blockchain/core/types"
+)
+
+func TestServeTxCheck(t *testing.T) {
+	bc, ws := testutil.BuildTestChain(t, 3)
+	tx := testutil.SpendEach(t, bc, ws)[0]
+	s := InitServer(bc)
+
+	check := func(method, body string) (int, txCheck) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(method, "/tx/check", strings.NewReader(body)))
+		var result txCheck
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec.Code, result
+	}
+
+	if code, result := check(http.MethodPost, types.EncodeRawTransaction(tx)); code != http.StatusOK || !result.Valid {
+		t.Fatalf("Got %d %+v for a valid transaction", code, result)
+	}
+	if bc.Mempool.Size() != 0 {
+		t.Fatal("Checked transaction was added to the mempool")
+	}
+
+	bc.Mempool.MinRelayFee = 1
+	if code, result := check(http.MethodPost, types.EncodeRawTransaction(tx)); code != http.StatusOK || result.Valid ||
+		result.Error != core.ErrFeeTooLow.Error() {
+		t.Fatalf("Got %d %+v, want ErrFeeTooLow", code, result)
+	}
+
+	if code, _ := check(http.MethodPost, "not hex"); code != http.StatusBadRequest {
+		t.Fatalf("Got %d for bad hex, want %d", code, http.StatusBadRequest)
+	}
+	if code, _ := check(http.MethodGet, ""); code != http.StatusMethodNotAllowed {
+		t.Fatalf("Got %d for a GET, want %d", code, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,71 @@
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/danitello/go-blockchain/crypto"
+	"github.com/danitello/go-blockchain/wallet/walletutil"
+	"golang.org/x/crypto/ripemd160"
+)
+
+// AddressParams is the address scheme of a network. Addresses made under one are rejected by ValidateAddress under
+// another, as their checksums don't match -
+// ChecksumLen - number of bytes of the checksum ending each address
+// ChecksumHash - name of the hash whose first ChecksumLen bytes are the checksum, a key of ChecksumHashes
+type AddressParams struct {
+	ChecksumLen  int
+	ChecksumHash string
+}
+
+// ChecksumHashes are the hashes an address checksum can be taken from, by name
+var ChecksumHashes = map[string]func([]byte) []byte{
+	"sha256d": crypto.DoubleHash256,
+	"sha256":  crypto.Hash256,
+}
+
+// DefaultAddressParams is the address scheme of GetAddress, AddressFromPubKeyHash and ValidateAddress, that of
+// Bitcoin
+var DefaultAddressParams = AddressParams{ChecksumLen: ChecksumLen, ChecksumHash: "sha256d"}
+
+// Validate checks that the AddressParams name a known hash with a checksum length it can provide
+func (p AddressParams) Validate() error {
+	hash, ok := ChecksumHashes[p.ChecksumHash]
+	if !ok {
+		return fmt.Errorf("Unknown checksum hash %q", p.ChecksumHash)
+	}
+	if p.ChecksumLen < 1 || p.ChecksumLen > len(hash(nil)) {
+		return errors.New("Checksum length must be between 1 and the length of the hash")
+	}
+
+	return nil
+}
+
+// Address derives the address for a pub key hash, e.g. one read from a txo, under the AddressParams
+func (p AddressParams) Address(pubKeyHash []byte) []byte {
+	versionedHash := append([]byte{version}, pubKeyHash...)
+	checksum := addressChecksum(versionedHash, p)
+	fullHash := append(versionedHash, checksum...)
+
+	return walletutil.Base58Encode(fullHash)
+}
+
+// ValidateAddress determines if a given address is correctly constructed under the AddressParams. An address whose
+// length doesn't fit their ChecksumLen is rejected before its checksum is compared
+func (p AddressParams) ValidateAddress(address string) bool {
+	decodedAddress, err := walletutil.ParseBase58(address)
+	if err != nil || len(decodedAddress) != 1+ripemd160.Size+p.ChecksumLen || decodedAddress[0] != version {
+		return false
+	}
+
+	checksum := decodedAddress[len(decodedAddress)-p.ChecksumLen:]
+	targetChecksum := addressChecksum(decodedAddress[0:len(decodedAddress)-p.ChecksumLen], p)
+
+	return bytes.Compare(checksum, targetChecksum) == 0
+}
+
+// addressChecksum computes the checksum of the payload of an address under given AddressParams
+func addressChecksum(payload []byte, p AddressParams) []byte {
+	return ChecksumHashes[p.ChecksumHash](payload)[:p.ChecksumLen]
+}
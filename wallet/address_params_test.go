@@ -0,0 +1,69 @@
+package wallet
+
+import "testing"
+
+func TestAddressParamsMismatch(t *testing.T) {
+	w := testWallet(t, 1)
+	configs := []AddressParams{
+		DefaultAddressParams,
+		{ChecksumLen: 4, ChecksumHash: "sha256"},
+		{ChecksumLen: 6, ChecksumHash: "sha256d"},
+	}
+
+	addresses := make([]string, len(configs))
+	for i, p := range configs {
+		addresses[i] = string(w.GetAddressWithParams(p))
+		if !p.ValidateAddress(addresses[i]) {
+			t.Fatalf("%+v: address made under it fails validation", p)
+		}
+		if got := GetPubKeyHashFromAddress(addresses[i]); string(got) != string(HashPubKey(w.GetPubKey())) {
+			t.Fatalf("%+v: got pub key hash %x back", p, got)
+		}
+	}
+	if addresses[0] != string(w.GetAddress()) || !ValidateAddress(addresses[0]) {
+		t.Fatal("DefaultAddressParams aren't those of GetAddress and ValidateAddress")
+	}
+
+	for i, p := range configs {
+		for j, address := range addresses {
+			if i != j && p.ValidateAddress(address) {
+				t.Fatalf("Address made under %+v validates under %+v", configs[j], p)
+			}
+		}
+	}
+}
+
+func TestWalletsAddressParams(t *testing.T) {
+	p := AddressParams{ChecksumLen: 6, ChecksumHash: "sha256"}
+	ws := &Wallets{Wallets: make(map[string]*Wallet), Labels: make(map[string]string), params: p}
+
+	address := ws.CreateWallet(true)
+	if !p.ValidateAddress(address) || ValidateAddress(address) {
+		t.Fatalf("Created %s under %+v, which validates under DefaultAddressParams", address, p)
+	}
+	if errs := ws.Validate(); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if err := ws.SetLabel(string(testWallet(t, 1).GetAddress()), "default"); err == nil {
+		t.Fatal("Labeled an address made under DefaultAddressParams")
+	}
+
+	if (&Wallets{}).AddressParams() != DefaultAddressParams {
+		t.Fatal("Wallets without params don't use DefaultAddressParams")
+	}
+}
+
+func TestAddressParamsInvalid(t *testing.T) {
+	for _, p := range []AddressParams{
+		{ChecksumLen: 4, ChecksumHash: "md5"},
+		{ChecksumLen: 0, ChecksumHash: "sha256"},
+		{ChecksumLen: 33, ChecksumHash: "sha256"},
+	} {
+		if err := p.Validate(); err == nil {
+			t.Fatalf("Validated invalid params %+v", p)
+		}
+		if _, err := InitWalletsWithParams(p); err == nil {
+			t.Fatalf("Made Wallets with invalid params %+v", p)
+		}
+	}
+}
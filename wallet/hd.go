@@ -0,0 +1,320 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"math/big"
+
+	"github.com/danitello/go-blockchain/wallet/walletutil"
+)
+
+// Extended keys follow BIP 32 on the P256 curve (as SLIP 10 does), serialized in the Bitcoin xprv/xpub format. Keys
+// are interchangeable with other tools using P256, but not with Bitcoin wallets, whose keys are on secp256k1
+
+const (
+	// HardenedKeyStart is the first child index of a hardened child, which can only be derived from a private key
+	HardenedKeyStart = uint32(1) << 31
+	// ReceiveChain is the child index under an account key of the chain of receive addresses
+	ReceiveChain = 0
+
+	// masterKeySalt is the HMAC key deriving a master key from a seed (SLIP 10)
+	masterKeySalt = "Nist256p1 seed"
+	// extendedKeyLen is the length of a serialized extended key before its checksum
+	extendedKeyLen = 78
+)
+
+var (
+	// xprvVersion and xpubVersion start serialized private and public extended keys
+	xprvVersion = []byte{0x04, 0x88, 0xad, 0xe4}
+	xpubVersion = []byte{0x04, 0x88, 0xb2, 0x1e}
+
+	// ErrBadExtendedKey is returned when parsing a string that is not a serialized extended key
+	ErrBadExtendedKey = errors.New("Malformed extended key")
+	// ErrInvalidChild is returned for the rare child index whose key falls outside the curve order, which is skipped
+	ErrInvalidChild = errors.New("Child index gives an invalid key, use the next one")
+)
+
+// extendedKey is a key along with the chain code its children are derived with -
+// priv - the private key, nil for a public extended key
+// x, y - the pub key
+// depth - number of derivations from the master key
+// parentFP - first 4 bytes of the HashPubKey of the parent's compressed pub key, zeroes for the master key
+// childNum - index of the key under its parent
+type extendedKey struct {
+	priv      *big.Int
+	x, y      *big.Int
+	chainCode []byte
+	depth     byte
+	parentFP  []byte
+	childNum  uint32
+}
+
+// HDWallet is a hierarchical deterministic wallet, holding the private extended key of an account from which the key
+// of each receive address is derived
+type HDWallet struct {
+	key *extendedKey
+}
+
+// HDWatchWallet is the watch-only form of an HDWallet, holding only the public extended key. It can derive every
+// receive address but no private key, so can't spend
+type HDWatchWallet struct {
+	key *extendedKey
+}
+
+// InitHDWallet derives the master key of an HDWallet from a seed of 16 to 64 bytes
+func InitHDWallet(seed []byte) (*HDWallet, error) {
+	if len(seed) < 16 || len(seed) > 64 {
+		return nil, errors.New("Seed must be 16 to 64 bytes")
+	}
+
+	mac := hmac.New(sha512.New, []byte(masterKeySalt))
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+
+	d := new(big.Int).SetBytes(sum[:32])
+	if d.Sign() == 0 || d.Cmp(elliptic.P256().Params().N) >= 0 {
+		return nil, errors.New("Seed gives an invalid master key, use another seed")
+	}
+
+	return &HDWallet{newPrivateKey(d, sum[32:], 0, make([]byte, 4), 0)}, nil
+}
+
+// ImportXPrv creates an HDWallet from a serialized private extended key, e.g. from XPrv
+func ImportXPrv(xprv string) (*HDWallet, error) {
+	key, err := parseExtendedKey(xprv)
+	if err != nil {
+		return nil, err
+	}
+	if key.priv == nil {
+		return nil, errors.New("Extended key is public, import it with ImportXPub")
+	}
+
+	return &HDWallet{key}, nil
+}
+
+// ImportXPub creates an HDWatchWallet from a serialized public extended key, e.g. from HDWallet.XPub
+func ImportXPub(xpub string) (*HDWatchWallet, error) {
+	key, err := parseExtendedKey(xpub)
+	if err != nil {
+		return nil, err
+	}
+	if key.priv != nil {
+		return nil, errors.New("Extended key is private, a watch-only wallet takes the public key")
+	}
+
+	return &HDWatchWallet{key}, nil
+}
+
+// Child derives the HDWallet of the child key at an index, hardened if index is at least HardenedKeyStart, e.g. an
+// account under the master key
+func (hd *HDWallet) Child(index uint32) (*HDWallet, error) {
+	child, err := hd.key.child(index)
+	if err != nil {
+		return nil, err
+	}
+
+	return &HDWallet{child}, nil
+}
+
+// XPrv serializes the private extended key
+func (hd *HDWallet) XPrv() string {
+	return hd.key.String()
+}
+
+// XPub serializes the public extended key, to import with ImportXPub
+func (hd *HDWallet) XPub() string {
+	return hd.key.public().String()
+}
+
+// Watch gets the HDWatchWallet of the HDWallet
+func (hd *HDWallet) Watch() *HDWatchWallet {
+	return &HDWatchWallet{hd.key.public()}
+}
+
+// Wallet derives the Wallet of the receive address at an index, whose address uses the compressed pub key
+func (hd *HDWallet) Wallet(index uint32) (*Wallet, error) {
+	key, err := receiveKey(hd.key, index)
+	if err != nil {
+		return nil, err
+	}
+
+	priv, pub := keyPairFromD(key.priv)
+	return &Wallet{priv, pub, true}, nil
+}
+
+// ReceiveAddress derives the receive address at an index
+func (hd *HDWallet) ReceiveAddress(index uint32) (string, error) {
+	return hd.Watch().ReceiveAddress(index)
+}
+
+// XPub serializes the public extended key
+func (hd *HDWatchWallet) XPub() string {
+	return hd.key.String()
+}
+
+// ReceivePubKeyHash derives the pub key hash of the receive address at an index
+func (hd *HDWatchWallet) ReceivePubKeyHash(index uint32) ([]byte, error) {
+	key, err := receiveKey(hd.key, index)
+	if err != nil {
+		return nil, err
+	}
+
+	return HashPubKey(compressPubKey(key.x, key.y)), nil
+}
+
+// ReceiveAddress derives the receive address at an index, the same one as HDWallet.ReceiveAddress
+func (hd *HDWatchWallet) ReceiveAddress(index uint32) (string, error) {
+	pubKeyHash, err := hd.ReceivePubKeyHash(index)
+	if err != nil {
+		return "", err
+	}
+
+	return string(AddressFromPubKeyHash(pubKeyHash)), nil
+}
+
+// receiveKey derives the key of the receive address at an index under an account key
+func receiveKey(account *extendedKey, index uint32) (*extendedKey, error) {
+	if index >= HardenedKeyStart {
+		return nil, errors.New("Receive addresses use non-hardened indices")
+	}
+
+	chain, err := account.child(ReceiveChain)
+	if err != nil {
+		return nil, err
+	}
+
+	return chain.child(index)
+}
+
+// newPrivateKey creates a private extendedKey, working out its pub key
+func newPrivateKey(d *big.Int, chainCode []byte, depth byte, parentFP []byte, childNum uint32) *extendedKey {
+	x, y := elliptic.P256().ScalarBaseMult(d.Bytes())
+	return &extendedKey{d, x, y, chainCode, depth, parentFP, childNum}
+}
+
+// child derives the child key at an index. A public key can only derive non-hardened children, which are the public
+// keys of the children its private key derives
+func (k *extendedKey) child(index uint32) (*extendedKey, error) {
+	hardened := index >= HardenedKeyStart
+	if hardened && k.priv == nil {
+		return nil, errors.New("Cannot derive a hardened child from a public key")
+	}
+	if k.depth == 255 {
+		return nil, errors.New("Extended key is at the maximum depth")
+	}
+
+	data := make([]byte, 0, 37)
+	if hardened {
+		data = append(data, 0x00)
+		data = append(data, padTo32(k.priv.Bytes())...)
+	} else {
+		data = append(data, compressPubKey(k.x, k.y)...)
+	}
+	indexBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(indexBytes, index)
+	data = append(data, indexBytes...)
+
+	mac := hmac.New(sha512.New, k.chainCode)
+	mac.Write(data)
+	sum := mac.Sum(nil)
+
+	curve := elliptic.P256()
+	n := curve.Params().N
+	il := new(big.Int).SetBytes(sum[:32])
+	if il.Cmp(n) >= 0 {
+		return nil, ErrInvalidChild
+	}
+	parentFP := HashPubKey(compressPubKey(k.x, k.y))[:4]
+
+	if k.priv != nil {
+		d := new(big.Int).Add(il, k.priv)
+		d.Mod(d, n)
+		if d.Sign() == 0 {
+			return nil, ErrInvalidChild
+		}
+		return newPrivateKey(d, sum[32:], k.depth+1, parentFP, index), nil
+	}
+
+	ilx, ily := curve.ScalarBaseMult(sum[:32])
+	x, y := curve.Add(ilx, ily, k.x, k.y)
+	if x.Sign() == 0 && y.Sign() == 0 {
+		return nil, ErrInvalidChild // point at infinity
+	}
+
+	return &extendedKey{nil, x, y, sum[32:], k.depth + 1, parentFP, index}, nil
+}
+
+// public gets the public extendedKey of k
+func (k *extendedKey) public() *extendedKey {
+	return &extendedKey{nil, k.x, k.y, k.chainCode, k.depth, k.parentFP, k.childNum}
+}
+
+// String serializes the key with Base58 - version, depth, parentFP, childNum, chainCode and the key (0x00 and the
+// private key, or the compressed pub key), followed by a checksum
+func (k *extendedKey) String() string {
+	version, keyData := xpubVersion, compressPubKey(k.x, k.y)
+	if k.priv != nil {
+		version, keyData = xprvVersion, append([]byte{0x00}, padTo32(k.priv.Bytes())...)
+	}
+
+	data := make([]byte, 0, extendedKeyLen+ChecksumLen)
+	data = append(data, version...)
+	data = append(data, k.depth)
+	data = append(data, k.parentFP...)
+	childNum := make([]byte, 4)
+	binary.BigEndian.PutUint32(childNum, k.childNum)
+	data = append(data, childNum...)
+	data = append(data, k.chainCode...)
+	data = append(data, keyData...)
+	data = append(data, checksum(data)...)
+
+	return string(walletutil.Base58Encode(data))
+}
+
+// parseExtendedKey decodes a key serialized by extendedKey.String, checking the key is valid
+func parseExtendedKey(s string) (*extendedKey, error) {
+	data, err := walletutil.ParseBase58(s)
+	if err != nil || len(data) != extendedKeyLen+ChecksumLen {
+		return nil, ErrBadExtendedKey
+	}
+	payload := data[:extendedKeyLen]
+	if bytes.Compare(data[extendedKeyLen:], checksum(payload)) != 0 {
+		return nil, ErrBadExtendedKey
+	}
+
+	k := &extendedKey{
+		depth:     payload[4],
+		parentFP:  append([]byte{}, payload[5:9]...),
+		childNum:  binary.BigEndian.Uint32(payload[9:13]),
+		chainCode: append([]byte{}, payload[13:45]...)}
+	keyData := payload[45:]
+
+	switch {
+	case bytes.Compare(payload[:4], xprvVersion) == 0:
+		d := new(big.Int).SetBytes(keyData[1:])
+		if keyData[0] != 0x00 || d.Sign() == 0 || d.Cmp(elliptic.P256().Params().N) >= 0 {
+			return nil, ErrBadExtendedKey
+		}
+		return newPrivateKey(d, k.chainCode, k.depth, k.parentFP, k.childNum), nil
+	case bytes.Compare(payload[:4], xpubVersion) == 0:
+		pub, err := ParsePubKey(keyData)
+		if err != nil || len(keyData) != CompressedPubKeyLen || !elliptic.P256().IsOnCurve(pub.X, pub.Y) {
+			return nil, ErrBadExtendedKey
+		}
+		k.x, k.y = pub.X, pub.Y
+		return k, nil
+	}
+
+	return nil, ErrBadExtendedKey
+}
+
+// padTo32 left pads b with zeroes to 32 bytes
+func padTo32(b []byte) []byte {
+	padded := make([]byte, 32)
+	copy(padded[32-len(b):], b)
+	return padded
+}
@@ -0,0 +1,94 @@
+package wallet
+
+import (
+	"bytes"
+	"testing"
+)
+
+// testAccount derives the first hardened account of an HDWallet from a fixed seed
+func testAccount(t *testing.T) *HDWallet {
+	t.Helper()
+	master, err := InitHDWallet(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	account, err := master.Child(HardenedKeyStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return account
+}
+
+func TestImportXPubMatchesXPrv(t *testing.T) {
+	account := testAccount(t)
+
+	imported, err := ImportXPrv(account.XPrv())
+	if err != nil {
+		t.Fatal(err)
+	}
+	watch, err := ImportXPub(account.XPub())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if watch.XPub() != account.XPub() || imported.XPub() != account.XPub() {
+		t.Fatal("Extended keys changed by importing")
+	}
+
+	for i := uint32(0); i < 10; i++ {
+		w, err := imported.Wallet(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		watched, err := watch.ReceiveAddress(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(w.GetAddress()) != watched {
+			t.Fatalf("Got %s from the xpub for index %d, want %s from the xprv", watched, i, w.GetAddress())
+		}
+		if err := validateWallet(watched, w, DefaultAddressParams); err != nil {
+			t.Fatal(err)
+		}
+	}
+	first, _ := watch.ReceiveAddress(0)
+	if second, _ := watch.ReceiveAddress(1); first == second {
+		t.Fatal("Different indices gave the same address")
+	}
+}
+
+func TestWatchWalletCannotSpend(t *testing.T) {
+	account := testAccount(t)
+	watch := account.Watch()
+
+	if _, err := ImportXPub(account.XPrv()); err == nil {
+		t.Fatal("Watch-only wallet imported from a private key")
+	}
+	if _, err := ImportXPrv(account.XPub()); err == nil {
+		t.Fatal("Spending wallet imported from a public key")
+	}
+	if watch.key.priv != nil {
+		t.Fatal("Watch-only wallet holds a private key")
+	}
+	if _, err := watch.key.child(HardenedKeyStart); err == nil {
+		t.Fatal("Hardened child derived from a public key")
+	}
+	if _, err := watch.ReceiveAddress(HardenedKeyStart); err == nil {
+		t.Fatal("Receive address derived at a hardened index")
+	}
+}
+
+func TestParseExtendedKeyMalformed(t *testing.T) {
+	xpub := testAccount(t).XPub()
+	corrupt := []byte(xpub)
+	if corrupt[20] == 'a' {
+		corrupt[20] = 'b'
+	} else {
+		corrupt[20] = 'a'
+	}
+
+	for _, s := range []string{"", "not base58 0OIl", xpub[:len(xpub)-1], string(corrupt)} {
+		if _, err := ImportXPub(s); err != ErrBadExtendedKey {
+			t.Fatalf("Got %v importing %q, want ErrBadExtendedKey", err, s)
+		}
+	}
+}
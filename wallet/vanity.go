@@ -0,0 +1,64 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// base58Alphabet is the set of characters an address can contain
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// GenerateVanityWallet makes new Wallets on workers goroutines until one has an address starting with prefix.
+// Every address starts with "1" (the version byte), and each character after it multiplies the expected number
+// of attempts by 58, so the time taken grows exponentially with the length of prefix. Returns ctx.Err() if ctx
+// is done before a match is found
+func GenerateVanityWallet(prefix string, workers int, ctx context.Context) (*Wallet, error) {
+	for _, c := range prefix {
+		if !strings.ContainsRune(base58Alphabet, c) {
+			return nil, fmt.Errorf("Prefix contains invalid base58 character %q", c)
+		}
+	}
+	if !strings.HasPrefix(prefix, "1") {
+		return nil, errors.New("Prefix must start with 1")
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	found := make(chan *Wallet, 1)
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for ctx.Err() == nil {
+				w := InitWallet()
+				if strings.HasPrefix(string(w.GetAddress()), prefix) {
+					select {
+					case found <- w:
+						cancel() // stop the other workers
+					default:
+					}
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	select {
+	case w := <-found:
+		return w, nil
+	default:
+		return nil, ctx.Err()
+	}
+}
@@ -1,75 +1,217 @@
 package wallet
 
 import (
-	"bytes"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
-	"crypto/sha256"
+	"errors"
+	"io"
+	"math/big"
 
 	"github.com/danitello/go-blockchain/common/errutil"
+	"github.com/danitello/go-blockchain/crypto"
 	"github.com/danitello/go-blockchain/wallet/walletutil"
 	"golang.org/x/crypto/ripemd160"
 )
 
 const (
-	// ChecksumLen is number of initial bytes to take from result of the sha256 hashes of the pub key hash
+	// ChecksumLen is number of initial bytes to take from result of the sha256 hashes of the pub key hash, by default
+	// (see AddressParams) and always for an extended key
 	ChecksumLen = 4
 	// version of gen algo
 	version = byte(0x00)
+	// CompressedPubKeyLen is the length of a compressed pub key (prefix byte and x)
+	CompressedPubKeyLen = 33
 )
 
-// Wallet is the entity for ownership on the chain
+// Wallet is the entity for ownership on the chain -
+// Compressed - whether the address (and txins) use the compressed form of the pub key
 type Wallet struct {
 	PrivateKey ecdsa.PrivateKey
 	PublicKey  []byte
+	Compressed bool
 }
 
 // InitWallet initializes a new Wallet
 func InitWallet() *Wallet {
 	priv, pub := createKeyPair()
-	return &Wallet{priv, pub}
+	return &Wallet{priv, pub, false}
+}
+
+// InitCompressedWallet initializes a new Wallet whose address uses the compressed pub key
+func InitCompressedWallet() *Wallet {
+	w := InitWallet()
+	w.Compressed = true
+	return w
+}
+
+// InitWalletFromReader initializes a new Wallet whose key is derived from the entropy read from r,
+// so a deterministic r (e.g. in tests) always gives the same Wallet
+func InitWalletFromReader(r io.Reader) (*Wallet, error) {
+	priv, pub, err := createKeyPairFromReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &Wallet{priv, pub, false}, nil
 }
 
 // createKeyPair makes a new priv and pub key pair
 func createKeyPair() (ecdsa.PrivateKey, []byte) {
-	curve := elliptic.P256()
-	privKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+	privKey, pubKey, err := createKeyPairFromReader(rand.Reader)
 	errutil.Handle(err)
 
+	return privKey, pubKey
+}
+
+// createKeyPairFromReader makes a priv and pub key pair from the entropy read from r. Unlike ecdsa.GenerateKey the
+// result only depends on the bytes read
+func createKeyPairFromReader(r io.Reader) (ecdsa.PrivateKey, []byte, error) {
+	curve := elliptic.P256()
+	params := curve.Params()
+
+	// Read 64 more bits than needed so the reduction into [1, N-1] is unbiased (FIPS 186-3, B.4.1)
+	b := make([]byte, params.BitSize/8+8)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return ecdsa.PrivateKey{}, nil, err
+	}
+
+	one := big.NewInt(1)
+	d := new(big.Int).SetBytes(b)
+	d.Mod(d, new(big.Int).Sub(params.N, one))
+	d.Add(d, one)
+
+	privKey, pubKey := keyPairFromD(d)
+	return privKey, pubKey, nil
+}
+
+// keyPairFromD makes the priv and pub key pair of a P256 private key d in [1, N-1]
+func keyPairFromD(d *big.Int) (ecdsa.PrivateKey, []byte) {
+	curve := elliptic.P256()
+
+	var privKey ecdsa.PrivateKey
+	privKey.Curve = curve
+	privKey.D = d
+	privKey.X, privKey.Y = curve.ScalarBaseMult(d.Bytes())
+
 	// Derive []byte representation of pub key
 	pubKey := append(privKey.PublicKey.X.Bytes(), privKey.PublicKey.Y.Bytes()...)
-	return *privKey, pubKey
+	return privKey, pubKey
 }
 
-// GetAddress derives the human readable address for a Wallet using pub key hash, version, and checksum (bitcoin spec)
-func (w Wallet) GetAddress() []byte {
-	pubKeyHash := HashPubKey(w.PublicKey)
+// Zero overwrites the words of the private key D and leaves it 0, so the Wallet can no longer sign (see Zeroed).
+// This is best effort - copies of the key made by the runtime or the caller (e.g. a Wallet passed by value, or the
+// gob encoding of Wallets) are not reached, and the GC may already have moved or copied the words
+func (w *Wallet) Zero() {
+	if w.PrivateKey.D == nil {
+		return
+	}
+
+	words := w.PrivateKey.D.Bits()
+	for i := range words {
+		words[i] = 0
+	}
+	w.PrivateKey.D.SetInt64(0)
+}
 
-	versionedHash := append([]byte{version}, pubKeyHash...)
-	checksum := checksum(versionedHash)
-	fullHash := append(versionedHash, checksum...)
+// Zeroed determines whether the private key has been wiped by Zero (or was never set)
+func (w Wallet) Zeroed() bool {
+	return w.PrivateKey.D == nil || w.PrivateKey.D.Sign() == 0
+}
 
-	return walletutil.Base58Encode(fullHash)
+// CompressedPubKey gets the compressed form of the pub key - 0x02 (even y) or 0x03 (odd y) followed by x
+func (w Wallet) CompressedPubKey() []byte {
+	return compressPubKey(w.PrivateKey.X, w.PrivateKey.Y)
 }
 
-// ValidateAddress determines if a given address is correctly constructed
-func ValidateAddress(address string) bool {
-	decodedAddress := walletutil.Base58Decode([]byte(address))
+// compressPubKey gets the compressed form of the pub key at a point (x, y)
+func compressPubKey(x, y *big.Int) []byte {
+	prefix := byte(0x02)
+	if y.Bit(0) == 1 {
+		prefix = 0x03
+	}
 
-	addressChecksum := decodedAddress[len(decodedAddress)-ChecksumLen:]
-	targetChecksum := checksum(decodedAddress[0 : len(decodedAddress)-ChecksumLen])
+	xBytes := x.Bytes()
+	compressed := make([]byte, CompressedPubKeyLen)
+	compressed[0] = prefix
+	copy(compressed[CompressedPubKeyLen-len(xBytes):], xBytes) // left pad x to 32 bytes
 
-	return bytes.Compare(addressChecksum, targetChecksum) == 0
+	return compressed
+}
+
+// GetPubKey gets the pub key in the form the address is derived from
+func (w Wallet) GetPubKey() []byte {
+	if w.Compressed {
+		return w.CompressedPubKey()
+	}
+	return w.PublicKey
+}
+
+// ParsePubKey reconstructs an ecdsa pub key from either its compressed or uncompressed []byte form
+func ParsePubKey(pubKey []byte) (*ecdsa.PublicKey, error) {
+	curve := elliptic.P256()
+
+	if len(pubKey) == CompressedPubKeyLen && (pubKey[0] == 0x02 || pubKey[0] == 0x03) {
+		x := new(big.Int).SetBytes(pubKey[1:])
+		y, err := decompressY(curve, x, pubKey[0] == 0x03)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+
+	keyLen := len(pubKey)
+	x := new(big.Int).SetBytes(pubKey[:(keyLen / 2)])
+	y := new(big.Int).SetBytes(pubKey[(keyLen / 2):])
+
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+// decompressY solves the curve equation y^2 = x^3 - 3x + b for the y with the given parity
+func decompressY(curve elliptic.Curve, x *big.Int, odd bool) (*big.Int, error) {
+	params := curve.Params()
+
+	x3 := new(big.Int).Exp(x, big.NewInt(3), params.P)
+	threeX := new(big.Int).Mul(x, big.NewInt(3))
+	ySquared := new(big.Int).Sub(x3, threeX)
+	ySquared.Add(ySquared, params.B)
+	ySquared.Mod(ySquared, params.P)
+
+	y := new(big.Int).ModSqrt(ySquared, params.P)
+	if y == nil {
+		return nil, errors.New("Compressed pub key is not on the curve")
+	}
+	if (y.Bit(0) == 1) != odd {
+		y.Sub(params.P, y)
+	}
+
+	return y, nil
+}
 
+// GetAddress derives the human readable address for a Wallet using pub key hash, version, and checksum (bitcoin spec)
+func (w Wallet) GetAddress() []byte {
+	return w.GetAddressWithParams(DefaultAddressParams)
+}
+
+// GetAddressWithParams derives the address for a Wallet under given AddressParams, e.g. those of the network it is
+// used on
+func (w Wallet) GetAddressWithParams(p AddressParams) []byte {
+	return p.Address(HashPubKey(w.GetPubKey()))
+}
+
+// AddressFromPubKeyHash derives the address for a pub key hash, e.g. one read from a txo, under DefaultAddressParams
+func AddressFromPubKeyHash(pubKeyHash []byte) []byte {
+	return DefaultAddressParams.Address(pubKeyHash)
+}
+
+// ValidateAddress determines if a given address is correctly constructed under DefaultAddressParams
+func ValidateAddress(address string) bool {
+	return DefaultAddressParams.ValidateAddress(address)
 }
 
 // HashPubKey computes the pub key hash
 func HashPubKey(pubKey []byte) []byte {
-	shaPubKey := sha256.Sum256(pubKey)
-
 	ripemdHasher := ripemd160.New()
-	_, err := ripemdHasher.Write(shaPubKey[:])
+	_, err := ripemdHasher.Write(crypto.Hash256(pubKey))
 	errutil.Handle(err)
 	ripemdPubKey := ripemdHasher.Sum(nil)
 
@@ -77,17 +219,15 @@ func HashPubKey(pubKey []byte) []byte {
 
 }
 
-// checksum computes the checksum of a given payload
+// checksum computes the checksum of a given payload, e.g. an extended key. Addresses use addressChecksum
 func checksum(payload []byte) []byte {
-	firstSHA := sha256.Sum256(payload)
-	secondSHA := sha256.Sum256(firstSHA[:])
-
-	return secondSHA[:ChecksumLen]
+	return crypto.DoubleHash256(payload)[:ChecksumLen]
 }
 
-// GetPubKeyHashFromAddress takes in an address and returns its pub key hash portion
+// GetPubKeyHashFromAddress takes in an address and returns its pub key hash portion. It comes before the checksum, so
+// is found the same way under any AddressParams
 func GetPubKeyHashFromAddress(address string) []byte {
 	decodedAddress := walletutil.Base58Decode([]byte(address))
-	pubKeyHash := decodedAddress[1 : len(decodedAddress)-ChecksumLen]
+	pubKeyHash := decodedAddress[1 : 1+ripemd160.Size]
 	return pubKeyHash
 }
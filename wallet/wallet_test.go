@@ -0,0 +1,140 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"math/big"
+	"math/rand"
+	"testing"
+)
+
+// testWallet makes a Wallet from a seeded reader, so it is the same on every run
+func testWallet(t *testing.T, seed int64) *Wallet {
+	t.Helper()
+	w, err := InitWalletFromReader(rand.New(rand.NewSource(seed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func TestInitWalletFromReaderRepeats(t *testing.T) {
+	a, b := testWallet(t, 7), testWallet(t, 7)
+	if string(a.GetAddress()) != string(b.GetAddress()) || a.PrivateKey.D.Cmp(b.PrivateKey.D) != 0 {
+		t.Fatal("Same seed gave different wallets")
+	}
+	if string(testWallet(t, 8).GetAddress()) == string(a.GetAddress()) {
+		t.Fatal("Different seeds gave the same address")
+	}
+	if err := validateWallet(string(a.GetAddress()), a, DefaultAddressParams); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := InitWalletFromReader(bytes.NewReader(make([]byte, 8))); err == nil {
+		t.Fatal("Made a wallet from too little entropy")
+	}
+}
+
+func TestCompressedPubKeyRoundTrip(t *testing.T) {
+	for seed := int64(1); seed <= 20; seed++ {
+		w := testWallet(t, seed)
+
+		compressed := w.CompressedPubKey()
+		if len(compressed) != CompressedPubKeyLen {
+			t.Fatalf("Seed %d: compressed pub key has %d bytes", seed, len(compressed))
+		}
+		if wantPrefix := byte(0x02 + w.PrivateKey.Y.Bit(0)); compressed[0] != wantPrefix {
+			t.Fatalf("Seed %d: prefix %x for y parity %d", seed, compressed[0], w.PrivateKey.Y.Bit(0))
+		}
+
+		for _, pubKey := range [][]byte{compressed, w.PublicKey} {
+			parsed, err := ParsePubKey(pubKey)
+			if err != nil {
+				t.Fatalf("Seed %d: %s", seed, err)
+			}
+			if parsed.X.Cmp(w.PrivateKey.X) != 0 || parsed.Y.Cmp(w.PrivateKey.Y) != 0 {
+				t.Fatalf("Seed %d: %x parsed to a different point", seed, pubKey)
+			}
+		}
+	}
+}
+
+func TestCompressedAddress(t *testing.T) {
+	w := testWallet(t, 1)
+	uncompressed := string(w.GetAddress())
+	w.Compressed = true
+	compressed := string(w.GetAddress())
+
+	if compressed == uncompressed {
+		t.Fatal("Compressed and uncompressed keys give the same address")
+	}
+	for _, address := range []string{uncompressed, compressed} {
+		if !ValidateAddress(address) {
+			t.Fatalf("Address %s is invalid", address)
+		}
+	}
+	if bytes.Compare(GetPubKeyHashFromAddress(compressed), HashPubKey(w.CompressedPubKey())) != 0 {
+		t.Fatal("Compressed address does not hold the hash of the compressed pub key")
+	}
+}
+
+func TestParsePubKeyNotOnCurve(t *testing.T) {
+	// About half of all x have no point on the curve
+	for x := int64(1); x < 100; x++ {
+		pubKey := make([]byte, CompressedPubKeyLen)
+		pubKey[0] = 0x02
+		xBytes := big.NewInt(x).Bytes()
+		copy(pubKey[CompressedPubKeyLen-len(xBytes):], xBytes)
+
+		if _, err := ParsePubKey(pubKey); err != nil {
+			return
+		}
+	}
+	t.Fatal("Every x parsed as a point on the curve")
+}
+
+func TestAddressVectors(t *testing.T) {
+	tests := []struct{ pubKeyHash, address string }{
+		{"0000000000000000000000000000000000000000", "1111111111111111111114oLvT2"},
+		{"62e907b15cbf27d5425399ebf6f0fb50ebb88f18", "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa"},
+	}
+
+	for _, test := range tests {
+		pubKeyHash, err := hex.DecodeString(test.pubKeyHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(AddressFromPubKeyHash(pubKeyHash)); got != test.address {
+			t.Errorf("Address of %s is %s, want %s", test.pubKeyHash, got, test.address)
+		}
+		if !ValidateAddress(test.address) {
+			t.Errorf("Address %s is invalid", test.address)
+		}
+	}
+}
+
+func TestZero(t *testing.T) {
+	w := testWallet(t, 1)
+	words := w.PrivateKey.D.Bits()
+	address := string(w.GetAddress())
+
+	w.Zero()
+	if !w.Zeroed() || w.PrivateKey.D.Sign() != 0 {
+		t.Fatal("Key not zeroed")
+	}
+	for _, word := range words {
+		if word != 0 {
+			t.Fatal("Old key bytes left in memory")
+		}
+	}
+	if _, _, err := ecdsa.Sign(rand.New(rand.NewSource(1)), &w.PrivateKey, make([]byte, 32)); err == nil {
+		t.Fatal("Zeroed key can still sign")
+	}
+	if string(w.GetAddress()) != address {
+		t.Fatal("Zero changed the pub key")
+	}
+
+	w.Zero() // already zeroed
+	(&Wallet{}).Zero()
+}
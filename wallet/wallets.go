@@ -4,40 +4,94 @@ import (
 	"bytes"
 	"crypto/elliptic"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"sort"
+	"strings"
 
 	"github.com/danitello/go-blockchain/common/errutil"
 )
 
 const walletFile = "./tmp/wallets.dat"
 
-// Wallets keeps track of all current Wallet structs
+// MaxLabelLen is the most bytes a label set with SetLabel can have
+const MaxLabelLen = 64
+
+// Wallets keeps track of all current Wallet structs -
+// Labels - human readable labels of addresses, whether or not they belong to a Wallet
+// params - address scheme of the Wallets, not saved with them. The zero value means DefaultAddressParams
 type Wallets struct {
 	Wallets map[string]*Wallet
+	Labels  map[string]string
+	params  AddressParams
 }
 
 // InitWallets makes a new Wallets struct and loads it with previous Wallets data if possible
 func InitWallets() (*Wallets, error) {
-	wallets := Wallets{}
+	return InitWalletsWithParams(DefaultAddressParams)
+}
+
+// InitWalletsWithParams makes a new Wallets struct whose addresses are made and validated under given AddressParams,
+// e.g. those of a BlockChain, and loads it with previous Wallets data if possible
+func InitWalletsWithParams(p AddressParams) (*Wallets, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	wallets := Wallets{params: p}
 	wallets.Wallets = make(map[string]*Wallet)
+	wallets.Labels = make(map[string]string)
 
 	err := wallets.LoadFromFile()
 
 	return &wallets, err
 }
 
-// CreateWallet makes a new wallet and adds it to the Wallets
-func (ws *Wallets) CreateWallet() string {
+// AddressParams gets the address scheme the Wallets make and validate addresses under
+func (ws *Wallets) AddressParams() AddressParams {
+	if ws.params == (AddressParams{}) {
+		return DefaultAddressParams
+	}
+	return ws.params
+}
+
+// CreateWallet makes a new wallet and adds it to the Wallets -
+// compressed - whether the address uses the compressed pub key
+func (ws *Wallets) CreateWallet(compressed bool) string {
 	wallet := InitWallet()
-	address := fmt.Sprintf("%s", wallet.GetAddress())
+	wallet.Compressed = compressed
+	address := fmt.Sprintf("%s", wallet.GetAddressWithParams(ws.AddressParams()))
 
 	ws.Wallets[address] = wallet
 
 	return address
 }
 
+// DeleteWallet removes the Wallet of an address, first wiping its private key with Zero. Its label is kept, like the
+// label of any other address
+func (ws *Wallets) DeleteWallet(address string) error {
+	w, ok := ws.Wallets[address]
+	if !ok {
+		return fmt.Errorf("No wallet for address %s", address)
+	}
+
+	w.Zero()
+	delete(ws.Wallets, address)
+
+	return nil
+}
+
+// Zero wipes the private key of every Wallet with Wallet.Zero, e.g. on shutdown once the Wallets are no longer needed
+func (ws *Wallets) Zero() {
+	for _, w := range ws.Wallets {
+		if w != nil {
+			w.Zero()
+		}
+	}
+}
+
 // GetAddresses retrieves all of the address from the Wallets
 func (ws *Wallets) GetAddresses() []string {
 	var addresses []string
@@ -45,46 +99,194 @@ func (ws *Wallets) GetAddresses() []string {
 	for address := range ws.Wallets {
 		addresses = append(addresses, address)
 	}
+	sort.Strings(addresses)
 
 	return addresses
 }
 
+// SetLabel attaches a label of at most MaxLabelLen bytes to an address, replacing any previous one. The address
+// needn't belong to a Wallet. An empty label removes it
+func (ws *Wallets) SetLabel(address, label string) error {
+	if !ws.AddressParams().ValidateAddress(address) {
+		return errors.New("Invalid address")
+	}
+	if len(label) > MaxLabelLen {
+		return fmt.Errorf("Label is %d bytes, the most is %d", len(label), MaxLabelLen)
+	}
+
+	if label == "" {
+		delete(ws.Labels, address)
+	} else {
+		if ws.Labels == nil {
+			ws.Labels = make(map[string]string)
+		}
+		ws.Labels[address] = label
+	}
+
+	return nil
+}
+
+// GetLabel retrieves the label of an address, or "" if it has none
+func (ws *Wallets) GetLabel(address string) string {
+	return ws.Labels[address]
+}
+
 // GetWallet retrieves a specific wallet by address
 func (ws Wallets) GetWallet(address string) Wallet {
 	return *ws.Wallets[address]
 }
 
-// LoadFromFile loads Wallets data from disk
+// Merge imports the Wallets of other that ws doesn't have, along with labels for addresses ws hasn't labeled.
+// Returns the number of Wallets added and the addresses in both whose keys differ, or whose Wallet in other is
+// invalid, which are left as they are in ws
+func (ws *Wallets) Merge(other *Wallets) (int, []string) {
+	added := 0
+	var conflicts []string
+
+	for _, address := range other.GetAddresses() {
+		w := other.Wallets[address]
+		if validateWallet(address, w, ws.AddressParams()) != nil {
+			conflicts = append(conflicts, address)
+			continue
+		}
+
+		if existing, ok := ws.Wallets[address]; ok {
+			if existing == nil || existing.PrivateKey.D == nil || existing.PrivateKey.D.Cmp(w.PrivateKey.D) != 0 {
+				conflicts = append(conflicts, address)
+			}
+			continue
+		}
+		ws.Wallets[address] = w
+		added++
+	}
+
+	if ws.Labels == nil {
+		ws.Labels = make(map[string]string)
+	}
+	for address, label := range other.Labels {
+		if _, ok := ws.Labels[address]; !ok {
+			ws.Labels[address] = label
+		}
+	}
+
+	return added, conflicts
+}
+
+// LoadFromFile loads Wallets data from disk. If any Wallet fails Validate nothing is loaded and the
+// returned error lists the bad addresses
 func (ws *Wallets) LoadFromFile() error {
-	if _, err := os.Stat(walletFile); os.IsNotExist(err) {
+	wallets, err := ReadWalletsFile()
+	if err != nil {
 		return err
 	}
 
+	wallets.params = ws.params
+	if errs := wallets.Validate(); len(errs) > 0 {
+		var bad []string
+		for _, err := range errs {
+			bad = append(bad, err.Error())
+		}
+		return fmt.Errorf("Invalid wallets in %s: %s", walletFile, strings.Join(bad, ", "))
+	}
+
+	ws.Wallets = wallets.Wallets
+	if wallets.Labels != nil { // files written before labels existed have none
+		ws.Labels = wallets.Labels
+	}
+
+	return nil
+}
+
+// ReadWalletsFile decodes the Wallets data on disk without validating it, so a possibly corrupt file can be
+// inspected with Validate. Use InitWallets to get Wallets to use
+func ReadWalletsFile() (*Wallets, error) {
+	return ReadWalletsFileAt(walletFile)
+}
+
+// ReadWalletsFileAt decodes Wallets data saved at a given path, e.g. a wallet file from another machine, like
+// ReadWalletsFile
+func ReadWalletsFileAt(path string) (*Wallets, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, err
+	}
+
 	var wallets Wallets
 
-	data, err := ioutil.ReadFile(walletFile)
-	errutil.Handle(err)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
 
 	gob.Register(elliptic.P256())
 	decoder := gob.NewDecoder(bytes.NewReader(data))
-	err = decoder.Decode(&wallets)
-	errutil.Handle(err)
+	if err := decoder.Decode(&wallets); err != nil {
+		return nil, err
+	}
 
-	ws.Wallets = wallets.Wallets
+	return &wallets, nil
+}
+
+// Validate checks every Wallet with validateWallet, returning an error naming the address of each bad one
+func (ws *Wallets) Validate() []error {
+	var errs []error
+
+	for _, address := range ws.GetAddresses() {
+		if err := validateWallet(address, ws.Wallets[address], ws.AddressParams()); err != nil {
+			errs = append(errs, fmt.Errorf("%s (%s)", address, err))
+		}
+	}
+
+	return errs
+}
+
+// validateWallet determines whether a Wallet holds a usable P256 key pair whose address under given AddressParams is
+// the given one
+func validateWallet(address string, w *Wallet, p AddressParams) error {
+	if w == nil {
+		return errors.New("wallet is empty")
+	}
+	priv := w.PrivateKey
+	curve := elliptic.P256()
+
+	if priv.Curve == nil || priv.Curve.Params().Name != curve.Params().Name {
+		return errors.New("private key is not on the P256 curve")
+	}
+	if priv.D == nil || priv.D.Sign() <= 0 || priv.D.Cmp(curve.Params().N) >= 0 {
+		return errors.New("private key is out of range")
+	}
+	if priv.X == nil || priv.Y == nil || !curve.IsOnCurve(priv.X, priv.Y) {
+		return errors.New("public key is not on the P256 curve")
+	}
+
+	x, y := curve.ScalarBaseMult(priv.D.Bytes())
+	if x.Cmp(priv.X) != 0 || y.Cmp(priv.Y) != 0 {
+		return errors.New("public key does not match private key")
+	}
+	if bytes.Compare(w.PublicKey, append(priv.X.Bytes(), priv.Y.Bytes()...)) != 0 {
+		return errors.New("public key bytes do not match private key")
+	}
+	if string(w.GetAddressWithParams(p)) != address {
+		return errors.New("address does not match key")
+	}
 
 	return nil
 }
 
 // SaveToFile writes the Wallets data to disk
 func (ws *Wallets) SaveToFile() {
+	errutil.Handle(ws.SaveToFileAt(walletFile))
+}
+
+// SaveToFileAt writes the Wallets data to a given path, to be read back with ReadWalletsFileAt
+func (ws *Wallets) SaveToFileAt(path string) error {
 	var data bytes.Buffer
 
 	gob.Register(elliptic.P256())
 
 	encoder := gob.NewEncoder(&data)
-	err := encoder.Encode(ws)
-	errutil.Handle(err)
+	if err := encoder.Encode(ws); err != nil {
+		return err
+	}
 
-	err = ioutil.WriteFile(walletFile, data.Bytes(), 0644)
-	errutil.Handle(err)
+	return ioutil.WriteFile(path, data.Bytes(), 0644)
 }
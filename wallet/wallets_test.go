@@ -0,0 +1,214 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"encoding/gob"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateCorruptedKeys(t *testing.T) {
+	ws := &Wallets{Wallets: make(map[string]*Wallet), Labels: make(map[string]string)}
+	var addresses []string
+	for seed := int64(1); seed <= 3; seed++ {
+		w := testWallet(t, seed)
+		address := string(w.GetAddress())
+		ws.Wallets[address] = w
+		addresses = append(addresses, address)
+	}
+	if errs := ws.Validate(); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+
+	// A private key that no longer matches its pub key, and a pub key off the curve
+	ws.Wallets[addresses[0]].PrivateKey.D = new(big.Int).Add(ws.Wallets[addresses[0]].PrivateKey.D, big.NewInt(1))
+	ws.Wallets[addresses[1]].PrivateKey.X = big.NewInt(1)
+
+	errs := ws.Validate()
+	if len(errs) != 2 {
+		t.Fatalf("Got %d errors, want 2: %v", len(errs), errs)
+	}
+	for _, err := range errs {
+		if strings.Contains(err.Error(), addresses[2]) {
+			t.Fatalf("Error %q names a valid wallet", err)
+		}
+	}
+}
+
+func TestValidateOneGoodOneCorrupt(t *testing.T) {
+	good := testWallet(t, 1)
+	goodAddress := string(good.GetAddress())
+	badAddress := string(testWallet(t, 3).GetAddress())
+	ws := &Wallets{Wallets: map[string]*Wallet{goodAddress: good, badAddress: testWallet(t, 2)}}
+
+	errs := ws.Validate()
+	if len(errs) != 1 {
+		t.Fatalf("Got %d errors, want 1: %v", len(errs), errs)
+	}
+	if want := badAddress + " (address does not match key)"; errs[0].Error() != want {
+		t.Fatalf("Got error %q, want %q", errs[0], want)
+	}
+}
+
+func TestValidateWallet(t *testing.T) {
+	w := testWallet(t, 1)
+	address := string(w.GetAddress())
+	if err := validateWallet(address, w, DefaultAddressParams); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validateWallet(string(testWallet(t, 2).GetAddress()), w, DefaultAddressParams); err == nil {
+		t.Fatal("Wallet valid under another address")
+	}
+
+	otherCurve := *w
+	otherCurve.PrivateKey.Curve = elliptic.P384()
+	if err := validateWallet(address, &otherCurve, DefaultAddressParams); err == nil {
+		t.Fatal("Wallet on the P384 curve is valid")
+	}
+
+	if err := validateWallet(address, nil, DefaultAddressParams); err == nil {
+		t.Fatal("Empty wallet is valid")
+	}
+}
+
+func TestReadWalletsFileAtGarbage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wallets.dat")
+	if _, err := ReadWalletsFileAt(path); err == nil {
+		t.Fatal("Read a missing wallet file")
+	}
+
+	if err := ioutil.WriteFile(path, []byte("not a wallet file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadWalletsFileAt(path); err == nil {
+		t.Fatal("Read a garbage wallet file")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	shared, own, unique := testWallet(t, 1), testWallet(t, 2), testWallet(t, 3)
+	sharedAddress, ownAddress, uniqueAddress := string(shared.GetAddress()), string(own.GetAddress()), string(unique.GetAddress())
+	ws := &Wallets{
+		Wallets: map[string]*Wallet{sharedAddress: shared, ownAddress: own},
+		Labels:  map[string]string{sharedAddress: "mine"}}
+
+	sharedCopy := *shared
+	other := &Wallets{
+		Wallets: map[string]*Wallet{sharedAddress: &sharedCopy, uniqueAddress: unique},
+		Labels:  map[string]string{sharedAddress: "theirs", uniqueAddress: "savings"}}
+
+	added, conflicts := ws.Merge(other)
+	if added != 1 || len(conflicts) != 0 {
+		t.Fatalf("Added %d with conflicts %v, want 1 and none", added, conflicts)
+	}
+	if len(ws.Wallets) != 3 || ws.Wallets[uniqueAddress] != unique {
+		t.Fatal("Unique wallet not imported")
+	}
+	if ws.GetLabel(sharedAddress) != "mine" || ws.GetLabel(uniqueAddress) != "savings" {
+		t.Fatalf("Got labels %v", ws.Labels)
+	}
+
+	// The same address with a different key is reported and left alone
+	forged := *testWallet(t, 4)
+	other = &Wallets{Wallets: map[string]*Wallet{ownAddress: &forged}}
+	if added, conflicts := ws.Merge(other); added != 0 || len(conflicts) != 1 || conflicts[0] != ownAddress {
+		t.Fatalf("Added %d with conflicts %v, want 0 and %s", added, conflicts, ownAddress)
+	}
+	if ws.Wallets[ownAddress] != own {
+		t.Fatal("Conflicting wallet replaced")
+	}
+}
+
+func TestDeleteWalletZeroes(t *testing.T) {
+	deleted, kept := testWallet(t, 1), testWallet(t, 2)
+	deletedAddress, keptAddress := string(deleted.GetAddress()), string(kept.GetAddress())
+	ws := &Wallets{Wallets: map[string]*Wallet{deletedAddress: deleted, keptAddress: kept}}
+
+	if err := ws.DeleteWallet(deletedAddress); err != nil {
+		t.Fatal(err)
+	}
+	if !deleted.Zeroed() || kept.Zeroed() {
+		t.Fatal("DeleteWallet didn't zero only the deleted wallet")
+	}
+	if _, ok := ws.Wallets[deletedAddress]; ok {
+		t.Fatal("Wallet not deleted")
+	}
+	if err := ws.DeleteWallet(deletedAddress); err == nil {
+		t.Fatal("Deleted a missing wallet")
+	}
+
+	ws.Zero()
+	if !kept.Zeroed() {
+		t.Fatal("Wallets.Zero left a key")
+	}
+}
+
+func TestSetLabel(t *testing.T) {
+	own, other := testWallet(t, 1), testWallet(t, 2)
+	ownAddress, otherAddress := string(own.GetAddress()), string(other.GetAddress())
+
+	for _, c := range []struct {
+		name, address, label string
+		valid                bool
+		want                 string
+	}{
+		{"own address", ownAddress, "savings", true, "savings"},
+		{"address without a wallet", otherAddress, "rent", true, "rent"},
+		{"empty label", ownAddress, "", true, ""},
+		{"longest label", ownAddress, strings.Repeat("a", MaxLabelLen), true, strings.Repeat("a", MaxLabelLen)},
+		{"overlong label", ownAddress, strings.Repeat("a", MaxLabelLen+1), false, "old"},
+		{"invalid address", ownAddress[:len(ownAddress)-1] + "x", "typo", false, ""},
+		{"no address", "", "nothing", false, ""},
+	} {
+		// Each starts with the own address labeled
+		ws := &Wallets{Wallets: map[string]*Wallet{ownAddress: own}, Labels: map[string]string{ownAddress: "old"}}
+
+		err := ws.SetLabel(c.address, c.label)
+		if (err == nil) != c.valid {
+			t.Fatalf("Got %v labeling with the %s", err, c.name)
+		}
+		if got := ws.GetLabel(c.address); got != c.want {
+			t.Fatalf("Got label %q with the %s, want %q", got, c.name, c.want)
+		}
+		if _, ok := ws.Labels[c.address]; c.want == "" && ok {
+			t.Fatalf("Got an empty label kept with the %s", c.name)
+		}
+	}
+
+	// Labels survive saving and loading. The Wallets are left out, as gob can't encode their curve
+	ws := &Wallets{Wallets: make(map[string]*Wallet)}
+	for address, label := range map[string]string{ownAddress: "savings", otherAddress: "rent"} {
+		if err := ws.SetLabel(address, label); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "wallets.dat")
+	if err := ws.SaveToFileAt(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := ReadWalletsFileAt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Labels) != 2 || loaded.GetLabel(ownAddress) != "savings" || loaded.GetLabel(otherAddress) != "rent" {
+		t.Fatalf("Got labels %v after loading", loaded.Labels)
+	}
+
+	// Files written before labels existed load without any
+	old := &struct{ Wallets map[string]*Wallet }{make(map[string]*Wallet)}
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(old); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err = ReadWalletsFileAt(path); err != nil || len(loaded.Labels) != 0 {
+		t.Fatalf("Got %+v (%v) from a file without labels", loaded, err)
+	}
+}
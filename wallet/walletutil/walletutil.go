@@ -19,3 +19,9 @@ func Base58Decode(input []byte) []byte {
 
 	return decode
 }
+
+// ParseBase58 decodes base58 encoded input like Base58Decode, returning an error for invalid input rather than
+// panicking, for input from outside the program
+func ParseBase58(input string) ([]byte, error) {
+	return base58.Decode(input)
+}
@@ -0,0 +1,271 @@
+// Package webhook POSTs a summary of each Block connected to a BlockChain to registered HTTP endpoints
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/danitello/go-blockchain/core"
+	"github.com/danitello/go-blockchain/core/types"
+)
+
+const (
+	// DefaultTimeout is how long a single POST to a webhook may take
+	DefaultTimeout = 10 * time.Second
+	// DefaultMaxAttempts is how many times a summary is POSTed to a webhook before it is given up on
+	DefaultMaxAttempts = 5
+	// DefaultBackoff is the wait before the first retry, doubled before each one after
+	DefaultBackoff = time.Second
+	// queueLen is the number of summaries waiting for a webhook after which new ones are dropped
+	queueLen = 100
+)
+
+var (
+	// ErrWebhookRegistered is returned by RegisterWebhook for a URL that is already registered
+	ErrWebhookRegistered = errors.New("Webhook is already registered")
+	// ErrWebhookNotFound is returned by UnregisterWebhook for a URL that isn't registered
+	ErrWebhookNotFound = errors.New("Webhook is not registered")
+	// ErrManagerClosed is returned by RegisterWebhook once the WebhookManager is closed
+	ErrManagerClosed = errors.New("Webhook manager is closed")
+)
+
+// BlockSummary is the JSON body POSTed to each webhook for a connected Block
+type BlockSummary struct {
+	Hash       string   `json:"hash"`
+	Height     int      `json:"height"`
+	PrevHash   string   `json:"prevHash"`
+	TimeStamp  string   `json:"timeStamp"`
+	Difficulty int      `json:"difficulty"`
+	TxIDs      []string `json:"txids"`
+}
+
+// summarize creates the BlockSummary of a Block
+func summarize(block *types.Block) BlockSummary {
+	summary := BlockSummary{
+		Hash:       hex.EncodeToString(block.Hash),
+		Height:     block.Index,
+		PrevHash:   hex.EncodeToString(block.PrevHash),
+		TimeStamp:  string(block.TimeStamp),
+		Difficulty: block.Difficulty,
+		TxIDs:      []string{}}
+	for _, tx := range block.Transactions {
+		summary.TxIDs = append(summary.TxIDs, hex.EncodeToString(tx.ID))
+	}
+
+	return summary
+}
+
+// WebhookManager POSTs a BlockSummary to each registered webhook when a Block is connected. Each webhook has its own
+// queue and goroutine, so a slow or failing one neither holds up the BlockChain nor the others, and gets summaries in
+// the order Blocks are connected. The settings apply to webhooks registered after they are changed -
+// Timeout - how long a single POST may take
+// MaxAttempts - how many times a summary is POSTed before it is given up on, retrying after a non-2xx status or error
+// Backoff - wait before the first retry, doubled before each one after
+type WebhookManager struct {
+	Timeout     time.Duration
+	MaxAttempts int
+	Backoff     time.Duration
+
+	mu       sync.Mutex
+	closed   bool
+	webhooks map[string]*webhook
+	wg       sync.WaitGroup // webhook goroutines running
+}
+
+// webhook is a registered URL along with its queue of summaries to deliver
+type webhook struct {
+	url         string
+	timeout     time.Duration
+	maxAttempts int
+	backoff     time.Duration
+	client      *http.Client
+	queue       chan []byte
+	stop        chan struct{}
+}
+
+// InitWebhookManager creates a new WebhookManager with DefaultTimeout, DefaultMaxAttempts and DefaultBackoff,
+// notified of each Block connected to a BlockChain
+func InitWebhookManager(bc *core.BlockChain) *WebhookManager {
+	m := &WebhookManager{
+		Timeout:     DefaultTimeout,
+		MaxAttempts: DefaultMaxAttempts,
+		Backoff:     DefaultBackoff,
+		webhooks:    make(map[string]*webhook)}
+	bc.OnConnect(m.notify)
+
+	return m
+}
+
+// RegisterWebhook starts POSTing a BlockSummary of each Block connected from now on to an http or https URL
+func (m *WebhookManager) RegisterWebhook(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("Invalid webhook URL %q", rawURL)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrManagerClosed
+	}
+	if _, ok := m.webhooks[rawURL]; ok {
+		return ErrWebhookRegistered
+	}
+
+	wh := &webhook{
+		url:         rawURL,
+		timeout:     m.Timeout,
+		maxAttempts: m.MaxAttempts,
+		backoff:     m.Backoff,
+		client:      &http.Client{},
+		queue:       make(chan []byte, queueLen),
+		stop:        make(chan struct{})}
+	m.webhooks[rawURL] = wh
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		wh.run()
+	}()
+
+	return nil
+}
+
+// UnregisterWebhook stops POSTing to a URL. Summaries not yet delivered to it are dropped, and a POST underway is
+// cancelled
+func (m *WebhookManager) UnregisterWebhook(rawURL string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	wh, ok := m.webhooks[rawURL]
+	if !ok {
+		return ErrWebhookNotFound
+	}
+	delete(m.webhooks, rawURL)
+	close(wh.stop)
+
+	return nil
+}
+
+// Webhooks gets the registered URLs
+func (m *WebhookManager) Webhooks() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var urls []string
+	for u := range m.webhooks {
+		urls = append(urls, u)
+	}
+	return urls
+}
+
+// Close unregisters every webhook and waits for their goroutines to stop. Blocks connected after are not POSTed
+func (m *WebhookManager) Close() {
+	m.mu.Lock()
+	m.closed = true
+	for u, wh := range m.webhooks {
+		delete(m.webhooks, u)
+		close(wh.stop)
+	}
+	m.mu.Unlock()
+
+	m.wg.Wait()
+}
+
+// notify queues the BlockSummary of a connected Block for each webhook. It runs as a BlockChain hook, so never waits:
+// a summary for a webhook whose queue is full is dropped
+func (m *WebhookManager) notify(block *types.Block) {
+	body, err := json.Marshal(summarize(block))
+	if err != nil {
+		log.Printf("Webhook summary of block %x: %s\n", block.Hash, err)
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, wh := range m.webhooks {
+		select {
+		case wh.queue <- body:
+		default:
+			log.Printf("Webhook %s is behind, dropping block %x\n", wh.url, block.Hash)
+		}
+	}
+}
+
+// run delivers queued summaries in order until the webhook is stopped
+func (wh *webhook) run() {
+	for {
+		select {
+		case <-wh.stop:
+			return
+		case body := <-wh.queue:
+			wh.deliver(body)
+		}
+	}
+}
+
+// deliver POSTs a summary, retrying with backoff up to maxAttempts times
+func (wh *webhook) deliver(body []byte) {
+	backoff := wh.backoff
+	for attempt := 1; ; attempt++ {
+		err := wh.post(body)
+		if err == nil {
+			return
+		}
+		if attempt >= wh.maxAttempts {
+			log.Printf("Webhook %s failed %d times, giving up: %s\n", wh.url, attempt, err)
+			return
+		}
+
+		select {
+		case <-wh.stop:
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post makes a single POST of a summary, cancelled if the webhook is stopped
+func (wh *webhook) post(body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), wh.timeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-wh.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	req, err := http.NewRequest(http.MethodPost, wh.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := wh.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body) // so the connection can be reused
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("Status %s", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package webhook
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/danitello/go-blockchain/core"
+	"github.com/danitello/go-blockchain/core/testutil"
+	"github.com/danitello/go-blockchain/core/types"
+)
+
+// mine adds a Block holding only a coinbase tx to bc, returning it
+func mine(t *testing.T, bc *core.BlockChain, address string) *types.Block {
+	t.Helper()
+	_, tip := bc.Tip()
+	if err := bc.AddBlock([]*types.Transaction{types.CoinbaseTx(address, tip+1)}); err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := bc.ChainDB.ReadBlockWithHash(bc.LastHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+// mockWebhook starts a server answering each POST with the next of statuses, then 200 once they run out, and sends
+// each BlockSummary it gets on the returned channel along with the status it answered
+func mockWebhook(t *testing.T, statuses ...int) (*httptest.Server, chan delivery) {
+	t.Helper()
+	deliveries := make(chan delivery, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var summary BlockSummary
+		if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&summary) != nil {
+			t.Errorf("Got a %s request without a BlockSummary", r.Method)
+		}
+
+		status := http.StatusOK
+		if len(statuses) > 0 {
+			status, statuses = statuses[0], statuses[1:]
+		}
+		w.WriteHeader(status)
+		deliveries <- delivery{summary, status}
+	}))
+	t.Cleanup(ts.Close)
+
+	return ts, deliveries
+}
+
+// delivery is a BlockSummary POSTed to a mockWebhook, along with the status it was answered with
+type delivery struct {
+	summary BlockSummary
+	status  int
+}
+
+// next waits for the next delivery to a mockWebhook
+func next(t *testing.T, deliveries chan delivery) delivery {
+	t.Helper()
+	select {
+	case d := <-deliveries:
+		return d
+	case <-time.After(5 * time.Second):
+		t.Fatal("Got no webhook delivery")
+	}
+	return delivery{}
+}
+
+// testManager makes a WebhookManager with quick retries on a new BlockChain, closed when the test finishes
+func testManager(t *testing.T) (*WebhookManager, *core.BlockChain, string) {
+	t.Helper()
+	bc, ws := testutil.BuildTestChain(t, 1)
+	m := InitWebhookManager(bc)
+	m.Backoff = time.Millisecond
+	t.Cleanup(m.Close)
+
+	return m, bc, ws.GetAddresses()[0]
+}
+
+func TestDelivery(t *testing.T) {
+	m, bc, address := testManager(t)
+	ts, deliveries := mockWebhook(t)
+	if err := m.RegisterWebhook(ts.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		block := mine(t, bc, address)
+		got := next(t, deliveries).summary
+		if got.Hash != hex.EncodeToString(block.Hash) || got.Height != block.Index ||
+			got.PrevHash != hex.EncodeToString(block.PrevHash) || len(got.TxIDs) != 1 {
+			t.Fatalf("Got %+v, want the summary of block %d %x", got, block.Index, block.Hash)
+		}
+	}
+
+	if err := m.UnregisterWebhook(ts.URL); err != nil {
+		t.Fatal(err)
+	}
+	mine(t, bc, address)
+	select {
+	case d := <-deliveries:
+		t.Fatalf("Got %+v after unregistering", d.summary)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestRetry(t *testing.T) {
+	m, bc, address := testManager(t)
+	m.MaxAttempts = 3
+	ts, deliveries := mockWebhook(t, http.StatusInternalServerError, http.StatusServiceUnavailable)
+	if err := m.RegisterWebhook(ts.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	block := mine(t, bc, address)
+	for _, want := range []int{http.StatusInternalServerError, http.StatusServiceUnavailable, http.StatusOK} {
+		if d := next(t, deliveries); d.status != want || d.summary.Hash != hex.EncodeToString(block.Hash) {
+			t.Fatalf("Got %+v answered %d, want block %x answered %d", d.summary, d.status, block.Hash, want)
+		}
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	m, bc, address := testManager(t)
+	m.MaxAttempts = 2
+	ts, deliveries := mockWebhook(t, http.StatusInternalServerError, http.StatusInternalServerError)
+	if err := m.RegisterWebhook(ts.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	failed := mine(t, bc, address)
+	for i := 0; i < 2; i++ {
+		if d := next(t, deliveries); d.summary.Hash != hex.EncodeToString(failed.Hash) {
+			t.Fatalf("Got %+v on attempt %d, want block %x", d.summary, i+1, failed.Hash)
+		}
+	}
+
+	// The next Block is delivered rather than a third attempt at the failed one
+	block := mine(t, bc, address)
+	if d := next(t, deliveries); d.status != http.StatusOK || d.summary.Hash != hex.EncodeToString(block.Hash) {
+		t.Fatalf("Got %+v answered %d, want block %x", d.summary, d.status, block.Hash)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	m, bc, address := testManager(t)
+	m.Timeout = 50 * time.Millisecond
+
+	var calls int32
+	attempts := make(chan int32, 10)
+	hung := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempt := atomic.AddInt32(&calls, 1)
+		attempts <- attempt
+		if attempt == 1 {
+			<-hung // not answered before the client gives up
+		}
+	}))
+	defer ts.Close()
+	defer close(hung)
+	if err := m.RegisterWebhook(ts.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	mine(t, bc, address)
+	for _, want := range []int32{1, 2} {
+		select {
+		case attempt := <-attempts:
+			if attempt != want {
+				t.Fatalf("Got attempt %d, want %d", attempt, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Got no attempt %d after the timeout", want)
+		}
+	}
+}
+
+func TestSlowWebhook(t *testing.T) {
+	m, bc, address := testManager(t)
+
+	release := make(chan struct{})
+	answered := make(chan struct{}, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		answered <- struct{}{}
+	}))
+	defer ts.Close()
+	if err := m.RegisterWebhook(ts.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	// Blocks are connected while the webhook is still busy with the first
+	for i := 0; i < 3; i++ {
+		mine(t, bc, address)
+	}
+	close(release)
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-answered:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Got %d deliveries, want 3", i)
+		}
+	}
+}
+
+func TestRegisterWebhook(t *testing.T) {
+	m, _, _ := testManager(t)
+
+	for _, bad := range []string{"", "ftp://example.com/hook", "http://", "://nope"} {
+		if err := m.RegisterWebhook(bad); err == nil {
+			t.Fatalf("Got %q registered", bad)
+		}
+	}
+
+	if err := m.RegisterWebhook("http://127.0.0.1:1/hook"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RegisterWebhook("http://127.0.0.1:1/hook"); err != ErrWebhookRegistered {
+		t.Fatalf("Got %v registering twice, want ErrWebhookRegistered", err)
+	}
+	if got := m.Webhooks(); len(got) != 1 || got[0] != "http://127.0.0.1:1/hook" {
+		t.Fatalf("Got webhooks %v", got)
+	}
+
+	if err := m.UnregisterWebhook("http://127.0.0.1:2/hook"); err != ErrWebhookNotFound {
+		t.Fatalf("Got %v unregistering an unknown URL, want ErrWebhookNotFound", err)
+	}
+
+	m.Close()
+	if err := m.RegisterWebhook("http://127.0.0.1:3/hook"); err != ErrManagerClosed {
+		t.Fatalf("Got %v registering once closed, want ErrManagerClosed", err)
+	}
+	if len(m.Webhooks()) != 0 {
+		t.Fatalf("Got webhooks %v once closed", m.Webhooks())
+	}
+}